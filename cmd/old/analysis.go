@@ -0,0 +1,508 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"io"
+	"math"
+	"os"
+	"slices"
+	"strings"
+
+	rl "github.com/gen2brain/raylib-go/raylib"
+	"github.com/ha1tch/deluxedraw/palette"
+)
+
+// Palette analysis: the WCAG contrast of every pair of colours, colours
+// too close to tell apart, and the palette and canvas as people with
+// protanopia, deuteranopia or tritanopia see them.
+
+const (
+	defaultDuplicateDelta = 5.0 // CIEDE2000
+	duplicateRows         = 13  // near duplicates listed at once
+)
+
+// Short names of the kinds of vision, for buttons
+var visionLabels = []string{"NORMAL", "PROTAN", "DEUTAN", "TRITAN"}
+
+// State of the analysis dialog
+type paletteAnalysis struct {
+	box       rl.Rectangle
+	buttons   []Button // -, +, then a canvas view per vision, DONE
+	threshold float64
+	scroll    int
+	colors    []rl.Color            // analysed, to notice edits
+	contrast  [][]float64           // WCAG ratio of every pair
+	views     []*palette.Palette    // the colours with each vision
+	dups      [][]palette.Duplicate // near duplicates with each vision
+}
+
+// Open the analysis of the colours being edited
+func (app *App) OpenPaletteAnalysis() {
+	a := &paletteAnalysis{
+		box:       rl.Rectangle{X: screenWidth/2 - 360, Y: screenHeight/2 - 240, Width: 720, Height: 480},
+		threshold: defaultDuplicateDelta,
+	}
+	x, y := a.box.X, a.box.Y
+	a.buttons = []Button{
+		{rect: rl.Rectangle{X: x + 620, Y: y + 26, Width: 16, Height: 14}, text: "-"},
+		{rect: rl.Rectangle{X: x + 690, Y: y + 26, Width: 16, Height: 14}, text: "+"},
+	}
+	for v, label := range visionLabels {
+		a.buttons = append(a.buttons, Button{rect: rl.Rectangle{X: x + 90 + float32(v)*64, Y: y + 446, Width: 60, Height: 20}, text: label})
+	}
+	a.buttons = append(a.buttons, Button{rect: rl.Rectangle{X: x + 630, Y: y + 446, Width: 80, Height: 20}, text: "DONE"})
+	app.analysis = a
+	a.analyse(app.swatches(), app.analysisSkip())
+}
+
+// Entry left out of the analysis: the transparent one of indexed palettes
+func (app *App) analysisSkip() int {
+	if app.palette == nil {
+		return -1
+	}
+	return app.palette.transparent
+}
+
+// Palette of plain colours for the palette package
+func swatchPalette(colors []rl.Color) *palette.Palette {
+	p := &palette.Palette{}
+	for _, c := range colors {
+		p.Colors = append(p.Colors, palette.Color{R: c.R, G: c.G, B: c.B, A: 255})
+	}
+	return p
+}
+
+// Analyse colours. Pairs with the skipped entry are not reported as
+// duplicates.
+func (a *paletteAnalysis) analyse(colors []rl.Color, skip int) {
+	a.colors = append([]rl.Color(nil), colors...)
+	p := swatchPalette(colors)
+	a.contrast = palette.ContrastMatrix(p)
+	a.views, a.dups = nil, nil
+	for v := range palette.VisionNames {
+		view := palette.Vision(v).SimulatePalette(p)
+		var dups []palette.Duplicate
+		for _, d := range palette.NearDuplicates(view, a.threshold) {
+			if d.A != skip && d.B != skip {
+				dups = append(dups, d)
+			}
+		}
+		a.views = append(a.views, view)
+		a.dups = append(a.dups, dups)
+	}
+}
+
+// Area of the contrast matrix, and the size of its cells
+func (a *paletteAnalysis) matrix() (rl.Rectangle, float32) {
+	cell := float32(320) / float32(max(1, len(a.colors)))
+	cell = clamp(cell, 1, 20)
+	return rl.Rectangle{X: a.box.X + 24, Y: a.box.Y + 54, Width: cell * float32(len(a.colors)), Height: cell * float32(len(a.colors))}, cell
+}
+
+// Handle input in the analysis dialog
+func (app *App) updatePaletteAnalysis(mousePos rl.Vector2) {
+	a := app.analysis
+	if colors := app.swatches(); !slices.Equal(colors, a.colors) {
+		a.analyse(colors, app.analysisSkip())
+	}
+	if wheel := rl.GetMouseWheelMove(); wheel != 0 {
+		a.scroll -= int(wheel) * 3
+	}
+	a.scroll = max(0, min(a.scroll, len(a.dups[palette.NormalVision])-duplicateRows))
+	if !rl.IsMouseButtonPressed(rl.MouseLeftButton) {
+		return
+	}
+	for i, btn := range a.buttons {
+		if !rl.CheckCollisionPointRec(mousePos, btn.rect) {
+			continue
+		}
+		switch i {
+		case 0:
+			a.threshold = math.Max(1, a.threshold-1)
+			a.analyse(a.colors, app.analysisSkip())
+		case 1:
+			a.threshold = math.Min(a.threshold+1, 20)
+			a.analyse(a.colors, app.analysisSkip())
+		case len(a.buttons) - 1:
+			app.analysis = nil
+		default:
+			app.SetVision(palette.Vision(i - 2))
+		}
+		return
+	}
+}
+
+// Show the canvas as people with a kind of colour vision see it
+func (app *App) SetVision(v palette.Vision) {
+	app.vision = v
+	if v == palette.NormalVision {
+		app.SetStatus("CANVAS VIEW: NORMAL VISION")
+		return
+	}
+	app.SetStatus("CANVAS VIEW: " + strings.ToUpper(palette.VisionNames[v]))
+}
+
+// Show the canvas with the next kind of vision
+func (app *App) CycleVision() {
+	app.SetVision((app.vision + 1) % palette.Vision(len(palette.VisionNames)))
+}
+
+// Shader showing the canvas with another kind of colour vision; loaded
+// on first use
+var visionShader struct {
+	shader rl.Shader
+	rows   [3]int32 // locations of the matrix rows
+	loaded bool
+}
+
+const visionFragmentShader = `#version 330
+in vec2 fragTexCoord;
+in vec4 fragColor;
+uniform sampler2D texture0;
+uniform vec4 colDiffuse;
+uniform vec3 row0;
+uniform vec3 row1;
+uniform vec3 row2;
+out vec4 finalColor;
+` + srgbShaderFunctions + `
+void main() {
+	vec4 texel = texture(texture0, fragTexCoord)*colDiffuse*fragColor;
+	vec3 lin = decode(texel.rgb);
+	finalColor = vec4(encode(vec3(dot(row0, lin), dot(row1, lin), dot(row2, lin))), texel.a);
+}
+`
+
+// Start drawing the canvas as the chosen vision sees it; false with
+// normal vision, when there is nothing to end
+func (app *App) beginVision() bool {
+	if app.vision == palette.NormalVision {
+		return false
+	}
+	if !visionShader.loaded {
+		visionShader.shader = rl.LoadShaderFromMemory(paletteVertexShader, visionFragmentShader)
+		for k := range visionShader.rows {
+			visionShader.rows[k] = rl.GetShaderLocation(visionShader.shader, fmt.Sprintf("row%d", k))
+		}
+		visionShader.loaded = true
+	}
+	rl.BeginShaderMode(visionShader.shader)
+	m := app.vision.Matrix()
+	for k, row := range m {
+		rl.SetShaderValue(visionShader.shader, visionShader.rows[k], []float32{float32(row[0]), float32(row[1]), float32(row[2])}, rl.ShaderUniformVec3)
+	}
+	return true
+}
+
+// Colour of a contrast cell: green passes for body text, amber only for
+// large text, red fails
+func contrastColor(ratio float64) rl.Color {
+	switch {
+	case ratio >= palette.ContrastAAA:
+		return rl.Color{40, 150, 70, 255}
+	case ratio >= palette.ContrastAA:
+		return rl.Color{110, 170, 60, 255}
+	case ratio >= palette.ContrastAALarge:
+		return rl.Color{200, 150, 40, 255}
+	default:
+		return rl.Color{110, 40, 40, 255}
+	}
+}
+
+// WCAG level a contrast ratio reaches
+func contrastLevel(ratio float64) string {
+	switch {
+	case ratio >= palette.ContrastAAA:
+		return "AAA"
+	case ratio >= palette.ContrastAA:
+		return "AA"
+	case ratio >= palette.ContrastAALarge:
+		return "AA LARGE"
+	default:
+		return "FAIL"
+	}
+}
+
+// Draw the analysis dialog over the whole window
+func (app *App) drawPaletteAnalysis() {
+	a := app.analysis
+	if a == nil {
+		return
+	}
+	mousePos := rl.GetMousePosition()
+	x, y := int32(a.box.X), int32(a.box.Y)
+	rl.DrawRectangle(0, 0, screenWidth, screenHeight, rl.Color{0, 0, 0, 160})
+	rl.DrawRectangleRec(a.box, rl.Color{50, 50, 50, 255})
+	rl.DrawRectangleLinesEx(a.box, 1, rl.Color{90, 90, 90, 255})
+	rl.DrawText(fmt.Sprintf("ANALYZE PALETTE, %d COLORS", len(a.colors)), x+10, y+10, fontSize, rl.White)
+
+	// Contrast matrix, with the colours along its edges
+	rl.DrawText("CONTRAST (WCAG)", x+10, y+30, fontSize, rl.LightGray)
+	area, cell := a.matrix()
+	for i, c := range a.colors {
+		offset := float32(i) * cell
+		rl.DrawRectangleRec(rl.Rectangle{X: area.X - 10, Y: area.Y + offset, Width: 8, Height: cell}, c)
+		rl.DrawRectangleRec(rl.Rectangle{X: area.X + offset, Y: area.Y - 10, Width: cell, Height: 8}, c)
+		for j := range a.colors {
+			shade := contrastColor(a.contrast[i][j])
+			if i == j {
+				shade = rl.Color{30, 30, 30, 255}
+			}
+			rl.DrawRectangleRec(rl.Rectangle{X: area.X + float32(j)*cell, Y: area.Y + offset, Width: cell, Height: cell}, shade)
+		}
+	}
+	legendY := y + 384
+	for k, level := range []float64{palette.ContrastAAA, palette.ContrastAA, palette.ContrastAALarge, 1} {
+		lx := x + 10 + int32(k)*80
+		rl.DrawRectangle(lx, legendY, 10, 10, contrastColor(level))
+		rl.DrawText(contrastLevel(level), lx+14, legendY+1, fontSize, rl.LightGray)
+	}
+	if rl.CheckCollisionPointRec(mousePos, area) && len(a.colors) > 0 {
+		i := min(int((mousePos.Y-area.Y)/cell), len(a.colors)-1)
+		j := min(int((mousePos.X-area.X)/cell), len(a.colors)-1)
+		ratio := a.contrast[i][j]
+		rl.DrawRectangle(x+10, legendY+18, 60, 24, a.colors[i])
+		rl.DrawText("Aa 123", x+18, legendY+26, fontSize, a.colors[j])
+		rl.DrawText(fmt.Sprintf("#%d ON #%d: %.2f:1 %s", j, i, ratio, contrastLevel(ratio)), x+78, legendY+26, fontSize, rl.White)
+	}
+
+	// Near duplicates
+	dx, dy := x+370, y+30
+	rl.DrawText("NEAR DUPLICATES, DELTA E UNDER", dx, dy, fontSize, rl.LightGray)
+	rl.DrawText(fmt.Sprintf("%.0f", a.threshold), x+652, y+30, fontSize, rl.White)
+	dups := a.dups[palette.NormalVision]
+	if len(dups) == 0 {
+		rl.DrawText("NONE", dx, dy+18, fontSize, rl.Gray)
+	}
+	for r := range duplicateRows {
+		k := a.scroll + r
+		if k >= len(dups) {
+			break
+		}
+		d := dups[k]
+		ry := dy + 18 + int32(r)*14
+		rl.DrawRectangle(dx, ry, 12, 12, a.colors[d.A])
+		rl.DrawRectangle(dx+14, ry, 12, 12, a.colors[d.B])
+		rl.DrawText(fmt.Sprintf("#%d AND #%d  %.2f", d.A, d.B, d.DeltaE), dx+32, ry+2, fontSize, rl.White)
+	}
+
+	// The palette with each kind of vision, and colours it confuses
+	for v, view := range a.views {
+		vy := y + 250 + int32(v)*32
+		label := strings.ToUpper(palette.VisionNames[v])
+		if v != int(palette.NormalVision) {
+			label += fmt.Sprintf(", %d PAIRS CONFUSED", len(a.dups[v]))
+		}
+		rl.DrawText(label, x+370, vy, fontSize, rl.LightGray)
+		w := float32(340) / float32(max(1, len(view.Colors)))
+		for i, c := range view.Colors {
+			rl.DrawRectangleRec(rl.Rectangle{X: float32(x+370) + float32(i)*w, Y: float32(vy + 12), Width: w + 0.5, Height: 14}, rl.Color{c.R, c.G, c.B, 255})
+		}
+	}
+
+	rl.DrawText("CANVAS VIEW", x+10, y+452, fontSize, rl.LightGray)
+	for i, btn := range a.buttons {
+		btn.selected = i >= 2 && i < len(a.buttons)-1 && palette.Vision(i-2) == app.vision
+		drawButton(btn, rl.CheckCollisionPointRec(mousePos, btn.rect))
+	}
+}
+
+// Analysis of a palette as dd palette analyze prints it
+type paletteReport struct {
+	Name       string            `json:"name"`
+	Colors     []colorReport     `json:"colors"`
+	Contrast   [][]float64       `json:"contrast"`
+	Duplicates []duplicateReport `json:"duplicates"`
+	Visions    []visionReport    `json:"visions"`
+}
+
+type colorReport struct {
+	Hex       string  `json:"hex"`
+	Name      string  `json:"name,omitempty"`
+	Luminance float64 `json:"luminance"`
+}
+
+type duplicateReport struct {
+	A      int     `json:"a"`
+	B      int     `json:"b"`
+	DeltaE float64 `json:"delta_e"`
+}
+
+type visionReport struct {
+	Vision     string            `json:"vision"`
+	Colors     []string          `json:"colors"`
+	Duplicates []duplicateReport `json:"duplicates"` // pairs confused with this vision
+}
+
+// Round to hundredths, as reports show numbers
+func hundredths(v float64) float64 {
+	return math.Round(v*100) / 100
+}
+
+// Near duplicates as reported
+func duplicateReports(dups []palette.Duplicate) []duplicateReport {
+	out := []duplicateReport{}
+	for _, d := range dups {
+		out = append(out, duplicateReport{d.A, d.B, hundredths(d.DeltaE)})
+	}
+	return out
+}
+
+// Analyse a palette for dd palette analyze
+func analyzePalette(p *palette.Palette, threshold float64) *paletteReport {
+	report := &paletteReport{Name: p.Name, Duplicates: duplicateReports(palette.NearDuplicates(p, threshold))}
+	for _, c := range p.Colors {
+		report.Colors = append(report.Colors, colorReport{c.Hex(), c.Name, hundredths(c.Luminance())})
+	}
+	for _, row := range palette.ContrastMatrix(p) {
+		for j := range row {
+			row[j] = hundredths(row[j])
+		}
+		report.Contrast = append(report.Contrast, row)
+	}
+	for vision := palette.Protanopia; int(vision) < len(palette.VisionNames); vision++ {
+		view := vision.SimulatePalette(p)
+		vr := visionReport{Vision: palette.VisionNames[vision], Duplicates: duplicateReports(palette.NearDuplicates(view, threshold))}
+		for _, c := range view.Colors {
+			vr.Colors = append(vr.Colors, c.Hex())
+		}
+		report.Visions = append(report.Visions, vr)
+	}
+	return report
+}
+
+// Print an analysis for people
+func printPaletteReport(w io.Writer, r *paletteReport, threshold float64) {
+	fmt.Fprintf(w, "%s: %d colors\n", r.Name, len(r.Colors))
+	for i, c := range r.Colors {
+		fmt.Fprintf(w, "  %3d %s L=%.2f %s\n", i, c.Hex, c.Luminance, c.Name)
+	}
+
+	fmt.Fprintf(w, "\ncontrast (WCAG 2):\n     ")
+	for j := range r.Colors {
+		fmt.Fprintf(w, " %5d", j)
+	}
+	fmt.Fprintln(w)
+	pairs, aa, large := 0, 0, 0
+	for i, row := range r.Contrast {
+		fmt.Fprintf(w, "  %3d", i)
+		for j, ratio := range row {
+			if i == j {
+				fmt.Fprintf(w, " %5s", "-")
+				continue
+			}
+			fmt.Fprintf(w, " %5.2f", ratio)
+			if j > i {
+				pairs++
+				if ratio >= palette.ContrastAA {
+					aa++
+				}
+				if ratio >= palette.ContrastAALarge {
+					large++
+				}
+			}
+		}
+		fmt.Fprintln(w)
+	}
+	fmt.Fprintf(w, "  %d of %d pairs pass AA (%.1f:1), %d pass AA for large text (%.0f:1)\n", aa, pairs, palette.ContrastAA, large, palette.ContrastAALarge)
+
+	printDuplicates := func(dups []duplicateReport, colors []string) {
+		if len(dups) == 0 {
+			fmt.Fprintln(w, "  none")
+		}
+		for _, d := range dups {
+			fmt.Fprintf(w, "  %3d %s and %3d %s: %.2f\n", d.A, colors[d.A], d.B, colors[d.B], d.DeltaE)
+		}
+	}
+	hexes := make([]string, len(r.Colors))
+	for i, c := range r.Colors {
+		hexes[i] = c.Hex
+	}
+	fmt.Fprintf(w, "\nnear duplicates (CIEDE2000 under %g):\n", threshold)
+	printDuplicates(r.Duplicates, hexes)
+	for _, v := range r.Visions {
+		fmt.Fprintf(w, "\n%s:\n ", v.Vision)
+		for i, c := range v.Colors {
+			if i > 0 && i%8 == 0 {
+				fmt.Fprint(w, "\n ")
+			}
+			fmt.Fprintf(w, " %s", c)
+		}
+		fmt.Fprintln(w, "\n  confused:")
+		printDuplicates(v.Duplicates, v.Colors)
+	}
+}
+
+// Image of a palette as each kind of vision sees it, a row of swatches each
+func visionSheet(p *palette.Palette) *image.NRGBA {
+	const cell = 32
+	img := image.NewNRGBA(image.Rect(0, 0, max(1, len(p.Colors))*cell, len(palette.VisionNames)*cell))
+	for v := range palette.VisionNames {
+		for i, c := range palette.Vision(v).SimulatePalette(p).Colors {
+			r := image.Rect(i*cell, v*cell, (i+1)*cell, (v+1)*cell)
+			draw.Draw(img, r, image.NewUniform(color.NRGBA{c.R, c.G, c.B, 255}), image.Point{}, draw.Src)
+		}
+	}
+	return img
+}
+
+// dd palette: palette tools
+func cmdPalette(args []string) int {
+	if len(args) > 0 && args[0] == "analyze" {
+		return cmdPaletteAnalyze(args[1:])
+	}
+	fmt.Fprintln(os.Stderr, "Usage: dd "+paletteUsage)
+	return 2
+}
+
+// dd palette analyze: contrast, near duplicates and colour-blind views
+func cmdPaletteAnalyze(args []string) int {
+	flags := flag.NewFlagSet("palette analyze", flag.ExitOnError)
+	jsonOutput := flags.Bool("json", false, "print the analysis as JSON")
+	threshold := flags.Float64("delta", defaultDuplicateDelta, "CIEDE2000 difference under which colours are near duplicates")
+	sheet := flags.String("png", "", "also write the palette as each kind of vision sees it to this `file`")
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: dd "+paletteUsage)
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return 2
+	}
+
+	p, err := namedPalette(flags.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "dd: %v\n", err)
+		return 1
+	}
+	if p.Name == "" {
+		p.Name = flags.Arg(0)
+	}
+	if *sheet != "" {
+		err := writeFileAtomic(*sheet, func(w io.Writer) error {
+			return encodeTaggedPNG(w, visionSheet(p), false)
+		})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "dd: %s: %v\n", *sheet, err)
+			return 1
+		}
+	}
+
+	report := analyzePalette(p, *threshold)
+	if *jsonOutput {
+		out := json.NewEncoder(os.Stdout)
+		out.SetIndent("", "  ")
+		if err := out.Encode(report); err != nil {
+			fmt.Fprintln(os.Stderr, "dd:", err)
+			return 1
+		}
+		return 0
+	}
+	printPaletteReport(os.Stdout, report, *threshold)
+	return 0
+}
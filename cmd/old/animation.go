@@ -0,0 +1,287 @@
+package main
+
+import (
+	"fmt"
+)
+
+// Animation frames. Every layer has a cel per frame; the layer's tiles
+// hold the cel of the current frame while it is being edited, and are
+// written back to the cel when another frame is shown. Frames that hold
+// the same Cel are linked: painting one changes them all.
+
+const (
+	defaultFrameDuration = 100 // milliseconds
+	maxFrameDuration     = 60000
+	maxFrames            = 10000
+)
+
+// One frame of the animation
+type Frame struct {
+	duration int // milliseconds
+}
+
+// Pixels of one layer in one or more (linked) frames
+type Cel struct {
+	tiles *TileStore
+}
+
+// The layer's cel in a frame; nil when the frame is empty
+func (l *Layer) cel(frame int) *Cel {
+	if frame < 0 || frame >= len(l.cels) {
+		return nil
+	}
+	return l.cels[frame]
+}
+
+// Set the layer's cel in a frame
+func (l *Layer) setCel(frame int, c *Cel) {
+	for len(l.cels) <= frame {
+		l.cels = append(l.cels, nil)
+	}
+	l.cels[frame] = c
+}
+
+// Write the layer pixels back to the cel of the frame being left. Empty
+// frames without a cel stay without one.
+func (l *Layer) storeCel(frame int) {
+	c := l.cel(frame)
+	if c == nil {
+		if l.tiles.TileCount() == 0 {
+			return
+		}
+		c = &Cel{}
+		l.setCel(frame, c)
+	}
+	c.tiles = l.Snapshot()
+}
+
+// Show a frame's cel in the layer
+func (l *Layer) loadCel(frame, width, height int) {
+	if c := l.cel(frame); c != nil {
+		l.SetStore(c.tiles.Clone())
+	} else {
+		l.SetStore(NewTileStore(width, height))
+	}
+}
+
+// Whether a frame's cel is shared with another frame
+func (l *Layer) linked(frame int) bool {
+	c := l.cel(frame)
+	if c == nil {
+		return false
+	}
+	for f, other := range l.cels {
+		if f != frame && other == c {
+			return true
+		}
+	}
+	return false
+}
+
+// Give a layer copies of another layer's cels, linked the same way
+func (l *Layer) copyCels(src *Layer) {
+	copies := make(map[*Cel]*Cel)
+	l.cels = nil
+	for f, c := range src.cels {
+		if c == nil {
+			continue
+		}
+		if copies[c] == nil {
+			copies[c] = &Cel{tiles: c.tiles.Clone()}
+		}
+		l.setCel(f, copies[c])
+	}
+}
+
+// Pixels of every layer in every frame, nil for empty frames. The current
+// frame comes from live, given the layer, and linked frames share a store.
+func (d *Document) celStores(live func(layer *Layer) *TileStore) [][]*TileStore {
+	stores := make([][]*TileStore, len(d.layers))
+	for i, layer := range d.layers {
+		stores[i] = make([]*TileStore, len(d.frames))
+		current := layer.cel(d.frame)
+		tiles := live(layer)
+		seen := make(map[*Cel]*TileStore)
+		for f := range d.frames {
+			c := layer.cel(f)
+			switch {
+			case f == d.frame || c != nil && c == current:
+				stores[i][f] = tiles
+			case c == nil:
+			case seen[c] != nil:
+				stores[i][f] = seen[c]
+			default:
+				stores[i][f] = c.tiles
+				seen[c] = c.tiles
+			}
+		}
+		if stores[i][0] == nil {
+			stores[i][0] = NewTileStore(d.canvasWidth, d.canvasHeight)
+		}
+	}
+	return stores
+}
+
+// Check whether two cel stores hold the same tiles; nil is empty
+func sameCel(a, b *TileStore) bool {
+	if a == nil || b == nil {
+		return (a == nil || a.TileCount() == 0) && (b == nil || b.TileCount() == 0)
+	}
+	return a.SameTiles(b)
+}
+
+// Frame durations as saved
+func (d *Document) frameData() []FrameData {
+	frames := make([]FrameData, len(d.frames))
+	for i, f := range d.frames {
+		frames[i] = FrameData{Duration: f.duration}
+	}
+	return frames
+}
+
+// Frame structure, for telling whether frames were added, removed, linked
+// or retimed: the durations, then for each layer and frame the first frame
+// sharing its cel
+func (d *Document) frameLayout() []int {
+	var layout []int
+	for _, f := range d.frames {
+		layout = append(layout, f.duration)
+	}
+	for _, layer := range d.layers {
+		for f := range d.frames {
+			first := f
+			if c := layer.cel(f); c != nil {
+				for g := 0; g < f; g++ {
+					if layer.cel(g) == c {
+						first = g
+						break
+					}
+				}
+			}
+			layout = append(layout, first)
+		}
+	}
+	return layout
+}
+
+// Show another frame
+func (d *Document) SetFrame(frame int) {
+	if frame < 0 || frame >= len(d.frames) || frame == d.frame {
+		return
+	}
+	for _, layer := range d.layers {
+		layer.storeCel(d.frame)
+		layer.loadCel(frame, d.canvasWidth, d.canvasHeight)
+		d.compositor.MarkLayer(layer)
+	}
+	d.frame = frame
+}
+
+// Show a frame without keeping the current one, which is gone
+func (d *Document) reloadFrame(frame int) {
+	d.frame = frame
+	for _, layer := range d.layers {
+		layer.loadCel(frame, d.canvasWidth, d.canvasHeight)
+		d.compositor.MarkLayer(layer)
+	}
+}
+
+// Renumber undo steps after frames were inserted or removed at frame.
+// Steps in a removed frame can no longer be undone.
+func (d *Document) shiftHistory(frame, delta int) {
+	for i := range d.history {
+		action := &d.history[i]
+		switch {
+		case delta < 0 && action.frame == frame:
+			action.frame = -1
+		case action.frame >= frame:
+			action.frame += delta
+		}
+	}
+}
+
+// Add a frame after the current one and show it. cel gives each layer's
+// cel in the new frame.
+func (app *App) insertFrame(cel func(layer *Layer) *Cel) {
+	if len(app.frames) >= maxFrames {
+		app.SetStatus(fmt.Sprintf("AT MOST %d FRAMES", maxFrames))
+		return
+	}
+	at := app.frame + 1
+	for _, layer := range app.layers {
+		layer.storeCel(app.frame)
+		c := cel(layer)
+		for len(layer.cels) < at {
+			layer.cels = append(layer.cels, nil)
+		}
+		layer.cels = append(layer.cels[:at], append([]*Cel{c}, layer.cels[at:]...)...)
+	}
+	app.frames = append(app.frames[:at], append([]Frame{app.frames[app.frame]}, app.frames[at:]...)...)
+	app.shiftHistory(at, 1)
+	app.reloadFrame(at)
+}
+
+// Add an empty frame after the current one
+func (app *App) NewFrame() {
+	app.insertFrame(func(layer *Layer) *Cel {
+		return nil
+	})
+}
+
+// Add a copy of the current frame after it
+func (app *App) DuplicateFrame() {
+	app.insertFrame(func(layer *Layer) *Cel {
+		if c := layer.cel(app.frame); c != nil {
+			return &Cel{tiles: c.tiles.Clone()}
+		}
+		return nil
+	})
+}
+
+// Add a frame after the current one whose cels are linked to it
+func (app *App) LinkedFrame() {
+	app.insertFrame(func(layer *Layer) *Cel {
+		c := layer.cel(app.frame)
+		if c == nil {
+			c = &Cel{tiles: NewTileStore(app.canvasWidth, app.canvasHeight)}
+			layer.setCel(app.frame, c)
+		}
+		return c
+	})
+}
+
+// Delete the current frame
+func (app *App) DeleteFrame() {
+	if len(app.frames) <= 1 {
+		app.SetStatus("CAN'T DELETE THE ONLY FRAME")
+		return
+	}
+	at := app.frame
+	for _, layer := range app.layers {
+		if at < len(layer.cels) {
+			layer.cels = append(layer.cels[:at], layer.cels[at+1:]...)
+		}
+	}
+	app.frames = append(app.frames[:at], app.frames[at+1:]...)
+	app.shiftHistory(at, -1)
+	app.reloadFrame(min(at, len(app.frames)-1))
+}
+
+// Give the active layer its own copy of a cel linked to other frames
+func (app *App) UnlinkCel() {
+	layer := app.layers[app.activeLayer]
+	if !layer.linked(app.frame) {
+		app.SetStatus("CEL IS NOT LINKED")
+		return
+	}
+	// The linked frames keep what was painted so far
+	layer.storeCel(app.frame)
+	layer.setCel(app.frame, &Cel{tiles: layer.Snapshot()})
+	app.SetStatus("UNLINKED " + layer.name)
+}
+
+// Change the current frame's duration
+func (app *App) AdjustFrameDuration(delta int) {
+	f := &app.frames[app.frame]
+	f.duration = max(10, min(f.duration+delta, maxFrameDuration))
+}
@@ -0,0 +1,398 @@
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/gif"
+	"io"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// Animated GIF and APNG export. Frames are whole canvas composites, either
+// the animation frames or every visible layer on its own.
+
+// APNG frame disposal, as stored in fcTL chunks
+const (
+	apngDisposeNone       = 0
+	apngDisposeBackground = 1
+	apngDisposePrevious   = 2
+)
+
+// APNG frame blending, as stored in fcTL chunks
+const (
+	apngBlendSource = 0
+	apngBlendOver   = 1
+)
+
+var disposeNames = []string{"none", "background", "previous"}
+var blendNames = []string{"source", "over"}
+
+// Choices for animated exports
+type AnimExportOptions struct {
+	LayerFrames bool // every visible layer as a frame, instead of the animation frames
+	Delay       int  // milliseconds per layer frame
+	Dither      bool // GIF: error diffusion to the palette
+	Plays       int  // times to play; 0 loops forever
+	Dispose     int  // APNG: what happens to a frame before the next
+	Blend       int  // APNG: how a frame goes over the one before
+	Cycle       bool // one loop of the palette's colour cycling over the first frame
+	Sequence    bool // numbered PNG files instead of one animation
+	ICC         bool // PNG: an embedded sRGB profile instead of the sRGB chunk
+}
+
+// A whole canvas image and how long it shows
+type animFrame struct {
+	img   *image.NRGBA
+	delay int // milliseconds
+}
+
+// Composite the frames to export from a project's layers and cels
+func animationFrames(project ProjectData, layers []*TileStore, cels [][]*TileStore, opts AnimExportOptions) []animFrame {
+	w, h := project.CanvasWidth, project.CanvasHeight
+	palette := projectColors(project)
+	mode := projectCompositing(project)
+	var frames []animFrame
+
+	if opts.LayerFrames {
+		for i, data := range project.Layers {
+			if !data.Visible || i >= len(layers) {
+				continue
+			}
+			layer := compositeStack{[]compositeLayer{{layers[i], data.Opacity, BlendNormal, palette}}, mode}
+			frames = append(frames, animFrame{compositeImage(layer, w, h), opts.Delay})
+		}
+		return frames
+	}
+
+	if opts.Cycle {
+		return cycleFrames(project, layers)
+	}
+	if cels == nil {
+		return []animFrame{{compositeImage(compositeLayers(project.Layers, layers, palette, mode), w, h), defaultFrameDuration}}
+	}
+	empty := NewTileStore(w, h)
+	for f := range max(1, len(project.Frames)) {
+		stores := make([]*TileStore, len(cels))
+		for i := range cels {
+			stores[i] = empty
+			if f < len(cels[i]) && cels[i][f] != nil {
+				stores[i] = cels[i][f]
+			}
+		}
+		delay := defaultFrameDuration
+		if f < len(project.Frames) {
+			delay = project.Frames[f].Duration
+		}
+		frames = append(frames, animFrame{compositeImage(compositeLayers(project.Layers, stores, palette, mode), w, h), delay})
+	}
+	return frames
+}
+
+// Write frames as an animated GIF or APNG, chosen by the file extension,
+// or as a PNG sequence. GIFs use the project's palette.
+func writeAnimation(filename string, frames []animFrame, project ProjectData, opts AnimExportOptions) error {
+	if len(frames) == 0 {
+		return fmt.Errorf("no frames to export")
+	}
+	if opts.Sequence {
+		return writeFrameSequence(filename, frames, opts.ICC)
+	}
+	return writeFileAtomic(filename, func(w io.Writer) error {
+		if strings.EqualFold(filepath.Ext(filename), ".gif") {
+			return encodeGIF(w, frames, project.Palette, gifTransparent(project), opts)
+		}
+		return encodeAPNG(w, frames, opts)
+	})
+}
+
+// Write each frame as a PNG named after the file, name_0001.png and on.
+// Frame delays are lost.
+func writeFrameSequence(filename string, frames []animFrame, icc bool) error {
+	base := strings.TrimSuffix(filename, filepath.Ext(filename))
+	for i, f := range frames {
+		err := writeFileAtomic(fmt.Sprintf("%s_%04d.png", base, i+1), func(w io.Writer) error {
+			return encodeTaggedPNG(w, f.img, icc)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Export the current document's animation, as set by the export options
+func (app *App) ExportAnimation(filename string) error {
+	snap := app.snapshotProject(app.Document)
+	frames := animationFrames(snap.project, snap.layers, snap.cels, app.exportOptions)
+	return writeAnimation(filename, frames, snap.project, app.exportOptions)
+}
+
+// Entry of a project's palette that clear pixels show, for GIFs; -1 if
+// the project is truecolour and its palette has none
+func gifTransparent(project ProjectData) int {
+	if project.ColorMode == colorModeIndexed {
+		return project.Transparent
+	}
+	return -1
+}
+
+// Encode frames as a GIF in the given palette. Pixels less than half
+// opaque become the transparent entry, or one added after the palette if
+// transparent is -1.
+func encodeGIF(w io.Writer, frames []animFrame, palette []ColorData, transparent int, opts AnimExportOptions) error {
+	if len(palette) == 0 {
+		return fmt.Errorf("the project has no palette")
+	}
+	if transparent >= len(palette) {
+		return fmt.Errorf("transparent entry %d of %d colors", transparent, len(palette))
+	}
+	if transparent < 0 && len(palette) > 255 {
+		return fmt.Errorf("palette has %d colors and no transparent entry, GIF allows 256 in all", len(palette))
+	}
+	pal := make(color.Palette, len(palette), len(palette)+1)
+	for i, c := range palette {
+		pal[i] = color.NRGBA{c.R, c.G, c.B, 255}
+	}
+	if transparent < 0 {
+		transparent = len(pal)
+		pal = append(pal, nil)
+	}
+	pal[transparent] = color.NRGBA{}
+
+	bounds := frames[0].img.Bounds()
+	anim := &gif.GIF{
+		Config:    image.Config{ColorModel: pal, Width: bounds.Dx(), Height: bounds.Dy()},
+		LoopCount: gifLoopCount(opts.Plays),
+	}
+	for _, f := range frames {
+		anim.Image = append(anim.Image, quantizeNRGBA(f.img, pal, transparent, opts.Dither))
+		// Browsers slow delays under 2 centiseconds down to 10
+		anim.Delay = append(anim.Delay, max(2, (f.delay+5)/10))
+		anim.Disposal = append(anim.Disposal, gif.DisposalBackground)
+	}
+	return gif.EncodeAll(w, anim)
+}
+
+// GIF loop count for a number of plays. GIF counts repeats after the
+// first play: 0 loops forever and -1 plays once, so one play can't be 0.
+func gifLoopCount(plays int) int {
+	switch {
+	case plays <= 0:
+		return 0
+	case plays == 1:
+		return -1
+	}
+	return plays - 1
+}
+
+// Map an image onto a palette with a transparent entry, optionally
+// spreading the error Floyd-Steinberg style over opaque pixels
+func quantizeNRGBA(img *image.NRGBA, pal color.Palette, transparent int, dither bool) *image.Paletted {
+	bounds := img.Bounds()
+	out := image.NewPaletted(bounds, pal)
+	opaque := slices.Delete(slices.Clone(pal), transparent, transparent+1)
+	nearest := make(map[[3]int32]uint8)
+
+	w := bounds.Dx()
+	errCur := make([][3]int32, w+2)
+	errNext := make([][3]int32, w+2)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			i := img.PixOffset(x, y)
+			if img.Pix[i+3] < 128 {
+				out.SetColorIndex(x, y, uint8(transparent))
+				continue
+			}
+
+			// Errors are kept in 1/16ths
+			e := errCur[x-bounds.Min.X+1]
+			var c [3]int32
+			for k := range c {
+				c[k] = int32(img.Pix[i+k])
+				if dither {
+					c[k] = max(0, c[k]+e[k]/16)
+					if c[k] > 255 {
+						c[k] = 255
+					}
+				}
+			}
+			index, ok := nearest[c]
+			if !ok {
+				k := opaque.Index(color.NRGBA{uint8(c[0]), uint8(c[1]), uint8(c[2]), 255})
+				if k >= transparent {
+					k++
+				}
+				index = uint8(k)
+				nearest[c] = index
+			}
+			out.SetColorIndex(x, y, index)
+
+			if dither {
+				r, g, b, _ := pal[index].RGBA()
+				got := [3]int32{int32(r >> 8), int32(g >> 8), int32(b >> 8)}
+				ex := x - bounds.Min.X + 1
+				for k := range c {
+					d := c[k] - got[k]
+					errCur[ex+1][k] += d * 7
+					errNext[ex-1][k] += d * 3
+					errNext[ex][k] += d * 5
+					errNext[ex+1][k] += d
+				}
+			}
+		}
+		errCur, errNext = errNext, errCur
+		clear(errNext)
+	}
+	return out
+}
+
+// Encode frames as an animated PNG, all full canvas frames in 8-bit RGBA.
+// Viewers without APNG support show the first frame.
+func encodeAPNG(w io.Writer, frames []animFrame, opts AnimExportOptions) error {
+	bounds := frames[0].img.Bounds()
+	if _, err := io.WriteString(w, "\x89PNG\r\n\x1a\n"); err != nil {
+		return err
+	}
+
+	ihdr := make([]byte, 13)
+	binary.BigEndian.PutUint32(ihdr[0:], uint32(bounds.Dx()))
+	binary.BigEndian.PutUint32(ihdr[4:], uint32(bounds.Dy()))
+	ihdr[8] = 8 // bit depth
+	ihdr[9] = 6 // RGBA
+	if err := writePNGChunk(w, "IHDR", ihdr); err != nil {
+		return err
+	}
+	if err := writePNGColorChunks(w, opts.ICC); err != nil {
+		return err
+	}
+	actl := make([]byte, 8)
+	binary.BigEndian.PutUint32(actl[0:], uint32(len(frames)))
+	binary.BigEndian.PutUint32(actl[4:], uint32(max(0, opts.Plays)))
+	if err := writePNGChunk(w, "acTL", actl); err != nil {
+		return err
+	}
+
+	// fcTL and fdAT chunks share one sequence
+	seq := uint32(0)
+	for i, f := range frames {
+		fctl := make([]byte, 26)
+		binary.BigEndian.PutUint32(fctl[0:], seq)
+		binary.BigEndian.PutUint32(fctl[4:], uint32(bounds.Dx()))
+		binary.BigEndian.PutUint32(fctl[8:], uint32(bounds.Dy()))
+		binary.BigEndian.PutUint16(fctl[20:], uint16(min(f.delay, 65535)))
+		binary.BigEndian.PutUint16(fctl[22:], 1000)
+		fctl[24] = byte(opts.Dispose)
+		fctl[25] = byte(opts.Blend)
+		if err := writePNGChunk(w, "fcTL", fctl); err != nil {
+			return err
+		}
+		seq++
+
+		data, err := pngImageData(f.img)
+		if err != nil {
+			return err
+		}
+		if i == 0 {
+			err = writePNGChunk(w, "IDAT", data)
+		} else {
+			err = writePNGChunk(w, "fdAT", append(binary.BigEndian.AppendUint32(nil, seq), data...))
+			seq++
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return writePNGChunk(w, "IEND", nil)
+}
+
+// Write one PNG chunk with its length and CRC
+func writePNGChunk(w io.Writer, kind string, data []byte) error {
+	buf := binary.BigEndian.AppendUint32(nil, uint32(len(data)))
+	buf = append(buf, kind...)
+	buf = append(buf, data...)
+	buf = binary.BigEndian.AppendUint32(buf, crc32.ChecksumIEEE(buf[4:]))
+	_, err := w.Write(buf)
+	return err
+}
+
+// Compressed RGBA scanlines of an image, each with the PNG filter that
+// leaves the smallest values
+func pngImageData(img *image.NRGBA) ([]byte, error) {
+	var buf bytes.Buffer
+	zw, err := zlib.NewWriterLevel(&buf, zlib.BestCompression)
+	if err != nil {
+		return nil, err
+	}
+	bounds := img.Bounds()
+	n := bounds.Dx() * 4
+	prev := make([]byte, n)
+	var rows [5][]byte
+	for i := range rows {
+		rows[i] = make([]byte, n+1)
+		rows[i][0] = byte(i)
+	}
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		cur := img.Pix[img.PixOffset(bounds.Min.X, y):][:n]
+		best, bestSum := 0, -1
+		for filter, row := range rows {
+			sum := 0
+			for i, v := range cur {
+				var left, upLeft byte
+				if i >= 4 {
+					left, upLeft = cur[i-4], prev[i-4]
+				}
+				up := prev[i]
+				switch filter {
+				case 1:
+					v -= left
+				case 2:
+					v -= up
+				case 3:
+					v -= byte((int(left) + int(up)) / 2)
+				case 4:
+					v -= paeth(left, up, upLeft)
+				}
+				row[i+1] = v
+				sum += absInt(int(int8(v)))
+			}
+			if bestSum < 0 || sum < bestSum {
+				best, bestSum = filter, sum
+			}
+		}
+		if _, err := zw.Write(rows[best]); err != nil {
+			return nil, err
+		}
+		prev = cur
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// PNG Paeth predictor
+func paeth(a, b, c byte) byte {
+	p := int(a) + int(b) - int(c)
+	pa, pb, pc := absInt(p-int(a)), absInt(p-int(b)), absInt(p-int(c))
+	switch {
+	case pa <= pb && pa <= pc:
+		return a
+	case pb <= pc:
+		return b
+	}
+	return c
+}
+
+func absInt(a int) int {
+	if a < 0 {
+		return -a
+	}
+	return a
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/png"
+	"slices"
+	"testing"
+)
+
+var testAnimPalette = []ColorData{{R: 255, A: 255}, {G: 255, A: 255}, {B: 255, A: 255}}
+
+// Frames of 4x3 pixels, each a palette colour with a transparent corner
+func testAnimFrames(delays ...int) []animFrame {
+	frames := make([]animFrame, len(delays))
+	for i, delay := range delays {
+		img := image.NewNRGBA(image.Rect(0, 0, 4, 3))
+		c := testAnimPalette[i%len(testAnimPalette)]
+		for y := range 3 {
+			for x := range 4 {
+				img.SetNRGBA(x, y, color.NRGBA{c.R, c.G, c.B, 255})
+			}
+		}
+		img.SetNRGBA(3, 2, color.NRGBA{})
+		frames[i] = animFrame{img, delay}
+	}
+	return frames
+}
+
+func TestGIFLoopCount(t *testing.T) {
+	for _, tc := range []struct{ plays, loops int }{
+		{0, 0}, {1, -1}, {2, 1}, {5, 4},
+	} {
+		if got := gifLoopCount(tc.plays); got != tc.loops {
+			t.Errorf("gifLoopCount(%d) = %d, want %d", tc.plays, got, tc.loops)
+		}
+	}
+}
+
+func TestEncodeGIFTransparentEntry(t *testing.T) {
+	// A full indexed palette keeps its own transparent entry
+	palette := make([]ColorData, 256)
+	for i := range palette {
+		palette[i] = ColorData{R: uint8(i), G: uint8(255 - i), B: 7, A: 255}
+	}
+	palette[1] = ColorData{R: 255, A: 255}
+	const transparent = 100
+	frames := testAnimFrames(100)
+	var buf bytes.Buffer
+	if err := encodeGIF(&buf, frames, palette, transparent, AnimExportOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	anim, err := gif.DecodeAll(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	img := anim.Image[0]
+	if len(img.Palette) != 256 {
+		t.Errorf("%d palette entries, want 256", len(img.Palette))
+	}
+	if got := img.ColorIndexAt(0, 0); got != 1 {
+		t.Errorf("red pixel has index %d, want 1", got)
+	}
+	if got := img.ColorIndexAt(3, 2); got != transparent {
+		t.Errorf("clear pixel has index %d, want %d", got, transparent)
+	}
+
+	// Without a transparent entry, one is added, if there is room
+	if err := encodeGIF(&buf, frames, palette, -1, AnimExportOptions{}); err == nil {
+		t.Error("256 colors and a transparent entry encoded")
+	}
+	if err := encodeGIF(&buf, frames, palette, 256, AnimExportOptions{}); err == nil {
+		t.Error("transparent entry past the palette encoded")
+	}
+}
+
+func TestEncodeGIF(t *testing.T) {
+	frames := testAnimFrames(100, 15, 40)
+	for _, plays := range []int{0, 1, 3} {
+		var buf bytes.Buffer
+		if err := encodeGIF(&buf, frames, testAnimPalette, -1, AnimExportOptions{Plays: plays}); err != nil {
+			t.Fatal(err)
+		}
+		anim, err := gif.DecodeAll(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if anim.LoopCount != gifLoopCount(plays) {
+			t.Errorf("%d plays: loop count %d, want %d", plays, anim.LoopCount, gifLoopCount(plays))
+		}
+		if len(anim.Image) != len(frames) {
+			t.Fatalf("%d frames, want %d", len(anim.Image), len(frames))
+		}
+		// Centiseconds, no shorter than 2
+		if want := []int{10, 2, 4}; !slices.Equal(anim.Delay, want) {
+			t.Errorf("delays %v, want %v", anim.Delay, want)
+		}
+		for i, d := range anim.Disposal {
+			if d != gif.DisposalBackground {
+				t.Errorf("frame %d disposal %d, want background", i, d)
+			}
+		}
+		for i, img := range anim.Image {
+			c := testAnimPalette[i%len(testAnimPalette)]
+			if got := color.NRGBAModel.Convert(img.At(0, 0)); got != (color.NRGBA{c.R, c.G, c.B, 255}) {
+				t.Errorf("frame %d pixel %v, want %v", i, got, c)
+			}
+			if _, _, _, a := img.At(3, 2).RGBA(); a != 0 {
+				t.Errorf("frame %d transparent pixel has alpha %d", i, a)
+			}
+		}
+	}
+}
+
+type pngChunk struct {
+	kind string
+	data []byte
+}
+
+// Split a PNG into its chunks, checking their CRCs
+func readPNGChunks(t *testing.T, data []byte) []pngChunk {
+	t.Helper()
+	if !bytes.HasPrefix(data, []byte("\x89PNG\r\n\x1a\n")) {
+		t.Fatal("no PNG signature")
+	}
+	var chunks []pngChunk
+	for data = data[8:]; len(data) > 0; {
+		if len(data) < 12 {
+			t.Fatal("truncated chunk")
+		}
+		n := int(binary.BigEndian.Uint32(data))
+		if len(data) < 12+n {
+			t.Fatal("truncated chunk")
+		}
+		if crc32.ChecksumIEEE(data[4:8+n]) != binary.BigEndian.Uint32(data[8+n:]) {
+			t.Fatalf("bad CRC on %s chunk", data[4:8])
+		}
+		chunks = append(chunks, pngChunk{string(data[4:8]), data[8 : 8+n]})
+		data = data[12+n:]
+	}
+	return chunks
+}
+
+// Decode one APNG frame by giving its image data a PNG of its own
+func decodeAPNGFrame(t *testing.T, ihdr, data []byte) image.Image {
+	t.Helper()
+	var buf bytes.Buffer
+	buf.WriteString("\x89PNG\r\n\x1a\n")
+	for _, c := range []pngChunk{{"IHDR", ihdr}, {"IDAT", data}, {"IEND", nil}} {
+		if err := writePNGChunk(&buf, c.kind, c.data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	img, err := png.Decode(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return img
+}
+
+func TestEncodeAPNG(t *testing.T) {
+	frames := testAnimFrames(100, 15, 40)
+	opts := AnimExportOptions{Plays: 2, Dispose: apngDisposePrevious, Blend: apngBlendOver}
+	var buf bytes.Buffer
+	if err := encodeAPNG(&buf, frames, opts); err != nil {
+		t.Fatal(err)
+	}
+	chunks := readPNGChunks(t, buf.Bytes())
+
+	var kinds []string
+	for _, c := range chunks {
+		kinds = append(kinds, c.kind)
+	}
+	want := []string{"IHDR", "sRGB", "gAMA", "cHRM", "acTL", "fcTL", "IDAT", "fcTL", "fdAT", "fcTL", "fdAT", "IEND"}
+	if !slices.Equal(kinds, want) {
+		t.Fatalf("chunks %v, want %v", kinds, want)
+	}
+
+	actl := chunks[4].data
+	if n := binary.BigEndian.Uint32(actl); n != 3 {
+		t.Errorf("acTL has %d frames, want 3", n)
+	}
+	if n := binary.BigEndian.Uint32(actl[4:]); n != 2 {
+		t.Errorf("acTL has %d plays, want 2", n)
+	}
+
+	// fcTL and fdAT share one sequence from 0 with no gaps; IDAT has none
+	seq := uint32(0)
+	frame := 0
+	for _, c := range chunks {
+		switch c.kind {
+		case "fcTL":
+			if got := binary.BigEndian.Uint32(c.data); got != seq {
+				t.Errorf("fcTL of frame %d has sequence %d, want %d", frame, got, seq)
+			}
+			seq++
+			if w, h := binary.BigEndian.Uint32(c.data[4:]), binary.BigEndian.Uint32(c.data[8:]); w != 4 || h != 3 {
+				t.Errorf("frame %d is %dx%d, want 4x3", frame, w, h)
+			}
+			num, den := binary.BigEndian.Uint16(c.data[20:]), binary.BigEndian.Uint16(c.data[22:])
+			if int(num) != frames[frame].delay || den != 1000 {
+				t.Errorf("frame %d delay %d/%d, want %d/1000", frame, num, den, frames[frame].delay)
+			}
+			if c.data[24] != apngDisposePrevious || c.data[25] != apngBlendOver {
+				t.Errorf("frame %d dispose %d blend %d, want %d and %d", frame, c.data[24], c.data[25], apngDisposePrevious, apngBlendOver)
+			}
+		case "IDAT", "fdAT":
+			data := c.data
+			if c.kind == "fdAT" {
+				if got := binary.BigEndian.Uint32(data); got != seq {
+					t.Errorf("fdAT of frame %d has sequence %d, want %d", frame, got, seq)
+				}
+				seq++
+				data = data[4:]
+			}
+			img := decodeAPNGFrame(t, chunks[0].data, data)
+			c := testAnimPalette[frame%len(testAnimPalette)]
+			if got := color.NRGBAModel.Convert(img.At(0, 0)); got != (color.NRGBA{c.R, c.G, c.B, 255}) {
+				t.Errorf("frame %d pixel %v, want %v", frame, got, c)
+			}
+			if got := color.NRGBAModel.Convert(img.At(3, 2)); got != (color.NRGBA{}) {
+				t.Errorf("frame %d transparent pixel is %v", frame, got)
+			}
+			frame++
+		}
+	}
+	if seq != 5 {
+		t.Errorf("sequence ends at %d, want 5", seq)
+	}
+}
+
+func TestEncodeAPNGProfile(t *testing.T) {
+	var buf bytes.Buffer
+	if err := encodeAPNG(&buf, testAnimFrames(100), AnimExportOptions{ICC: true}); err != nil {
+		t.Fatal(err)
+	}
+	chunks := readPNGChunks(t, buf.Bytes())
+	if chunks[1].kind != "iCCP" {
+		t.Fatalf("chunk after IHDR is %s, want iCCP", chunks[1].kind)
+	}
+	if n := binary.BigEndian.Uint32(chunks[4].data[4:]); n != 0 {
+		t.Errorf("acTL has %d plays, want 0 for forever", n)
+	}
+}
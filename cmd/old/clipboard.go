@@ -0,0 +1,215 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/draw"
+	"os"
+	"path/filepath"
+	"strings"
+
+	rl "github.com/gen2brain/raylib-go/raylib"
+)
+
+// Pixels cut or copied in the editor. The clipboard is shared by all
+// documents and kept apart from the system clipboard, which only carries
+// DPF icon text into the editor. It always holds colours; indexed
+// documents map them to their palette when pasting.
+type clipboard struct {
+	img   *image.NRGBA // bounds are where the pixels came from on the canvas
+	name  string
+	layer *LayerData // properties, when a whole layer was copied
+	text  string     // system clipboard text at copy time
+}
+
+// The part of the canvas cut and copy work on: the selection, or the
+// whole canvas
+func (app *App) copyRect() image.Rectangle {
+	if r := app.selection.Intersect(app.canvasBounds()); !r.Empty() {
+		return r
+	}
+	return app.canvasBounds()
+}
+
+// Copy of the pixels of img inside r, keeping r as the bounds
+func cropNRGBA(img *image.NRGBA, r image.Rectangle) *image.NRGBA {
+	out := image.NewNRGBA(r)
+	draw.Draw(out, r, img, r.Min, draw.Src)
+	return out
+}
+
+// Remember pixels as the clipboard contents
+func (app *App) setClipboard(img *image.NRGBA, name string, layer *LayerData) {
+	app.clip = &clipboard{img: img, name: name, layer: layer, text: rl.GetClipboardText()}
+}
+
+// Copy the selection, or the whole layer, of the active layer
+func (app *App) Copy() {
+	layer := app.layers[app.activeLayer]
+	r := app.copyRect()
+	img := cropNRGBA(app.colorStore(layer.Snapshot()).ToNRGBA(), r)
+	if app.selection.Empty() {
+		data := layer.Data()
+		app.setClipboard(img, layer.name, &data)
+		app.SetStatus("COPIED LAYER " + layer.name)
+		return
+	}
+	app.setClipboard(img, layer.name, nil)
+	app.SetStatus(fmt.Sprintf("COPIED %dX%d", r.Dx(), r.Dy()))
+}
+
+// Copy the selection, or the whole canvas, as it looks with all visible
+// layers composed
+func (app *App) CopyMerged() {
+	r := app.copyRect()
+	app.setClipboard(cropNRGBA(app.compositor.Image(app.layers), r), "MERGED", nil)
+	app.SetStatus(fmt.Sprintf("COPIED MERGED %dX%d", r.Dx(), r.Dy()))
+}
+
+// Copy the selection, or the whole layer, and clear it
+func (app *App) Cut() {
+	layer := app.layers[app.activeLayer]
+	if layer.locked || layer.lockAlpha {
+		app.SetStatus("LAYER IS LOCKED")
+		return
+	}
+	app.Copy()
+	r := app.copyRect()
+	app.SaveLayerState(app.activeLayer, "cut")
+	app.editLayer(layer, func(img *image.NRGBA) {
+		draw.Draw(img, r, image.Transparent, image.Point{}, draw.Src)
+	})
+	if app.selection.Empty() {
+		app.SetStatus("CUT LAYER " + layer.name)
+	} else {
+		app.SetStatus(fmt.Sprintf("CUT %dX%d", r.Dx(), r.Dy()))
+	}
+}
+
+// Change a layer's pixels as a whole image. Tiles left unchanged stay
+// shared with the undo history.
+func (app *App) editLayer(layer *Layer, edit func(img *image.NRGBA)) {
+	store := layer.Snapshot()
+	img := store.ToNRGBA()
+	edit(img)
+	store.SetNRGBA(img)
+	layer.SetStore(store)
+	app.compositor.MarkLayer(layer)
+}
+
+// Paste as a new layer above the active one, where the pixels were copied
+// from. DPF icon text put on the system clipboard since the last copy is
+// pasted instead.
+func (app *App) Paste() {
+	text := rl.GetClipboardText()
+	if strings.Contains(text, "BITMAP") && (app.clip == nil || text != app.clip.text) {
+		app.PasteDPF(text)
+		return
+	}
+	if app.clip == nil {
+		app.SetStatus("NOTHING TO PASTE")
+		return
+	}
+	app.pasteAsLayer(app.clip.img, app.clip.name, app.clip.layer)
+}
+
+// Add pixels as a new layer at their bounds
+func (app *App) pasteAsLayer(img *image.NRGBA, name string, props *LayerData) {
+	canvas := image.NewNRGBA(app.canvasBounds())
+	draw.Draw(canvas, img.Bounds(), img, img.Bounds().Min, draw.Src)
+
+	data := LayerData{Name: name + " PASTE", Visible: true, Opacity: 1}
+	if props != nil {
+		data = *props
+	}
+	app.PasteLayer(data, app.indexStore(TileStoreFromNRGBA(canvas)))
+	app.selection = img.Bounds().Intersect(app.canvasBounds())
+	app.SetStatus("PASTED AS " + data.Name)
+}
+
+// Paste into the active layer, where the pixels were copied from
+func (app *App) PasteInPlace() {
+	if app.clip == nil {
+		app.SetStatus("NOTHING TO PASTE")
+		return
+	}
+	layer := app.layers[app.activeLayer]
+	if layer.locked {
+		app.SetStatus("LAYER IS LOCKED")
+		return
+	}
+	src := app.clip.img
+	if app.palette != nil {
+		src = cropNRGBA(src, src.Bounds())
+		app.palette.indexImage(src)
+	}
+	app.SaveLayerState(app.activeLayer, "paste")
+	app.editLayer(layer, func(img *image.NRGBA) {
+		r := src.Bounds().Intersect(img.Bounds())
+		if !layer.lockAlpha {
+			draw.Draw(img, r, src, r.Min, draw.Over)
+			return
+		}
+
+		// Keep the layer's alpha, as painting does
+		alpha := cropNRGBA(img, r)
+		draw.Draw(img, r, src, r.Min, draw.Over)
+		for y := r.Min.Y; y < r.Max.Y; y++ {
+			for x := r.Min.X; x < r.Max.X; x++ {
+				img.Pix[img.PixOffset(x, y)+3] = alpha.Pix[alpha.PixOffset(x, y)+3]
+			}
+		}
+	})
+	app.selection = src.Bounds().Intersect(app.canvasBounds())
+	app.SetStatus("PASTED INTO " + layer.name)
+}
+
+// Place pixels from outside the document at the top left of the view,
+// kept on the canvas where they fit
+func (app *App) viewPlacement(img *image.NRGBA) *image.NRGBA {
+	x, y := app.ScreenToCanvas(leftPanel, topBar)
+	x = max(0, min(x, app.canvasWidth-img.Bounds().Dx()))
+	y = max(0, min(y, app.canvasHeight-img.Bounds().Dy()))
+	out := image.NewNRGBA(img.Bounds().Sub(img.Bounds().Min).Add(image.Pt(x, y)))
+	draw.Draw(out, out.Bounds(), img, img.Bounds().Min, draw.Src)
+	return out
+}
+
+// Paste DPF icon text as a new layer. Bare bitmaps use the current palette,
+// keyed in dpfKeys order, or an indexed document's keys.
+func (app *App) PasteDPF(text string) {
+	palette, err := dpfPalette(app.colorPalette)
+	if app.palette != nil {
+		palette, err = app.palette.colors, nil
+	}
+	if err != nil {
+		app.SetStatus(fmt.Sprintf("PASTE FAILED: %v", err))
+		return
+	}
+	icon, palette, err := readDPFIcon(text, palette)
+	if err != nil {
+		app.SetStatus(fmt.Sprintf("PASTE FAILED: %v", err))
+		return
+	}
+	name := strings.ToUpper(icon.name)
+	if name == "" {
+		name = "ICON"
+	}
+	app.pasteAsLayer(app.viewPlacement(icon.Image(palette)), name, nil)
+}
+
+// Paste a PNG file as a new layer
+func (app *App) PastePNG(path string) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		app.SetStatus(fmt.Sprintf("PASTE FAILED: %v", err))
+		return
+	}
+	img, err := decodeImage(data)
+	if err != nil {
+		app.SetStatus(fmt.Sprintf("PASTE FAILED: %v", err))
+		return
+	}
+	name := strings.ToUpper(strings.TrimSuffix(filepath.Base(path), filepath.Ext(path)))
+	app.pasteAsLayer(app.viewPlacement(img), name, nil)
+}
@@ -0,0 +1,760 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+
+	rl "github.com/gen2brain/raylib-go/raylib"
+	"github.com/ha1tch/deluxedraw/palette"
+)
+
+// The colour editor: a picker for the colour being painted, with an HSV
+// wheel and square, RGB, HSL and OKLCH sliders, hex entry and alpha, beside
+// the list of colours to add, remove, move, name and key, and a generator
+// of ramps between two of them.
+
+const (
+	editorRows       = 22   // entries listed at once
+	wheelOuter       = 95   // radius of the hue ring
+	wheelInner       = 78   // radius of its hole
+	squareHalf       = 52   // half the saturation and value square, within the hole
+	okChroma         = 0.33 // OKLCH chroma at the end of its slider, about the most sRGB reaches
+	maxRampSteps     = 16
+	maxRampShift     = 90
+	defaultRampSteps = 4
+)
+
+// Labels of the editor's sliders: RGB, HSL, OKLCH and alpha
+var sliderLabels = []string{"R", "G", "B", "HUE", "SAT", "LUM", "OK L", "OK C", "OK H", "ALPHA"}
+
+// Text fields of the colour editor
+const (
+	fieldNone = iota
+	fieldHex
+	fieldName
+	fieldKey
+)
+
+// What the mouse is dragging in the colour editor; slider k is dragSlider+k
+const (
+	dragNone = iota
+	dragHue
+	dragSquare
+	dragSlider
+)
+
+// State of the colour editor
+type colorEditor struct {
+	box       rl.Rectangle
+	buttons   []Button   // ADD, DEL, UP, DOWN, FROM, TO, STEPS -, +, SHIFT -, +, ADD RAMP, DONE, ANALYZE, ORDER, SORT, KEYS, PIXELS
+	entry     int        // current colour being edited in truecolour documents, -1 for none
+	hsv       [3]float64 // picker position, kept so greys do not lose their hue
+	drag      int
+	field     int
+	text      string
+	scroll    int
+	rampFrom  int
+	rampTo    int
+	rampSteps int     // colours between the ends
+	rampShift float64 // degrees the hue bends midway
+	sortOrder palette.Order
+	keysStay  bool // sorting leaves each entry its key
+	recolor   bool // sorting leaves pixels their indices
+}
+
+// Open the colour editor on the colour being painted
+func (app *App) OpenColorEditor() {
+	ed := &colorEditor{
+		box:       rl.Rectangle{X: screenWidth/2 - 320, Y: screenHeight/2 - 220, Width: 640, Height: 440},
+		entry:     slices.Index(app.colorPalette, app.currentColor),
+		rampSteps: defaultRampSteps,
+	}
+	x, y := ed.box.X, ed.box.Y
+	ed.buttons = []Button{
+		{rect: rl.Rectangle{X: x + 440, Y: y + 312, Width: 44, Height: 16}, text: "ADD"},
+		{rect: rl.Rectangle{X: x + 488, Y: y + 312, Width: 44, Height: 16}, text: "DEL"},
+		{rect: rl.Rectangle{X: x + 536, Y: y + 312, Width: 44, Height: 16}, text: "UP"},
+		{rect: rl.Rectangle{X: x + 584, Y: y + 312, Width: 46, Height: 16}, text: "DOWN"},
+		{rect: rl.Rectangle{X: x + 10, Y: y + 298, Width: 40, Height: 16}, text: "FROM"},
+		{rect: rl.Rectangle{X: x + 76, Y: y + 298, Width: 40, Height: 16}, text: "TO"},
+		{rect: rl.Rectangle{X: x + 180, Y: y + 298, Width: 16, Height: 16}, text: "-"},
+		{rect: rl.Rectangle{X: x + 222, Y: y + 298, Width: 16, Height: 16}, text: "+"},
+		{rect: rl.Rectangle{X: x + 310, Y: y + 298, Width: 16, Height: 16}, text: "-"},
+		{rect: rl.Rectangle{X: x + 362, Y: y + 298, Width: 16, Height: 16}, text: "+"},
+		{rect: rl.Rectangle{X: x + 10, Y: y + 356, Width: 80, Height: 18}, text: "ADD RAMP"},
+		{rect: rl.Rectangle{X: x + 550, Y: y + 410, Width: 80, Height: 20}, text: "DONE"},
+		{rect: rl.Rectangle{X: x + 460, Y: y + 410, Width: 80, Height: 20}, text: "ANALYZE"},
+		{rect: rl.Rectangle{X: x + 440, Y: y + 374, Width: 92, Height: 14}},
+		{rect: rl.Rectangle{X: x + 536, Y: y + 374, Width: 94, Height: 14}, text: "SORT"},
+		{rect: rl.Rectangle{X: x + 440, Y: y + 392, Width: 92, Height: 14}},
+		{rect: rl.Rectangle{X: x + 536, Y: y + 392, Width: 94, Height: 14}},
+	}
+	entry := app.editorEntry(ed)
+	ed.rampFrom, ed.rampTo = max(0, entry), max(0, entry)
+	ed.pickerTo(app.editedColor(ed))
+	ed.showEntry(entry)
+	app.colorEditor = ed
+}
+
+// Colour being edited: an entry of the list, or the current colour alone
+func (app *App) editorEntry(ed *colorEditor) int {
+	if app.palette != nil {
+		return app.currentIndex
+	}
+	if ed.entry >= len(app.colorPalette) {
+		ed.entry = -1
+	}
+	return ed.entry
+}
+
+// The colour the editor shows
+func (app *App) editedColor(ed *colorEditor) rl.Color {
+	if i := app.editorEntry(ed); i >= 0 {
+		return app.swatches()[i]
+	}
+	return app.currentColor
+}
+
+// Change the colour being edited, and paint with it
+func (app *App) setEditedColor(ed *colorEditor, c rl.Color) {
+	if i := app.editorEntry(ed); i >= 0 {
+		app.SetColor(i, c)
+	}
+	if app.palette == nil {
+		app.currentColor = c
+	}
+}
+
+// Edit colour i of the list
+func (app *App) selectEditorEntry(ed *colorEditor, i int) {
+	if app.palette != nil {
+		app.selectIndex(i)
+	} else {
+		ed.entry = i
+		app.currentColor = app.colorPalette[i]
+	}
+	ed.showEntry(i)
+}
+
+// Scroll the list so entry i shows
+func (ed *colorEditor) showEntry(i int) {
+	if i < 0 {
+		return
+	}
+	if i < ed.scroll {
+		ed.scroll = i
+	}
+	if i >= ed.scroll+editorRows {
+		ed.scroll = i - editorRows + 1
+	}
+}
+
+// Colour as the palette package holds it
+func paletteColor(c rl.Color) palette.Color {
+	return palette.Color{R: c.R, G: c.G, B: c.B, A: c.A}
+}
+
+// Colour from the palette package with alpha a
+func rlColor(c palette.Color, a uint8) rl.Color {
+	return rl.Color{c.R, c.G, c.B, a}
+}
+
+// "#RRGGBB", with alpha after it unless opaque
+func colorHex(c rl.Color) string {
+	if c.A == 255 {
+		return fmt.Sprintf("#%02X%02X%02X", c.R, c.G, c.B)
+	}
+	return fmt.Sprintf("#%02X%02X%02X%02X", c.R, c.G, c.B, c.A)
+}
+
+// Parse "#RGB", "#RRGGBB" or "#RRGGBBAA", the # optional. Colours without
+// alpha keep the given one.
+func parseColorHex(text string, alpha uint8) (rl.Color, bool) {
+	text = strings.TrimPrefix(strings.TrimSpace(text), "#")
+	if len(text) == 3 {
+		text = string([]byte{text[0], text[0], text[1], text[1], text[2], text[2]})
+	}
+	if len(text) != 6 && len(text) != 8 {
+		return rl.Color{}, false
+	}
+	v, err := strconv.ParseUint(text, 16, 32)
+	if err != nil {
+		return rl.Color{}, false
+	}
+	if len(text) == 6 {
+		return rl.Color{uint8(v >> 16), uint8(v >> 8), uint8(v), alpha}, true
+	}
+	return rl.Color{uint8(v >> 24), uint8(v >> 16), uint8(v >> 8), uint8(v)}, true
+}
+
+// Move the picker to a colour, keeping its hue for greys and its
+// saturation for black
+func (ed *colorEditor) pickerTo(c rl.Color) {
+	shown := palette.FromHSV(ed.hsv[0], ed.hsv[1], ed.hsv[2])
+	if shown.R == c.R && shown.G == c.G && shown.B == c.B {
+		return
+	}
+	h, s, v := paletteColor(c).HSV()
+	if s == 0 {
+		h = ed.hsv[0]
+	}
+	if v == 0 {
+		s = ed.hsv[1]
+	}
+	ed.hsv = [3]float64{h, s, v}
+}
+
+// Centre of the hue ring
+func (ed *colorEditor) wheelCenter() rl.Vector2 {
+	return rl.Vector2{X: ed.box.X + 110, Y: ed.box.Y + 140}
+}
+
+// Saturation and value square inside the ring
+func (ed *colorEditor) squareRect() rl.Rectangle {
+	c := ed.wheelCenter()
+	return rl.Rectangle{X: c.X - squareHalf, Y: c.Y - squareHalf, Width: 2 * squareHalf, Height: 2 * squareHalf}
+}
+
+// Slider k, in groups of three
+func (ed *colorEditor) sliderRect(k int) rl.Rectangle {
+	return rl.Rectangle{X: ed.box.X + 270, Y: ed.box.Y + float32(40+k*18+k/3*6), Width: 140, Height: 12}
+}
+
+// Text field f
+func (ed *colorEditor) fieldRect(f int) rl.Rectangle {
+	x, y := ed.box.X, ed.box.Y
+	switch f {
+	case fieldHex:
+		return rl.Rectangle{X: x + 270, Y: y + 246, Width: 70, Height: 14}
+	case fieldName:
+		return rl.Rectangle{X: x + 476, Y: y + 336, Width: 154, Height: 14}
+	default:
+		return rl.Rectangle{X: x + 476, Y: y + 354, Width: 20, Height: 14}
+	}
+}
+
+// Row r of the entry list
+func (ed *colorEditor) rowRect(r int) rl.Rectangle {
+	return rl.Rectangle{X: ed.box.X + 440, Y: ed.box.Y + float32(40+r*12), Width: 190, Height: 11}
+}
+
+// Strip showing the ramp
+func (ed *colorEditor) rampRect() rl.Rectangle {
+	return rl.Rectangle{X: ed.box.X + 10, Y: ed.box.Y + 322, Width: 400, Height: 24}
+}
+
+// Hue, saturation and lightness, greys taking the picker's hue
+func (ed *colorEditor) hsl(c rl.Color) (h, s, l float64) {
+	h, s, l = paletteColor(c).HSL()
+	if s == 0 {
+		h = ed.hsv[0]
+	}
+	return h, s, l
+}
+
+// OKLCH, greys taking the hue of the picker's
+func (ed *colorEditor) lch(c rl.Color) palette.OKLCH {
+	lch := paletteColor(c).OKLab().LCH()
+	if lch.C == 0 {
+		lch.H = palette.FromHSV(ed.hsv[0], 1, 1).OKLab().LCH().H
+	}
+	return lch
+}
+
+// Position of slider k for a colour, 0-1
+func (ed *colorEditor) sliderValue(k int, c rl.Color) float64 {
+	h, s, l := ed.hsl(c)
+	lch := ed.lch(c)
+	switch k {
+	case 0:
+		return float64(c.R) / 255
+	case 1:
+		return float64(c.G) / 255
+	case 2:
+		return float64(c.B) / 255
+	case 3:
+		return h
+	case 4:
+		return s
+	case 5:
+		return l
+	case 6:
+		return lch.L
+	case 7:
+		return math.Min(lch.C/okChroma, 1)
+	case 8:
+		return lch.H / 360
+	default:
+		return float64(c.A) / 255
+	}
+}
+
+// Colour with slider k moved to v, 0-1
+func (ed *colorEditor) withSlider(k int, c rl.Color, v float64) rl.Color {
+	u := uint8(math.Round(v * 255))
+	switch k {
+	case 0:
+		c.R = u
+	case 1:
+		c.G = u
+	case 2:
+		c.B = u
+	case 3, 4, 5:
+		h, s, l := ed.hsl(c)
+		hsl := []*float64{&h, &s, &l}
+		*hsl[k-3] = v
+		return rlColor(palette.FromHSL(h, s, l), c.A)
+	case 6, 7, 8:
+		lch := ed.lch(c)
+		switch k {
+		case 6:
+			lch.L = v
+		case 7:
+			lch.C = v * okChroma
+		default:
+			lch.H = v * 360
+		}
+		return rlColor(lch.OKLab().Color(), c.A)
+	default:
+		c.A = u
+	}
+	return c
+}
+
+// Slider k's value as shown beside it
+func sliderText(k int, v float64) string {
+	switch k {
+	case 3, 8:
+		return fmt.Sprintf("%.0f", v*360)
+	case 4, 5, 6:
+		return fmt.Sprintf("%.0f%%", v*100)
+	case 7:
+		return fmt.Sprintf("%.3f", v*okChroma)
+	default:
+		return fmt.Sprintf("%.0f", v*255)
+	}
+}
+
+// Colours of the ramp between the FROM and TO entries, both included
+func (app *App) editorRamp(ed *colorEditor) []rl.Color {
+	colors := app.swatches()
+	ed.rampFrom = max(0, min(ed.rampFrom, len(colors)-1))
+	ed.rampTo = max(0, min(ed.rampTo, len(colors)-1))
+	if len(colors) == 0 {
+		return nil
+	}
+	a, b := colors[ed.rampFrom], colors[ed.rampTo]
+	var ramp []rl.Color
+	for _, c := range palette.Ramp(paletteColor(a), paletteColor(b), ed.rampSteps+2, ed.rampShift) {
+		ramp = append(ramp, rl.Color{c.R, c.G, c.B, c.A})
+	}
+	return ramp
+}
+
+// Text a field starts with
+func (app *App) fieldText(ed *colorEditor, f int) string {
+	switch f {
+	case fieldHex:
+		return colorHex(app.editedColor(ed))
+	case fieldName:
+		return app.palette.colors[app.currentIndex].name
+	default:
+		return string(app.palette.colors[app.currentIndex].key)
+	}
+}
+
+// Whether a text field can be edited: names and keys belong to indexed
+// palettes
+func (app *App) fieldEnabled(f int) bool {
+	return f == fieldHex || app.palette != nil && app.currentIndex < len(app.palette.colors)
+}
+
+// Apply what was typed into the field being edited
+func (app *App) commitEditorField(ed *colorEditor) {
+	field, text := ed.field, ed.text
+	ed.field, ed.text = fieldNone, ""
+	switch field {
+	case fieldHex:
+		c := app.editedColor(ed)
+		if c, ok := parseColorHex(text, c.A); ok {
+			app.setEditedColor(ed, c)
+		} else {
+			app.SetStatus(fmt.Sprintf("NOT A HEX COLOR: %s", text))
+		}
+	case fieldName:
+		app.RenameColor(app.currentIndex, strings.TrimSpace(text))
+	case fieldKey:
+		if text == "" {
+			return
+		}
+		if err := app.SetColorKey(app.currentIndex, []rune(text)[0]); err != nil {
+			app.SetStatus(fmt.Sprintf("KEY NOT SET: %v", err))
+		}
+	}
+}
+
+// Handle typing into the field being edited
+func (app *App) updateEditorField(ed *colorEditor, mousePos rl.Vector2) {
+	limit := 24
+	if ed.field == fieldHex {
+		limit = 9
+	}
+	for ch := rl.GetCharPressed(); ch > 0; ch = rl.GetCharPressed() {
+		switch {
+		case ch < 32 || ch >= 127:
+		case ed.field == fieldKey:
+			ed.text = string(rune(ch))
+		case len(ed.text) < limit:
+			ed.text += string(rune(ch))
+		}
+	}
+	if (rl.IsKeyPressed(rl.KeyBackspace) || rl.IsKeyPressedRepeat(rl.KeyBackspace)) && len(ed.text) > 0 {
+		ed.text = ed.text[:len(ed.text)-1]
+	}
+	if rl.IsKeyPressed(rl.KeyEnter) || rl.IsKeyPressed(rl.KeyKpEnter) {
+		app.commitEditorField(ed)
+	}
+	if rl.IsMouseButtonPressed(rl.MouseLeftButton) && !rl.CheckCollisionPointRec(mousePos, ed.fieldRect(ed.field)) {
+		app.commitEditorField(ed)
+	}
+}
+
+// Move whatever is being dragged to the mouse
+func (app *App) dragEditor(ed *colorEditor, mousePos rl.Vector2) {
+	c := app.editedColor(ed)
+	switch {
+	case ed.drag == dragHue:
+		center := ed.wheelCenter()
+		angle := math.Atan2(float64(mousePos.Y-center.Y), float64(mousePos.X-center.X))
+		ed.hsv[0] = math.Mod(angle/(2*math.Pi)+1, 1)
+	case ed.drag == dragSquare:
+		sq := ed.squareRect()
+		ed.hsv[1] = float64(clamp((mousePos.X-sq.X)/sq.Width, 0, 1))
+		ed.hsv[2] = float64(clamp(1-(mousePos.Y-sq.Y)/sq.Height, 0, 1))
+	default:
+		k := ed.drag - dragSlider
+		rect := ed.sliderRect(k)
+		v := float64(clamp((mousePos.X-rect.X)/rect.Width, 0, 1))
+		if next := ed.withSlider(k, c, v); next != c {
+			app.setEditedColor(ed, next)
+		}
+		return
+	}
+	if next := rlColor(palette.FromHSV(ed.hsv[0], ed.hsv[1], ed.hsv[2]), c.A); next != c {
+		app.setEditedColor(ed, next)
+	}
+}
+
+// Handle input in the colour editor
+func (app *App) updateColorEditor(mousePos rl.Vector2) {
+	ed := app.colorEditor
+	if ed.drag != dragHue && ed.drag != dragSquare {
+		ed.pickerTo(app.editedColor(ed))
+	}
+	if ed.field != fieldNone {
+		app.updateEditorField(ed, mousePos)
+	}
+
+	n := app.colorCount()
+	if wheel := rl.GetMouseWheelMove(); wheel != 0 && rl.CheckCollisionPointRec(mousePos, rl.Rectangle{X: ed.box.X + 440, Y: ed.box.Y + 40, Width: 190, Height: editorRows * 12}) {
+		ed.scroll -= int(wheel) * 3
+	}
+	ed.scroll = max(0, min(ed.scroll, n-editorRows))
+
+	if ed.drag != dragNone {
+		if rl.IsMouseButtonDown(rl.MouseLeftButton) {
+			app.dragEditor(ed, mousePos)
+		} else {
+			ed.drag = dragNone
+		}
+		return
+	}
+	if !rl.IsMouseButtonPressed(rl.MouseLeftButton) {
+		return
+	}
+
+	// Picker and sliders
+	center := ed.wheelCenter()
+	dist := rl.Vector2Distance(mousePos, center)
+	switch {
+	case dist >= wheelInner && dist <= wheelOuter+4:
+		ed.drag = dragHue
+	case rl.CheckCollisionPointRec(mousePos, ed.squareRect()):
+		ed.drag = dragSquare
+	}
+	for k := range sliderLabels {
+		if rl.CheckCollisionPointRec(mousePos, ed.sliderRect(k)) {
+			ed.drag = dragSlider + k
+		}
+	}
+	if ed.drag != dragNone {
+		app.dragEditor(ed, mousePos)
+		return
+	}
+
+	for r := range editorRows {
+		if i := ed.scroll + r; i < n && rl.CheckCollisionPointRec(mousePos, ed.rowRect(r)) {
+			app.selectEditorEntry(ed, i)
+			return
+		}
+	}
+	for _, f := range []int{fieldHex, fieldName, fieldKey} {
+		if f != ed.field && app.fieldEnabled(f) && rl.CheckCollisionPointRec(mousePos, ed.fieldRect(f)) {
+			ed.field, ed.text = f, app.fieldText(ed, f)
+			return
+		}
+	}
+
+	entry := app.editorEntry(ed)
+	for i, btn := range ed.buttons {
+		hidden := (i == 15 || i == 16) && app.palette == nil
+		if hidden || !rl.CheckCollisionPointRec(mousePos, btn.rect) {
+			continue
+		}
+		switch i {
+		case 0:
+			at := entry
+			if at < 0 {
+				at = n - 1
+			}
+			if err := app.InsertColors(at, []rl.Color{app.editedColor(ed)}); err != nil {
+				app.SetStatus(fmt.Sprintf("CANNOT ADD: %v", err))
+				return
+			}
+			app.selectEditorEntry(ed, at+1)
+		case 1:
+			if entry < 0 {
+				return
+			}
+			if err := app.DeleteColor(entry); err != nil {
+				app.SetStatus(fmt.Sprintf("CANNOT DELETE: %v", err))
+				return
+			}
+			if app.palette == nil {
+				app.selectEditorEntry(ed, min(entry, len(app.colorPalette)-1))
+			}
+		case 2, 3:
+			to := entry - 1
+			if i == 3 {
+				to = entry + 1
+			}
+			if entry < 0 || to < 0 || to >= n {
+				return
+			}
+			app.SwapColors(entry, to)
+			app.selectEditorEntry(ed, to)
+		case 4:
+			ed.rampFrom = max(0, entry)
+		case 5:
+			ed.rampTo = max(0, entry)
+		case 6:
+			ed.rampSteps = max(1, ed.rampSteps-1)
+		case 7:
+			ed.rampSteps = min(ed.rampSteps+1, maxRampSteps)
+		case 8:
+			ed.rampShift = math.Max(-maxRampShift, ed.rampShift-5)
+		case 9:
+			ed.rampShift = math.Min(ed.rampShift+5, maxRampShift)
+		case 10:
+			app.addEditorRamp(ed)
+		case 11:
+			app.colorEditor = nil
+		case 12:
+			app.OpenPaletteAnalysis()
+		case 13:
+			ed.sortOrder = (ed.sortOrder + 1) % palette.Order(len(palette.OrderNames))
+		case 14:
+			app.SortColors(ed.sortOrder, ed.keysStay, ed.recolor)
+			app.SetStatus("SORTED BY " + strings.ToUpper(palette.OrderNames[ed.sortOrder]))
+		case 15:
+			ed.keysStay = !ed.keysStay
+		case 16:
+			ed.recolor = !ed.recolor
+		}
+		return
+	}
+}
+
+// Insert the colours between the ramp's ends after its FROM entry
+func (app *App) addEditorRamp(ed *colorEditor) {
+	ramp := app.editorRamp(ed)
+	if ed.rampFrom == ed.rampTo {
+		app.SetStatus("PICK DIFFERENT FROM AND TO COLORS")
+		return
+	}
+	inner := ramp[1 : len(ramp)-1]
+	if err := app.InsertColors(ed.rampFrom, inner); err != nil {
+		app.SetStatus(fmt.Sprintf("CANNOT ADD RAMP: %v", err))
+		return
+	}
+	if ed.rampTo > ed.rampFrom {
+		ed.rampTo += len(inner)
+	}
+	if app.palette == nil && ed.entry > ed.rampFrom {
+		ed.entry += len(inner)
+	}
+	app.SetStatus(fmt.Sprintf("ADDED A RAMP OF %d COLORS", len(inner)))
+}
+
+// Draw a slider's bar, shaded with the colours it runs through
+func (ed *colorEditor) drawSlider(k int, c rl.Color) {
+	const segments = 32
+	rect := ed.sliderRect(k)
+	rl.DrawRectangleRec(rect, rl.Color{60, 60, 60, 255})
+	w := rect.Width / segments
+	for s := range segments {
+		shade := ed.withSlider(k, c, (float64(s)+0.5)/segments)
+		rl.DrawRectangleRec(rl.Rectangle{X: rect.X + float32(s)*w, Y: rect.Y, Width: w + 1, Height: rect.Height}, shade)
+	}
+	rl.DrawRectangleLinesEx(rect, 1, rl.Color{90, 90, 90, 255})
+	v := ed.sliderValue(k, c)
+	pos := rect.X + float32(v)*rect.Width
+	rl.DrawRectangle(int32(pos-2), int32(rect.Y)-1, 4, int32(rect.Height)+2, rl.White)
+	rl.DrawRectangleLines(int32(pos-2), int32(rect.Y)-1, 4, int32(rect.Height)+2, rl.Black)
+	rl.DrawText(sliderLabels[k], int32(ed.box.X)+225, int32(rect.Y)+2, fontSize, rl.LightGray)
+	rl.DrawText(sliderText(k, v), int32(rect.X+rect.Width)+6, int32(rect.Y)+2, fontSize, rl.White)
+}
+
+// Draw a text field, with a cursor while typing
+func (app *App) drawEditorField(ed *colorEditor, f int) {
+	rect := ed.fieldRect(f)
+	text, color := "", rl.Gray
+	if app.fieldEnabled(f) {
+		text, color = app.fieldText(ed, f), rl.White
+	}
+	if ed.field == f {
+		text = ed.text + "_"
+		rl.DrawRectangleRec(rect, rl.Color{30, 30, 30, 255})
+	}
+	rl.DrawRectangleLinesEx(rect, 1, rl.Color{90, 90, 90, 255})
+	rl.DrawText(fitText(text, int32(rect.Width)-6, ed.field == f), int32(rect.X)+3, int32(rect.Y)+3, fontSize, color)
+}
+
+// Draw the colour editor over the whole window
+func (app *App) drawColorEditor() {
+	ed := app.colorEditor
+	if ed == nil {
+		return
+	}
+	mousePos := rl.GetMousePosition()
+	x, y := int32(ed.box.X), int32(ed.box.Y)
+	rl.DrawRectangle(0, 0, screenWidth, screenHeight, rl.Color{0, 0, 0, 160})
+	rl.DrawRectangleRec(ed.box, rl.Color{50, 50, 50, 255})
+	rl.DrawRectangleLinesEx(ed.box, 1, rl.Color{90, 90, 90, 255})
+	title := "EDIT COLORS"
+	if app.palette != nil {
+		title = fmt.Sprintf("EDIT PALETTE, %d OF %d COLORS", len(app.palette.colors), maxIndexedColors)
+	}
+	rl.DrawText(title, x+10, y+10, fontSize, rl.White)
+
+	// Hue ring and saturation/value square
+	c := app.editedColor(ed)
+	center := ed.wheelCenter()
+	for i := range 90 {
+		a := float32(i * 4)
+		hue := rlColor(palette.FromHSV((float64(i)+0.5)/90, 1, 1), 255)
+		rl.DrawRing(center, wheelInner, wheelOuter, a, a+4.5, 2, hue)
+	}
+	sq := ed.squareRect()
+	hue := rlColor(palette.FromHSV(ed.hsv[0], 1, 1), 255)
+	rl.DrawRectangleGradientH(int32(sq.X), int32(sq.Y), int32(sq.Width), int32(sq.Height), rl.White, hue)
+	rl.DrawRectangleGradientV(int32(sq.X), int32(sq.Y), int32(sq.Width), int32(sq.Height), rl.Color{0, 0, 0, 0}, rl.Black)
+	angle := ed.hsv[0] * 2 * math.Pi
+	r := float64(wheelInner+wheelOuter) / 2
+	rl.DrawCircleLines(int32(float64(center.X)+r*math.Cos(angle)), int32(float64(center.Y)+r*math.Sin(angle)), 5, rl.White)
+	mark := rl.White
+	if ed.hsv[2] > 0.6 && ed.hsv[1] < 0.4 {
+		mark = rl.Black
+	}
+	rl.DrawCircleLines(int32(sq.X+float32(ed.hsv[1])*sq.Width), int32(sq.Y+float32(1-ed.hsv[2])*sq.Height), 4, mark)
+
+	// Sliders, hex and preview
+	for k := range sliderLabels {
+		ed.drawSlider(k, c)
+	}
+	rl.DrawText("HEX", x+225, y+249, fontSize, rl.LightGray)
+	app.drawEditorField(ed, fieldHex)
+	preview := rl.Rectangle{X: ed.box.X + 350, Y: ed.box.Y + 242, Width: 60, Height: 22}
+	rl.DrawRectangleRec(preview, c)
+	rl.DrawRectangleLinesEx(preview, 1, rl.White)
+
+	// Entry list
+	colors := app.swatches()
+	entry := app.editorEntry(ed)
+	rl.DrawText(fmt.Sprintf("%d COLORS", len(colors)), x+440, y+28, fontSize, rl.LightGray)
+	for r := range editorRows {
+		i := ed.scroll + r
+		if i >= len(colors) {
+			break
+		}
+		row := ed.rowRect(r)
+		switch {
+		case i == entry:
+			rl.DrawRectangleRec(row, rl.Color{100, 100, 150, 255})
+		case rl.CheckCollisionPointRec(mousePos, row):
+			rl.DrawRectangleRec(row, rl.Color{70, 70, 70, 255})
+		}
+		rl.DrawRectangle(int32(row.X)+1, int32(row.Y)+1, 16, 9, colors[i])
+		text := fmt.Sprintf("%d  %s", i, colorHex(colors[i]))
+		if app.palette != nil {
+			e := app.palette.colors[i]
+			text = fmt.Sprintf("%d  %c  %s", i, e.key, e.name)
+			if e.name == "" {
+				text += colorHex(e.color)
+			}
+			if i == app.palette.transparent {
+				text += " (T)"
+			}
+		}
+		rl.DrawText(fitText(text, int32(row.Width)-24, false), int32(row.X)+22, int32(row.Y)+2, fontSize, rl.White)
+	}
+	rl.DrawText("NAME", x+440, y+339, fontSize, rl.LightGray)
+	rl.DrawText("KEY", x+440, y+357, fontSize, rl.LightGray)
+	app.drawEditorField(ed, fieldName)
+	app.drawEditorField(ed, fieldKey)
+
+	// Ramp
+	ramp := app.editorRamp(ed)
+	rl.DrawText("RAMP", x+10, y+284, fontSize, rl.White)
+	if len(colors) > 0 {
+		rl.DrawRectangle(x+54, y+298, 16, 16, colors[ed.rampFrom])
+		rl.DrawRectangle(x+120, y+298, 16, 16, colors[ed.rampTo])
+	}
+	rl.DrawText("STEPS", x+146, y+302, fontSize, rl.LightGray)
+	rl.DrawText(fmt.Sprintf("%d", ed.rampSteps), x+204, y+302, fontSize, rl.White)
+	rl.DrawText("SHIFT", x+276, y+302, fontSize, rl.LightGray)
+	rl.DrawText(fmt.Sprintf("%+.0f", ed.rampShift), x+332, y+302, fontSize, rl.White)
+	strip := ed.rampRect()
+	for i, shade := range ramp {
+		w := strip.Width / float32(len(ramp))
+		rl.DrawRectangleRec(rl.Rectangle{X: strip.X + float32(i)*w, Y: strip.Y, Width: w, Height: strip.Height}, shade)
+	}
+	rl.DrawRectangleLinesEx(strip, 1, rl.Color{90, 90, 90, 255})
+
+	for i, btn := range ed.buttons {
+		switch i {
+		case 13:
+			btn.text = "BY " + strings.ToUpper(palette.OrderNames[ed.sortOrder])
+		case 15, 16:
+			// Keys and pixels belong to indexed documents
+			if app.palette == nil {
+				continue
+			}
+			if i == 15 {
+				btn.text, btn.selected = "KEYS MOVE", ed.keysStay
+				if ed.keysStay {
+					btn.text = "KEYS STAY"
+				}
+			} else {
+				btn.text, btn.selected = "ART KEPT", ed.recolor
+				if ed.recolor {
+					btn.text = "RECOLOR ART"
+				}
+			}
+		}
+		drawButton(btn, rl.CheckCollisionPointRec(mousePos, btn.rect))
+	}
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io"
+)
+
+// Colour space tags of PNG and JPEG files. Exports are tagged as sRGB;
+// imports carrying an ICC profile are converted to sRGB, which Go's
+// decoders leave to the caller.
+
+// Decode an image file's contents to straight 8-bit sRGB
+func decodeImage(data []byte) (*image.NRGBA, error) {
+	decoded, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	img := toNRGBA(decoded)
+	if profile, err := parseICC(embeddedProfile(data)); err == nil {
+		profile.toSRGB(img)
+	}
+	return img, nil
+}
+
+// ICC profile embedded in a PNG or JPEG file, or nil if it has none or
+// says it is sRGB
+func embeddedProfile(data []byte) []byte {
+	switch {
+	case bytes.HasPrefix(data, []byte("\x89PNG\r\n\x1a\n")):
+		return pngProfile(data)
+	case bytes.HasPrefix(data, []byte{0xff, 0xd8}):
+		return jpegProfile(data)
+	}
+	return nil
+}
+
+// Profile of a PNG's iCCP chunk, which comes before the image data
+func pngProfile(data []byte) []byte {
+	for i := 8; i+12 <= len(data); {
+		n := int(binary.BigEndian.Uint32(data[i:]))
+		if i+12+n > len(data) {
+			return nil
+		}
+		chunk := data[i+8 : i+8+n]
+		switch string(data[i+4 : i+8]) {
+		case "iCCP":
+			// Profile name, then compression method 0 and zlib data
+			_, rest, ok := bytes.Cut(chunk, []byte{0})
+			if !ok || len(rest) < 1 || rest[0] != 0 {
+				return nil
+			}
+			zr, err := zlib.NewReader(bytes.NewReader(rest[1:]))
+			if err != nil {
+				return nil
+			}
+			profile, err := io.ReadAll(io.LimitReader(zr, maxProfileSize))
+			if err != nil {
+				return nil
+			}
+			return profile
+		case "sRGB", "IDAT":
+			return nil
+		}
+		i += 12 + n
+	}
+	return nil
+}
+
+// Profile of a JPEG's APP2 ICC_PROFILE segments, which number their parts
+func jpegProfile(data []byte) []byte {
+	parts := make(map[byte][]byte)
+	count := 0
+	for i := 2; i+4 <= len(data) && data[i] == 0xff; {
+		marker := data[i+1]
+		if marker == 0xda || marker == 0xd9 { // start of scan, end of image
+			break
+		}
+		n := int(binary.BigEndian.Uint16(data[i+2:]))
+		if n < 2 || i+2+n > len(data) {
+			break
+		}
+		segment := data[i+4 : i+2+n]
+		if marker == 0xe2 && len(segment) > 14 && string(segment[:12]) == "ICC_PROFILE\x00" {
+			parts[segment[12]] = segment[14:]
+			count = int(segment[13])
+		}
+		i += 2 + n
+	}
+	if count == 0 || len(parts) != count {
+		return nil
+	}
+	var profile []byte
+	for seq := 1; seq <= count; seq++ {
+		part, ok := parts[byte(seq)]
+		if !ok {
+			return nil
+		}
+		profile = append(profile, part...)
+	}
+	return profile
+}
+
+// Write the chunks marking a PNG as sRGB, which go straight after IHDR:
+// an sRGB chunk, or with icc an iCCP holding an sRGB profile, and the gAMA
+// and cHRM that decoders without either fall back on
+func writePNGColorChunks(w io.Writer, icc bool) error {
+	if icc {
+		var buf bytes.Buffer
+		buf.WriteString("sRGB\x00\x00") // profile name, compression method 0
+		zw := zlib.NewWriter(&buf)
+		if _, err := zw.Write(srgbProfile); err != nil {
+			return err
+		}
+		if err := zw.Close(); err != nil {
+			return err
+		}
+		if err := writePNGChunk(w, "iCCP", buf.Bytes()); err != nil {
+			return err
+		}
+	} else if err := writePNGChunk(w, "sRGB", []byte{0}); err != nil { // perceptual intent
+		return err
+	}
+	if err := writePNGChunk(w, "gAMA", binary.BigEndian.AppendUint32(nil, 45455)); err != nil {
+		return err
+	}
+	// White point and red, green and blue primaries, times 100000
+	var chrm []byte
+	for _, v := range []uint32{31270, 32900, 64000, 33000, 30000, 60000, 15000, 6000} {
+		chrm = binary.BigEndian.AppendUint32(chrm, v)
+	}
+	return writePNGChunk(w, "cHRM", chrm)
+}
+
+// Encode an sRGB image as a PNG tagged as such
+func encodeTaggedPNG(w io.Writer, img image.Image, icc bool) error {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return err
+	}
+	// The signature and IHDR always take the first 33 bytes
+	data := buf.Bytes()
+	if _, err := w.Write(data[:33]); err != nil {
+		return err
+	}
+	if err := writePNGColorChunks(w, icc); err != nil {
+		return err
+	}
+	_, err := w.Write(data[33:])
+	return err
+}
+
+// Encode an sRGB image as a JPEG, with icc embedding an sRGB profile.
+// Untagged JPEGs are taken as sRGB anyway.
+func encodeTaggedJPEG(w io.Writer, img image.Image, quality int, icc bool) error {
+	if !icc {
+		return jpeg.Encode(w, img, &jpeg.Options{Quality: quality})
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: quality}); err != nil {
+		return err
+	}
+	// One APP2 segment straight after the start of image marker
+	data := buf.Bytes()
+	segment := []byte{0xff, 0xe2, 0, 0}
+	segment = append(segment, "ICC_PROFILE\x00\x01\x01"...)
+	segment = append(segment, srgbProfile...)
+	binary.BigEndian.PutUint16(segment[2:], uint16(len(segment)-2))
+	for _, part := range [][]byte{data[:2], segment, data[2:]} {
+		if _, err := w.Write(part); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,338 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Command line tools, run as "dd <command> [arguments]" without opening
+// the editor window
+var commands = map[string]struct {
+	run   func(args []string) int
+	usage string
+}{
+	"validate": {cmdValidate, validateUsage},
+	"convert":  {cmdConvert, convertUsage},
+	"info":     {cmdInfo, infoUsage},
+	"export":   {cmdExport, exportUsage},
+	"sheet":    {cmdSheet, sheetUsage},
+	"quantize": {cmdQuantize, quantizeUsage},
+	"palette":  {cmdPalette, paletteUsage},
+}
+
+const (
+	validateUsage = "validate [-json] file...       check projects for errors"
+	convertUsage  = "convert from to                copy a project between .ddd and .ddproj"
+	infoUsage     = "info [-json] [flags] file...   show or edit project metadata"
+	exportUsage   = "export [flags] project out      write an animated .gif, .png or PNG sequence"
+	sheetUsage    = "sheet [flags] input out.png    pack frames, layers or DPF icons into a sprite sheet"
+	quantizeUsage = "quantize [flags] image out     fit an image to a palette, as an indexed .png or .dpf"
+	paletteUsage  = "palette analyze [flags] file   contrast, near duplicates and colour-blind views of a palette"
+)
+
+// Run a command line tool and return its exit status
+func runCommand(args []string) int {
+	cmd, ok := commands[args[0]]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "dd: unknown command %q\n\nUsage: dd [command]\n\nWith no command the editor opens. Commands:\n", args[0])
+		var names []string
+		for name := range commands {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Fprintf(os.Stderr, "  dd %s\n", commands[name].usage)
+		}
+		return 2
+	}
+	return cmd.run(args[1:])
+}
+
+// dd validate: exit status 1 if any file has errors
+func cmdValidate(args []string) int {
+	flags := flag.NewFlagSet("validate", flag.ExitOnError)
+	jsonOutput := flags.Bool("json", false, "print reports as JSON")
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: dd "+validateUsage)
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return 2
+	}
+
+	status := 0
+	var reports []*ValidationReport
+	for _, filename := range flags.Args() {
+		_, report := openProject(filename)
+		if report.Problems == nil {
+			report.Problems = []Problem{}
+		}
+		if report.Count("error") > 0 {
+			status = 1
+		}
+		reports = append(reports, report)
+	}
+
+	if *jsonOutput {
+		out := json.NewEncoder(os.Stdout)
+		out.SetIndent("", "  ")
+		if err := out.Encode(reports); err != nil {
+			fmt.Fprintln(os.Stderr, "dd:", err)
+			return 1
+		}
+		return status
+	}
+
+	for _, report := range reports {
+		result := "OK"
+		if n := report.Count("error"); n > 0 {
+			result = fmt.Sprintf("%d errors", n)
+		}
+		if n := report.Count("warning"); n > 0 {
+			result += fmt.Sprintf(", %d warnings", n)
+		}
+		fmt.Printf("%s: format %d, %dx%d, %d layers, %d frames: %s\n", report.File, report.FormatVersion, report.CanvasWidth, report.CanvasHeight, report.Layers, report.Frames, result)
+		for _, p := range report.Problems {
+			fmt.Printf("  %s\n", p)
+		}
+	}
+	return status
+}
+
+// dd convert: the output format follows the output name
+func cmdConvert(args []string) int {
+	flags := flag.NewFlagSet("convert", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: dd "+convertUsage)
+	}
+	flags.Parse(args)
+	if flags.NArg() != 2 {
+		flags.Usage()
+		return 2
+	}
+
+	lp, report := openProject(flags.Arg(0))
+	if err := report.Err(); err != nil {
+		fmt.Fprintf(os.Stderr, "dd: %s: %v\n", flags.Arg(0), err)
+		return 1
+	}
+	snap := &projectSnapshot{project: lp.project, layers: lp.layers, cels: lp.cels, metadata: lp.metadata.forSave()}
+	snap.project.TileSize = tileSize
+	if err := writeProjectFile(flags.Arg(1), snap, nil); err != nil {
+		fmt.Fprintf(os.Stderr, "dd: %s: %v\n", flags.Arg(1), err)
+		return 1
+	}
+	return 0
+}
+
+// dd info: reads only project.json, metadata.json and the thumbnail header
+func cmdInfo(args []string) int {
+	flags := flag.NewFlagSet("info", flag.ExitOnError)
+	jsonOutput := flags.Bool("json", false, "print metadata as JSON")
+	author := flags.String("author", "", "set the author")
+	description := flags.String("description", "", "set the description")
+	tags := flags.String("tags", "", "set the tags, separated by commas")
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: dd "+infoUsage)
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return 2
+	}
+	set := make(map[string]bool)
+	flags.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+
+	status := 0
+	var infos []*ProjectInfo
+	for _, filename := range flags.Args() {
+		info, err := readProjectInfo(filename)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "dd: %s: %v\n", filename, err)
+			status = 1
+			continue
+		}
+
+		// Edit metadata
+		if set["author"] || set["description"] || set["tags"] {
+			meta := &info.ProjectMetadata
+			if set["author"] {
+				meta.Author = *author
+			}
+			if set["description"] {
+				meta.Description = *description
+			}
+			if set["tags"] {
+				meta.Tags = splitTags(*tags)
+			}
+			if err := writeProjectMetadata(filename, *meta); err != nil {
+				fmt.Fprintf(os.Stderr, "dd: %s: %v\n", filename, err)
+				status = 1
+				continue
+			}
+		}
+		infos = append(infos, info)
+	}
+
+	if *jsonOutput {
+		out := json.NewEncoder(os.Stdout)
+		out.SetIndent("", "  ")
+		if err := out.Encode(infos); err != nil {
+			fmt.Fprintln(os.Stderr, "dd:", err)
+			return 1
+		}
+		return status
+	}
+
+	date := func(t time.Time) string {
+		if t.IsZero() {
+			return "unknown"
+		}
+		return t.Local().Format("2006-01-02 15:04:05")
+	}
+	for i, info := range infos {
+		if i > 0 {
+			fmt.Println()
+		}
+		fmt.Println(info.File)
+		fmt.Printf("  format:      %d\n", info.FormatVersion)
+		fmt.Printf("  canvas:      %dx%d, %d layers, %d frames\n", info.CanvasWidth, info.CanvasHeight, info.Layers, info.Frames)
+		fmt.Printf("  colors:      %s, %d in the palette\n", info.ColorMode, info.Colors)
+		fmt.Printf("  compositing: %s\n", info.Compositing)
+		fmt.Printf("  author:      %s\n", info.Author)
+		fmt.Printf("  description: %s\n", info.Description)
+		fmt.Printf("  tags:        %s\n", strings.Join(info.Tags, ", "))
+		fmt.Printf("  created:     %s\n", date(info.Created))
+		fmt.Printf("  modified:    %s\n", date(info.Modified))
+		fmt.Printf("  app version: %s\n", info.AppVersion)
+		fmt.Printf("  edit time:   %s\n", time.Duration(info.EditTime)*time.Second)
+		if info.ThumbnailWidth > 0 {
+			fmt.Printf("  thumbnail:   %dx%d\n", info.ThumbnailWidth, info.ThumbnailHeight)
+		} else {
+			fmt.Printf("  thumbnail:   none\n")
+		}
+	}
+	return status
+}
+
+// dd export: a .gif name gives an animated GIF, anything else an APNG;
+// -sequence writes numbered PNGs instead
+func cmdExport(args []string) int {
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	layers := flags.Bool("layers", false, "export every visible layer as a frame")
+	delay := flags.Int("delay", defaultFrameDuration, "milliseconds per layer frame")
+	dither := flags.Bool("dither", false, "dither GIF frames to the palette")
+	plays := flags.Int("plays", 0, "times to play, 0 for forever")
+	dispose := flags.String("dispose", "none", "APNG frame disposal: "+strings.Join(disposeNames, ", "))
+	blend := flags.String("blend", "source", "APNG frame blending: "+strings.Join(blendNames, ", "))
+	cycle := flags.Bool("cycle", false, "export one loop of the palette's colour cycling")
+	sequence := flags.Bool("sequence", false, "write out_0001.png and on instead of one animation")
+	icc := flags.Bool("icc", false, "embed an sRGB ICC profile in PNGs instead of the sRGB chunk")
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: dd "+exportUsage)
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() != 2 {
+		flags.Usage()
+		return 2
+	}
+	opts := AnimExportOptions{
+		LayerFrames: *layers,
+		Delay:       max(10, min(*delay, maxFrameDuration)),
+		Dither:      *dither,
+		Plays:       max(0, *plays),
+		Dispose:     slices.Index(disposeNames, *dispose),
+		Blend:       slices.Index(blendNames, *blend),
+		Cycle:       *cycle,
+		Sequence:    *sequence,
+		ICC:         *icc,
+	}
+	if opts.Dispose < 0 || opts.Blend < 0 {
+		flags.Usage()
+		return 2
+	}
+
+	lp, report := openProject(flags.Arg(0))
+	if err := report.Err(); err != nil {
+		fmt.Fprintf(os.Stderr, "dd: %s: %v\n", flags.Arg(0), err)
+		return 1
+	}
+	frames := animationFrames(lp.project, lp.layers, lp.cels, opts)
+	if err := writeAnimation(flags.Arg(1), frames, lp.project, opts); err != nil {
+		fmt.Fprintf(os.Stderr, "dd: %s: %v\n", flags.Arg(1), err)
+		return 1
+	}
+	return 0
+}
+
+// dd sheet: the input is a project or a DPF file; the atlas goes next to
+// the sheet unless -atlas names it
+func cmdSheet(args []string) int {
+	flags := flag.NewFlagSet("sheet", flag.ExitOnError)
+	layers := flags.Bool("layers", false, "pack every visible layer instead of the animation frames")
+	packing := flags.String("packing", "maxrects", "layout: "+strings.Join(sheetPackings, ", "))
+	columns := flags.Int("columns", 0, "grid columns, 0 for about square")
+	padding := flags.Int("padding", 1, "pixels between sprites")
+	extrude := flags.Int("extrude", 0, "pixels of edge repeated around each sprite")
+	trim := flags.Bool("trim", true, "drop transparent borders")
+	format := flags.String("format", "hash", "atlas format: "+strings.Join(atlasFormats, ", "))
+	atlas := flags.String("atlas", "", "atlas file (default: the sheet name with .json, .txt or .xml)")
+	icc := flags.Bool("icc", false, "embed an sRGB ICC profile in the sheet instead of the sRGB chunk")
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: dd "+sheetUsage)
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() != 2 || !slices.Contains(sheetPackings, *packing) || !slices.Contains(atlasFormats, *format) {
+		flags.Usage()
+		return 2
+	}
+	opts := SheetOptions{Packing: *packing, Columns: *columns, Padding: *padding, Extrude: *extrude, Trim: *trim, Format: *format, ICC: *icc}
+
+	input, out := flags.Arg(0), flags.Arg(1)
+	var sprites []sheetSprite
+	if fileFilters[filterDPF].match(input) {
+		dpf, err := loadDPF(input)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "dd: %s: %v\n", input, err)
+			return 1
+		}
+		sprites = dpfSprites(dpf)
+	} else {
+		lp, report := openProject(input)
+		if err := report.Err(); err != nil {
+			fmt.Fprintf(os.Stderr, "dd: %s: %v\n", input, err)
+			return 1
+		}
+		sprites = projectSprites(lp.project, lp.layers, lp.cels, *layers)
+	}
+
+	if *atlas == "" {
+		ext := ".json"
+		switch *format {
+		case "plain":
+			ext = ".txt"
+		case "xml":
+			ext = ".xml"
+		}
+		*atlas = strings.TrimSuffix(out, filepath.Ext(out)) + ext
+	}
+	if err := writeSpriteSheet(out, *atlas, sprites, opts); err != nil {
+		fmt.Fprintf(os.Stderr, "dd: %s: %v\n", out, err)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"math"
+)
+
+// Compositing on the CPU, for work that happens away from the GPU
+// (thumbnails on the save goroutine, command line tools). Each blend mode
+// applies the same equation as the GPU blend state LayerBlendMode.Begin
+// sets, to all four channels, with results rounded after every layer to
+// the depth of the render texture the GPU would use. Fully transparent
+// layer pixels leave the composite unchanged in every mode, so they are
+// skipped.
+
+// A layer as seen by the compositor
+type compositeLayer struct {
+	store   *TileStore
+	opacity float32
+	mode    LayerBlendMode
+	palette []color.RGBA // colours of indexed pixels; nil for truecolour
+}
+
+// Layers to composite, bottom first, and how they blend
+type compositeStack struct {
+	layers []compositeLayer
+	mode   Compositing
+}
+
+// Visible layers of saved layer data, with pixels indexing palette unless
+// it is nil
+func compositeLayers(data []LayerData, stores []*TileStore, palette []color.RGBA, mode Compositing) compositeStack {
+	stack := compositeStack{mode: mode}
+	for i, d := range data {
+		if !d.Visible || i >= len(stores) {
+			continue
+		}
+		blend, _ := ParseBlendMode(d.BlendMode)
+		stack.layers = append(stack.layers, compositeLayer{stores[i], d.Opacity, blend, palette})
+	}
+	return stack
+}
+
+// Compositing mode of saved project data
+func projectCompositing(project ProjectData) Compositing {
+	mode, _ := ParseCompositing(project.Compositing)
+	return mode
+}
+
+// Blend src over dst (0-1 values) with a layer blend mode, rounding to
+// depth bits per channel. src is not premultiplied; its colour is weighted
+// by its alpha here, as the compositing shaders do before blending.
+func blendPixel(mode LayerBlendMode, dst, src [4]float32, depth int) [4]float32 {
+	var out [4]float32
+	sa := src[3]
+	for i := range out {
+		var v float32
+		p := src[i] * sa
+		switch {
+		case i == 3:
+			// srcAlpha*srcAlpha + dstAlpha*(1-srcAlpha) in every mode
+			v = p + dst[i]*(1-sa)
+		case mode == BlendMultiply:
+			// src*dst + dst*(1-srcAlpha)
+			v = p*dst[i] + dst[i]*(1-sa)
+		case mode == BlendAdd:
+			// src + dst
+			v = p + dst[i]
+		case mode == BlendSubtract:
+			// dst - src
+			v = dst[i] - p
+		case mode == BlendScreen:
+			// src + dst*(1-src)
+			v = p + dst[i]*(1-p)
+		default:
+			// src + dst*(1-srcAlpha)
+			v = p + dst[i]*(1-sa)
+		}
+		switch depth {
+		case 8:
+			out[i] = float32(math.Round(float64(clamp(v, 0, 1)*255))) / 255
+		case 16:
+			out[i] = roundHalf(v)
+		default:
+			out[i] = v
+		}
+	}
+	return out
+}
+
+// Composite colour of one canvas pixel, as 0-1 values
+func compositeAt(stack compositeStack, x, y int) [4]float32 {
+	var dst [4]float32
+	linear, depth := stack.mode.Linear(), stack.mode.depth()
+	for _, layer := range stack.layers {
+		c := layer.store.At(x, y)
+		if layer.palette != nil {
+			c = indexColor(layer.palette, c)
+		}
+		if c.A == 0 {
+			continue
+		}
+		src := [4]float32{float32(c.R) / 255, float32(c.G) / 255, float32(c.B) / 255, float32(c.A) / 255 * layer.opacity}
+		if linear {
+			src[0], src[1], src[2] = linearLight[c.R], linearLight[c.G], linearLight[c.B]
+		}
+		dst = blendPixel(layer.mode, dst, src, depth)
+	}
+	if linear {
+		for k := 0; k < 3; k++ {
+			dst[k] = float32(encodeSRGB(float64(clamp(dst[k], 0, 1))))
+		}
+		dst[3] = clamp(dst[3], 0, 1)
+	}
+	return dst
+}
+
+// Composite a whole canvas
+func compositeImage(stack compositeStack, width, height int) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			c := compositeAt(stack, x, y)
+			i := img.PixOffset(x, y)
+			for k := 0; k < 4; k++ {
+				img.Pix[i+k] = uint8(c[k]*255 + 0.5)
+			}
+		}
+	}
+	return img
+}
+
+// Composite a preview of the canvas fitting in size x size pixels. Each
+// preview pixel averages a grid of canvas samples, weighted by alpha.
+func compositeThumbnail(stack compositeStack, width, height, size int) *image.NRGBA {
+	tw, th := width, height
+	if longest := max(width, height); longest > size {
+		tw = max(1, width*size/longest)
+		th = max(1, height*size/longest)
+	}
+	scaleX := float32(width) / float32(tw)
+	scaleY := float32(height) / float32(th)
+	n := min(4, int(math.Ceil(float64(maxf(scaleX, scaleY)))))
+
+	img := image.NewNRGBA(image.Rect(0, 0, tw, th))
+	for ty := 0; ty < th; ty++ {
+		for tx := 0; tx < tw; tx++ {
+			var sum [4]float32
+			for sy := 0; sy < n; sy++ {
+				for sx := 0; sx < n; sx++ {
+					x := int((float32(tx) + (float32(sx)+0.5)/float32(n)) * scaleX)
+					y := int((float32(ty) + (float32(sy)+0.5)/float32(n)) * scaleY)
+					c := compositeAt(stack, x, y)
+					sum[0] += c[0] * c[3]
+					sum[1] += c[1] * c[3]
+					sum[2] += c[2] * c[3]
+					sum[3] += c[3]
+				}
+			}
+			i := img.PixOffset(tx, ty)
+			if sum[3] > 0 {
+				for k := 0; k < 3; k++ {
+					img.Pix[i+k] = uint8(sum[k]/sum[3]*255 + 0.5)
+				}
+			}
+			img.Pix[i+3] = uint8(sum[3]/float32(n*n)*255 + 0.5)
+		}
+	}
+	return img
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"math"
+	"testing"
+
+	rl "github.com/gen2brain/raylib-go/raylib"
+)
+
+// Blend factor as GL applies it, for one channel of a premultiplied
+// fragment src over dst
+func glFactor(factor int32, src, dst [4]float32, i int) float32 {
+	switch factor {
+	case rl.Zero:
+		return 0
+	case rl.One:
+		return 1
+	case rl.SrcColor:
+		return src[i]
+	case rl.OneMinusSrcColor:
+		return 1 - src[i]
+	case rl.SrcAlpha:
+		return src[3]
+	case rl.OneMinusSrcAlpha:
+		return 1 - src[3]
+	case rl.DstColor:
+		return dst[i]
+	case rl.OneMinusDstColor:
+		return 1 - dst[i]
+	}
+	panic("unexpected blend factor")
+}
+
+// What the GPU computes for a blend state, drawing src through the
+// compositing shaders
+func glBlend(s blendState, dst, src [4]float32) [4]float32 {
+	frag := [4]float32{src[0] * src[3], src[1] * src[3], src[2] * src[3], src[3]}
+	var out [4]float32
+	for i := range out {
+		sf, df, eq := s.srcRGB, s.dstRGB, s.eqRGB
+		if i == 3 {
+			sf, df, eq = s.srcAlpha, s.dstAlpha, s.eqAlpha
+		}
+		a := frag[i] * glFactor(sf, frag, dst, i)
+		b := dst[i] * glFactor(df, frag, dst, i)
+		switch eq {
+		case rl.FuncAdd:
+			out[i] = a + b
+		case rl.FuncSubtract:
+			out[i] = a - b
+		case rl.FuncReverseSubtract:
+			out[i] = b - a
+		default:
+			panic("unexpected blend equation")
+		}
+	}
+	return out
+}
+
+func TestBlendPixelMatchesGPU(t *testing.T) {
+	dsts := [][4]float32{{0, 0, 0, 0}, {0.2, 0.5, 0.8, 1}, {1, 1, 1, 1}, {0.4, 0.3, 0.2, 0.5}}
+	srcs := [][4]float32{{0, 0, 0, 0}, {1, 1, 1, 1}, {0.9, 0.1, 0.3, 1}, {0.5, 0.6, 0.7, 0.4}, {1, 0, 0, 0}}
+	for mode := range LayerBlendMode(len(blendModeNames)) {
+		for _, opacity := range []float32{0, 0.5, 1} {
+			for _, dst := range dsts {
+				for _, src := range srcs {
+					src[3] *= opacity
+					got := blendPixel(mode, dst, src, 32)
+					want := glBlend(mode.blendState(), dst, src)
+					for i := range got {
+						if math.Abs(float64(got[i]-want[i])) > 1e-6 {
+							t.Errorf("%v at %v: %v over %v = %v, GPU gives %v", mode, opacity, src, dst, got, want)
+							break
+						}
+					}
+					// Transparent pixels and zero opacity leave the composite alone
+					if src[3] == 0 && got != dst {
+						t.Errorf("%v at %v: transparent %v over %v = %v", mode, opacity, src, dst, got)
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestBlendPixelModes(t *testing.T) {
+	// Alpha blends as in normal mode, srcAlpha*srcAlpha + dstAlpha*(1-srcAlpha)
+	dst := [4]float32{0.2, 0.5, 0.8, 1}
+	for _, tc := range []struct {
+		mode LayerBlendMode
+		src  [4]float32
+		want [4]float32
+	}{
+		// White multiplies to the base at any opacity
+		{BlendMultiply, [4]float32{1, 1, 1, 0.5}, [4]float32{0.2, 0.5, 0.8, 0.75}},
+		{BlendMultiply, [4]float32{0, 0, 0, 0.5}, [4]float32{0.1, 0.25, 0.4, 0.75}},
+		{BlendAdd, [4]float32{0.5, 0.5, 0.5, 0.5}, [4]float32{0.45, 0.75, 1.05, 0.75}},
+		// Subtract takes the layer away from the base
+		{BlendSubtract, [4]float32{0.2, 0.2, 0.2, 1}, [4]float32{0, 0.3, 0.6, 1}},
+		{BlendSubtract, [4]float32{0.2, 0.2, 0.2, 0.5}, [4]float32{0.1, 0.4, 0.7, 0.75}},
+		// Black screens to the base at any opacity
+		{BlendScreen, [4]float32{0, 0, 0, 0.5}, [4]float32{0.2, 0.5, 0.8, 0.75}},
+		{BlendScreen, [4]float32{1, 1, 1, 1}, [4]float32{1, 1, 1, 1}},
+	} {
+		got := blendPixel(tc.mode, dst, tc.src, 32)
+		for i := range got {
+			if math.Abs(float64(got[i]-tc.want[i])) > 1e-6 {
+				t.Errorf("%v: %v over %v = %v, want %v", tc.mode, tc.src, dst, got, tc.want)
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,406 @@
+package main
+
+import (
+	"image"
+	"slices"
+
+	rl "github.com/gen2brain/raylib-go/raylib"
+)
+
+// Compositor caches the composite of all layers as GPU pages covering the
+// visible part of the canvas, and only redraws pages touched since the
+// last composition.
+type Compositor struct {
+	sampler rl.RenderTexture2D // 1x1 target for single pixel reads
+	width   int
+	height  int
+	pagesX  int
+	pagesY  int
+
+	// Composite pages currently resident
+	pages map[int]rl.RenderTexture2D
+
+	// Composite pages that need redrawing
+	dirty []bool
+
+	// Dirty bounds per layer since the last composition
+	layerDirty map[*Layer]rl.Rectangle
+
+	// Layer stack state at the last composition; any change forces a full redraw
+	lastStack []layerStackEntry
+
+	// Colours of palette indices, a 256x1 texture; unloaded for truecolour
+	palette rl.Texture2D
+	colors  []rl.Color
+
+	// How layers blend, and the page they blend into in linear light;
+	// unloaded until needed
+	mode Compositing
+	work rl.RenderTexture2D
+}
+
+// Compositing-relevant state of one layer
+type layerStackEntry struct {
+	layer     *Layer
+	visible   bool
+	opacity   float32
+	blendMode LayerBlendMode
+}
+
+// Create a compositor for a canvas
+func NewCompositor(width, height int) *Compositor {
+	c := &Compositor{
+		sampler:    rl.LoadRenderTexture(1, 1),
+		pages:      make(map[int]rl.RenderTexture2D),
+		layerDirty: make(map[*Layer]rl.Rectangle),
+	}
+	c.Resize(width, height)
+	return c
+}
+
+// Resize for a new canvas; everything becomes dirty
+func (c *Compositor) Resize(width, height int) {
+	c.unloadPages()
+	c.width = width
+	c.height = height
+	c.pagesX, c.pagesY = pageGrid(width, height)
+	c.dirty = make([]bool, c.pagesX*c.pagesY)
+	c.lastStack = nil
+	c.Invalidate()
+}
+
+func (c *Compositor) unloadPages() {
+	for key, target := range c.pages {
+		rl.UnloadRenderTexture(target)
+		delete(c.pages, key)
+	}
+}
+
+// Free GPU resources
+func (c *Compositor) Unload() {
+	c.unloadPages()
+	rl.UnloadRenderTexture(c.sampler)
+	if c.work.ID != 0 {
+		rl.UnloadRenderTexture(c.work)
+	}
+	if c.palette.ID != 0 {
+		rl.UnloadTexture(c.palette)
+	}
+}
+
+// Set the colours layer pixels index, or nil when they are colours
+// themselves; everything becomes dirty
+func (c *Compositor) SetPalette(colors []rl.Color) {
+	// Cycling sets the palette every frame, mostly unchanged
+	if (colors == nil) == (c.colors == nil) && slices.Equal(colors, c.colors) {
+		return
+	}
+	c.colors = slices.Clone(colors)
+	c.Invalidate()
+	if colors == nil {
+		if c.palette.ID != 0 {
+			rl.UnloadTexture(c.palette)
+			c.palette = rl.Texture2D{}
+		}
+		return
+	}
+	if c.palette.ID == 0 {
+		img := rl.GenImageColor(maxIndexedColors, 1, rl.Blank)
+		c.palette = rl.LoadTextureFromImage(img)
+		rl.UnloadImage(img)
+	}
+	pixels := make([]rl.Color, maxIndexedColors)
+	copy(pixels, colors)
+	rl.UpdateTexture(c.palette, pixels)
+}
+
+// Set how layers blend; everything becomes dirty
+func (c *Compositor) SetCompositing(mode Compositing) {
+	if mode == c.mode {
+		return
+	}
+	c.mode = mode
+	if c.work.ID != 0 {
+		rl.UnloadRenderTexture(c.work)
+		c.work = rl.RenderTexture2D{}
+	}
+	c.Invalidate()
+}
+
+// Mark the whole canvas dirty
+func (c *Compositor) Invalidate() {
+	for i := range c.dirty {
+		c.dirty[i] = true
+	}
+}
+
+// Mark a whole layer dirty
+func (c *Compositor) MarkLayer(layer *Layer) {
+	c.MarkLayerRect(layer, rl.Rectangle{X: 0, Y: 0, Width: float32(c.width), Height: float32(c.height)})
+}
+
+// Mark a region of a layer dirty (canvas coordinates)
+func (c *Compositor) MarkLayerRect(layer *Layer, rect rl.Rectangle) {
+	if r, ok := c.layerDirty[layer]; ok {
+		rect = unionRect(r, rect)
+	}
+	c.layerDirty[layer] = rect
+}
+
+// Mark the area touched by a stroke segment of the given width
+func (c *Compositor) MarkStroke(layer *Layer, from, to rl.Vector2, width float32) {
+	c.MarkLayerRect(layer, strokeBounds(from, to, width))
+}
+
+// Bounding box of a stroke segment of the given width
+func strokeBounds(from, to rl.Vector2, width float32) rl.Rectangle {
+	pad := width/2 + 2
+	x0, x1 := minf(from.X, to.X)-pad, maxf(from.X, to.X)+pad
+	y0, y1 := minf(from.Y, to.Y)-pad, maxf(from.Y, to.Y)+pad
+	return rl.Rectangle{X: x0, Y: y0, Width: x1 - x0, Height: y1 - y0}
+}
+
+// Check whether the layer stack changed in a way that affects every pixel
+func (c *Compositor) stackChanged(layers []*Layer) bool {
+	changed := len(layers) != len(c.lastStack)
+	if !changed {
+		for i, layer := range layers {
+			e := c.lastStack[i]
+			if e.layer != layer || e.visible != layer.visible || e.opacity != layer.opacity || e.blendMode != layer.blendMode {
+				changed = true
+				break
+			}
+		}
+	}
+	if changed {
+		c.lastStack = c.lastStack[:0]
+		for _, layer := range layers {
+			c.lastStack = append(c.lastStack, layerStackEntry{layer, layer.visible, layer.opacity, layer.blendMode})
+		}
+	}
+	return changed
+}
+
+// Fold pending layer changes into the page dirty flags
+func (c *Compositor) collectDirty(layers []*Layer) {
+	if c.stackChanged(layers) {
+		c.Invalidate()
+	}
+	for layer, rect := range c.layerDirty {
+		if layer.visible {
+			px0, py0, px1, py1 := pageRange(rect, c.width, c.height)
+			for py := py0; py < py1; py++ {
+				for px := px0; px < px1; px++ {
+					c.dirty[py*c.pagesX+px] = true
+				}
+			}
+		}
+		delete(c.layerDirty, layer)
+	}
+}
+
+// Bring the composite up to date for the visible region (canvas
+// coordinates). Pages outside view are released.
+func (c *Compositor) Compose(layers []*Layer, view rl.Rectangle) {
+	c.collectDirty(layers)
+
+	px0, py0, px1, py1 := pageRange(view, c.width, c.height)
+	for key, target := range c.pages {
+		px, py := key%c.pagesX, key/c.pagesX
+		if px < px0 || px >= px1 || py < py0 || py >= py1 {
+			rl.UnloadRenderTexture(target)
+			delete(c.pages, key)
+		}
+	}
+
+	for py := py0; py < py1; py++ {
+		for px := px0; px < px1; px++ {
+			c.composePage(layers, px, py)
+		}
+	}
+}
+
+// Make one composite page resident and up to date
+func (c *Compositor) composePage(layers []*Layer, px, py int) rl.RenderTexture2D {
+	key := py*c.pagesX + px
+	target, ok := c.pages[key]
+	if !ok {
+		target = rl.LoadRenderTexture(pageSize, pageSize)
+		c.pages[key] = target
+		c.dirty[key] = true
+	}
+	if c.dirty[key] {
+		c.drawPage(layers, px, py, target)
+		c.dirty[key] = false
+	}
+	return target
+}
+
+// Composite the layers' pages at (px, py) into target, blending in the
+// working page first when compositing in linear light
+func (c *Compositor) drawPage(layers []*Layer, px, py int, target rl.RenderTexture2D) {
+	if !c.mode.Linear() {
+		drawLayerPages(layers, px, py, target, c.palette, false)
+		return
+	}
+	if c.work.ID == 0 {
+		c.work = loadWorkPage(c.mode.depth())
+	}
+	drawLayerPages(layers, px, py, c.work, c.palette, true)
+	encodeWorkPage(c.work, target)
+}
+
+// Draw the layers' pages at (px, py) into target, with pixels indexing
+// palette when it is loaded, and decoded to linear light if linear is set
+func drawLayerPages(layers []*Layer, px, py int, target rl.RenderTexture2D, palette rl.Texture2D, linear bool) {
+	rl.BeginTextureMode(target)
+	rl.ClearBackground(rl.Color{0, 0, 0, 0})
+	switch {
+	case linear:
+		beginLinearShader(palette)
+	case palette.ID != 0:
+		beginPaletteShader(palette)
+	default:
+		beginPremultiplyShader()
+	}
+
+	for _, layer := range layers {
+		if !layer.visible {
+			continue
+		}
+		p := layer.page(px, py, false)
+		if p == nil {
+			continue
+		}
+
+		layer.blendMode.Begin()
+		rl.DrawTextureRec(
+			p.target.Texture,
+			rl.Rectangle{X: 0, Y: 0, Width: pageSize, Height: -pageSize},
+			rl.Vector2{X: 0, Y: 0},
+			rl.Fade(rl.White, layer.opacity),
+		)
+		rl.EndBlendMode()
+	}
+
+	rl.EndShaderMode()
+	rl.EndTextureMode()
+}
+
+// Shader premultiplying truecolour layer pages by alpha for the layer
+// blend modes; loaded on first use
+var premultiplyShader struct {
+	shader rl.Shader
+	loaded bool
+}
+
+const premultiplyFragmentShader = `#version 330
+in vec2 fragTexCoord;
+in vec4 fragColor;
+uniform sampler2D texture0;
+uniform vec4 colDiffuse;
+out vec4 finalColor;
+void main() {
+	finalColor = texture(texture0, fragTexCoord)*colDiffuse*fragColor;
+	finalColor.rgb *= finalColor.a;
+}
+`
+
+// Start drawing layer pages premultiplied by alpha
+func beginPremultiplyShader() {
+	if !premultiplyShader.loaded {
+		premultiplyShader.shader = rl.LoadShaderFromMemory(paletteVertexShader, premultiplyFragmentShader)
+		premultiplyShader.loaded = true
+	}
+	rl.BeginShaderMode(premultiplyShader.shader)
+}
+
+// Draw the resident composite pages with the canvas origin at (x, y)
+func (c *Compositor) Draw(x, y, zoom float32) {
+	for key, target := range c.pages {
+		px, py := key%c.pagesX, key/c.pagesX
+		w := float32(min(pageSize, c.width-px*pageSize))
+		h := float32(min(pageSize, c.height-py*pageSize))
+		srcRect := rl.Rectangle{X: 0, Y: pageSize - h, Width: w, Height: -h}
+		dstRect := rl.Rectangle{
+			X:      x + float32(px*pageSize)*zoom,
+			Y:      y + float32(py*pageSize)*zoom,
+			Width:  w * zoom,
+			Height: h * zoom,
+		}
+		rl.DrawTexturePro(target.Texture, srcRect, dstRect, rl.Vector2{}, 0, rl.White)
+	}
+}
+
+// Read one composite pixel without reading back a whole texture.
+// The pixel is copied into a 1x1 target and only that is downloaded.
+func (c *Compositor) Sample(layers []*Layer, x, y int) rl.Color {
+	if x < 0 || y < 0 || x >= c.width || y >= c.height {
+		return rl.Blank
+	}
+	c.collectDirty(layers)
+	target := c.composePage(layers, x/pageSize, y/pageSize)
+
+	rl.BeginTextureMode(c.sampler)
+	rl.ClearBackground(rl.Color{0, 0, 0, 0})
+	// Straight copy, no blending
+	rl.SetBlendFactors(rl.One, rl.Zero, rl.FuncAdd)
+	rl.BeginBlendMode(rl.BlendCustom)
+	rl.DrawTextureRec(
+		target.Texture,
+		rl.Rectangle{X: float32(x % pageSize), Y: float32(pageSize - 1 - y%pageSize), Width: 1, Height: 1}, // Flip Y
+		rl.Vector2{X: 0, Y: 0},
+		rl.White,
+	)
+	rl.EndBlendMode()
+	rl.EndTextureMode()
+
+	img := rl.LoadImageFromTexture(c.sampler.Texture)
+	defer rl.UnloadImage(img)
+	return rl.GetImageColor(*img, 0, 0)
+}
+
+// Compose the whole canvas into an image, one page at a time. Layer pages
+// that were not resident are released again afterwards.
+func (c *Compositor) Image(layers []*Layer) *image.NRGBA {
+	out := image.NewNRGBA(image.Rect(0, 0, c.width, c.height))
+	scratch := rl.LoadRenderTexture(pageSize, pageSize)
+	defer rl.UnloadRenderTexture(scratch)
+
+	for py := 0; py < c.pagesY; py++ {
+		for px := 0; px < c.pagesX; px++ {
+			var loaded []*Layer
+			for _, layer := range layers {
+				if layer.visible && !layer.hasPage(px, py) {
+					loaded = append(loaded, layer)
+				}
+			}
+
+			c.drawPage(layers, px, py, scratch)
+
+			page := readRenderTexture(scratch.Texture)
+			w := min(pageSize, c.width-px*pageSize)
+			h := min(pageSize, c.height-py*pageSize)
+			copyRows(out.Pix[out.PixOffset(px*pageSize, py*pageSize):], out.Stride, page.Pix, page.Stride, w*4, h, false)
+
+			for _, layer := range loaded {
+				layer.releasePage(px, py)
+			}
+		}
+	}
+
+	return out
+}
+
+func unionRect(a, b rl.Rectangle) rl.Rectangle {
+	x0, y0 := minf(a.X, b.X), minf(a.Y, b.Y)
+	x1, y1 := maxf(a.X+a.Width, b.X+b.Width), maxf(a.Y+a.Height, b.Y+b.Height)
+	return rl.Rectangle{X: x0, Y: y0, Width: x1 - x0, Height: y1 - y0}
+}
+
+func maxf(a, b float32) float32 {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,295 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"sort"
+
+	rl "github.com/gen2brain/raylib-go/raylib"
+)
+
+// Palette colour cycling, as in Deluxe Paint: ranges of entries in an
+// indexed palette rotate their colours while the pixels stay put, so
+// whatever is painted with them appears to move. The editor previews
+// cycling live; exports turn one loop of it into frames.
+
+const (
+	maxCycles        = 16
+	maxCycleRate     = 60 // steps per second
+	defaultCycleRate = 10
+	maxCycleLoop     = 60000 // milliseconds of cycling exported at most
+	maxCycleFrames   = 600
+)
+
+// A range of palette entries whose colours rotate
+type Cycle struct {
+	start, end int     // entries, inclusive
+	rate       float32 // steps per second
+	reverse    bool    // colours move towards lower entries
+	pingPong   bool    // back and forth instead of round
+}
+
+// Colour cycling range as stored in project.json
+type CycleData struct {
+	Start    int     `json:"start"`
+	End      int     `json:"end"`
+	Rate     float32 `json:"rate"` // steps per second
+	Reverse  bool    `json:"reverse,omitempty"`
+	PingPong bool    `json:"ping_pong,omitempty"`
+}
+
+func (c Cycle) Data() CycleData {
+	return CycleData{Start: c.start, End: c.end, Rate: c.rate, Reverse: c.reverse, PingPong: c.pingPong}
+}
+
+func cycleFromData(d CycleData) Cycle {
+	return Cycle{start: d.Start, end: d.End, rate: d.Rate, reverse: d.Reverse, pingPong: d.PingPong}
+}
+
+// Steps before the range is back where it started
+func (c Cycle) period() int {
+	n := c.end - c.start + 1
+	if c.pingPong {
+		return 2 * (n - 1)
+	}
+	return n
+}
+
+// Entries the colours have moved after t seconds
+func (c Cycle) offset(t float64) int {
+	n := c.end - c.start + 1
+	s := int(t*float64(c.rate)) % c.period()
+	if s >= n {
+		// On the way back
+		s = c.period() - s
+	}
+	if c.reverse {
+		s = (n - s) % n
+	}
+	return s
+}
+
+// Colours the indices show after cycling for t seconds, the transparent
+// entry clear
+func (p *docPalette) cycledRGBA(t float64) []rl.Color {
+	colors := make([]rl.Color, len(p.colors))
+	for i, c := range p.colors {
+		colors[i] = c.color
+	}
+	for _, c := range p.cycles {
+		if c.end >= len(colors) {
+			continue
+		}
+		n := c.end - c.start + 1
+		s := c.offset(t)
+		src := slices.Clone(colors[c.start : c.end+1])
+		for i := range n {
+			colors[c.start+(i+s)%n] = src[i]
+		}
+	}
+	colors[p.transparent] = rl.Blank
+	return colors
+}
+
+// Milliseconds until every range is back where it started, at most
+// maxCycleLoop
+func (p *docPalette) cycleLoop() int {
+	loop := 1
+	for _, c := range p.cycles {
+		period := max(1, int(math.Round(float64(c.period())*1000/float64(c.rate))))
+		a, b := loop, period
+		for b != 0 {
+			a, b = b, a%b
+		}
+		loop = loop / a * period
+		if loop >= maxCycleLoop {
+			return maxCycleLoop
+		}
+	}
+	return loop
+}
+
+// Times in milliseconds at which the colours change during a loop, from 0
+func (p *docPalette) cycleTimes(loop int) []int {
+	seen := map[int]bool{0: true}
+	for _, c := range p.cycles {
+		for k := 1; len(seen) < maxCycleFrames; k++ {
+			t := int(math.Round(float64(k) * 1000 / float64(c.rate)))
+			if t >= loop {
+				break
+			}
+			seen[t] = true
+		}
+	}
+	times := make([]int, 0, len(seen))
+	for t := range seen {
+		times = append(times, t)
+	}
+	sort.Ints(times)
+	return times
+}
+
+// One loop of a project's colour cycling over its first frame, a frame
+// per change of colours
+func cycleFrames(project ProjectData, layers []*TileStore) []animFrame {
+	w, h := project.CanvasWidth, project.CanvasHeight
+	palette := projectPalette(project)
+	mode := projectCompositing(project)
+	if palette == nil || len(palette.cycles) == 0 {
+		return []animFrame{{compositeImage(compositeLayers(project.Layers, layers, projectColors(project), mode), w, h), defaultFrameDuration}}
+	}
+
+	loop := palette.cycleLoop()
+	times := palette.cycleTimes(loop)
+	var frames []animFrame
+	for i, t := range times {
+		next := loop
+		if i+1 < len(times) {
+			next = times[i+1]
+		}
+		// Colours from the middle of the frame, clear of rounding
+		colors := palette.cycledRGBA(float64(t+next) / 2000)
+		frames = append(frames, animFrame{compositeImage(compositeLayers(project.Layers, layers, colors, mode), w, h), next - t})
+	}
+	return frames
+}
+
+// Buttons of the cycling panel, under the palette sliders
+func (app *App) initCycling() {
+	app.cycleButtons = []Button{
+		{rect: rl.Rectangle{X: 10, Y: 720, Width: 14, Height: 13}, text: "<"},
+		{rect: rl.Rectangle{X: 26, Y: 720, Width: 14, Height: 13}, text: ">"},
+		{rect: rl.Rectangle{X: 42, Y: 720, Width: 22, Height: 13}, text: "FWD"},
+		{rect: rl.Rectangle{X: 66, Y: 720, Width: 24, Height: 13}, text: "PP"},
+		{rect: rl.Rectangle{X: 10, Y: 736, Width: 14, Height: 13}, text: "-"},
+		{rect: rl.Rectangle{X: 26, Y: 736, Width: 14, Height: 13}, text: "+"},
+		{rect: rl.Rectangle{X: 10, Y: 752, Width: 38, Height: 13}, text: "CYCLE"},
+		{rect: rl.Rectangle{X: 52, Y: 752, Width: 38, Height: 13}, text: "DEL"},
+	}
+}
+
+// The range being edited, if the active document has one
+func (app *App) selectedCycle() (Cycle, bool) {
+	if app.palette == nil || len(app.palette.cycles) == 0 {
+		return Cycle{}, false
+	}
+	app.cycleRange = max(0, min(app.cycleRange, len(app.palette.cycles)-1))
+	return app.palette.cycles[app.cycleRange], true
+}
+
+// Add a cycling range between two entries of the active document's palette
+func (app *App) AddCycle(a, b int) {
+	start, end := min(a, b), max(a, b)
+	switch {
+	case start == end:
+		app.SetStatus("A RANGE NEEDS TWO COLORS")
+		return
+	case len(app.palette.cycles) >= maxCycles:
+		app.SetStatus(fmt.Sprintf("AT MOST %d RANGES", maxCycles))
+		return
+	}
+	palette := app.palette.clone()
+	palette.cycles = append(palette.cycles, Cycle{start: start, end: end, rate: defaultCycleRate})
+	app.setPalette(palette)
+	app.cycleRange = len(palette.cycles) - 1
+	app.SetStatus(fmt.Sprintf("CYCLE RANGE %d-%d", start, end))
+}
+
+// Change the range being edited
+func (app *App) editCycle(edit func(c *Cycle)) {
+	if _, ok := app.selectedCycle(); !ok {
+		return
+	}
+	palette := app.palette.clone()
+	edit(&palette.cycles[app.cycleRange])
+	app.setPalette(palette)
+}
+
+// Remove the range being edited
+func (app *App) DeleteCycle() {
+	if _, ok := app.selectedCycle(); !ok {
+		return
+	}
+	palette := app.palette.clone()
+	palette.cycles = slices.Delete(palette.cycles, app.cycleRange, app.cycleRange+1)
+	app.setPalette(palette)
+}
+
+// Start or stop previewing colour cycling
+func (app *App) ToggleCycling() {
+	if !app.cycling && (app.palette == nil || len(app.palette.cycles) == 0) {
+		app.SetStatus("NO COLOR CYCLES")
+		return
+	}
+	app.cycling = !app.cycling
+	app.cycleTime = 0
+	if !app.cycling {
+		app.compositor.SetPalette(app.paletteColors())
+	}
+}
+
+// Handle the cycling panel and Tab, and advance the preview; call once
+// per frame
+func (app *App) updateCycling(mousePos rl.Vector2) {
+	ctrl := rl.IsKeyDown(rl.KeyLeftControl) || rl.IsKeyDown(rl.KeyRightControl)
+	if !ctrl && rl.IsKeyPressed(rl.KeyTab) {
+		app.ToggleCycling()
+	}
+	if app.cycling && app.palette != nil {
+		app.cycleTime += float64(rl.GetFrameTime())
+		app.compositor.SetPalette(app.palette.cycledRGBA(app.cycleTime))
+	}
+
+	if app.palette == nil || !rl.IsMouseButtonPressed(rl.MouseLeftButton) {
+		return
+	}
+	for i, btn := range app.cycleButtons {
+		if !rl.CheckCollisionPointRec(mousePos, btn.rect) {
+			continue
+		}
+		switch i {
+		case 0:
+			app.cycleRange = max(0, app.cycleRange-1)
+		case 1:
+			app.cycleRange = min(app.cycleRange+1, len(app.palette.cycles)-1)
+		case 2:
+			app.editCycle(func(c *Cycle) { c.reverse = !c.reverse })
+		case 3:
+			app.editCycle(func(c *Cycle) { c.pingPong = !c.pingPong })
+		case 4:
+			app.editCycle(func(c *Cycle) { c.rate = clamp(float32(math.Ceil(float64(c.rate)))-1, 1, maxCycleRate) })
+		case 5:
+			app.editCycle(func(c *Cycle) { c.rate = clamp(float32(math.Floor(float64(c.rate)))+1, 1, maxCycleRate) })
+		case 6:
+			app.ToggleCycling()
+		case 7:
+			app.DeleteCycle()
+		}
+		return
+	}
+}
+
+// Draw the cycling panel of an indexed document
+func (app *App) drawCycling(mousePos rl.Vector2) {
+	cycle, ok := app.selectedCycle()
+	if ok {
+		rl.DrawText(fmt.Sprintf("RANGE %d: %d-%d", app.cycleRange+1, cycle.start, cycle.end), 10, 708, fontSize, rl.LightGray)
+		rl.DrawText(fmt.Sprintf("%g/S", cycle.rate), 44, 739, fontSize, rl.White)
+	} else {
+		rl.DrawText("NO RANGES", 10, 708, fontSize, rl.LightGray)
+	}
+	for i, btn := range app.cycleButtons {
+		switch i {
+		case 2:
+			if cycle.reverse {
+				btn.text = "REV"
+			}
+		case 3:
+			btn.selected = cycle.pingPong
+		case 6:
+			btn.selected = app.cycling
+		}
+		drawButton(btn, rl.CheckCollisionPointRec(mousePos, btn.rect))
+	}
+}
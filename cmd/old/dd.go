@@ -2,20 +2,14 @@ package main
 
 import (
 	"archive/zip"
-	"bytes"
-	"encoding/json"
 	"fmt"
 	"image"
-	"image/color"
-	"image/draw"
-	"image/jpeg"
-	"image/png"
-	"io"
 	"os"
 	"path/filepath"
 	"strings"
 
 	rl "github.com/gen2brain/raylib-go/raylib"
+	"github.com/ha1tch/deluxedraw/palette"
 )
 
 const (
@@ -28,17 +22,54 @@ const (
 
 // Project structure for save/load
 type ProjectData struct {
-	CanvasWidth  int         `json:"canvas_width"`
-	CanvasHeight int         `json:"canvas_height"`
-	Layers       []LayerData `json:"layers"`
-	Palette      []ColorData `json:"palette"`
+	FormatVersion int         `json:"format_version"`
+	CanvasWidth   int         `json:"canvas_width"`
+	CanvasHeight  int         `json:"canvas_height"`
+	TileSize      int         `json:"tile_size,omitempty"` // 0: one layer_N.png per layer
+	Layers        []LayerData `json:"layers"`
+	Palette       []ColorData `json:"palette"`
+	Frames        []FrameData `json:"frames,omitempty"`      // empty: a single frame
+	Compositing   string      `json:"compositing,omitempty"` // "linear" or "linear32", or empty for sRGB
+
+	// Indexed colour: pixels are indices into Palette
+	ColorMode   string      `json:"color_mode,omitempty"`   // "indexed", or empty for truecolour
+	Transparent int         `json:"transparent,omitempty"`  // entry that clear pixels show
+	PaletteKeys string      `json:"palette_keys,omitempty"` // DPF key character of each entry
+	Cycles      []CycleData `json:"cycles,omitempty"`       // colour cycling ranges
 }
 
 type LayerData struct {
-	Name    string  `json:"name"`
-	Visible bool    `json:"visible"`
-	Locked  bool    `json:"locked"`
-	Opacity float32 `json:"opacity"`
+	ID           string    `json:"id,omitempty"` // stable across saves
+	Name         string    `json:"name"`
+	Visible      bool      `json:"visible"`
+	Locked       bool      `json:"locked"` // pixel lock
+	LockAlpha    bool      `json:"lock_alpha,omitempty"`
+	LockPosition bool      `json:"lock_position,omitempty"`
+	Opacity      float32   `json:"opacity"`
+	BlendMode    string    `json:"blend_mode,omitempty"`
+	ColorTag     int       `json:"color_tag,omitempty"`
+	Tiles        []TileRef `json:"tiles,omitempty"` // first frame
+	Cels         []CelData `json:"cels,omitempty"`  // later frames that are not empty
+}
+
+// Animation frame
+type FrameData struct {
+	Duration int `json:"duration"` // milliseconds
+}
+
+// A layer's pixels in one frame after the first. A linked cel shares the
+// pixels of an earlier frame and lists no tiles of its own.
+type CelData struct {
+	Frame int       `json:"frame"`
+	Link  *int      `json:"link,omitempty"`
+	Tiles []TileRef `json:"tiles,omitempty"`
+}
+
+// Non-empty tile of a layer, stored as tiles/<id>.png
+type TileRef struct {
+	X  int    `json:"x"`
+	Y  int    `json:"y"`
+	ID string `json:"id"`
 }
 
 type ColorData struct {
@@ -50,11 +81,22 @@ type ColorData struct {
 
 // Layer represents a single drawing layer
 type Layer struct {
-	name    string
-	texture rl.RenderTexture2D
-	visible bool
-	locked  bool
-	opacity float32
+	id           int    // stable within a session
+	uid          string // stable across saves; names the layer file in .ddproj
+	source       int    // ID of the layer this was duplicated from, if any
+	name         string
+	tiles        *TileStore       // pixels, sparse and copy-on-write
+	cels         []*Cel           // pixels per animation frame
+	pages        map[int]*gpuPage // GPU pages currently resident
+	thumb        rl.Texture2D
+	thumbDirty   bool
+	visible      bool
+	locked       bool // no painting at all
+	lockAlpha    bool // paint only where already opaque
+	lockPosition bool // no reordering
+	opacity      float32
+	blendMode    LayerBlendMode
+	colorTag     int
 }
 
 // Tool types
@@ -71,6 +113,7 @@ const (
 	ToolCircle
 	ToolMove
 	ToolZoom
+	ToolSelect
 )
 
 // Pen shapes
@@ -109,22 +152,24 @@ type CheckBox struct {
 type HistoryAction struct {
 	actionType string
 	layerIndex int
-	layerData  *image.RGBA
+	frame      int // -1 once the frame is deleted
+	layerData  *TileStore
 }
 
 // Application state
 type App struct {
-	// Canvas and layers
-	layers       []*Layer
-	activeLayer  int
-	canvasWidth  int
-	canvasHeight int
-	layerCounter int
+	// Open documents; the fields of the active one are promoted
+	*Document
+	documents      []*Document
+	nextLayerID    int
+	clip           *clipboard
+	closing        *Document // asking whether to save before closing
+	closeAfterSave *Document
+	closeButtons   []Button
+	quitting       bool
+	quit           bool
 
 	// View
-	zoom      float32
-	panX      float32
-	panY      float32
 	isPanning bool
 	panStartX float32
 	panStartY float32
@@ -134,6 +179,13 @@ type App struct {
 	penSize      float32
 	penShape     PenShape
 	currentColor rl.Color
+	currentIndex int // palette entry painted in indexed documents
+
+	// Colour cycling preview
+	cycling      bool
+	cycleTime    float64 // seconds
+	cycleRange   int     // range edited in the cycling panel
+	cycleButtons []Button
 
 	// UI
 	toolButtons   []Button
@@ -143,74 +195,93 @@ type App struct {
 	shapeButtons  []Button
 	fileButtons   []Button
 
+	// Layer properties
+	layerNameRect      rl.Rectangle
+	layerOpacitySlider Slider
+	layerBlendButton   Button
+	layerLockBoxes     []CheckBox
+	renamingLayer      bool
+	renameBuffer       string
+
 	// State
 	isDrawing    bool
 	lastMousePos rl.Vector2
 	lineStart    rl.Vector2
+	selecting    bool
+	selectStart  image.Point
+
+	// Animation preview and onion skins
+	playing         bool
+	playMode        PlayMode
+	playDir         int
+	playTime        float32 // milliseconds into the current frame
+	onionSkin       bool
+	onionBefore     int
+	onionAfter      int
+	onionTints      [2]rl.Color // frames before, after
+	timelineButtons []Button
 
 	// Layer dragging
 	isDraggingLayer bool
 	draggedLayer    int
 	dragOffsetY     float32
 
-	// Render targets
-	compositeTexture rl.RenderTexture2D
-
 	// File operations
-	currentFilePath string
+	saveJob       *saveJob
+	recovery      *recovery
+	lastInput     float64
+	browser       *fileBrowser
+	picker        *palettePicker
+	quantizer     *quantizeDialog
+	colorEditor   *colorEditor
+	analysis      *paletteAnalysis
+	recentFiles   []string
+	exportOptions AnimExportOptions
+
+	quantizeOptions QuantizeOptions
+	vision          palette.Vision // the canvas as people with colour blindness see it
+
+	// Status line
+	statusMessage string
+	statusUntil   float64
 
 	// Undo/Redo
-	history      []HistoryAction
-	historyIndex int
-	maxHistory   int
+	maxHistory int
 }
 
 // Create a new layer
 func NewLayer(name string, width, height int) *Layer {
-	texture := rl.LoadRenderTexture(int32(width), int32(height))
-
-	// Clear to transparent
-	rl.BeginTextureMode(texture)
-	rl.ClearBackground(rl.Color{0, 0, 0, 0})
-	rl.EndTextureMode()
-
 	return &Layer{
 		name:    name,
-		texture: texture,
+		tiles:   NewTileStore(width, height),
+		pages:   make(map[int]*gpuPage),
 		visible: true,
 		locked:  false,
 		opacity: 1.0,
 	}
 }
 
+// Create a layer for the current canvas with a fresh ID
+func (app *App) newLayer(name string) *Layer {
+	layer := NewLayer(name, app.canvasWidth, app.canvasHeight)
+	app.nextLayerID++
+	layer.id = app.nextLayerID
+	layer.uid = newLayerID()
+	return layer
+}
+
 // Initialize application
 func NewApp() *App {
 	app := &App{
-		canvasWidth:  512,
-		canvasHeight: 512,
-		zoom:         1.0,
 		currentTool:  ToolPen,
 		penSize:      4.0,
 		penShape:     PenShapeRound,
 		currentColor: rl.Black,
-		layerCounter: 3,
 		maxHistory:   50,
-		historyIndex: -1,
 	}
 
-	// Create initial layers
-	app.layers = append(app.layers, NewLayer("BACKGROUND", app.canvasWidth, app.canvasHeight))
-	app.layers = append(app.layers, NewLayer("LAYER 1", app.canvasWidth, app.canvasHeight))
-	app.layers = append(app.layers, NewLayer("LAYER 2", app.canvasWidth, app.canvasHeight))
-	app.activeLayer = 1
-
-	// Fill background with white
-	rl.BeginTextureMode(app.layers[0].texture)
-	rl.ClearBackground(rl.White)
-	rl.EndTextureMode()
-
-	// Initialize composite texture
-	app.compositeTexture = rl.LoadRenderTexture(int32(app.canvasWidth), int32(app.canvasHeight))
+	// Create the first document
+	app.NewDocument(512, 512)
 
 	// Initialize tool buttons
 	tools := []struct {
@@ -228,6 +299,7 @@ func NewApp() *App {
 		{ToolCircle, 'C', "CIRCLE"},
 		{ToolMove, 'M', "MOVE"},
 		{ToolZoom, 'Z', "ZOOM"},
+		{ToolSelect, 'S', "SELECT"},
 	}
 
 	x := float32(10)
@@ -286,6 +358,20 @@ func NewApp() *App {
 		{rect: rl.Rectangle{X: leftPanel + 210, Y: 10, Width: 40, Height: 30}, text: "REDO"},
 	}
 
+	// Initialize the save prompt shown when closing a modified document
+	app.closeButtons = []Button{
+		{rect: rl.Rectangle{X: screenWidth/2 - 145, Y: screenHeight/2 + 10, Width: 90, Height: 30}, text: "SAVE"},
+		{rect: rl.Rectangle{X: screenWidth/2 - 45, Y: screenHeight/2 + 10, Width: 90, Height: 30}, text: "DISCARD"},
+		{rect: rl.Rectangle{X: screenWidth/2 + 55, Y: screenHeight/2 + 10, Width: 90, Height: 30}, text: "CANCEL"},
+	}
+
+	app.initLayerProps()
+	app.initTimeline()
+	app.initCycling()
+	app.exportOptions = AnimExportOptions{Delay: defaultFrameDuration, Dispose: apngDisposeNone, Blend: apngBlendSource}
+	app.quantizeOptions = QuantizeOptions{Fixed: true, Colors: 16}
+	app.recentFiles = loadRecentFiles()
+
 	return app
 }
 
@@ -295,21 +381,8 @@ func (app *App) SaveLayerState(layerIndex int, actionType string) {
 		return
 	}
 
-	// Get image from layer texture
-	img := rl.LoadImageFromTexture(app.layers[layerIndex].texture.Texture)
-	defer rl.UnloadImage(img)
-
-	// Convert to Go image
-	width := int(img.Width)
-	height := int(img.Height)
-	goImg := image.NewRGBA(image.Rect(0, 0, width, height))
-
-	for y := 0; y < height; y++ {
-		for x := 0; x < width; x++ {
-			c := rl.GetImageColor(*img, int32(x), int32(y))
-			goImg.Set(x, height-1-y, color.RGBA{c.R, c.G, c.B, c.A}) // Flip Y
-		}
-	}
+	// Snapshot layer pixels (tiles are shared, not copied)
+	snapshot := app.layers[layerIndex].Snapshot()
 
 	// Truncate history if we're not at the end
 	if app.historyIndex < len(app.history)-1 {
@@ -320,7 +393,8 @@ func (app *App) SaveLayerState(layerIndex int, actionType string) {
 	app.history = append(app.history, HistoryAction{
 		actionType: actionType,
 		layerIndex: layerIndex,
-		layerData:  goImg,
+		frame:      app.frame,
+		layerData:  snapshot,
 	})
 	app.historyIndex++
 
@@ -338,32 +412,13 @@ func (app *App) Undo() {
 	}
 
 	action := app.history[app.historyIndex]
-	if action.layerIndex < len(app.layers) {
-		// Restore layer state
+	if action.layerIndex < len(app.layers) && action.frame >= 0 {
+		// Restore layer state, in the frame it was painted in
+		app.SetFrame(action.frame)
 		layer := app.layers[action.layerIndex]
 
-		// Convert Go image back to raylib texture
-		bounds := action.layerData.Bounds()
-		rlImg := rl.GenImageColor(bounds.Dx(), bounds.Dy(), rl.Blank)
-
-		for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
-			for x := bounds.Min.X; x < bounds.Max.X; x++ {
-				r, g, b, a := action.layerData.At(x, y).RGBA()
-				c := rl.Color{uint8(r >> 8), uint8(g >> 8), uint8(b >> 8), uint8(a >> 8)}
-				rl.ImageDrawPixel(rlImg, int32(x), int32(bounds.Dy()-1-y), c) // Flip Y
-			}
-		}
-
-		texture := rl.LoadTextureFromImage(rlImg)
-		rl.UnloadImage(rlImg)
-
-		// Draw to layer texture
-		rl.BeginTextureMode(layer.texture)
-		rl.ClearBackground(rl.Color{0, 0, 0, 0})
-		rl.DrawTexture(texture, 0, 0, rl.White)
-		rl.EndTextureMode()
-
-		rl.UnloadTexture(texture)
+		layer.SetStore(action.layerData.Clone())
+		app.compositor.MarkLayer(layer)
 	}
 
 	app.historyIndex--
@@ -377,32 +432,13 @@ func (app *App) Redo() {
 		// Skip to next action
 		app.historyIndex++
 		action := app.history[app.historyIndex]
-		if action.layerIndex < len(app.layers) {
-			// Restore layer state
+		if action.layerIndex < len(app.layers) && action.frame >= 0 {
+			// Restore layer state, in the frame it was painted in
+			app.SetFrame(action.frame)
 			layer := app.layers[action.layerIndex]
 
-			// Convert Go image back to raylib texture
-			bounds := action.layerData.Bounds()
-			rlImg := rl.GenImageColor(bounds.Dx(), bounds.Dy(), rl.Blank)
-
-			for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
-				for x := bounds.Min.X; x < bounds.Max.X; x++ {
-					r, g, b, a := action.layerData.At(x, y).RGBA()
-					c := rl.Color{uint8(r >> 8), uint8(g >> 8), uint8(b >> 8), uint8(a >> 8)}
-					rl.ImageDrawPixel(rlImg, int32(x), int32(bounds.Dy()-1-y), c) // Flip Y
-				}
-			}
-
-			texture := rl.LoadTextureFromImage(rlImg)
-			rl.UnloadImage(rlImg)
-
-			// Draw to layer texture
-			rl.BeginTextureMode(layer.texture)
-			rl.ClearBackground(rl.Color{0, 0, 0, 0})
-			rl.DrawTexture(texture, 0, 0, rl.White)
-			rl.EndTextureMode()
-
-			rl.UnloadTexture(texture)
+			layer.SetStore(action.layerData.Clone())
+			app.compositor.MarkLayer(layer)
 		}
 	}
 }
@@ -410,7 +446,7 @@ func (app *App) Redo() {
 // Screen to canvas coordinates
 func (app *App) ScreenToCanvas(screenX, screenY float32) (int, int) {
 	canvasX := int((screenX - leftPanel - app.panX) / app.zoom)
-	canvasY := int((screenY - 50 - app.panY) / app.zoom)
+	canvasY := int((screenY - topBar - app.panY) / app.zoom)
 	return canvasX, canvasY
 }
 
@@ -418,41 +454,42 @@ func (app *App) ScreenToCanvas(screenX, screenY float32) (int, int) {
 func (app *App) AddLayer() {
 	name := fmt.Sprintf("LAYER %d", app.layerCounter)
 	app.layerCounter++
-	newLayer := NewLayer(name, app.canvasWidth, app.canvasHeight)
+	newLayer := app.newLayer(name)
 	app.layers = append(app.layers, newLayer)
 	app.activeLayer = len(app.layers) - 1
+	app.showLayer(app.activeLayer)
 }
 
 // Duplicate active layer
 func (app *App) DuplicateActiveLayer() {
 	srcLayer := app.layers[app.activeLayer]
 	name := fmt.Sprintf("%s COPY", srcLayer.name)
-	newLayer := NewLayer(name, app.canvasWidth, app.canvasHeight)
-
-	// Copy content
-	rl.BeginTextureMode(newLayer.texture)
-	rl.DrawTextureRec(
-		srcLayer.texture.Texture,
-		rl.Rectangle{X: 0, Y: 0, Width: float32(srcLayer.texture.Texture.Width), Height: -float32(srcLayer.texture.Texture.Height)},
-		rl.Vector2{X: 0, Y: 0},
-		rl.White,
-	)
-	rl.EndTextureMode()
+	newLayer := app.newLayer(name)
+	newLayer.source = srcLayer.id
+
+	// Share content; tiles are copied only when painted
+	newLayer.tiles = srcLayer.Snapshot()
+	newLayer.copyCels(srcLayer)
 
 	newLayer.visible = srcLayer.visible
 	newLayer.locked = srcLayer.locked
+	newLayer.lockAlpha = srcLayer.lockAlpha
+	newLayer.lockPosition = srcLayer.lockPosition
 	newLayer.opacity = srcLayer.opacity
+	newLayer.blendMode = srcLayer.blendMode
+	newLayer.colorTag = srcLayer.colorTag
 
 	// Insert after current layer
 	app.layers = append(app.layers[:app.activeLayer+1], append([]*Layer{newLayer}, app.layers[app.activeLayer+1:]...)...)
 	app.activeLayer++
+	app.showLayer(app.activeLayer)
 }
 
 // Delete active layer
 func (app *App) DeleteActiveLayer() {
 	if len(app.layers) > 1 && app.activeLayer > 0 { // Don't delete background
-		// Unload the texture
-		rl.UnloadRenderTexture(app.layers[app.activeLayer].texture)
+		// Unload GPU pages
+		app.layers[app.activeLayer].Unload()
 
 		// Remove from slice
 		app.layers = append(app.layers[:app.activeLayer], app.layers[app.activeLayer+1:]...)
@@ -475,6 +512,14 @@ func (app *App) ReorderLayers(fromIndex, toIndex int) {
 		return
 	}
 
+	// Position-locked layers stay where they are, including those the
+	// move would shift past
+	for i := min(fromIndex, toIndex); i <= max(fromIndex, toIndex); i++ {
+		if app.layers[i].lockPosition {
+			return
+		}
+	}
+
 	layer := app.layers[fromIndex]
 
 	// Remove layer from old position
@@ -498,63 +543,23 @@ func (app *App) ReorderLayers(fromIndex, toIndex int) {
 
 // Export to PNG
 func (app *App) ExportPNG(filename string) error {
-	// Compose layers first
-	app.ComposeLayers()
-
-	// Get image from composite texture
-	img := rl.LoadImageFromTexture(app.compositeTexture.Texture)
-	defer rl.UnloadImage(img)
+	// Compose the whole canvas
+	goImg := app.compositor.Image(app.layers)
 
-	// Convert to Go image
-	width := int(img.Width)
-	height := int(img.Height)
-	goImg := image.NewRGBA(image.Rect(0, 0, width, height))
-
-	for y := 0; y < height; y++ {
-		for x := 0; x < width; x++ {
-			c := rl.GetImageColor(*img, int32(x), int32(y))
-			goImg.Set(x, height-1-y, color.RGBA{c.R, c.G, c.B, c.A}) // Flip Y
-		}
-	}
-
-	// Save as PNG
+	// Save as PNG, tagged as sRGB
 	file, err := os.Create(filename)
 	if err != nil {
 		return err
 	}
 	defer file.Close()
 
-	return png.Encode(file, goImg)
+	return encodeTaggedPNG(file, goImg, app.exportOptions.ICC)
 }
 
 // Export to JPG
 func (app *App) ExportJPG(filename string) error {
-	// Compose layers first
-	app.ComposeLayers()
-
-	// Get image from composite texture
-	img := rl.LoadImageFromTexture(app.compositeTexture.Texture)
-	defer rl.UnloadImage(img)
-
-	// Convert to Go image (RGB, no alpha for JPEG)
-	width := int(img.Width)
-	height := int(img.Height)
-	goImg := image.NewRGBA(image.Rect(0, 0, width, height))
-
-	// Fill with white background
-	draw.Draw(goImg, goImg.Bounds(), &image.Uniform{color.White}, image.Point{}, draw.Src)
-
-	for y := 0; y < height; y++ {
-		for x := 0; x < width; x++ {
-			c := rl.GetImageColor(*img, int32(x), int32(y))
-			// Alpha blend with white background
-			alpha := float64(c.A) / 255.0
-			r := uint8(float64(c.R)*alpha + 255*(1-alpha))
-			g := uint8(float64(c.G)*alpha + 255*(1-alpha))
-			b := uint8(float64(c.B)*alpha + 255*(1-alpha))
-			goImg.Set(x, height-1-y, color.RGBA{r, g, b, 255}) // Flip Y
-		}
-	}
+	// Compose the whole canvas and blend with a white background (no alpha for JPEG)
+	goImg := flattenNRGBA(app.compositor.Image(app.layers), rl.White)
 
 	// Save as JPEG
 	file, err := os.Create(filename)
@@ -563,203 +568,81 @@ func (app *App) ExportJPG(filename string) error {
 	}
 	defer file.Close()
 
-	return jpeg.Encode(file, goImg, &jpeg.Options{Quality: 95})
+	return encodeTaggedJPEG(file, goImg, 95, app.exportOptions.ICC)
 }
 
-// Save project as .ddd
-func (app *App) SaveProject(filename string) error {
-	// Create project data
-	project := ProjectData{
-		CanvasWidth:  app.canvasWidth,
-		CanvasHeight: app.canvasHeight,
-		Layers:       make([]LayerData, len(app.layers)),
-		Palette:      make([]ColorData, len(app.colorPalette)),
-	}
-
-	// Fill layer data
-	for i, layer := range app.layers {
-		project.Layers[i] = LayerData{
-			Name:    layer.name,
-			Visible: layer.visible,
-			Locked:  layer.locked,
-			Opacity: layer.opacity,
-		}
-	}
-
-	// Fill palette data
-	for i, color := range app.colorPalette {
-		project.Palette[i] = ColorData{
-			R: color.R,
-			G: color.G,
-			B: color.B,
-			A: color.A,
-		}
-	}
-
-	// Create zip file
-	file, err := os.Create(filename)
-	if err != nil {
-		return err
-	}
-	defer file.Close()
-
-	zipWriter := zip.NewWriter(file)
-	defer zipWriter.Close()
-
-	// Save project.json
-	jsonData, err := json.MarshalIndent(project, "", "  ")
-	if err != nil {
-		return err
-	}
-
-	jsonFile, err := zipWriter.Create("project.json")
-	if err != nil {
-		return err
-	}
-	_, err = jsonFile.Write(jsonData)
-	if err != nil {
-		return err
-	}
-
-	// Save each layer as PNG
-	for i, layer := range app.layers {
-		img := rl.LoadImageFromTexture(layer.texture.Texture)
-		defer rl.UnloadImage(img)
-
-		// Convert to Go image
-		width := int(img.Width)
-		height := int(img.Height)
-		goImg := image.NewRGBA(image.Rect(0, 0, width, height))
-
-		for y := 0; y < height; y++ {
-			for x := 0; x < width; x++ {
-				c := rl.GetImageColor(*img, int32(x), int32(y))
-				goImg.Set(x, height-1-y, color.RGBA{c.R, c.G, c.B, c.A}) // Flip Y
-			}
-		}
-
-		// Add to zip
-		pngFile, err := zipWriter.Create(fmt.Sprintf("layer_%d.png", i))
-		if err != nil {
-			return err
-		}
-
-		err = png.Encode(pngFile, goImg)
-		if err != nil {
-			return err
-		}
-	}
-
-	app.currentFilePath = filename
-	return nil
+// Project read from a .ddd archive
+type loadedProject struct {
+	project  ProjectData
+	layers   []*TileStore         // first frame
+	cels     [][]*TileStore       // by layer and frame, as returned by linkCels
+	files    map[string]*zip.File // all archive entries by name
+	tiles    *archiveTiles
+	metadata ProjectMetadata
 }
 
-// Load project from .ddd
-func (app *App) LoadProject(filename string) error {
-	// Open zip file
-	reader, err := zip.OpenReader(filename)
-	if err != nil {
-		return err
-	}
-	defer reader.Close()
-
-	// Find and read project.json
-	var projectFile *zip.File
-	layerFiles := make(map[int]*zip.File)
-
-	for _, file := range reader.File {
-		if file.Name == "project.json" {
-			projectFile = file
-		} else if strings.HasPrefix(file.Name, "layer_") && strings.HasSuffix(file.Name, ".png") {
-			// Extract layer index
-			var idx int
-			fmt.Sscanf(file.Name, "layer_%d.png", &idx)
-			layerFiles[idx] = file
-		}
-	}
-
-	if projectFile == nil {
-		return fmt.Errorf("project.json not found in archive")
+// Decode a .ddd archive, failing if it does not validate
+func readProjectArchive(zr *zip.Reader) (*loadedProject, error) {
+	lp, report := decodeProjectArchive(zr)
+	if err := report.Err(); err != nil {
+		return nil, err
 	}
+	return lp, nil
+}
 
-	// Read project data
-	rc, err := projectFile.Open()
-	if err != nil {
-		return err
-	}
-	defer rc.Close()
-
-	var project ProjectData
-	decoder := json.NewDecoder(rc)
-	err = decoder.Decode(&project)
-	if err != nil {
-		return err
-	}
+// Replace the active document with a loaded project
+func (app *App) applyProject(lp *loadedProject) {
+	project := lp.project
 
-	// Clear existing layers
+	// Clear existing layers and history
 	for _, layer := range app.layers {
-		rl.UnloadRenderTexture(layer.texture)
+		layer.Unload()
 	}
 	app.layers = nil
+	app.history = nil
+	app.historyIndex = -1
 
 	// Update canvas size
 	app.canvasWidth = project.CanvasWidth
 	app.canvasHeight = project.CanvasHeight
+	app.metadata = lp.metadata
 
-	// Recreate composite texture
-	rl.UnloadRenderTexture(app.compositeTexture)
-	app.compositeTexture = rl.LoadRenderTexture(int32(app.canvasWidth), int32(app.canvasHeight))
+	// Resize compositor
+	app.compositor.Resize(app.canvasWidth, app.canvasHeight)
+	app.compositing = projectCompositing(project)
+	app.compositor.SetCompositing(app.compositing)
 
-	// Load layers
+	// Load frames and layers, showing the first frame
+	app.frames = nil
+	for _, frameData := range project.Frames {
+		app.frames = append(app.frames, Frame{duration: frameData.Duration})
+	}
+	if len(app.frames) == 0 {
+		app.frames = []Frame{{duration: defaultFrameDuration}}
+	}
+	app.frame = 0
 	for i, layerData := range project.Layers {
-		layer := NewLayer(layerData.Name, app.canvasWidth, app.canvasHeight)
-		layer.visible = layerData.Visible
-		layer.locked = layerData.Locked
-		layer.opacity = layerData.Opacity
-
-		// Load layer image
-		if layerFile, ok := layerFiles[i]; ok {
-			rc, err := layerFile.Open()
-			if err == nil {
-				// Read PNG data
-				imgData, err := io.ReadAll(rc)
-				rc.Close()
-
-				if err == nil {
-					// Decode PNG
-					img, err := png.Decode(bytes.NewReader(imgData))
-					if err == nil {
-						// Convert to raylib texture
-						bounds := img.Bounds()
-						rlImg := rl.GenImageColor(bounds.Dx(), bounds.Dy(), rl.Blank)
-
-						for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
-							for x := bounds.Min.X; x < bounds.Max.X; x++ {
-								r, g, b, a := img.At(x, y).RGBA()
-								c := rl.Color{uint8(r >> 8), uint8(g >> 8), uint8(b >> 8), uint8(a >> 8)}
-								rl.ImageDrawPixel(rlImg, int32(x), int32(bounds.Dy()-1-y), c) // Flip Y
-							}
-						}
-
-						texture := rl.LoadTextureFromImage(rlImg)
-						rl.UnloadImage(rlImg)
-
-						// Draw to layer texture
-						rl.BeginTextureMode(layer.texture)
-						rl.DrawTexture(texture, 0, 0, rl.White)
-						rl.EndTextureMode()
-
-						rl.UnloadTexture(texture)
-					}
+		layer := app.newLayer(layerData.Name)
+		layer.tiles = lp.layers[i]
+		if i < len(lp.cels) {
+			cels := make(map[*TileStore]*Cel)
+			for f, store := range lp.cels[i] {
+				if store == nil {
+					continue
+				}
+				if cels[store] == nil {
+					cels[store] = &Cel{tiles: store}
 				}
+				layer.setCel(f, cels[store])
 			}
 		}
-
+		layer.SetData(layerData)
 		app.layers = append(app.layers, layer)
 	}
 
-	// Load palette
-	if len(project.Palette) > 0 {
+	// Load palette; an indexed document keeps its own
+	app.setPalette(projectPalette(project))
+	if app.palette == nil && len(project.Palette) > 0 {
 		app.colorPalette = nil
 		for _, colorData := range project.Palette {
 			app.colorPalette = append(app.colorPalette, rl.Color{
@@ -773,10 +656,67 @@ func (app *App) LoadProject(filename string) error {
 
 	// Reset view
 	app.activeLayer = min(1, len(app.layers)-1)
+	app.renamingLayer = false
 	app.zoom = 1.0
 	app.panX = 0
 	app.panY = 0
+}
+
+// Load project from .ddd or .ddproj
+func (app *App) LoadProject(filename string) error {
+	// A file that is already open just gets its tab back
+	for _, doc := range app.documents {
+		if doc.currentFilePath != "" && sameFile(doc.currentFilePath, filename) {
+			app.SwitchDocument(doc)
+			return nil
+		}
+	}
+
+	lp, report := openProject(filename)
+	if err := report.Err(); err != nil {
+		return err
+	}
+
+	app.openDocument()
+	app.applyProject(lp)
 	app.currentFilePath = filename
+	app.markSaved()
+
+	return nil
+}
+
+// Open a PNG or JPEG as a new single-layer document. It has no file name
+// yet, so the next save asks for one.
+func (app *App) ImportImage(filename string) error {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	img, err := decodeImage(data)
+	if err != nil {
+		return err
+	}
+	w, h := img.Rect.Dx(), img.Rect.Dy()
+	if w < 1 || h < 1 || w > maxCanvasSize || h > maxCanvasSize {
+		return fmt.Errorf("image is %dx%d, canvas must be 1-%d pixels each way", w, h, maxCanvasSize)
+	}
+
+	name := strings.ToUpper(strings.TrimSuffix(filepath.Base(filename), filepath.Ext(filename)))
+	if len(name) > 24 {
+		name = name[:24]
+	}
+	app.openDocument()
+	app.applyProject(&loadedProject{
+		project: ProjectData{
+			CanvasWidth:  w,
+			CanvasHeight: h,
+			Layers:       []LayerData{{Name: name, Visible: true, Opacity: 1}},
+		},
+		layers:   []*TileStore{TileStoreFromNRGBA(img)},
+		metadata: newProjectMetadata(),
+	})
+	app.currentFilePath = ""
+	app.markSaved()
 
 	return nil
 }
@@ -785,6 +725,56 @@ func (app *App) LoadProject(filename string) error {
 func (app *App) Update() {
 	mousePos := rl.GetMousePosition()
 
+	// Finish background saves, and close documents waiting on them
+	app.pollSave()
+	app.updateQuit()
+
+	app.trackEditTime()
+
+	// Autosave, and ask about work left by a crashed session
+	app.updateRecovery()
+	if app.recovery != nil && app.recovery.prompt != "" {
+		app.updateRecoveryPrompt(mousePos)
+		return
+	}
+
+	// Dialogs take all input while open
+	if app.closing != nil {
+		app.updateClosePrompt(mousePos)
+		return
+	}
+	if app.browser != nil {
+		app.updateFileBrowser(mousePos)
+		return
+	}
+	if app.picker != nil {
+		app.updatePalettePicker(mousePos)
+		return
+	}
+	if app.quantizer != nil {
+		app.updateQuantize(mousePos)
+		return
+	}
+	if app.analysis != nil {
+		app.updatePaletteAnalysis(mousePos)
+		return
+	}
+	if app.colorEditor != nil {
+		app.updateColorEditor(mousePos)
+		return
+	}
+
+	// Document tabs
+	if app.updateTabs(mousePos) {
+		return
+	}
+
+	// Layer rename takes all keyboard input
+	if app.renamingLayer {
+		app.updateRename(mousePos)
+		return
+	}
+
 	// Handle keyboard shortcuts
 	if rl.IsKeyDown(rl.KeyLeftControl) || rl.IsKeyDown(rl.KeyRightControl) {
 		if rl.IsKeyPressed(rl.KeyZ) {
@@ -798,17 +788,82 @@ func (app *App) Update() {
 			app.Redo()
 		}
 		if rl.IsKeyPressed(rl.KeyS) {
-			if app.currentFilePath != "" {
-				app.SaveProject(app.currentFilePath)
+			if app.currentFilePath != "" && !rl.IsKeyDown(rl.KeyLeftShift) && !rl.IsKeyDown(rl.KeyRightShift) {
+				app.StartSave(app.currentFilePath)
 			} else {
-				app.SaveProject("untitled.ddd")
+				app.OpenFileBrowser(browseSave)
 			}
 		}
 		if rl.IsKeyPressed(rl.KeyO) {
-			app.LoadProject("untitled.ddd") // In real app, would show file dialog
+			app.OpenFileBrowser(browseOpen)
 		}
 		if rl.IsKeyPressed(rl.KeyE) {
-			app.ExportPNG("export.png")
+			app.OpenFileBrowser(browseExport)
+		}
+		if rl.IsKeyPressed(rl.KeyN) {
+			app.NewDocument(app.canvasWidth, app.canvasHeight)
+		}
+		if rl.IsKeyPressed(rl.KeyW) {
+			app.CloseDocument(app.Document)
+		}
+		if rl.IsKeyPressed(rl.KeyTab) {
+			// Next document, or previous with Shift
+			step := 1
+			if rl.IsKeyDown(rl.KeyLeftShift) || rl.IsKeyDown(rl.KeyRightShift) {
+				step = len(app.documents) - 1
+			}
+			for i, doc := range app.documents {
+				if doc == app.Document {
+					app.SwitchDocument(app.documents[(i+step)%len(app.documents)])
+					break
+				}
+			}
+		}
+		shift := rl.IsKeyDown(rl.KeyLeftShift) || rl.IsKeyDown(rl.KeyRightShift)
+		alt := rl.IsKeyDown(rl.KeyLeftAlt) || rl.IsKeyDown(rl.KeyRightAlt)
+		if rl.IsKeyPressed(rl.KeyA) {
+			app.SelectAll()
+		}
+		if rl.IsKeyPressed(rl.KeyD) {
+			app.Deselect()
+		}
+		if rl.IsKeyPressed(rl.KeyX) {
+			app.Cut()
+		}
+		if rl.IsKeyPressed(rl.KeyC) {
+			if shift {
+				app.CopyMerged()
+			} else {
+				app.Copy()
+			}
+		}
+		if rl.IsKeyPressed(rl.KeyI) {
+			app.ToggleIndexed()
+		}
+		if rl.IsKeyPressed(rl.KeyP) {
+			app.OpenPalettePicker()
+		}
+		if rl.IsKeyPressed(rl.KeyR) {
+			app.OpenQuantize()
+		}
+		if rl.IsKeyPressed(rl.KeyK) {
+			app.OpenColorEditor()
+		}
+		if rl.IsKeyPressed(rl.KeyB) {
+			app.CycleVision()
+		}
+		if rl.IsKeyPressed(rl.KeyL) {
+			app.CycleCompositing()
+		}
+		if rl.IsKeyPressed(rl.KeyV) {
+			switch {
+			case alt:
+				app.OpenFileBrowser(browsePaste)
+			case shift:
+				app.PasteInPlace()
+			default:
+				app.Paste()
+			}
 		}
 	}
 
@@ -818,14 +873,14 @@ func (app *App) Update() {
 			switch i {
 			case 0: // Save
 				if app.currentFilePath != "" {
-					app.SaveProject(app.currentFilePath)
+					app.StartSave(app.currentFilePath)
 				} else {
-					app.SaveProject("untitled.ddd")
+					app.OpenFileBrowser(browseSave)
 				}
 			case 1: // Load
-				app.LoadProject("untitled.ddd") // In real app, would show file dialog
+				app.OpenFileBrowser(browseOpen)
 			case 2: // Export
-				app.ExportPNG("export.png")
+				app.OpenFileBrowser(browseExport)
 			case 3: // Undo
 				app.Undo()
 			case 4: // Redo
@@ -834,21 +889,22 @@ func (app *App) Update() {
 		}
 	}
 
-	// Handle layer dragging
+	// Handle layer list scrolling and dragging
+	app.updateLayerScroll(mousePos)
 	if app.isDraggingLayer {
 		if rl.IsMouseButtonReleased(rl.MouseLeftButton) {
 			// Calculate new position
-			layerY := float32(100)
 			newIndex := -1
 
 			for i := len(app.layers) - 1; i >= 0; i-- {
-				y := layerY + float32(len(app.layers)-1-i)*60
+				y := app.layerRowY(i)
 				if mousePos.Y < y+30 {
 					newIndex = i
 				}
 			}
 
-			if newIndex >= 0 && newIndex != app.draggedLayer {
+			// A layer dropped on another document's tab is copied there
+			if !app.dropLayerOnTab(mousePos) && newIndex >= 0 && newIndex != app.draggedLayer {
 				app.ReorderLayers(app.draggedLayer, newIndex)
 			}
 
@@ -882,7 +938,7 @@ func (app *App) Update() {
 
 	// Handle zoom with mouse wheel
 	wheel := rl.GetMouseWheelMove()
-	if wheel != 0 && mousePos.X > leftPanel && mousePos.X < screenWidth-rightPanel {
+	if wheel != 0 && mousePos.X > leftPanel && mousePos.X < screenWidth-rightPanel && mousePos.Y < timelineY {
 		oldZoom := app.zoom
 		app.zoom *= 1.0 + wheel*0.1
 		app.zoom = clamp(app.zoom, 0.25, 32.0)
@@ -891,7 +947,7 @@ func (app *App) Update() {
 		if app.zoom != oldZoom {
 			zoomFactor := app.zoom / oldZoom
 			app.panX = mousePos.X - leftPanel - (mousePos.X-leftPanel-app.panX)*zoomFactor
-			app.panY = mousePos.Y - 50 - (mousePos.Y-50-app.panY)*zoomFactor
+			app.panY = mousePos.Y - topBar - (mousePos.Y-topBar-app.panY)*zoomFactor
 		}
 	}
 
@@ -926,16 +982,8 @@ func (app *App) Update() {
 	}
 
 	// Handle color palette
-	paletteY := float32(400)
-	for i, color := range app.colorPalette {
-		x := float32(10 + (i%3)*25)
-		y := paletteY + float32(i/3)*25
-		rect := rl.Rectangle{X: x, Y: y, Width: 20, Height: 20}
-
-		if rl.CheckCollisionPointRec(mousePos, rect) && rl.IsMouseButtonPressed(rl.MouseLeftButton) {
-			app.currentColor = color
-		}
-	}
+	app.updatePalette(mousePos)
+	app.updateCycling(mousePos)
 
 	// Handle pen size slider
 	if rl.CheckCollisionPointRec(mousePos, app.penSizeSlider.rect) && rl.IsMouseButtonDown(rl.MouseLeftButton) {
@@ -961,10 +1009,15 @@ func (app *App) Update() {
 		}
 	}
 
+	// Handle layer properties panel
+	app.updateLayerProps(mousePos)
+
 	// Handle layer selection and dragging
-	layerY := float32(100)
 	for i := len(app.layers) - 1; i >= 0; i-- {
-		y := layerY + float32(len(app.layers)-1-i)*60
+		if !app.layerRowShown(i) {
+			continue
+		}
+		y := app.layerRowY(i)
 		layerRect := rl.Rectangle{
 			X:      screenWidth - rightPanel + 10,
 			Y:      y,
@@ -980,28 +1033,38 @@ func (app *App) Update() {
 			} else {
 				app.activeLayer = i
 				// Start dragging
-				app.isDraggingLayer = true
-				app.draggedLayer = i
-				app.dragOffsetY = mousePos.Y - y
+				if !app.layers[i].lockPosition {
+					app.isDraggingLayer = true
+					app.draggedLayer = i
+					app.dragOffsetY = mousePos.Y - y
+				}
 			}
 		}
 	}
 
+	// Animation timeline
+	app.updateTimeline(mousePos)
+	app.updatePlayback()
+
+	// Selecting works on locked layers too
+	if app.currentTool == ToolSelect {
+		app.updateSelect(mousePos)
+	}
+
 	// Handle drawing on canvas
-	if mousePos.X > leftPanel && mousePos.X < screenWidth-rightPanel && !app.layers[app.activeLayer].locked {
+	if app.currentTool != ToolSelect && mousePos.X > leftPanel && mousePos.X < screenWidth-rightPanel && (mousePos.Y > topBar && mousePos.Y < timelineY || app.isDrawing) && !app.layers[app.activeLayer].locked {
 		canvasX, canvasY := app.ScreenToCanvas(mousePos.X, mousePos.Y)
 
 		if rl.IsMouseButtonPressed(rl.MouseLeftButton) {
 			// Handle eyedropper tool
 			if app.currentTool == ToolEyedropper {
-				// Get color from composite texture
-				app.ComposeLayers()
-				img := rl.LoadImageFromTexture(app.compositeTexture.Texture)
-				defer rl.UnloadImage(img)
-
+				// Get color from composite, or the index of an indexed document
 				if canvasX >= 0 && canvasX < app.canvasWidth && canvasY >= 0 && canvasY < app.canvasHeight {
-					color := rl.GetImageColor(*img, int32(canvasX), int32(app.canvasHeight-1-canvasY)) // Flip Y
-					app.currentColor = color
+					if app.palette != nil {
+						app.selectIndex(app.pickIndex(canvasX, canvasY))
+					} else {
+						app.currentColor = app.compositor.Sample(app.layers, canvasX, canvasY)
+					}
 				}
 			} else {
 				// Save state before any drawing operation
@@ -1016,60 +1079,86 @@ func (app *App) Update() {
 		currentPos := rl.Vector2{X: float32(canvasX), Y: float32(canvasY)}
 
 		// Draw on active layer
-		rl.BeginTextureMode(app.layers[app.activeLayer].texture)
-
-		switch app.currentTool {
-		case ToolPen, ToolBrush:
-			if app.penShape == PenShapeSquare {
-				// Draw square pen
-				DrawSquareLine(app.lastMousePos, currentPos, app.penSize, app.currentColor)
-			} else {
-				// Draw round pen
-				rl.DrawLineEx(app.lastMousePos, currentPos, app.penSize, app.currentColor)
-				rl.DrawCircleV(currentPos, app.penSize/2, app.currentColor)
+		layer := app.layers[app.activeLayer]
+		from := app.lastMousePos
+		paint := app.paintColor()
+
+		switch {
+		case !app.isDrawing:
+		case app.currentTool == ToolPen || app.currentTool == ToolBrush:
+			area := strokeBounds(from, currentPos, app.penSize)
+			app.compositor.MarkLayerRect(layer, area)
+			layer.Paint(area, func() {
+				beginLayerPaint(layer, app.palette != nil)
+				if app.penShape == PenShapeSquare {
+					// Draw square pen
+					DrawSquareLine(from, currentPos, app.penSize, paint)
+				} else {
+					// Draw round pen
+					rl.DrawLineEx(from, currentPos, app.penSize, paint)
+					rl.DrawCircleV(currentPos, app.penSize/2, paint)
+				}
+				rl.EndBlendMode()
+			})
+		case app.currentTool == ToolEraser:
+			// Erasing changes alpha, so alpha-locked layers are left alone
+			if layer.lockAlpha {
+				break
 			}
-		case ToolEraser:
+
 			// Use blend mode for erasing
-			rl.BeginBlendMode(rl.BlendSubtractColors)
-			if app.penShape == PenShapeSquare {
-				DrawSquareLine(app.lastMousePos, currentPos, app.penSize*2, rl.Color{255, 255, 255, 255})
-			} else {
-				rl.DrawLineEx(app.lastMousePos, currentPos, app.penSize*2, rl.Color{255, 255, 255, 255})
-				rl.DrawCircleV(currentPos, app.penSize, rl.Color{255, 255, 255, 255})
-			}
-			rl.EndBlendMode()
+			area := strokeBounds(from, currentPos, app.penSize*2)
+			app.compositor.MarkLayerRect(layer, area)
+			layer.Paint(area, func() {
+				rl.BeginBlendMode(rl.BlendSubtractColors)
+				if app.penShape == PenShapeSquare {
+					DrawSquareLine(from, currentPos, app.penSize*2, rl.Color{255, 255, 255, 255})
+				} else {
+					rl.DrawLineEx(from, currentPos, app.penSize*2, rl.Color{255, 255, 255, 255})
+					rl.DrawCircleV(currentPos, app.penSize, rl.Color{255, 255, 255, 255})
+				}
+				rl.EndBlendMode()
+			})
 		}
 
-		rl.EndTextureMode()
 		app.lastMousePos = currentPos
 	}
 
 	if rl.IsMouseButtonReleased(rl.MouseLeftButton) && app.isDrawing {
+		layer := app.layers[app.activeLayer]
 		if app.currentTool == ToolLine || app.currentTool == ToolRect || app.currentTool == ToolCircle {
 			currentPos := rl.Vector2{X: float32(screenWidth), Y: float32(screenHeight)}
-
-			rl.BeginTextureMode(app.layers[app.activeLayer].texture)
-
-			switch app.currentTool {
-			case ToolLine:
-				rl.DrawLineEx(app.lineStart, currentPos, app.penSize, app.currentColor)
-			case ToolRect:
-				x := minf(app.lineStart.X, currentPos.X)
-				y := minf(app.lineStart.Y, currentPos.Y)
-				w := abs(currentPos.X - app.lineStart.X)
-				h := abs(currentPos.Y - app.lineStart.Y)
-				rl.DrawRectangleLines(int32(x), int32(y), int32(w), int32(h), app.currentColor)
-			case ToolCircle:
-				center := rl.Vector2{
-					X: (app.lineStart.X + currentPos.X) / 2,
-					Y: (app.lineStart.Y + currentPos.Y) / 2,
+			area := strokeBounds(app.lineStart, currentPos, app.penSize)
+			paint := app.paintColor()
+
+			layer.Paint(area, func() {
+				beginLayerPaint(layer, app.palette != nil)
+
+				switch app.currentTool {
+				case ToolLine:
+					rl.DrawLineEx(app.lineStart, currentPos, app.penSize, paint)
+				case ToolRect:
+					x := minf(app.lineStart.X, currentPos.X)
+					y := minf(app.lineStart.Y, currentPos.Y)
+					w := abs(currentPos.X - app.lineStart.X)
+					h := abs(currentPos.Y - app.lineStart.Y)
+					rl.DrawRectangleLines(int32(x), int32(y), int32(w), int32(h), paint)
+				case ToolCircle:
+					center := rl.Vector2{
+						X: (app.lineStart.X + currentPos.X) / 2,
+						Y: (app.lineStart.Y + currentPos.Y) / 2,
+					}
+					radius := rl.Vector2Distance(app.lineStart, currentPos) / 2
+					rl.DrawCircleLines(int32(center.X), int32(center.Y), radius, paint)
 				}
-				radius := rl.Vector2Distance(app.lineStart, currentPos) / 2
-				rl.DrawCircleLines(int32(center.X), int32(center.Y), radius, app.currentColor)
-			}
 
-			rl.EndTextureMode()
+				rl.EndBlendMode()
+			})
+			app.compositor.MarkLayerRect(layer, area)
 		}
+
+		// Write the stroke back to the tile store
+		layer.Sync()
 		app.isDrawing = false
 	}
 
@@ -1118,25 +1207,27 @@ func DrawSquareLine(start, end rl.Vector2, size float32, color rl.Color) {
 	}
 }
 
-// Render layers to composite texture
+// Visible part of the canvas in canvas coordinates
+func (app *App) CanvasView() rl.Rectangle {
+	return rl.Rectangle{
+		X:      -app.panX / app.zoom,
+		Y:      -app.panY / app.zoom,
+		Width:  float32(screenWidth-leftPanel-rightPanel) / app.zoom,
+		Height: float32(timelineY-topBar) / app.zoom,
+	}
+}
+
+// Page layers for the current view and bring the composite up to date
 func (app *App) ComposeLayers() {
-	rl.BeginTextureMode(app.compositeTexture)
-	rl.ClearBackground(rl.Color{0, 0, 0, 0})
+	view := app.CanvasView()
 
+	// Keep one page of margin around the view resident
+	keep := rl.Rectangle{X: view.X - pageSize, Y: view.Y - pageSize, Width: view.Width + 2*pageSize, Height: view.Height + 2*pageSize}
 	for _, layer := range app.layers {
-		if !layer.visible {
-			continue
-		}
-
-		rl.DrawTextureRec(
-			layer.texture.Texture,
-			rl.Rectangle{X: 0, Y: 0, Width: float32(layer.texture.Texture.Width), Height: -float32(layer.texture.Texture.Height)},
-			rl.Vector2{X: 0, Y: 0},
-			rl.White,
-		)
+		layer.ReleasePages(keep)
 	}
 
-	rl.EndTextureMode()
+	app.compositor.Compose(app.layers, view)
 }
 
 // Draw application
@@ -1175,7 +1266,7 @@ func (app *App) Draw() {
 
 		// Draw tooltip on hover
 		if btn.hover {
-			tools := []string{"PEN", "BRUSH", "ERASER", "FILL", "PICKER", "LINE", "RECT", "CIRCLE", "MOVE", "ZOOM"}
+			tools := []string{"PEN", "BRUSH", "ERASER", "FILL", "PICKER", "LINE", "RECT", "CIRCLE", "MOVE", "ZOOM", "SELECT"}
 			rl.DrawText(tools[i], int32(mousePos.X+10), int32(mousePos.Y), fontSize, rl.Yellow)
 		}
 	}
@@ -1209,36 +1300,21 @@ func (app *App) Draw() {
 	}
 
 	// Draw color palette
-	rl.DrawText("COLORS", 10, 385, fontSize, rl.LightGray)
-	paletteY := float32(400)
-	for i, color := range app.colorPalette {
-		x := float32(10 + (i%3)*25)
-		y := paletteY + float32(i/3)*25
-		rect := rl.Rectangle{X: x, Y: y, Width: 20, Height: 20}
-
-		rl.DrawRectangleRec(rect, color)
-		if app.currentColor == color {
-			rl.DrawRectangleLinesEx(rect, 2, rl.White)
-		} else {
-			rl.DrawRectangleLinesEx(rect, 1, rl.Color{70, 70, 70, 255})
-		}
+	app.drawPalette()
+	if app.palette != nil {
+		app.drawCycling(mousePos)
 	}
 
-	// Draw current color
-	rl.DrawRectangle(10, 620, 40, 30, app.currentColor)
-	rl.DrawRectangleLines(10, 620, 40, 30, rl.White)
-
 	// Draw right panel (layers)
 	rl.DrawRectangle(screenWidth-rightPanel, 0, rightPanel, screenHeight, rl.Color{50, 50, 50, 255})
 	rl.DrawText("LAYERS", screenWidth-rightPanel+10, 10, fontSize, rl.White)
 
 	// Draw layer entries (top to bottom)
-	layerY := float32(100)
 	for i := len(app.layers) - 1; i >= 0; i-- {
-		y := layerY + float32(len(app.layers)-1-i)*60
+		y := app.layerRowY(i)
 
-		// Skip if being dragged
-		if app.isDraggingLayer && i == app.draggedLayer {
+		// Skip if being dragged or scrolled out of view
+		if (app.isDraggingLayer && i == app.draggedLayer) || !app.layerRowShown(i) {
 			continue
 		}
 
@@ -1249,6 +1325,11 @@ func (app *App) Draw() {
 		}
 		rl.DrawRectangle(screenWidth-rightPanel+10, int32(y), rightPanel-20, 50, bgColor)
 
+		// Colour tag
+		if tag := app.layers[i].colorTag; tag > 0 && tag < len(layerTagColors) {
+			rl.DrawRectangle(screenWidth-rightPanel+10, int32(y), 3, 50, layerTagColors[tag])
+		}
+
 		// Visibility toggle
 		visX := int32(screenWidth - rightPanel + 15)
 		visY := int32(y + 5)
@@ -1258,9 +1339,22 @@ func (app *App) Draw() {
 			rl.DrawText("V", visX+6, visY+6, fontSize, rl.White)
 		}
 
-		// Lock indicator
+		// Lock indicators
+		locks := ""
 		if app.layers[i].locked {
-			rl.DrawText("L", visX+45, visY+6, fontSize, rl.Yellow)
+			locks += "L"
+		}
+		if app.layers[i].lockAlpha {
+			locks += "A"
+		}
+		if app.layers[i].lockPosition {
+			locks += "P"
+		}
+		if locks != "" {
+			rl.DrawText(locks, visX+30, visY+26, fontSize, rl.Yellow)
+		}
+		if app.layers[i].opacity < 1 || app.layers[i].blendMode != BlendNormal {
+			rl.DrawText(fmt.Sprintf("%.0f%% %s", app.layers[i].opacity*100, app.layers[i].blendMode), visX+60, visY+26, fontSize, rl.LightGray)
 		}
 
 		// Layer name
@@ -1281,12 +1375,14 @@ func (app *App) Draw() {
 		rl.DrawRectangle(int32(previewX), int32(previewY+previewSize/2), int32(previewSize/2), int32(previewSize/2), rl.Color{150, 150, 150, 255})
 
 		// Draw layer preview
-		srcRect := rl.Rectangle{X: 0, Y: 0, Width: float32(app.canvasWidth), Height: -float32(app.canvasHeight)}
+		srcRect := rl.Rectangle{X: 0, Y: 0, Width: thumbSize, Height: thumbSize}
 		dstRect := rl.Rectangle{X: previewX, Y: previewY, Width: previewSize, Height: previewSize}
-		rl.DrawTexturePro(app.layers[i].texture.Texture, srcRect, dstRect, rl.Vector2{}, 0, rl.White)
+		rl.DrawTexturePro(app.layers[i].Thumbnail(app.paletteColors()), srcRect, dstRect, rl.Vector2{}, 0, rl.White)
 		rl.DrawRectangleLinesEx(dstRect, 1, rl.Color{70, 70, 70, 255})
 	}
 
+	app.drawLayerScrollBar()
+
 	// Draw dragged layer
 	if app.isDraggingLayer {
 		y := mousePos.Y - app.dragOffsetY
@@ -1298,9 +1394,8 @@ func (app *App) Draw() {
 		rl.DrawText(app.layers[app.draggedLayer].name, screenWidth-rightPanel+45, int32(y+8), fontSize, rl.White)
 
 		// Draw insertion line
-		layerY := float32(100)
 		for i := len(app.layers) - 1; i >= 0; i-- {
-			checkY := layerY + float32(len(app.layers)-1-i)*60
+			checkY := app.layerRowY(i)
 			if mousePos.Y < checkY+30 {
 				rl.DrawRectangle(screenWidth-rightPanel+10, int32(checkY-2), rightPanel-20, 4, rl.Yellow)
 				break
@@ -1308,6 +1403,9 @@ func (app *App) Draw() {
 		}
 	}
 
+	// Draw layer properties
+	app.drawLayerProps()
+
 	// Draw layer buttons
 	for _, btn := range app.layerButtons {
 		color := rl.Color{70, 70, 70, 255}
@@ -1326,6 +1424,7 @@ func (app *App) Draw() {
 
 	// Draw top bar
 	rl.DrawRectangle(leftPanel, 0, screenWidth-leftPanel-rightPanel, 50, rl.Color{60, 60, 60, 255})
+	app.drawTabs()
 
 	// Draw file buttons
 	for _, btn := range app.fileButtons {
@@ -1356,12 +1455,20 @@ func (app *App) Draw() {
 	if len(app.history) > 0 {
 		historyStatus = fmt.Sprintf(" | HISTORY: %d/%d", app.historyIndex+1, len(app.history))
 	}
-	info := fmt.Sprintf("FILE: %s | ZOOM: %.0f%% | %dX%d | %s%s%s",
-		fileStatus, app.zoom*100, app.canvasWidth, app.canvasHeight, app.layers[app.activeLayer].name, panStatus, historyStatus)
+	colorMode := "RGB"
+	if app.palette != nil {
+		colorMode = "INDEXED"
+	}
+	if app.compositing.Linear() {
+		colorMode += " " + compositingLabels[app.compositing]
+	}
+	info := fmt.Sprintf("FILE: %s | ZOOM: %.0f%% | %dX%d %s | %s%s%s",
+		fileStatus, app.zoom*100, app.canvasWidth, app.canvasHeight, colorMode, app.layers[app.activeLayer].name, panStatus, historyStatus)
 	rl.DrawText(info, leftPanel+280, 20, fontSize, rl.White)
+	app.drawStatus()
 
 	// Draw canvas viewport
-	rl.BeginScissorMode(leftPanel, 50, screenWidth-leftPanel-rightPanel, screenHeight-50)
+	rl.BeginScissorMode(leftPanel, topBar, screenWidth-leftPanel-rightPanel, timelineY-topBar)
 
 	// Draw checkerboard background
 	tileSize := int32(16 * app.zoom)
@@ -1373,7 +1480,7 @@ func (app *App) Draw() {
 			if (x+y)%2 == 0 {
 				rl.DrawRectangle(
 					leftPanel+x*tileSize+offsetX,
-					50+y*tileSize+offsetY,
+					topBar+y*tileSize+offsetY,
 					tileSize, tileSize,
 					rl.Color{150, 150, 150, 255},
 				)
@@ -1382,20 +1489,24 @@ func (app *App) Draw() {
 	}
 
 	// Draw canvas
-	srcRect := rl.Rectangle{X: 0, Y: 0, Width: float32(app.canvasWidth), Height: -float32(app.canvasHeight)}
+	vision := app.beginVision()
+	app.compositor.Draw(leftPanel+app.panX, topBar+app.panY, app.zoom)
+	app.drawOnionSkins()
+	if vision {
+		rl.EndShaderMode()
+	}
 	dstRect := rl.Rectangle{
 		X:      leftPanel + app.panX,
-		Y:      50 + app.panY,
+		Y:      topBar + app.panY,
 		Width:  float32(app.canvasWidth) * app.zoom,
 		Height: float32(app.canvasHeight) * app.zoom,
 	}
-	rl.DrawTexturePro(app.compositeTexture.Texture, srcRect, dstRect, rl.Vector2{}, 0, rl.White)
-
 	// Draw canvas border
 	rl.DrawRectangleLinesEx(dstRect, 2, rl.Color{100, 100, 100, 255})
+	app.drawSelection()
 
 	// Draw cursor
-	if mousePos.X > leftPanel && mousePos.X < screenWidth-rightPanel && !app.isPanning {
+	if mousePos.X > leftPanel && mousePos.X < screenWidth-rightPanel && mousePos.Y < timelineY && !app.isPanning {
 		canvasX, canvasY := app.ScreenToCanvas(mousePos.X, mousePos.Y)
 
 		if canvasX >= 0 && canvasX < app.canvasWidth && canvasY >= 0 && canvasY < app.canvasHeight {
@@ -1425,9 +1536,19 @@ func (app *App) Draw() {
 	}
 
 	rl.EndScissorMode()
+	app.drawTimeline(mousePos)
 
 	// Draw shortcuts help
-	rl.DrawText("CTRL+Z: UNDO | CTRL+Y: REDO | SPACE+DRAG: PAN", 10, screenHeight-20, fontSize, rl.LightGray)
+	rl.DrawText("CTRL+Z: UNDO | CTRL+Y: REDO | CTRL+X/C/V: CUT/COPY/PASTE | +SHIFT: MERGED/IN PLACE | CTRL+W: CLOSE | CTRL+I: INDEXED | CTRL+P: PALETTES | CTRL+R: QUANTIZE | CTRL+K: COLORS | CTRL+B: VISION | CTRL+L: LINEAR | TAB: CYCLE | ,/.: FRAME | ENTER: PLAY | SPACE+DRAG: PAN", 10, screenHeight-20, fontSize, rl.LightGray)
+
+	// Draw dialogs
+	app.drawFileBrowser()
+	app.drawPalettePicker()
+	app.drawQuantize()
+	app.drawColorEditor()
+	app.drawPaletteAnalysis()
+	app.drawClosePrompt()
+	app.drawRecoveryPrompt()
 
 	rl.EndDrawing()
 }
@@ -1465,7 +1586,7 @@ func clamp(value, min, max float32) float32 {
 }
 
 func getCurrentToolName(tool ToolType) string {
-	names := []string{"PEN", "BRUSH", "ERASER", "FILL", "PICKER", "LINE", "RECT", "CIRCLE", "MOVE", "ZOOM"}
+	names := []string{"PEN", "BRUSH", "ERASER", "FILL", "PICKER", "LINE", "RECT", "CIRCLE", "MOVE", "ZOOM", "SELECT"}
 	if int(tool) < len(names) {
 		return names[tool]
 	}
@@ -1473,20 +1594,36 @@ func getCurrentToolName(tool ToolType) string {
 }
 
 func main() {
+	// Command line tools
+	if len(os.Args) > 1 {
+		os.Exit(runCommand(os.Args[1:]))
+	}
+
 	rl.InitWindow(screenWidth, screenHeight, "Deluxe Draw - Advanced Sprite Editor")
 	rl.SetTargetFPS(60)
 
 	app := NewApp()
+	app.StartRecovery()
 
-	for !rl.WindowShouldClose() {
+	for !app.quit {
+		if rl.WindowShouldClose() {
+			app.RequestQuit()
+		}
 		app.Update()
 		app.Draw()
 	}
 
+	// Let a background save finish
+	if app.saveJob != nil {
+		<-app.saveJob.result
+	}
+
+	// A clean exit needs no recovery
+	app.StopRecovery()
+
 	// Clean up
-	for _, layer := range app.layers {
-		rl.UnloadRenderTexture(layer.texture)
+	for _, doc := range app.documents {
+		doc.Unload()
 	}
-	rl.UnloadRenderTexture(app.compositeTexture)
 	rl.CloseWindow()
 }
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+// App with one document of named layers, bottom first; names starting
+// with '*' are position-locked
+func testLayerApp(names ...string) *App {
+	doc := &Document{}
+	for _, name := range names {
+		doc.layers = append(doc.layers, &Layer{name: name, lockPosition: name[0] == '*'})
+	}
+	return &App{Document: doc, documents: []*Document{doc}}
+}
+
+func layerNames(app *App) []string {
+	var names []string
+	for _, l := range app.layers {
+		names = append(names, l.name)
+	}
+	return names
+}
+
+func TestReorderLayers(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		layers   []string
+		from, to int
+		want     []string
+		active   int
+	}{
+		{"up", []string{"a", "b", "c", "d"}, 0, 3, []string{"b", "c", "a", "d"}, 2},
+		{"down", []string{"a", "b", "c", "d"}, 3, 1, []string{"a", "d", "b", "c"}, 1},
+		{"locked layer moved", []string{"*a", "b", "c"}, 0, 2, []string{"*a", "b", "c"}, 0},
+		{"onto a locked layer", []string{"a", "b", "*c"}, 0, 2, []string{"a", "b", "*c"}, 0},
+		{"up past a locked layer", []string{"a", "*b", "c", "d"}, 0, 3, []string{"a", "*b", "c", "d"}, 0},
+		{"down past a locked layer", []string{"a", "b", "*c", "d"}, 3, 1, []string{"a", "b", "*c", "d"}, 3},
+		{"beside a locked layer", []string{"*a", "b", "c", "d"}, 1, 3, []string{"*a", "c", "b", "d"}, 2},
+	} {
+		app := testLayerApp(tc.layers...)
+		app.activeLayer = tc.from
+		app.ReorderLayers(tc.from, tc.to)
+		if got := layerNames(app); !slices.Equal(got, tc.want) {
+			t.Errorf("%s: layers %v, want %v", tc.name, got, tc.want)
+		}
+		if app.activeLayer != tc.active {
+			t.Errorf("%s: active layer %d, want %d", tc.name, app.activeLayer, tc.active)
+		}
+	}
+}
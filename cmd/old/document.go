@@ -0,0 +1,455 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/draw"
+	"os"
+	"path/filepath"
+	"reflect"
+
+	rl "github.com/gen2brain/raylib-go/raylib"
+)
+
+// Tab strip geometry; the canvas starts below the tabs
+const (
+	tabBarY   = 50
+	tabHeight = 20
+	tabWidth  = 140
+	topBar    = tabBarY + tabHeight
+)
+
+// Document is one open image: its layers, undo history, view and file.
+// Tools, palette and the rest of the UI are shared by all documents.
+type Document struct {
+	// Canvas and layers
+	layers       []*Layer
+	activeLayer  int
+	canvasWidth  int
+	canvasHeight int
+	layerCounter int
+	palette      *docPalette // indexed colour; nil for truecolour
+	compositing  Compositing
+	layerScroll  int // first row shown in the layer list
+
+	// Animation frames; the layers show the current one
+	frames []Frame
+	frame  int
+	onion  map[int]*onionSkin // composites of nearby frames
+
+	// View
+	zoom float32
+	panX float32
+	panY float32
+
+	// Render targets
+	compositor *Compositor
+
+	// Rectangle selection in canvas pixels; empty when nothing is selected
+	selection image.Rectangle
+
+	// File
+	currentFilePath string
+	metadata        ProjectMetadata
+	saved           *savedState // nil: never saved or loaded
+	autosave        *docRecovery
+
+	// Undo/Redo
+	history      []HistoryAction
+	historyIndex int
+}
+
+// Layers as last saved or loaded, for telling whether a document changed
+type savedState struct {
+	width       int
+	height      int
+	layers      []LayerData
+	layout      []int
+	stores      [][]*TileStore // by layer and frame
+	palette     *docPalette
+	compositing Compositing
+}
+
+// Create a document with a blank canvas and make it active
+func (app *App) NewDocument(width, height int) *Document {
+	doc := &Document{
+		canvasWidth:  width,
+		canvasHeight: height,
+		zoom:         1.0,
+		layerCounter: 3,
+		historyIndex: -1,
+		compositor:   NewCompositor(width, height),
+		metadata:     newProjectMetadata(),
+		frames:       []Frame{{duration: defaultFrameDuration}},
+	}
+	app.documents = append(app.documents, doc)
+	app.SwitchDocument(doc)
+
+	// Create initial layers
+	app.layers = append(app.layers, app.newLayer("BACKGROUND"))
+	app.layers = append(app.layers, app.newLayer("LAYER 1"))
+	app.layers = append(app.layers, app.newLayer("LAYER 2"))
+	app.activeLayer = 1
+
+	// Fill background with white
+	app.layers[0].tiles.Fill(rl.White)
+
+	doc.markSaved()
+	return doc
+}
+
+// Make a document to load a file into: the active one if it is a new,
+// untouched canvas, otherwise a new tab
+func (app *App) openDocument() {
+	if app.currentFilePath != "" || len(app.history) > 0 || app.Modified() {
+		app.NewDocument(app.canvasWidth, app.canvasHeight)
+	}
+}
+
+// Make a document active
+func (app *App) SwitchDocument(doc *Document) {
+	if app.Document == doc {
+		return
+	}
+	if app.renamingLayer {
+		app.CommitRenameLayer()
+	}
+	app.isDraggingLayer = false
+	app.isPanning = false
+	app.selecting = false
+	app.playing = false
+	if app.Document != nil {
+		// Leave it uncycled
+		app.compositor.SetPalette(app.paletteColors())
+		app.releaseGPU()
+	}
+	app.Document = doc
+}
+
+// Free GPU copies of the document's pixels; they are rebuilt on demand
+func (d *Document) releaseGPU() {
+	for _, layer := range d.layers {
+		// A rectangle left of and above the canvas keeps no pages
+		layer.ReleasePages(rl.Rectangle{X: -pageSize, Y: -pageSize})
+	}
+	d.compositor.unloadPages()
+	d.unloadOnion()
+}
+
+// Free all GPU resources of the document
+func (d *Document) Unload() {
+	for _, layer := range d.layers {
+		layer.Unload()
+	}
+	d.compositor.Unload()
+	d.unloadOnion()
+}
+
+// Remember the current layers as saved
+func (d *Document) markSaved() {
+	d.saved = d.savedState()
+}
+
+// Current layers in the form kept by markSaved; pixels are shared
+func (d *Document) savedState() *savedState {
+	s := &savedState{width: d.canvasWidth, height: d.canvasHeight, layout: d.frameLayout(), palette: d.palette.clone(), compositing: d.compositing}
+	for _, layer := range d.layers {
+		s.layers = append(s.layers, layer.Data())
+	}
+	s.stores = d.celStores(func(layer *Layer) *TileStore {
+		return layer.tiles.Clone()
+	})
+	return s
+}
+
+// Check for changes since the document was last saved or loaded. Pixels
+// are compared by tile, which is cheap since unchanged tiles are shared.
+func (d *Document) Modified() bool {
+	s := d.saved
+	if s == nil {
+		return true
+	}
+	if s.width != d.canvasWidth || s.height != d.canvasHeight || len(s.layers) != len(d.layers) || s.compositing != d.compositing {
+		return true
+	}
+	if !reflect.DeepEqual(d.frameLayout(), s.layout) || !d.palette.equal(s.palette) {
+		return true
+	}
+	stores := d.celStores(func(layer *Layer) *TileStore {
+		return layer.tiles
+	})
+	for i, layer := range d.layers {
+		if !reflect.DeepEqual(layer.Data(), s.layers[i]) {
+			return true
+		}
+		for f := range stores[i] {
+			if !sameCel(stores[i][f], s.stores[i][f]) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Check whether two paths name the same file
+func sameFile(a, b string) bool {
+	ia, errA := os.Stat(a)
+	ib, errB := os.Stat(b)
+	if errA != nil || errB != nil {
+		return filepath.Clean(a) == filepath.Clean(b)
+	}
+	return os.SameFile(ia, ib)
+}
+
+// Name shown on the document's tab
+func (d *Document) Title() string {
+	if d.currentFilePath == "" {
+		return "UNTITLED"
+	}
+	return filepath.Base(d.currentFilePath)
+}
+
+// Layer pixels resized to another canvas, anchored at the top left
+func fitStore(store *TileStore, width, height int) *TileStore {
+	if store.width == width && store.height == height {
+		return store.Clone()
+	}
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(img, img.Bounds(), store.ToNRGBA(), image.Point{}, draw.Src)
+	return TileStoreFromNRGBA(img)
+}
+
+// Add a copy of a layer, possibly from another document, above the active
+// layer of the active document
+func (app *App) PasteLayer(data LayerData, store *TileStore) {
+	layer := app.newLayer(data.Name)
+	data.ID = ""
+	layer.SetData(data)
+	layer.tiles = fitStore(store, app.canvasWidth, app.canvasHeight)
+
+	app.layers = append(app.layers[:app.activeLayer+1], append([]*Layer{layer}, app.layers[app.activeLayer+1:]...)...)
+	app.activeLayer++
+	app.showLayer(app.activeLayer)
+}
+
+// Close a document, asking first if it has unsaved changes
+func (app *App) CloseDocument(doc *Document) {
+	if doc.Modified() {
+		app.SwitchDocument(doc)
+		app.closing = doc
+		return
+	}
+	app.removeDocument(doc)
+}
+
+// Close a document without asking. The last document is replaced with a
+// new canvas.
+func (app *App) removeDocument(doc *Document) {
+	index := -1
+	for i, d := range app.documents {
+		if d == doc {
+			index = i
+		}
+	}
+	if index < 0 {
+		return
+	}
+	if app.closeAfterSave == doc {
+		app.closeAfterSave = nil
+	}
+	app.dropRecovery(doc)
+	app.documents = append(app.documents[:index], app.documents[index+1:]...)
+
+	if app.Document == doc {
+		app.SwitchDocument(nil)
+	}
+	doc.Unload()
+
+	if len(app.documents) == 0 {
+		app.NewDocument(512, 512)
+	} else if app.Document == nil {
+		app.SwitchDocument(app.documents[min(index, len(app.documents)-1)])
+	}
+}
+
+// Close the window once every modified document is saved or discarded
+func (app *App) RequestQuit() {
+	app.quitting = true
+}
+
+// Walk through modified documents while quitting; call once per frame
+func (app *App) updateQuit() {
+	if !app.quitting || app.closing != nil || app.closeAfterSave != nil || app.browser != nil {
+		return
+	}
+	for _, doc := range app.documents {
+		if doc.Modified() {
+			app.CloseDocument(doc)
+			return
+		}
+	}
+	app.quit = true
+}
+
+// Handle the save prompt of a document being closed
+func (app *App) updateClosePrompt(mousePos rl.Vector2) {
+	if !rl.IsMouseButtonPressed(rl.MouseLeftButton) {
+		return
+	}
+	doc := app.closing
+	for i, btn := range app.closeButtons {
+		if !rl.CheckCollisionPointRec(mousePos, btn.rect) {
+			continue
+		}
+		app.closing = nil
+		switch i {
+		case 0: // Save, then close once the save succeeds
+			if app.saveJob != nil {
+				app.SetStatus("SAVE ALREADY IN PROGRESS")
+				app.closing = doc
+				return
+			}
+			app.closeAfterSave = doc
+			if doc.currentFilePath != "" {
+				app.StartSave(doc.currentFilePath)
+			} else {
+				app.OpenFileBrowser(browseSave)
+			}
+		case 1: // Discard
+			app.removeDocument(doc)
+		case 2: // Cancel
+			app.quitting = false
+		}
+		return
+	}
+}
+
+// Draw the save prompt over the whole window
+func (app *App) drawClosePrompt() {
+	if app.closing == nil {
+		return
+	}
+	mousePos := rl.GetMousePosition()
+
+	rl.DrawRectangle(0, 0, screenWidth, screenHeight, rl.Color{0, 0, 0, 160})
+	box := rl.Rectangle{X: screenWidth/2 - 200, Y: screenHeight/2 - 60, Width: 400, Height: 110}
+	rl.DrawRectangleRec(box, rl.Color{50, 50, 50, 255})
+	rl.DrawRectangleLinesEx(box, 1, rl.Color{90, 90, 90, 255})
+
+	rl.DrawText(fitText("SAVE CHANGES TO "+app.closing.Title()+"?", 376, false), int32(box.X)+12, int32(box.Y)+12, fontSize, rl.White)
+	rl.DrawText("UNSAVED CHANGES ARE LOST OTHERWISE.", int32(box.X)+12, int32(box.Y)+26, fontSize, rl.LightGray)
+	for _, btn := range app.closeButtons {
+		drawButton(btn, rl.CheckCollisionPointRec(mousePos, btn.rect))
+	}
+}
+
+// Rectangles of the document tabs and the new-document tab after them
+func (app *App) tabRects() ([]rl.Rectangle, rl.Rectangle) {
+	width := float32(screenWidth - leftPanel - rightPanel - tabHeight)
+	w := minf(tabWidth, width/float32(len(app.documents)))
+	rects := make([]rl.Rectangle, len(app.documents))
+	for i := range rects {
+		rects[i] = rl.Rectangle{X: leftPanel + float32(i)*w, Y: tabBarY, Width: w - 2, Height: tabHeight}
+	}
+	plus := rl.Rectangle{X: leftPanel + float32(len(rects))*w, Y: tabBarY, Width: tabHeight, Height: tabHeight}
+	return rects, plus
+}
+
+// Close box inside a tab
+func tabCloseRect(tab rl.Rectangle) rl.Rectangle {
+	return rl.Rectangle{X: tab.X + tab.Width - 16, Y: tab.Y + 4, Width: 12, Height: 12}
+}
+
+// Index of the tab under a point, or -1
+func (app *App) tabAt(pos rl.Vector2) int {
+	rects, _ := app.tabRects()
+	for i, rect := range rects {
+		if rl.CheckCollisionPointRec(pos, rect) {
+			return i
+		}
+	}
+	return -1
+}
+
+// Handle clicks on the tab strip; reports whether the click was used
+func (app *App) updateTabs(mousePos rl.Vector2) bool {
+	if !rl.IsMouseButtonPressed(rl.MouseLeftButton) && !rl.IsMouseButtonPressed(rl.MouseMiddleButton) {
+		return false
+	}
+	rects, plus := app.tabRects()
+	if rl.IsMouseButtonPressed(rl.MouseLeftButton) && rl.CheckCollisionPointRec(mousePos, plus) {
+		app.NewDocument(app.canvasWidth, app.canvasHeight)
+		return true
+	}
+	for i, rect := range rects {
+		if !rl.CheckCollisionPointRec(mousePos, rect) {
+			continue
+		}
+		doc := app.documents[i]
+		if rl.IsMouseButtonPressed(rl.MouseMiddleButton) || rl.CheckCollisionPointRec(mousePos, tabCloseRect(rect)) {
+			app.CloseDocument(doc)
+		} else {
+			app.SwitchDocument(doc)
+		}
+		return true
+	}
+	return false
+}
+
+// Draw the tab strip
+func (app *App) drawTabs() {
+	mousePos := rl.GetMousePosition()
+	rl.DrawRectangle(leftPanel, tabBarY, screenWidth-leftPanel-rightPanel, tabHeight, rl.Color{45, 45, 45, 255})
+
+	rects, plus := app.tabRects()
+	for i, rect := range rects {
+		doc := app.documents[i]
+		color := rl.Color{60, 60, 60, 255}
+		if doc == app.Document {
+			color = rl.Color{100, 100, 150, 255}
+		} else if rl.CheckCollisionPointRec(mousePos, rect) {
+			color = rl.Color{80, 80, 80, 255}
+		}
+		// Highlight the tab a dragged layer would be dropped on
+		if app.isDraggingLayer && doc != app.Document && rl.CheckCollisionPointRec(mousePos, rect) {
+			color = rl.Color{150, 150, 60, 255}
+		}
+		rl.DrawRectangleRec(rect, color)
+
+		title := doc.Title()
+		if doc.Modified() {
+			title += " *"
+		}
+		rl.DrawText(fitText(title, int32(rect.Width)-24, false), int32(rect.X)+6, int32(rect.Y)+6, fontSize, rl.White)
+
+		closeRect := tabCloseRect(rect)
+		closeColor := rl.LightGray
+		if rl.CheckCollisionPointRec(mousePos, closeRect) {
+			closeColor = rl.White
+			rl.DrawRectangleRec(closeRect, rl.Color{120, 60, 60, 255})
+		}
+		rl.DrawText("X", int32(closeRect.X)+3, int32(closeRect.Y)+2, fontSize, closeColor)
+	}
+
+	color := rl.Color{60, 60, 60, 255}
+	if rl.CheckCollisionPointRec(mousePos, plus) {
+		color = rl.Color{80, 80, 80, 255}
+	}
+	rl.DrawRectangleRec(plus, color)
+	rl.DrawText("+", int32(plus.X)+7, int32(plus.Y)+6, fontSize, rl.White)
+}
+
+// Drop the dragged layer onto another document's tab: the layer is copied
+// there and that document becomes active. Reports whether it was dropped.
+func (app *App) dropLayerOnTab(mousePos rl.Vector2) bool {
+	i := app.tabAt(mousePos)
+	if i < 0 || app.documents[i] == app.Document {
+		return false
+	}
+	src := app.layers[app.draggedLayer]
+	data, store := src.Data(), app.colorStore(src.Snapshot())
+	app.SwitchDocument(app.documents[i])
+	app.PasteLayer(data, app.indexStore(store))
+	app.SetStatus(fmt.Sprintf("COPIED LAYER %s TO %s", data.Name, app.Title()))
+	return true
+}
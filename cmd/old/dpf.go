@@ -0,0 +1,436 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	rl "github.com/gen2brain/raylib-go/raylib"
+)
+
+// Deluxe Pixmap Format, the text format of the palette tools in
+// cmd/attic:
+//
+//	STARTFONT DPF 1.0
+//	FONT name
+//	PALETTE n
+//	<key> RRGGBBAA # colour name
+//	ENDPALETTE
+//	ICONS n
+//	STARTICON name
+//	BBX w h 0 0
+//	BITMAP
+//	<h rows of w key characters>
+//	ENDICON
+//	ENDFONT
+//
+// Each palette entry has a single printable key character that icon
+// bitmaps use to refer to it; an entry with alpha 00 is transparent.
+
+const dpfExt = ".dpf"
+
+// Key characters for palettes written by the editor, in order of use
+const dpfKeys = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz!#$%&()*+-/:;<=>?@[]^_{|}~"
+
+// One palette entry of a DPF file
+type dpfColor struct {
+	key   rune
+	color rl.Color
+	name  string
+}
+
+// Palette, name and icons read from a DPF file
+type dpfFile struct {
+	name    string
+	palette []dpfColor
+	icons   []*dpfIcon
+}
+
+// One icon: rows of palette key characters
+type dpfIcon struct {
+	name   string
+	width  int
+	height int
+	rows   []string
+}
+
+// Read a DPF file, checking icon bitmaps against the palette
+func readDPF(r io.Reader) (*dpfFile, error) {
+	dpf := &dpfFile{}
+	scanner := bufio.NewScanner(r)
+	line := 0
+	inPalette := false
+	started := false
+	iconCount := -1
+	var icon *dpfIcon
+	inBitmap := false
+	keys := make(map[rune]bool)
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+		if inPalette {
+			if text == "ENDPALETTE" {
+				inPalette = false
+				continue
+			}
+			c, err := parseDPFColor(text)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %v", line, err)
+			}
+			if keys[c.key] {
+				return nil, fmt.Errorf("line %d: duplicate palette character %q", line, c.key)
+			}
+			keys[c.key] = true
+			dpf.palette = append(dpf.palette, c)
+			continue
+		}
+		if inBitmap && text != "ENDICON" {
+			if err := icon.addRow(text, line, keys); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		fields := strings.Fields(text)
+		switch fields[0] {
+		case "STARTFONT":
+			if len(fields) < 2 || fields[1] != "DPF" {
+				return nil, fmt.Errorf("line %d: not a DPF file", line)
+			}
+			started = true
+		case "FONT":
+			dpf.name = strings.TrimSpace(strings.TrimPrefix(text, "FONT"))
+		case "PALETTE":
+			inPalette = true
+		case "ICONS":
+			n, err := strconv.Atoi(strings.Join(fields[1:], ""))
+			if err != nil || n < 0 {
+				return nil, fmt.Errorf("line %d: invalid icon count", line)
+			}
+			iconCount = n
+		case "STARTICON":
+			if icon != nil {
+				return nil, fmt.Errorf("line %d: nested STARTICON", line)
+			}
+			icon = &dpfIcon{name: strings.TrimSpace(strings.TrimPrefix(text, "STARTICON"))}
+		case "BBX":
+			if icon == nil {
+				return nil, fmt.Errorf("line %d: BBX outside of icon", line)
+			}
+			if err := icon.parseBBX(fields, line); err != nil {
+				return nil, err
+			}
+		case "BITMAP":
+			if icon == nil {
+				return nil, fmt.Errorf("line %d: BITMAP outside of icon", line)
+			}
+			inBitmap = true
+		case "ENDICON":
+			if icon == nil {
+				return nil, fmt.Errorf("line %d: ENDICON without STARTICON", line)
+			}
+			if err := icon.finish(line); err != nil {
+				return nil, err
+			}
+			dpf.icons = append(dpf.icons, icon)
+			icon, inBitmap = nil, false
+		case "ENDFONT":
+			if icon != nil {
+				return nil, fmt.Errorf("line %d: ENDFONT while icon is open", line)
+			}
+		}
+		if !started {
+			return nil, fmt.Errorf("line %d: missing STARTFONT", line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if !started {
+		return nil, fmt.Errorf("not a DPF file")
+	}
+	if len(dpf.palette) == 0 {
+		return nil, fmt.Errorf("DPF must contain at least one palette entry")
+	}
+	if iconCount >= 0 && iconCount != len(dpf.icons) {
+		return nil, fmt.Errorf("icon count mismatch (expected %d, found %d)", iconCount, len(dpf.icons))
+	}
+	return dpf, nil
+}
+
+// Parse "BBX w h x y"
+func (icon *dpfIcon) parseBBX(fields []string, line int) error {
+	if len(fields) < 3 {
+		return fmt.Errorf("line %d: BBX missing parameters", line)
+	}
+	w, err := strconv.Atoi(fields[1])
+	if err != nil || w <= 0 {
+		return fmt.Errorf("line %d: invalid BBX width", line)
+	}
+	h, err := strconv.Atoi(fields[2])
+	if err != nil || h <= 0 {
+		return fmt.Errorf("line %d: invalid BBX height", line)
+	}
+	icon.width, icon.height = w, h
+	return nil
+}
+
+// Add a bitmap row. Without a BBX the first row sets the width.
+func (icon *dpfIcon) addRow(text string, line int, keys map[rune]bool) error {
+	runes := []rune(text)
+	if icon.width == 0 {
+		icon.width = len(runes)
+	}
+	if len(runes) != icon.width {
+		return fmt.Errorf("line %d: bitmap width mismatch (expected %d characters, got %d)", line, icon.width, len(runes))
+	}
+	for col, r := range runes {
+		if !keys[r] {
+			return fmt.Errorf("line %d, col %d: character %q not in palette", line, col+1, r)
+		}
+	}
+	icon.rows = append(icon.rows, text)
+	return nil
+}
+
+// Check the bitmap is complete. Without a BBX the rows set the height.
+func (icon *dpfIcon) finish(line int) error {
+	if len(icon.rows) == 0 {
+		return fmt.Errorf("line %d: icon has no bitmap", line)
+	}
+	if icon.height == 0 {
+		icon.height = len(icon.rows)
+	}
+	if len(icon.rows) != icon.height {
+		return fmt.Errorf("line %d: bitmap height mismatch (expected %d lines, got %d)", line, icon.height, len(icon.rows))
+	}
+	return nil
+}
+
+// Render the icon with the colours its keys refer to
+func (icon *dpfIcon) Image(palette []dpfColor) *image.NRGBA {
+	colors := make(map[rune]color.NRGBA)
+	for _, c := range palette {
+		colors[c.key] = color.NRGBA{c.color.R, c.color.G, c.color.B, c.color.A}
+	}
+	img := image.NewNRGBA(image.Rect(0, 0, icon.width, icon.height))
+	for y, row := range icon.rows {
+		for x, r := range []rune(row) {
+			img.SetNRGBA(x, y, colors[r])
+		}
+	}
+	return img
+}
+
+// Read DPF icon text, as copied from an editor: either a whole DPF file,
+// whose first icon is used with its own palette, or just a BITMAP block
+// (optionally with its STARTICON, BBX and ENDICON lines) whose characters
+// are keys of palette
+func readDPFIcon(clip string, palette []dpfColor) (*dpfIcon, []dpfColor, error) {
+	if strings.Contains(clip, "STARTFONT") {
+		dpf, err := readDPF(strings.NewReader(clip))
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(dpf.icons) == 0 {
+			return nil, nil, fmt.Errorf("DPF has no icons")
+		}
+		return dpf.icons[0], dpf.palette, nil
+	}
+
+	keys := make(map[rune]bool)
+	for _, c := range palette {
+		keys[c.key] = true
+	}
+	icon := &dpfIcon{}
+	inBitmap := false
+	line := 0
+	for _, raw := range strings.Split(clip, "\n") {
+		line++
+		text := strings.TrimSpace(raw)
+		if text == "" {
+			continue
+		}
+		if inBitmap {
+			if text == "ENDICON" {
+				break
+			}
+			if err := icon.addRow(text, line, keys); err != nil {
+				return nil, nil, err
+			}
+			continue
+		}
+		fields := strings.Fields(text)
+		switch fields[0] {
+		case "STARTICON":
+			icon.name = strings.TrimSpace(strings.TrimPrefix(text, "STARTICON"))
+		case "BBX":
+			if err := icon.parseBBX(fields, line); err != nil {
+				return nil, nil, err
+			}
+		case "BITMAP":
+			inBitmap = true
+		}
+	}
+	if !inBitmap {
+		return nil, nil, fmt.Errorf("no DPF BITMAP block")
+	}
+	if err := icon.finish(line); err != nil {
+		return nil, nil, err
+	}
+	return icon, palette, nil
+}
+
+// Parse "<key> RRGGBBAA # name"
+func parseDPFColor(text string) (dpfColor, error) {
+	var c dpfColor
+	runes := []rune(text)
+	if len(runes) < 10 || runes[1] != ' ' {
+		return c, fmt.Errorf("palette entry must be <char> RRGGBBAA")
+	}
+	c.key = runes[0]
+	rest := strings.TrimSpace(string(runes[2:]))
+	hex, name, _ := strings.Cut(rest, "#")
+	hex = strings.TrimSpace(hex)
+	if len(hex) != 8 {
+		return c, fmt.Errorf("color must be 8 hex digits (RRGGBBAA)")
+	}
+	v, err := strconv.ParseUint(hex, 16, 32)
+	if err != nil {
+		return c, fmt.Errorf("invalid color %q", hex)
+	}
+	c.color = rl.Color{uint8(v >> 24), uint8(v >> 16), uint8(v >> 8), uint8(v)}
+	c.name = strings.TrimSpace(name)
+	return c, nil
+}
+
+// Load a DPF file's palette
+func loadDPF(filename string) (*dpfFile, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return readDPF(file)
+}
+
+// Write a palette and icons as a DPF file
+func writeDPF(w io.Writer, name string, palette []dpfColor, icons []*dpfIcon) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "STARTFONT DPF 1.0\nFONT %s\n\nPALETTE %d\n", name, len(palette))
+	for _, c := range palette {
+		fmt.Fprintf(bw, "%c %02X%02X%02X%02X", c.key, c.color.R, c.color.G, c.color.B, c.color.A)
+		if c.name != "" {
+			fmt.Fprintf(bw, " # %s", c.name)
+		}
+		bw.WriteString("\n")
+	}
+	fmt.Fprintf(bw, "ENDPALETTE\n\nICONS %d\n", len(icons))
+	for _, icon := range icons {
+		fmt.Fprintf(bw, "\nSTARTICON %s\nBBX %d %d 0 0\nBITMAP\n", icon.name, icon.width, icon.height)
+		for _, row := range icon.rows {
+			bw.WriteString(row + "\n")
+		}
+		bw.WriteString("ENDICON\n")
+	}
+	bw.WriteString("ENDFONT\n")
+	return bw.Flush()
+}
+
+// Palette entries for plain colours, keyed in dpfKeys order
+func dpfPalette(colors []rl.Color) ([]dpfColor, error) {
+	keys := []rune(dpfKeys)
+	if len(colors) > len(keys) {
+		return nil, fmt.Errorf("palette has %d colors, DPF allows %d", len(colors), len(keys))
+	}
+	palette := make([]dpfColor, len(colors))
+	for i, c := range colors {
+		palette[i] = dpfColor{key: keys[i], color: c, name: fmt.Sprintf("color %d", i+1)}
+	}
+	return palette, nil
+}
+
+// Export the current palette as a DPF file. An indexed document is written
+// with its own palette and keys, and its visible layers as icons.
+func (app *App) ExportDPF(filename string) error {
+	if app.palette != nil {
+		var icons []*dpfIcon
+		for _, layer := range app.layers {
+			if layer.visible {
+				icons = append(icons, dpfIndexIcon(layer.name, layer.Snapshot().ToNRGBA(), app.palette))
+			}
+		}
+		return writeFileAtomic(filename, func(w io.Writer) error {
+			return writeDPF(w, "DeluxeDraw Icons", app.palette.colors, icons)
+		})
+	}
+	palette, err := dpfPalette(app.colorPalette)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(filename, func(w io.Writer) error {
+		return writeDPF(w, "DeluxeDraw Palette", palette, nil)
+	})
+}
+
+// Open a DPF file. One with icons becomes a new indexed document with a
+// layer per icon; otherwise its palette replaces the active document's,
+// if that is indexed, or the current colours.
+func (app *App) OpenDPF(filename string) error {
+	dpf, err := loadDPF(filename)
+	if err != nil {
+		return err
+	}
+	if len(dpf.icons) > 0 {
+		return app.openDPFIcons(dpf)
+	}
+	return app.usePaletteColors(dpf.palette)
+}
+
+// Open DPF icons as a new indexed document, a layer per icon, on a canvas
+// that fits the largest
+func (app *App) openDPFIcons(dpf *dpfFile) error {
+	palette, err := dpfDocPalette(dpf.palette)
+	if err != nil {
+		return err
+	}
+	w, h := 0, 0
+	for _, icon := range dpf.icons {
+		w, h = max(w, icon.width), max(h, icon.height)
+	}
+	if w > maxCanvasSize || h > maxCanvasSize {
+		return fmt.Errorf("icons need a %dx%d canvas, at most %dx%d is allowed", w, h, maxCanvasSize, maxCanvasSize)
+	}
+
+	project := ProjectData{CanvasWidth: w, CanvasHeight: h}
+	palette.save(&project)
+	var layers []*TileStore
+	for i, icon := range dpf.icons {
+		name := strings.ToUpper(icon.name)
+		if name == "" {
+			name = fmt.Sprintf("ICON %d", i+1)
+		}
+		if len(name) > 24 {
+			name = name[:24]
+		}
+		project.Layers = append(project.Layers, LayerData{Name: name, Visible: true, Opacity: 1})
+		img := image.NewNRGBA(image.Rect(0, 0, w, h))
+		draw.Draw(img, img.Bounds(), dpfIconIndices(icon, palette), image.Point{}, draw.Src)
+		layers = append(layers, TileStoreFromNRGBA(img))
+	}
+
+	app.openDocument()
+	app.applyProject(&loadedProject{project: project, layers: layers, metadata: newProjectMetadata()})
+	app.currentFilePath = ""
+	app.markSaved()
+	return nil
+}
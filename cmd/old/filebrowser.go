@@ -0,0 +1,712 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	rl "github.com/gen2brain/raylib-go/raylib"
+	"github.com/ha1tch/deluxedraw/palette"
+)
+
+// In-app file dialog for opening, saving and exporting, and the list of
+// recently used files. Only one dialog is open at a time; while it is, it
+// takes all input.
+
+const (
+	recentFilesMax   = 10
+	browserRows      = 20 // rows visible in the file list
+	browserRowHeight = 18
+	doubleClickTime  = 0.4
+)
+
+// What the file browser was opened for
+type browseMode int
+
+const (
+	browseOpen browseMode = iota
+	browseSave
+	browseExport
+	browsePaste
+)
+
+var browseTitles = map[browseMode]string{
+	browseOpen:   "OPEN",
+	browseSave:   "SAVE AS",
+	browseExport: "EXPORT",
+	browsePaste:  "PASTE PNG",
+}
+
+// File types the browser can show
+type fileFilter struct {
+	label string
+	exts  []string // the first is appended to names without one
+}
+
+// Indices into fileFilters
+const (
+	filterProject = iota
+	filterDPF
+	filterPNG
+	filterJPEG
+	filterGIF
+	filterAPNG
+	filterSheet
+	filterPalette
+)
+
+var fileFilters = []fileFilter{
+	filterProject: {"DDD", []string{".ddd", projectDirExt}},
+	filterDPF:     {"DPF", []string{dpfExt}},
+	filterPNG:     {"PNG", []string{".png"}},
+	filterJPEG:    {"JPG", []string{".jpg", ".jpeg"}},
+	filterGIF:     {"GIF", []string{".gif"}},
+	filterAPNG:    {"APNG", []string{".apng"}},
+	filterSheet:   {"SHEET", []string{".json", ".xml"}}, // with a .png of the same name
+	filterPalette: {"PAL", palette.Extensions()},
+}
+
+// Filters offered by each mode, as indices into fileFilters; the first is
+// the default
+var browseFilters = map[browseMode][]int{
+	browseOpen:   {filterProject, filterDPF, filterPNG, filterJPEG, filterPalette},
+	browseSave:   {filterProject},
+	browseExport: {filterPNG, filterJPEG, filterGIF, filterAPNG, filterSheet, filterDPF},
+	browsePaste:  {filterPNG},
+}
+
+// Whether the dialog picks an existing file rather than naming one to write
+func (m browseMode) reads() bool {
+	return m == browseOpen || m == browsePaste
+}
+
+// A row of the file list
+type fileEntry struct {
+	name string
+	dir  bool
+}
+
+// State of the open file dialog
+type fileBrowser struct {
+	mode      browseMode
+	dir       string
+	entries   []fileEntry
+	filter    int // index into fileFilters
+	filename  string
+	selected  int    // entry index, -1 for none
+	scroll    Slider // first visible row
+	confirm   string // existing file waiting for overwrite confirmation
+	err       string
+	lastClick float64
+
+	box            rl.Rectangle
+	recentRect     rl.Rectangle
+	listRect       rl.Rectangle
+	nameRect       rl.Rectangle
+	buttons        []Button // UP, OK, CANCEL
+	filterButtons  []Button
+	optionBoxes    []CheckBox // animated export: dither, layers as frames
+	confirmButtons []Button   // YES, NO
+}
+
+// Check whether a file name has one of a filter's extensions
+func (f fileFilter) match(name string) bool {
+	ext := strings.ToLower(filepath.Ext(name))
+	for _, e := range f.exts {
+		if ext == e {
+			return true
+		}
+	}
+	return false
+}
+
+// Open the file dialog
+func (app *App) OpenFileBrowser(mode browseMode) {
+	b := &fileBrowser{mode: mode, filter: browseFilters[mode][0], selected: -1}
+
+	// Start where the document or the most recent file lives
+	b.dir, _ = os.Getwd()
+	if app.currentFilePath != "" {
+		b.dir = filepath.Dir(app.currentFilePath)
+	} else if len(app.recentFiles) > 0 {
+		b.dir = filepath.Dir(app.recentFiles[0])
+	}
+	if abs, err := filepath.Abs(b.dir); err == nil {
+		b.dir = abs
+	}
+
+	base := "untitled"
+	if app.currentFilePath != "" {
+		base = strings.TrimSuffix(filepath.Base(app.currentFilePath), filepath.Ext(app.currentFilePath))
+	}
+	switch mode {
+	case browseSave:
+		b.filename = base + fileFilters[b.filter].exts[0]
+		if app.currentFilePath != "" {
+			b.filename = filepath.Base(app.currentFilePath)
+		}
+	case browseExport:
+		b.filename = base + fileFilters[b.filter].exts[0]
+	}
+
+	// Layout
+	b.box = rl.Rectangle{X: screenWidth/2 - 340, Y: screenHeight/2 - 230, Width: 680, Height: 460}
+	x, y := b.box.X, b.box.Y
+	b.recentRect = rl.Rectangle{X: x + 10, Y: y + 48, Width: 200, Height: browserRows * browserRowHeight}
+	b.listRect = rl.Rectangle{X: x + 220, Y: y + 48, Width: 426, Height: browserRows * browserRowHeight}
+	b.scroll = Slider{rect: rl.Rectangle{X: x + 650, Y: y + 48, Width: 20, Height: browserRows * browserRowHeight}}
+	b.nameRect = rl.Rectangle{X: x + 220, Y: y + 420, Width: 270, Height: 20}
+	b.buttons = []Button{
+		{rect: rl.Rectangle{X: x + 610, Y: y + 22, Width: 60, Height: 20}, text: "UP"},
+		{rect: rl.Rectangle{X: x + 500, Y: y + 420, Width: 80, Height: 20}, text: browseTitles[mode]},
+		{rect: rl.Rectangle{X: x + 590, Y: y + 420, Width: 80, Height: 20}, text: "CANCEL"},
+	}
+	for i, f := range browseFilters[mode] {
+		b.filterButtons = append(b.filterButtons, Button{
+			rect: rl.Rectangle{X: x + 10 + float32(i)*35, Y: y + 420, Width: 33, Height: 20},
+			text: fileFilters[f].label,
+		})
+	}
+	if mode == browseExport {
+		b.optionBoxes = []CheckBox{
+			{rect: rl.Rectangle{X: x + 100, Y: y + 9, Width: 12, Height: 12}, checked: app.exportOptions.Dither, label: "DITHER GIF"},
+			{rect: rl.Rectangle{X: x + 190, Y: y + 9, Width: 12, Height: 12}, checked: app.exportOptions.LayerFrames, label: "LAYERS AS FRAMES"},
+			{rect: rl.Rectangle{X: x + 310, Y: y + 9, Width: 12, Height: 12}, checked: app.exportOptions.Cycle, label: "COLOR CYCLE"},
+			{rect: rl.Rectangle{X: x + 400, Y: y + 9, Width: 12, Height: 12}, checked: app.exportOptions.Sequence, label: "PNG SEQUENCE"},
+			{rect: rl.Rectangle{X: x + 500, Y: y + 9, Width: 12, Height: 12}, checked: app.exportOptions.ICC, label: "ICC PROFILE"},
+		}
+	}
+	b.confirmButtons = []Button{
+		{rect: rl.Rectangle{X: screenWidth/2 - 90, Y: screenHeight/2 + 10, Width: 80, Height: 24}, text: "YES"},
+		{rect: rl.Rectangle{X: screenWidth/2 + 10, Y: screenHeight/2 + 10, Width: 80, Height: 24}, text: "NO"},
+	}
+
+	b.readDir()
+	app.browser = b
+}
+
+// List the current directory: folders first, then files passing the filter
+func (b *fileBrowser) readDir() {
+	b.entries = nil
+	b.selected = -1
+	b.scroll.value = 0
+	b.err = ""
+
+	list, err := os.ReadDir(b.dir)
+	if err != nil {
+		b.err = err.Error()
+	}
+	filter := fileFilters[b.filter]
+	var dirs, files []fileEntry
+	for _, entry := range list {
+		name := entry.Name()
+		if strings.HasPrefix(name, ".") {
+			continue
+		}
+		isDir := entry.IsDir()
+		if !isDir && entry.Type()&os.ModeSymlink != 0 {
+			if info, err := os.Stat(filepath.Join(b.dir, name)); err == nil {
+				isDir = info.IsDir()
+			}
+		}
+		switch {
+		case filter.match(name):
+			// Project directories are documents, not folders
+			files = append(files, fileEntry{name: name})
+		case isDir:
+			dirs = append(dirs, fileEntry{name: name, dir: true})
+		}
+	}
+	byName := func(list []fileEntry) {
+		sort.Slice(list, func(i, j int) bool {
+			return strings.ToLower(list[i].name) < strings.ToLower(list[j].name)
+		})
+	}
+	byName(dirs)
+	byName(files)
+	b.entries = append(dirs, files...)
+	b.scroll.max = float32(max(0, len(b.entries)-browserRows))
+}
+
+// Change directory
+func (b *fileBrowser) chdir(dir string) {
+	b.dir = filepath.Clean(dir)
+	b.readDir()
+}
+
+// Resolve the typed name to a path, adding the filter's extension when
+// saving a name without a known one
+func (b *fileBrowser) target() string {
+	name := strings.TrimSpace(b.filename)
+	if name == "" {
+		return ""
+	}
+	path := name
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(b.dir, name)
+	}
+	if b.mode.reads() {
+		return path
+	}
+	for _, f := range browseFilters[b.mode] {
+		if fileFilters[f].match(path) {
+			return path
+		}
+	}
+	return path + fileFilters[b.filter].exts[0]
+}
+
+// Handle input for the file dialog
+func (app *App) updateFileBrowser(mousePos rl.Vector2) {
+	b := app.browser
+	pressed := rl.IsMouseButtonPressed(rl.MouseLeftButton)
+
+	// Overwrite confirmation
+	if b.confirm != "" {
+		if rl.IsKeyPressed(rl.KeyEnter) || rl.IsKeyPressed(rl.KeyKpEnter) || rl.IsKeyPressed(rl.KeyY) {
+			app.finishBrowse(b.confirm)
+			return
+		}
+		if rl.IsKeyPressed(rl.KeyN) {
+			b.confirm = ""
+			return
+		}
+		for i, btn := range b.confirmButtons {
+			if pressed && rl.CheckCollisionPointRec(mousePos, btn.rect) {
+				if i == 0 {
+					app.finishBrowse(b.confirm)
+				} else {
+					b.confirm = ""
+				}
+				return
+			}
+		}
+		return
+	}
+
+	// Filename input
+	for ch := rl.GetCharPressed(); ch > 0; ch = rl.GetCharPressed() {
+		if ch >= 32 && len(b.filename) < 255 {
+			b.filename += string(rune(ch))
+		}
+	}
+	if (rl.IsKeyPressed(rl.KeyBackspace) || rl.IsKeyPressedRepeat(rl.KeyBackspace)) && len(b.filename) > 0 {
+		_, size := utf8.DecodeLastRuneInString(b.filename)
+		b.filename = b.filename[:len(b.filename)-size]
+	}
+	if rl.IsKeyPressed(rl.KeyEnter) || rl.IsKeyPressed(rl.KeyKpEnter) {
+		app.acceptBrowse()
+		return
+	}
+
+	// Scrolling
+	if wheel := rl.GetMouseWheelMove(); wheel != 0 && rl.CheckCollisionPointRec(mousePos, b.listRect) {
+		b.scroll.value = clamp(b.scroll.value-wheel*3, 0, b.scroll.max)
+	}
+	if rl.IsMouseButtonDown(rl.MouseLeftButton) && rl.CheckCollisionPointRec(mousePos, b.scroll.rect) && b.scroll.max > 0 {
+		relY := (mousePos.Y - b.scroll.rect.Y) / b.scroll.rect.Height
+		b.scroll.value = clamp(float32(int(relY*(b.scroll.max+1))), 0, b.scroll.max)
+	}
+
+	if !pressed {
+		return
+	}
+
+	// Buttons
+	for i, btn := range b.buttons {
+		if !rl.CheckCollisionPointRec(mousePos, btn.rect) {
+			continue
+		}
+		switch i {
+		case 0: // Up
+			b.chdir(filepath.Dir(b.dir))
+		case 1: // Open/save/export
+			app.acceptBrowse()
+		case 2: // Cancel, including any close or quit waiting on a save
+			app.browser = nil
+			app.closeAfterSave = nil
+			app.quitting = false
+		}
+		return
+	}
+	for i := range b.optionBoxes {
+		box := &b.optionBoxes[i]
+		hit := rl.Rectangle{X: box.rect.X, Y: box.rect.Y, Width: 86, Height: box.rect.Height}
+		if rl.CheckCollisionPointRec(mousePos, hit) {
+			box.checked = !box.checked
+			app.exportOptions.Dither = b.optionBoxes[0].checked
+			app.exportOptions.LayerFrames = b.optionBoxes[1].checked
+			app.exportOptions.Cycle = b.optionBoxes[2].checked
+			app.exportOptions.Sequence = b.optionBoxes[3].checked
+			app.exportOptions.ICC = b.optionBoxes[4].checked
+			return
+		}
+	}
+	for i, btn := range b.filterButtons {
+		if rl.CheckCollisionPointRec(mousePos, btn.rect) {
+			b.filter = browseFilters[b.mode][i]
+			if !b.mode.reads() && b.filename != "" {
+				// Follow the new type in the typed name
+				name := strings.TrimSpace(b.filename)
+				for _, f := range browseFilters[b.mode] {
+					if fileFilters[f].match(name) {
+						name = strings.TrimSuffix(name, filepath.Ext(name))
+					}
+				}
+				b.filename = name + fileFilters[b.filter].exts[0]
+			}
+			b.readDir()
+			return
+		}
+	}
+
+	// Recent files
+	if rl.CheckCollisionPointRec(mousePos, b.recentRect) {
+		i := int((mousePos.Y - b.recentRect.Y) / browserRowHeight)
+		if i < len(app.recentFiles) {
+			path := app.recentFiles[i]
+			if b.mode.reads() {
+				app.finishBrowse(path)
+				return
+			}
+			b.chdir(filepath.Dir(path))
+			b.filename = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path)) + fileFilters[b.filter].exts[0]
+		}
+		return
+	}
+
+	// File list; a second click on the same row opens it
+	if rl.CheckCollisionPointRec(mousePos, b.listRect) {
+		i := int(b.scroll.value) + int((mousePos.Y-b.listRect.Y)/browserRowHeight)
+		if i >= len(b.entries) {
+			return
+		}
+		now := rl.GetTime()
+		double := i == b.selected && now-b.lastClick < doubleClickTime
+		b.selected = i
+		b.lastClick = now
+
+		entry := b.entries[i]
+		if entry.dir {
+			if double {
+				b.chdir(filepath.Join(b.dir, entry.name))
+			}
+			return
+		}
+		b.filename = entry.name
+		if double {
+			app.acceptBrowse()
+		}
+	}
+}
+
+// Act on the typed name: enter folders, confirm overwrites, or finish
+func (app *App) acceptBrowse() {
+	b := app.browser
+	path := b.target()
+	if path == "" {
+		if b.selected >= 0 && b.entries[b.selected].dir {
+			b.chdir(filepath.Join(b.dir, b.entries[b.selected].name))
+		}
+		return
+	}
+
+	info, err := os.Stat(path)
+	if err == nil && info.IsDir() && !fileFilters[filterProject].match(path) {
+		b.chdir(path)
+		b.filename = ""
+		return
+	}
+
+	switch b.mode {
+	case browseOpen, browsePaste:
+		if err != nil {
+			b.err = "NO SUCH FILE: " + filepath.Base(path)
+			return
+		}
+	default:
+		if err == nil {
+			b.confirm = path
+			return
+		}
+	}
+	app.finishBrowse(path)
+}
+
+// Close the dialog and open, save or export a file
+func (app *App) finishBrowse(path string) {
+	mode := app.browser.mode
+	app.browser = nil
+
+	switch mode {
+	case browseOpen:
+		app.OpenFile(path)
+	case browseSave:
+		app.StartSave(path)
+	case browseExport:
+		app.ExportFile(path)
+	case browsePaste:
+		app.PastePNG(path)
+	}
+}
+
+// Open a project, or import an image or palette, by file type
+func (app *App) OpenFile(path string) {
+	var err error
+	switch {
+	case isProjectDir(path) || fileFilters[filterProject].match(path):
+		err = app.LoadProject(path)
+	case fileFilters[filterDPF].match(path):
+		err = app.OpenDPF(path)
+	case fileFilters[filterPalette].match(path):
+		err = app.OpenPalette(path)
+	default:
+		err = app.ImportImage(path)
+	}
+	if err != nil {
+		app.SetStatus(fmt.Sprintf("OPEN FAILED: %v", err))
+		return
+	}
+	app.addRecentFile(path)
+	app.SetStatus("OPENED " + filepath.Base(path))
+}
+
+// Export the canvas, animation or palette, by file type
+func (app *App) ExportFile(path string) {
+	var err error
+	switch {
+	case fileFilters[filterDPF].match(path):
+		err = app.ExportDPF(path)
+	case fileFilters[filterJPEG].match(path):
+		err = app.ExportJPG(path)
+	case fileFilters[filterGIF].match(path) || fileFilters[filterAPNG].match(path), app.exportOptions.Sequence && fileFilters[filterPNG].match(path):
+		err = app.ExportAnimation(path)
+	case fileFilters[filterSheet].match(path):
+		err = app.ExportSpriteSheet(path)
+	default:
+		err = app.ExportPNG(path)
+	}
+	if err != nil {
+		app.SetStatus(fmt.Sprintf("EXPORT FAILED: %v", err))
+		return
+	}
+	app.SetStatus("EXPORTED " + filepath.Base(path))
+}
+
+// Draw the file dialog over the whole window
+func (app *App) drawFileBrowser() {
+	b := app.browser
+	if b == nil {
+		return
+	}
+	mousePos := rl.GetMousePosition()
+	modal := b.confirm == ""
+
+	rl.DrawRectangle(0, 0, screenWidth, screenHeight, rl.Color{0, 0, 0, 160})
+	rl.DrawRectangleRec(b.box, rl.Color{50, 50, 50, 255})
+	rl.DrawRectangleLinesEx(b.box, 1, rl.Color{90, 90, 90, 255})
+
+	x, y := int32(b.box.X), int32(b.box.Y)
+	rl.DrawText(browseTitles[b.mode], x+10, y+10, fontSize, rl.White)
+	if i := int((mousePos.Y - b.recentRect.Y) / browserRowHeight); modal && rl.CheckCollisionPointRec(mousePos, b.recentRect) && i < len(app.recentFiles) {
+		// Full path of the hovered recent file
+		rl.DrawText(fitText(app.recentFiles[i], 580, true), x+10, y+28, fontSize, rl.Yellow)
+	} else {
+		rl.DrawText(fitText(b.dir, 580, true), x+10, y+28, fontSize, rl.LightGray)
+	}
+	rl.DrawText("RECENT", x+10, y+38, fontSize, rl.LightGray)
+
+	// Recent files; missing ones are dimmed
+	rl.DrawRectangleRec(b.recentRect, rl.Color{40, 40, 40, 255})
+	for i, path := range app.recentFiles {
+		row := rl.Rectangle{X: b.recentRect.X, Y: b.recentRect.Y + float32(i)*browserRowHeight, Width: b.recentRect.Width, Height: browserRowHeight}
+		if row.Y+row.Height > b.recentRect.Y+b.recentRect.Height {
+			break
+		}
+		if modal && rl.CheckCollisionPointRec(mousePos, row) {
+			rl.DrawRectangleRec(row, rl.Color{80, 80, 80, 255})
+		}
+		color := rl.White
+		if _, err := os.Stat(path); err != nil {
+			color = rl.Gray
+		}
+		rl.DrawText(fitText(filepath.Base(path), int32(row.Width)-8, false), int32(row.X)+4, int32(row.Y)+5, fontSize, color)
+	}
+	rl.DrawRectangleLinesEx(b.recentRect, 1, rl.Color{90, 90, 90, 255})
+
+	// File list
+	rl.DrawRectangleRec(b.listRect, rl.Color{40, 40, 40, 255})
+	first := int(b.scroll.value)
+	for i := first; i < len(b.entries) && i < first+browserRows; i++ {
+		entry := b.entries[i]
+		row := rl.Rectangle{X: b.listRect.X, Y: b.listRect.Y + float32(i-first)*browserRowHeight, Width: b.listRect.Width, Height: browserRowHeight}
+		if i == b.selected {
+			rl.DrawRectangleRec(row, rl.Color{100, 100, 150, 255})
+		} else if modal && rl.CheckCollisionPointRec(mousePos, row) {
+			rl.DrawRectangleRec(row, rl.Color{80, 80, 80, 255})
+		}
+		name := entry.name
+		color := rl.White
+		if entry.dir {
+			name += "/"
+			color = rl.SkyBlue
+		}
+		rl.DrawText(fitText(name, int32(row.Width)-8, false), int32(row.X)+4, int32(row.Y)+5, fontSize, color)
+	}
+	rl.DrawRectangleLinesEx(b.listRect, 1, rl.Color{90, 90, 90, 255})
+	if b.err != "" {
+		rl.DrawText(fitText(strings.ToUpper(b.err), int32(b.listRect.Width)-8, false), int32(b.listRect.X)+4, int32(b.listRect.Y+b.listRect.Height)-14, fontSize, rl.Red)
+	}
+
+	// Scroll bar
+	rl.DrawRectangleRec(b.scroll.rect, rl.Color{60, 60, 60, 255})
+	if b.scroll.max > 0 {
+		thumbH := maxf(16, b.scroll.rect.Height*browserRows/float32(len(b.entries)))
+		thumbY := b.scroll.rect.Y + b.scroll.value/b.scroll.max*(b.scroll.rect.Height-thumbH)
+		rl.DrawRectangle(int32(b.scroll.rect.X), int32(thumbY), int32(b.scroll.rect.Width), int32(thumbH), rl.White)
+	}
+
+	// Filename field
+	rl.DrawText("NAME", int32(b.nameRect.X), int32(b.nameRect.Y)-12, fontSize, rl.LightGray)
+	rl.DrawRectangleRec(b.nameRect, rl.Color{30, 30, 30, 255})
+	rl.DrawRectangleLinesEx(b.nameRect, 1, rl.Color{90, 90, 90, 255})
+	name := fitText(b.filename, int32(b.nameRect.Width)-12, true)
+	rl.DrawText(name, int32(b.nameRect.X)+4, int32(b.nameRect.Y)+6, fontSize, rl.White)
+	if modal && int(rl.GetTime()*2)%2 == 0 {
+		cursorX := int32(b.nameRect.X) + 5 + rl.MeasureText(name, fontSize)
+		rl.DrawRectangle(cursorX, int32(b.nameRect.Y)+4, 1, 12, rl.White)
+	}
+
+	// Filters and buttons
+	rl.DrawText("TYPE", int32(b.box.X)+10, int32(b.nameRect.Y)-12, fontSize, rl.LightGray)
+	for i, btn := range b.filterButtons {
+		btn.selected = browseFilters[b.mode][i] == b.filter
+		drawButton(btn, modal && rl.CheckCollisionPointRec(mousePos, btn.rect))
+	}
+	for _, btn := range b.buttons {
+		drawButton(btn, modal && rl.CheckCollisionPointRec(mousePos, btn.rect))
+	}
+	for _, box := range b.optionBoxes {
+		rl.DrawRectangleRec(box.rect, rl.Color{40, 40, 40, 255})
+		rl.DrawRectangleLinesEx(box.rect, 1, rl.White)
+		if box.checked {
+			rl.DrawRectangle(int32(box.rect.X)+3, int32(box.rect.Y)+3, int32(box.rect.Width)-6, int32(box.rect.Height)-6, rl.Yellow)
+		}
+		rl.DrawText(box.label, int32(box.rect.X+box.rect.Width)+4, int32(box.rect.Y)+2, fontSize, rl.White)
+	}
+
+	// Overwrite confirmation
+	if b.confirm != "" {
+		rl.DrawRectangle(0, 0, screenWidth, screenHeight, rl.Color{0, 0, 0, 120})
+		box := rl.Rectangle{X: screenWidth/2 - 200, Y: screenHeight/2 - 40, Width: 400, Height: 84}
+		rl.DrawRectangleRec(box, rl.Color{50, 50, 50, 255})
+		rl.DrawRectangleLinesEx(box, 1, rl.Color{90, 90, 90, 255})
+		rl.DrawText(fitText(filepath.Base(b.confirm), 376, false)+" ALREADY EXISTS.", int32(box.X)+12, int32(box.Y)+12, fontSize, rl.White)
+		rl.DrawText("REPLACE IT?", int32(box.X)+12, int32(box.Y)+26, fontSize, rl.White)
+		for _, btn := range b.confirmButtons {
+			drawButton(btn, rl.CheckCollisionPointRec(mousePos, btn.rect))
+		}
+	}
+}
+
+// Draw a text button in the style of the file buttons
+func drawButton(btn Button, hover bool) {
+	color := rl.Color{70, 70, 70, 255}
+	if btn.selected {
+		color = rl.Color{100, 100, 150, 255}
+	} else if hover {
+		color = rl.Color{80, 80, 80, 255}
+	}
+	rl.DrawRectangleRec(btn.rect, color)
+	rl.DrawRectangleLinesEx(btn.rect, 1, rl.Color{90, 90, 90, 255})
+
+	textW := rl.MeasureText(btn.text, fontSize)
+	rl.DrawText(btn.text, int32(btn.rect.X+btn.rect.Width/2-float32(textW)/2), int32(btn.rect.Y+btn.rect.Height/2-4), fontSize, rl.White)
+}
+
+// Shorten text to fit a width, dropping characters from the start or end
+func fitText(text string, width int32, keepEnd bool) string {
+	if rl.MeasureText(text, fontSize) <= width {
+		return text
+	}
+	runes := []rune(text)
+	for len(runes) > 0 {
+		if keepEnd {
+			runes = runes[1:]
+			if s := "..." + string(runes); rl.MeasureText(s, fontSize) <= width {
+				return s
+			}
+		} else {
+			runes = runes[:len(runes)-1]
+			if s := string(runes) + "..."; rl.MeasureText(s, fontSize) <= width {
+				return s
+			}
+		}
+	}
+	return ""
+}
+
+// Where the recent-files list is kept
+func recentFilesPath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "deluxedraw", "recent.json"), nil
+}
+
+// Read the recent-files list; a missing or damaged list is empty
+func loadRecentFiles() []string {
+	path, err := recentFilesPath()
+	if err != nil {
+		return nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	var files []string
+	if err := json.Unmarshal(data, &files); err != nil {
+		return nil
+	}
+	if len(files) > recentFilesMax {
+		files = files[:recentFilesMax]
+	}
+	return files
+}
+
+// Move a file to the top of the recent-files list and save the list
+func (app *App) addRecentFile(path string) {
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+	files := []string{path}
+	for _, f := range app.recentFiles {
+		if f != path && len(files) < recentFilesMax {
+			files = append(files, f)
+		}
+	}
+	app.recentFiles = files
+
+	listPath, err := recentFilesPath()
+	if err != nil {
+		return
+	}
+	data, err := json.MarshalIndent(files, "", "  ")
+	if err != nil {
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(listPath), 0o755); err != nil {
+		return
+	}
+	// The list is a convenience; failing to save it is not worth a message
+	writeFileAtomic(listPath, func(w io.Writer) error {
+		_, err := w.Write(append(data, '\n'))
+		return err
+	})
+}
@@ -0,0 +1,320 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"math"
+)
+
+// ICC colour profiles, enough to bring images tagged with the usual RGB
+// and grey display profiles (Display P3, Adobe RGB, ProPhoto, gamma 2.2
+// greys) into sRGB, and to tag exports as sRGB. Only matrix/TRC profiles
+// are understood; profiles built from lookup tables alone are not.
+
+var errUnsupportedProfile = errors.New("unsupported ICC profile")
+
+// Largest embedded profile read
+const maxProfileSize = 4 << 20
+
+// PCS white, D50, as profiles round it
+var iccD50 = [3]float64{0.9642, 1, 0.8249}
+
+// Linear sRGB to PCS XYZ, adapted to D50 with Bradford
+var srgbToXYZ = [3][3]float64{
+	{0.4360747, 0.3850649, 0.1430804},
+	{0.2225045, 0.7168786, 0.0606169},
+	{0.0139322, 0.0971045, 0.7141733},
+}
+
+// Tone curve of a channel, from encoded 0-1 values to linear light
+type toneCurve func(float64) float64
+
+// A matrix/TRC profile
+type iccProfile struct {
+	gray   bool
+	matrix [3][3]float64 // linear device RGB to PCS XYZ; unused for grey
+	curves [3]toneCurve  // by channel; grey uses the first
+}
+
+// Parse the parts of a profile needed to convert from it
+func parseICC(data []byte) (*iccProfile, error) {
+	be := binary.BigEndian
+	if len(data) < 132 || string(data[36:40]) != "acsp" {
+		return nil, errors.New("not an ICC profile")
+	}
+	tags := make(map[string][]byte)
+	n := int(be.Uint32(data[128:]))
+	for i := 0; i < n && 132+12*i+12 <= len(data); i++ {
+		entry := data[132+12*i:]
+		offset, size := int(be.Uint32(entry[4:])), int(be.Uint32(entry[8:]))
+		if offset >= 0 && size >= 0 && offset+size <= len(data) {
+			tags[string(entry[:4])] = data[offset : offset+size]
+		}
+	}
+	if string(data[20:24]) != "XYZ " {
+		return nil, errUnsupportedProfile
+	}
+
+	p := &iccProfile{}
+	switch string(data[16:20]) {
+	case "RGB ":
+		for k, c := range []string{"r", "g", "b"} {
+			xyz, err := iccXYZ(tags[c+"XYZ"])
+			if err != nil {
+				return nil, fmt.Errorf("%sXYZ: %w", c, err)
+			}
+			if p.curves[k], err = iccCurve(tags[c+"TRC"]); err != nil {
+				return nil, fmt.Errorf("%sTRC: %w", c, err)
+			}
+			for i := range xyz {
+				p.matrix[i][k] = xyz[i]
+			}
+		}
+	case "GRAY":
+		p.gray = true
+		var err error
+		if p.curves[0], err = iccCurve(tags["kTRC"]); err != nil {
+			return nil, fmt.Errorf("kTRC: %w", err)
+		}
+	default:
+		return nil, errUnsupportedProfile
+	}
+	return p, nil
+}
+
+// Read an XYZType tag
+func iccXYZ(tag []byte) ([3]float64, error) {
+	var xyz [3]float64
+	if len(tag) < 20 || string(tag[:4]) != "XYZ " {
+		return xyz, errUnsupportedProfile
+	}
+	for i := range xyz {
+		xyz[i] = s15Fixed16(tag[8+4*i:])
+	}
+	return xyz, nil
+}
+
+// Read a curveType or parametricCurveType tag
+func iccCurve(tag []byte) (toneCurve, error) {
+	be := binary.BigEndian
+	if len(tag) < 12 {
+		return nil, errUnsupportedProfile
+	}
+	switch string(tag[:4]) {
+	case "curv":
+		n := int(be.Uint32(tag[8:]))
+		if len(tag) < 12+2*n {
+			return nil, errUnsupportedProfile
+		}
+		switch n {
+		case 0:
+			return func(v float64) float64 { return v }, nil
+		case 1:
+			gamma := float64(be.Uint16(tag[12:])) / 256
+			return func(v float64) float64 { return math.Pow(v, gamma) }, nil
+		}
+		table := make([]float64, n)
+		for i := range table {
+			table[i] = float64(be.Uint16(tag[12+2*i:])) / 65535
+		}
+		return func(v float64) float64 {
+			x := math.Max(0, math.Min(v, 1)) * float64(n-1)
+			i := min(int(x), n-2)
+			t := x - float64(i)
+			return table[i]*(1-t) + table[i+1]*t
+		}, nil
+	case "para":
+		// All five functions as the last: (a*v + b)^g + e from d up,
+		// c*v + f below
+		counts := []int{1, 3, 4, 5, 7}
+		fn := int(be.Uint16(tag[8:]))
+		if fn >= len(counts) || len(tag) < 12+4*counts[fn] {
+			return nil, errUnsupportedProfile
+		}
+		p := [7]float64{1, 1}
+		for i := range counts[fn] {
+			p[i] = s15Fixed16(tag[12+4*i:])
+		}
+		g, a, b, c, d, e, f := p[0], p[1], p[2], p[3], p[4], p[5], p[6]
+		switch fn {
+		case 1:
+			d = -b / a
+		case 2:
+			d, e, f = -b/a, c, c
+			c = 0
+		}
+		return func(v float64) float64 {
+			if v >= d {
+				return math.Pow(math.Max(a*v+b, 0), g) + e
+			}
+			return c*v + f
+		}, nil
+	}
+	return nil, errUnsupportedProfile
+}
+
+func s15Fixed16(b []byte) float64 {
+	return float64(int32(binary.BigEndian.Uint32(b))) / 65536
+}
+
+// Check whether a profile describes sRGB closely enough to leave pixels be
+func (p *iccProfile) isSRGB() bool {
+	if !p.gray {
+		for i := range p.matrix {
+			for k := range p.matrix[i] {
+				if math.Abs(p.matrix[i][k]-srgbToXYZ[i][k]) > 0.002 {
+					return false
+				}
+			}
+		}
+	}
+	for _, curve := range p.curves {
+		if curve == nil {
+			continue
+		}
+		for i := 0; i < 256; i++ {
+			if math.Abs(curve(float64(i)/255)-decodeSRGB(float64(i)/255)) > 0.5/255 {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// Convert pixels in the profile's colour space to sRGB in place. Colours
+// outside sRGB are clipped; alpha is kept.
+func (p *iccProfile) toSRGB(img *image.NRGBA) {
+	if p.isSRGB() {
+		return
+	}
+	var linear [3][256]float64
+	for k, curve := range p.curves {
+		if curve == nil {
+			continue
+		}
+		for i := range linear[k] {
+			linear[k][i] = curve(float64(i) / 255)
+		}
+	}
+	m := mulMatrix(invertMatrix(srgbToXYZ), p.matrix)
+
+	// sRGB encoding, interpolated between samples of linear light
+	const steps = 4096
+	var encoded [steps + 1]float64
+	for i := range encoded {
+		encoded[i] = encodeSRGB(float64(i)/steps) * 255
+	}
+	encode := func(v float64) uint8 {
+		x := math.Max(0, math.Min(v, 1)) * steps
+		i := min(int(x), steps-1)
+		t := x - float64(i)
+		return uint8(encoded[i]*(1-t) + encoded[i+1]*t + 0.5)
+	}
+
+	bounds := img.Rect
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		row := img.Pix[img.PixOffset(bounds.Min.X, y):][:bounds.Dx()*4]
+		for x := 0; x < len(row); x += 4 {
+			if p.gray {
+				v := encode(linear[0][row[x]])
+				row[x], row[x+1], row[x+2] = v, v, v
+				continue
+			}
+			r, g, b := linear[0][row[x]], linear[1][row[x+1]], linear[2][row[x+2]]
+			for k := range 3 {
+				row[x+k] = encode(m[k][0]*r + m[k][1]*g + m[k][2]*b)
+			}
+		}
+	}
+}
+
+func mulMatrix(a, b [3][3]float64) [3][3]float64 {
+	var out [3][3]float64
+	for i := range out {
+		for j := range out[i] {
+			out[i][j] = a[i][0]*b[0][j] + a[i][1]*b[1][j] + a[i][2]*b[2][j]
+		}
+	}
+	return out
+}
+
+func invertMatrix(m [3][3]float64) [3][3]float64 {
+	det := m[0][0]*(m[1][1]*m[2][2]-m[1][2]*m[2][1]) -
+		m[0][1]*(m[1][0]*m[2][2]-m[1][2]*m[2][0]) +
+		m[0][2]*(m[1][0]*m[2][1]-m[1][1]*m[2][0])
+	return [3][3]float64{
+		{(m[1][1]*m[2][2] - m[1][2]*m[2][1]) / det, (m[0][2]*m[2][1] - m[0][1]*m[2][2]) / det, (m[0][1]*m[1][2] - m[0][2]*m[1][1]) / det},
+		{(m[1][2]*m[2][0] - m[1][0]*m[2][2]) / det, (m[0][0]*m[2][2] - m[0][2]*m[2][0]) / det, (m[0][2]*m[1][0] - m[0][0]*m[1][2]) / det},
+		{(m[1][0]*m[2][1] - m[1][1]*m[2][0]) / det, (m[0][1]*m[2][0] - m[0][0]*m[2][1]) / det, (m[0][0]*m[1][1] - m[0][1]*m[1][0]) / det},
+	}
+}
+
+// Compact ICC v2 display profile describing sRGB, for tagging exports
+var srgbProfile = buildSRGBProfile()
+
+func buildSRGBProfile() []byte {
+	be := binary.BigEndian
+	xyz := func(v [3]float64) []byte {
+		tag := []byte("XYZ \x00\x00\x00\x00")
+		for _, c := range v {
+			tag = be.AppendUint32(tag, uint32(int32(math.Round(c*65536))))
+		}
+		return tag
+	}
+	column := func(k int) [3]float64 {
+		return [3]float64{srgbToXYZ[0][k], srgbToXYZ[1][k], srgbToXYZ[2][k]}
+	}
+
+	name := "sRGB IEC61966-2.1"
+	desc := be.AppendUint32([]byte("desc\x00\x00\x00\x00"), uint32(len(name)+1))
+	desc = append(desc, name...)
+	desc = append(desc, 0)
+	desc = append(desc, make([]byte, 8+3+67)...) // no Unicode or ScriptCode names
+	cprt := append([]byte("text\x00\x00\x00\x00"), "No copyright, use freely\x00"...)
+	curve := be.AppendUint32([]byte("curv\x00\x00\x00\x00"), 1024)
+	for i := range 1024 {
+		curve = be.AppendUint16(curve, uint16(math.Round(decodeSRGB(float64(i)/1023)*65535)))
+	}
+	tags := []struct {
+		sig  string
+		data []byte
+	}{
+		{"desc", desc}, {"cprt", cprt}, {"wtpt", xyz(iccD50)},
+		{"rXYZ", xyz(column(0))}, {"gXYZ", xyz(column(1))}, {"bXYZ", xyz(column(2))},
+		{"rTRC", curve}, {"gTRC", curve}, {"bTRC", curve}, // one curve, shared
+	}
+
+	header := make([]byte, 128)
+	be.PutUint32(header[8:], 0x02100000) // version 2.1
+	copy(header[12:], "mntr")
+	copy(header[16:], "RGB ")
+	copy(header[20:], "XYZ ")
+	for i, v := range []uint16{2000, 1, 1} {
+		be.PutUint16(header[24+2*i:], v)
+	}
+	copy(header[36:], "acsp")
+	copy(header[68:], xyz(iccD50)[8:])
+
+	table := be.AppendUint32(nil, uint32(len(tags)))
+	offset := len(header) + 4 + 12*len(tags)
+	var data []byte
+	offsets := make(map[*byte]int) // by tag data, so shared data is stored once
+	for _, t := range tags {
+		at, ok := offsets[&t.data[0]]
+		if !ok {
+			at = offset + len(data)
+			offsets[&t.data[0]] = at
+			data = append(data, t.data...)
+			data = append(data, make([]byte, -len(data)&3)...)
+		}
+		table = append(table, t.sig...)
+		table = be.AppendUint32(table, uint32(at))
+		table = be.AppendUint32(table, uint32(len(t.data)))
+	}
+	profile := bytes.Join([][]byte{header, table, data}, nil)
+	be.PutUint32(profile, uint32(len(profile)))
+	return profile
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"math"
+	"testing"
+)
+
+// A profile of a colour space ("RGB " or "GRAY") with tags by signature
+func testICC(space string, tags ...[2]string) []byte {
+	be := binary.BigEndian
+	header := make([]byte, 128)
+	copy(header[16:], space)
+	copy(header[20:], "XYZ ")
+	copy(header[36:], "acsp")
+	table := be.AppendUint32(nil, uint32(len(tags)))
+	var data []byte
+	offset := len(header) + 4 + 12*len(tags)
+	for _, t := range tags {
+		table = append(table, t[0]...)
+		table = be.AppendUint32(table, uint32(offset+len(data)))
+		table = be.AppendUint32(table, uint32(len(t[1])))
+		data = append(data, t[1]...)
+	}
+	return bytes.Join([][]byte{header, table, data}, nil)
+}
+
+func testS15Fixed16(b []byte, values ...float64) []byte {
+	for _, v := range values {
+		b = binary.BigEndian.AppendUint32(b, uint32(int32(math.Round(v*65536))))
+	}
+	return b
+}
+
+func testXYZTag(x, y, z float64) string {
+	return string(testS15Fixed16([]byte("XYZ \x00\x00\x00\x00"), x, y, z))
+}
+
+// A curveType tag of 16-bit entries; one entry is a gamma times 256
+func testCurvTag(entries ...uint16) string {
+	tag := binary.BigEndian.AppendUint32([]byte("curv\x00\x00\x00\x00"), uint32(len(entries)))
+	for _, e := range entries {
+		tag = binary.BigEndian.AppendUint16(tag, e)
+	}
+	return string(tag)
+}
+
+// A parametricCurveType tag of a function type and its parameters
+func testParaTag(fn uint16, params ...float64) string {
+	tag := binary.BigEndian.AppendUint16([]byte("para\x00\x00\x00\x00"), fn)
+	tag = append(tag, 0, 0)
+	return string(testS15Fixed16(tag, params...))
+}
+
+// RGB profile with a matrix whose columns are the primaries' XYZ, and one
+// curve for all three channels
+func testRGBProfile(matrix [3][3]float64, curve string) []byte {
+	return testICC("RGB ",
+		[2]string{"rXYZ", testXYZTag(matrix[0][0], matrix[1][0], matrix[2][0])},
+		[2]string{"gXYZ", testXYZTag(matrix[0][1], matrix[1][1], matrix[2][1])},
+		[2]string{"bXYZ", testXYZTag(matrix[0][2], matrix[1][2], matrix[2][2])},
+		[2]string{"rTRC", curve}, [2]string{"gTRC", curve}, [2]string{"bTRC", curve})
+}
+
+func TestICCCurve(t *testing.T) {
+	srgb := testParaTag(3, 2.4, 1/1.055, 0.055/1.055, 1/12.92, 0.04045)
+	for _, tc := range []struct {
+		name string
+		tag  string
+		in   []float64
+		want []float64
+	}{
+		{"curv identity", testCurvTag(), []float64{0, 0.3, 1}, []float64{0, 0.3, 1}},
+		{"curv gamma", testCurvTag(2 * 256), []float64{0, 0.5, 1}, []float64{0, 0.25, 1}},
+		{"curv table", testCurvTag(0, 65535, 0), []float64{0, 0.25, 0.5, 0.75, 1, 2}, []float64{0, 0.5, 1, 0.5, 0, 0}},
+		{"para 0", testParaTag(0, 2), []float64{0, 0.5, 1}, []float64{0, 0.25, 1}},
+		// (a*v + b)^g from -b/a up, 0 below
+		{"para 1", testParaTag(1, 2, 1, -0.5), []float64{0.25, 0.5, 0.75}, []float64{0, 0, 0.0625}},
+		// (a*v + b)^g + c from -b/a up, c below
+		{"para 2", testParaTag(2, 1, 1, -0.5, 0.25), []float64{0.25, 0.75}, []float64{0.25, 0.5}},
+		// (a*v + b)^g from d up, c*v below
+		{"para 3", srgb, []float64{0, 0.02, 0.5, 1}, []float64{decodeSRGB(0), decodeSRGB(0.02), decodeSRGB(0.5), 1}},
+		// (a*v + b)^g + e from d up, c*v + f below
+		{"para 4", testParaTag(4, 1, 0.5, 0, 1, 0.5, 0.125, 0.0625), []float64{0.25, 0.75}, []float64{0.3125, 0.5}},
+	} {
+		curve, err := iccCurve([]byte(tc.tag))
+		if err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+			continue
+		}
+		for i, v := range tc.in {
+			if got := curve(v); math.Abs(got-tc.want[i]) > 1e-4 {
+				t.Errorf("%s(%g) = %g, want %g", tc.name, v, got, tc.want[i])
+			}
+		}
+	}
+
+	for _, tc := range []struct{ name, tag string }{
+		{"short", "curv"},
+		{"curv past the tag", testCurvTag(0, 1, 2)[:14]},
+		{"para 5", testParaTag(5, 1, 1, 0, 0, 0, 0, 0, 0)},
+		{"para missing parameters", testParaTag(3, 2.4, 1)},
+		{"other type", "sf32\x00\x00\x00\x00\x00\x00\x00\x00"},
+	} {
+		if _, err := iccCurve([]byte(tc.tag)); err == nil {
+			t.Errorf("%s: curve read", tc.name)
+		}
+	}
+}
+
+func TestParseICC(t *testing.T) {
+	gamma := testCurvTag(563) // 2.2
+	rgb := testRGBProfile(srgbToXYZ, gamma)
+	labPCS := bytes.Clone(rgb)
+	copy(labPCS[20:], "Lab ")
+	cmyk := bytes.Clone(rgb)
+	copy(cmyk[16:], "CMYK")
+	for _, tc := range []struct {
+		name string
+		data []byte
+		ok   bool
+	}{
+		{"rgb", rgb, true},
+		{"gray", testICC("GRAY", [2]string{"kTRC", gamma}), true},
+		{"not a profile", make([]byte, 200), false},
+		{"truncated", rgb[:100], false},
+		{"lab pcs", labPCS, false},
+		{"cmyk", cmyk, false},
+		{"gray without a curve", testICC("GRAY"), false},
+		{"rgb without blue", testICC("RGB ",
+			[2]string{"rXYZ", testXYZTag(0.4, 0.2, 0)}, [2]string{"gXYZ", testXYZTag(0.4, 0.7, 0.1)},
+			[2]string{"rTRC", gamma}, [2]string{"gTRC", gamma}, [2]string{"bTRC", gamma}), false},
+		{"rgb with a bad curve", testRGBProfile(srgbToXYZ, testParaTag(9)), false},
+	} {
+		p, err := parseICC(tc.data)
+		if (err == nil) != tc.ok {
+			t.Errorf("%s: error %v", tc.name, err)
+			continue
+		}
+		if err == nil && p.isSRGB() {
+			t.Errorf("%s: gamma 2.2 taken as sRGB", tc.name)
+		}
+	}
+
+	p, err := parseICC(rgb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range p.matrix {
+		for k := range p.matrix[i] {
+			if math.Abs(p.matrix[i][k]-srgbToXYZ[i][k]) > 1e-4 {
+				t.Fatalf("matrix %v, want %v", p.matrix, srgbToXYZ)
+			}
+		}
+	}
+}
+
+func TestSRGBProfile(t *testing.T) {
+	p, err := parseICC(srgbProfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.gray || !p.isSRGB() {
+		t.Error("sRGB profile not taken as sRGB")
+	}
+	if got := binary.BigEndian.Uint32(srgbProfile); int(got) != len(srgbProfile) {
+		t.Errorf("profile size field %d, profile is %d bytes", got, len(srgbProfile))
+	}
+	// Curves as parametric functions describe sRGB too
+	srgb := testParaTag(3, 2.4, 1/1.055, 0.055/1.055, 1/12.92, 0.04045)
+	if p, err := parseICC(testRGBProfile(srgbToXYZ, srgb)); err != nil || !p.isSRGB() {
+		t.Errorf("parametric sRGB profile not taken as sRGB: %v", err)
+	}
+}
+
+func TestToSRGB(t *testing.T) {
+	// Channels swapped: device red is sRGB green and so on
+	var rotated [3][3]float64
+	for i := range rotated {
+		rotated[i] = [3]float64{srgbToXYZ[i][1], srgbToXYZ[i][2], srgbToXYZ[i][0]}
+	}
+	srgbCurve := testParaTag(3, 2.4, 1/1.055, 0.055/1.055, 1/12.92, 0.04045)
+	half := uint8(math.Round(encodeSRGB(128.0/255) * 255))
+
+	for _, tc := range []struct {
+		name    string
+		profile []byte
+		in      [4]uint8
+		want    [4]uint8
+	}{
+		{"srgb leaves pixels", srgbProfile, [4]uint8{10, 128, 250, 77}, [4]uint8{10, 128, 250, 77}},
+		{"swapped channels", testRGBProfile(rotated, srgbCurve), [4]uint8{200, 100, 50, 255}, [4]uint8{50, 200, 100, 255}},
+		{"linear rgb", testRGBProfile(srgbToXYZ, testCurvTag()), [4]uint8{0, 128, 255, 128}, [4]uint8{0, half, 255, 128}},
+		{"linear gray", testICC("GRAY", [2]string{"kTRC", testCurvTag()}), [4]uint8{128, 0, 0, 255}, [4]uint8{half, half, half, 255}},
+	} {
+		p, err := parseICC(tc.profile)
+		if err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+			continue
+		}
+		img := image.NewNRGBA(image.Rect(0, 0, 1, 1))
+		copy(img.Pix, tc.in[:])
+		p.toSRGB(img)
+		for k, v := range img.Pix {
+			if d := int(v) - int(tc.want[k]); d < -1 || d > 1 {
+				t.Errorf("%s: %v becomes %v, want %v", tc.name, tc.in, img.Pix, tc.want)
+				break
+			}
+		}
+	}
+}
+
+// JPEG of segments, each a marker and its contents, ending at the scan
+func testJPEG(segments ...[]byte) []byte {
+	data := []byte{0xff, 0xd8}
+	for _, s := range segments {
+		data = append(data, 0xff, s[0])
+		data = binary.BigEndian.AppendUint16(data, uint16(len(s)+1))
+		data = append(data, s[1:]...)
+	}
+	return append(data, 0xff, 0xda, 0, 2)
+}
+
+func testICCSegment(seq, count byte, part string) []byte {
+	return append([]byte("\xe2ICC_PROFILE\x00"+string([]byte{seq, count})), part...)
+}
+
+func TestJPEGProfile(t *testing.T) {
+	app0 := []byte("\xe0JFIF\x00\x01\x01\x00\x00\x01\x00\x01\x00\x00")
+	for _, tc := range []struct {
+		name string
+		data []byte
+		want string
+	}{
+		{"one part", testJPEG(app0, testICCSegment(1, 1, "whole")), "whole"},
+		{"parts in order", testJPEG(app0, testICCSegment(1, 3, "first "), testICCSegment(2, 3, "second "), testICCSegment(3, 3, "third")),
+			"first second third"},
+		{"parts out of order", testJPEG(testICCSegment(2, 2, "second"), app0, testICCSegment(1, 2, "first ")), "first second"},
+		{"no profile", testJPEG(app0), ""},
+		{"missing part", testJPEG(testICCSegment(1, 3, "first"), testICCSegment(3, 3, "third")), ""},
+		{"part numbered past the count", testJPEG(testICCSegment(1, 2, "first"), testICCSegment(3, 2, "third")), ""},
+		{"other APP2 data", testJPEG([]byte("\xe2FPXR\x00\x00\x00")), ""},
+		// Segments after the scan starts are image data
+		{"after the scan", append(testJPEG(app0), testJPEG(testICCSegment(1, 1, "late"))[2:]...), ""},
+	} {
+		if got := string(jpegProfile(tc.data)); got != tc.want {
+			t.Errorf("%s: profile %q, want %q", tc.name, got, tc.want)
+		}
+	}
+}
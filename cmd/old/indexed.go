@@ -0,0 +1,508 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"slices"
+
+	rl "github.com/gen2brain/raylib-go/raylib"
+)
+
+// Indexed colour documents. Their pixels are palette indices rather than
+// colours: the index in red with full alpha, or clear for the palette's
+// transparent entry. The palette belongs to the document, so changing an
+// entry recolours every pixel using it. Layer pages are turned into
+// colours by a shader as the compositor draws them, and compositeLayer
+// does the same on the CPU.
+
+const colorModeIndexed = "indexed"
+
+// Most entries an indexed palette can have
+const maxIndexedColors = 256
+
+// Palette of an indexed document
+type docPalette struct {
+	colors      []dpfColor // keys and names are kept for DPF files
+	transparent int        // entry that clear pixels show
+	cycles      []Cycle    // colour cycling ranges
+}
+
+// Copy of the palette, nil for nil
+func (p *docPalette) clone() *docPalette {
+	if p == nil {
+		return nil
+	}
+	c := *p
+	c.colors = append([]dpfColor(nil), p.colors...)
+	c.cycles = append([]Cycle(nil), p.cycles...)
+	return &c
+}
+
+// Check whether two palettes have the same entries, transparent entry and
+// cycles, an empty list being the same as none; nil equals only nil
+func (p *docPalette) equal(q *docPalette) bool {
+	if p == nil || q == nil {
+		return p == q
+	}
+	return p.transparent == q.transparent && slices.Equal(p.colors, q.colors) && slices.Equal(p.cycles, q.cycles)
+}
+
+// Colours the indices show, the transparent entry clear
+func (p *docPalette) rgba() []rl.Color {
+	colors := make([]rl.Color, len(p.colors))
+	for i, c := range p.colors {
+		if i != p.transparent {
+			colors[i] = c.color
+		}
+	}
+	return colors
+}
+
+// Closest entry to a colour; colours less than half opaque are the
+// transparent entry
+func (p *docPalette) nearest(c color.NRGBA) int {
+	if c.A < 128 {
+		return p.transparent
+	}
+	best, bestDist := p.transparent, -1
+	for i, e := range p.colors {
+		if i == p.transparent {
+			continue
+		}
+		dr, dg, db := int(c.R)-int(e.color.R), int(c.G)-int(e.color.G), int(c.B)-int(e.color.B)
+		if d := dr*dr + dg*dg + db*db; bestDist < 0 || d < bestDist {
+			best, bestDist = i, d
+		}
+	}
+	return best
+}
+
+// Replace the colours of an image with the pixels of their closest entries
+func (p *docPalette) indexImage(img *image.NRGBA) {
+	cache := make(map[color.NRGBA]color.NRGBA)
+	for i := 0; i < len(img.Pix); i += 4 {
+		c := color.NRGBA{img.Pix[i], img.Pix[i+1], img.Pix[i+2], img.Pix[i+3]}
+		px, ok := cache[c]
+		if !ok {
+			px = indexPixel(p.nearest(c), p.transparent)
+			cache[c] = px
+		}
+		img.Pix[i], img.Pix[i+1], img.Pix[i+2], img.Pix[i+3] = px.R, px.G, px.B, px.A
+	}
+}
+
+// Palette entries as saved in project.json
+func (p *docPalette) save(project *ProjectData) {
+	project.ColorMode = colorModeIndexed
+	project.Transparent = p.transparent
+	project.Palette = make([]ColorData, len(p.colors))
+	keys := make([]rune, len(p.colors))
+	for i, c := range p.colors {
+		project.Palette[i] = ColorData{c.color.R, c.color.G, c.color.B, c.color.A}
+		keys[i] = c.key
+	}
+	project.PaletteKeys = string(keys)
+	project.Cycles = nil
+	for _, c := range p.cycles {
+		project.Cycles = append(project.Cycles, c.Data())
+	}
+}
+
+// Palette of a saved project, nil unless it is indexed. Entries without
+// a saved key get the first unused one.
+func projectPalette(project ProjectData) *docPalette {
+	if project.ColorMode != colorModeIndexed {
+		return nil
+	}
+	p := &docPalette{transparent: project.Transparent}
+	keys := []rune(project.PaletteKeys)
+	for i, c := range project.Palette {
+		entry := dpfColor{color: rl.Color{c.R, c.G, c.B, c.A}, name: fmt.Sprintf("color %d", i+1)}
+		if len(keys) == len(project.Palette) {
+			entry.key = keys[i]
+		} else {
+			entry.key = unusedDPFKey(p.colors)
+		}
+		p.colors = append(p.colors, entry)
+	}
+	for _, c := range project.Cycles {
+		p.cycles = append(p.cycles, cycleFromData(c))
+	}
+	return p
+}
+
+// Colours the pixels of a saved project show, nil unless it is indexed
+func projectColors(project ProjectData) []rl.Color {
+	if p := projectPalette(project); p != nil {
+		return p.rgba()
+	}
+	return nil
+}
+
+// Indexed palette for DPF palette entries. The first clear entry is the
+// transparent one; without one, a clear entry is added.
+func dpfDocPalette(colors []dpfColor) (*docPalette, error) {
+	p := &docPalette{colors: append([]dpfColor(nil), colors...), transparent: -1}
+	for i, c := range colors {
+		if c.color.A == 0 {
+			p.transparent = i
+			break
+		}
+	}
+	if p.transparent < 0 {
+		p.transparent = len(p.colors)
+		p.colors = append(p.colors, dpfColor{key: unusedDPFKey(colors), name: "transparent"})
+	}
+	if len(p.colors) > maxIndexedColors {
+		return nil, fmt.Errorf("palette has %d colors, indexed documents allow %d", len(p.colors), maxIndexedColors)
+	}
+	return p, nil
+}
+
+// First key character not used by a palette, from dpfKeys while they last
+func unusedDPFKey(colors []dpfColor) rune {
+	used := make(map[rune]bool)
+	for _, c := range colors {
+		used[c.key] = true
+	}
+	for _, r := range dpfKeys {
+		if !used[r] {
+			return r
+		}
+	}
+	r := rune(0xC0)
+	for used[r] {
+		r++
+	}
+	return r
+}
+
+// Stored pixel for a palette index
+func indexPixel(index, transparent int) color.NRGBA {
+	if index == transparent {
+		return color.NRGBA{}
+	}
+	return color.NRGBA{uint8(index), 0, 0, 255}
+}
+
+// Palette index of a stored pixel
+func pixelIndex(c color.NRGBA, transparent int) int {
+	if c.A < 128 {
+		return transparent
+	}
+	return int(c.R)
+}
+
+// Colour a stored pixel shows with the given palette colours
+func indexColor(colors []rl.Color, c rl.Color) rl.Color {
+	if c.A < 128 || int(c.R) >= len(colors) {
+		return rl.Blank
+	}
+	return colors[c.R]
+}
+
+// Replace the pixels of an image with the colours they show
+func colorImage(img *image.NRGBA, colors []rl.Color) {
+	for i := 0; i < len(img.Pix); i += 4 {
+		c := indexColor(colors, rl.Color{img.Pix[i], img.Pix[i+1], img.Pix[i+2], img.Pix[i+3]})
+		img.Pix[i], img.Pix[i+1], img.Pix[i+2], img.Pix[i+3] = c.R, c.G, c.B, c.A
+	}
+}
+
+// Pixels of a DPF icon as indices into p, which holds the icon's palette
+func dpfIconIndices(icon *dpfIcon, p *docPalette) *image.NRGBA {
+	pixels := make(map[rune]color.NRGBA)
+	for i, c := range p.colors {
+		pixels[c.key] = indexPixel(i, p.transparent)
+	}
+	img := image.NewNRGBA(image.Rect(0, 0, icon.width, icon.height))
+	for y, row := range icon.rows {
+		for x, r := range []rune(row) {
+			img.SetNRGBA(x, y, pixels[r])
+		}
+	}
+	return img
+}
+
+// Icon of indexed pixels, with the keys of their palette entries
+func dpfIndexIcon(name string, img *image.NRGBA, p *docPalette) *dpfIcon {
+	b := img.Bounds()
+	icon := &dpfIcon{name: name, width: b.Dx(), height: b.Dy()}
+	row := make([]rune, b.Dx())
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			i := pixelIndex(img.NRGBAAt(x, y), p.transparent)
+			if i >= len(p.colors) {
+				i = p.transparent
+			}
+			row[x-b.Min.X] = p.colors[i].key
+		}
+		icon.rows = append(icon.rows, string(row))
+	}
+	return icon
+}
+
+// Colours of the document's palette entries, nil for truecolour
+func (d *Document) paletteColors() []rl.Color {
+	if d.palette == nil {
+		return nil
+	}
+	return d.palette.rgba()
+}
+
+// Make palette the document's, recolouring everything shown
+func (d *Document) setPalette(palette *docPalette) {
+	d.palette = palette
+	d.compositor.SetPalette(d.paletteColors())
+	for _, layer := range d.layers {
+		layer.thumbDirty = true
+	}
+	d.unloadOnion()
+}
+
+// Pixels of a layer from this document as colours
+func (d *Document) colorStore(store *TileStore) *TileStore {
+	if d.palette == nil {
+		return store
+	}
+	colors := d.paletteColors()
+	return convertStore(store, func(img *image.NRGBA) {
+		colorImage(img, colors)
+	})
+}
+
+// Colour pixels as this document stores them
+func (d *Document) indexStore(store *TileStore) *TileStore {
+	if d.palette == nil {
+		return store
+	}
+	return convertStore(store, d.palette.indexImage)
+}
+
+// Copy of a store with its pixels converted
+func convertStore(store *TileStore, convert func(img *image.NRGBA)) *TileStore {
+	img := store.ToNRGBA()
+	convert(img)
+	return TileStoreFromNRGBA(img)
+}
+
+// Convert the pixels of every layer in every frame. Linked frames stay
+// linked.
+func (d *Document) convertPixels(convert func(img *image.NRGBA)) {
+	for _, layer := range d.layers {
+		layer.storeCel(d.frame)
+		done := make(map[*Cel]bool)
+		for _, c := range layer.cels {
+			if c != nil && !done[c] {
+				c.tiles = convertStore(c.tiles, convert)
+				done[c] = true
+			}
+		}
+		layer.loadCel(d.frame, d.canvasWidth, d.canvasHeight)
+		d.compositor.MarkLayer(layer)
+	}
+}
+
+// Renumber the pixels of an image: index i becomes to[i], clear if that
+// is transparent. Clear pixels and indices past to are left alone.
+func renumberImage(to []int, transparent int) func(img *image.NRGBA) {
+	return func(img *image.NRGBA) {
+		for p := 0; p < len(img.Pix); p += 4 {
+			if img.Pix[p+3] < 128 || int(img.Pix[p]) >= len(to) {
+				continue
+			}
+			c := indexPixel(to[img.Pix[p]], transparent)
+			img.Pix[p], img.Pix[p+1], img.Pix[p+2], img.Pix[p+3] = c.R, c.G, c.B, c.A
+		}
+	}
+}
+
+// Renumber the pixels of every layer in every frame and of every undo
+// step, so the history stays usable with the palette they now index
+func (d *Document) renumberPixels(to []int, transparent int) {
+	renumber := renumberImage(to, transparent)
+	d.convertPixels(renumber)
+	for i := range d.history {
+		d.history[i].layerData = convertStore(d.history[i].layerData, renumber)
+	}
+}
+
+// Turn the active document into an indexed one using the current colours,
+// mapping every pixel to its closest entry. Undo history is cleared, since
+// it holds pixels in the old form.
+func (app *App) ConvertToIndexed() error {
+	if app.palette != nil {
+		return nil
+	}
+	colors, err := dpfPalette(app.colorPalette)
+	if err != nil {
+		return err
+	}
+	palette, err := dpfDocPalette(colors)
+	if err != nil {
+		return err
+	}
+	app.convertPixels(palette.indexImage)
+	app.history = nil
+	app.historyIndex = -1
+	app.setPalette(palette)
+	app.selectIndex(palette.nearest(color.NRGBA{app.currentColor.R, app.currentColor.G, app.currentColor.B, 255}))
+	return nil
+}
+
+// Turn the active document back into a truecolour one. Its palette
+// becomes the current colours.
+func (app *App) ConvertToRGB() {
+	if app.palette == nil {
+		return
+	}
+	colors := app.paletteColors()
+	app.convertPixels(func(img *image.NRGBA) {
+		colorImage(img, colors)
+	})
+	app.history = nil
+	app.historyIndex = -1
+	app.colorPalette = nil
+	for _, c := range app.palette.colors {
+		app.colorPalette = append(app.colorPalette, c.color)
+	}
+	app.setPalette(nil)
+}
+
+// Switch the active document between indexed and truecolour
+func (app *App) ToggleIndexed() {
+	if app.palette != nil {
+		app.ConvertToRGB()
+		app.SetStatus("CONVERTED TO RGB")
+		return
+	}
+	if err := app.ConvertToIndexed(); err != nil {
+		app.SetStatus(fmt.Sprintf("CONVERT FAILED: %v", err))
+		return
+	}
+	app.SetStatus(fmt.Sprintf("CONVERTED TO INDEXED, %d COLORS", len(app.palette.colors)))
+}
+
+// Paint with a palette entry
+func (app *App) selectIndex(i int) {
+	app.currentIndex = i
+	if c := app.palette.colors[i].color; c.A > 0 {
+		app.currentColor = c
+	}
+}
+
+// Colour the painting tools put into the active layer
+func (app *App) paintColor() rl.Color {
+	if app.palette == nil {
+		return app.currentColor
+	}
+	i := max(0, min(app.currentIndex, len(app.palette.colors)-1))
+	c := indexPixel(i, app.palette.transparent)
+	return rl.Color{c.R, c.G, c.B, c.A}
+}
+
+// Change a palette entry of the active document
+func (app *App) SetPaletteColor(i int, c rl.Color) {
+	palette := app.palette.clone()
+	palette.colors[i].color = c
+	app.setPalette(palette)
+	if i == app.currentIndex && c.A > 0 {
+		app.currentColor = c
+	}
+}
+
+// Make a palette entry the one clear pixels show. Pixels painted with it
+// become clear, and clear pixels stay clear.
+func (app *App) SetTransparentIndex(i int) {
+	palette := app.palette.clone()
+	palette.transparent = i
+	app.ReplacePalette(palette)
+	app.SetStatus(fmt.Sprintf("TRANSPARENT INDEX %d", i))
+}
+
+// Give the active document another palette. Pixels keep their indices;
+// when the transparent entry changes, pixels painted with the new one
+// become clear and the undo history is cleared.
+func (app *App) ReplacePalette(palette *docPalette) {
+	if palette.transparent != app.palette.transparent {
+		app.convertPixels(func(img *image.NRGBA) {
+			for p := 0; p < len(img.Pix); p += 4 {
+				if img.Pix[p+3] >= 128 && int(img.Pix[p]) == palette.transparent {
+					img.Pix[p], img.Pix[p+1], img.Pix[p+2], img.Pix[p+3] = 0, 0, 0, 0
+				}
+			}
+		})
+		app.history = nil
+		app.historyIndex = -1
+	}
+	app.setPalette(palette)
+	app.selectIndex(min(app.currentIndex, len(palette.colors)-1))
+}
+
+// Palette index of the topmost visible pixel of the active document
+func (app *App) pickIndex(x, y int) int {
+	for i := len(app.layers) - 1; i >= 0; i-- {
+		layer := app.layers[i]
+		if !layer.visible {
+			continue
+		}
+		layer.Sync()
+		if c := layer.tiles.At(x, y); c.A >= 128 && int(c.R) < len(app.palette.colors) {
+			return int(c.R)
+		}
+	}
+	return app.palette.transparent
+}
+
+// Shader turning layer pages of indices into colours, premultiplied by
+// alpha for the layer blend modes; loaded on first use
+var paletteShader struct {
+	shader  rl.Shader
+	palette int32 // location of the palette texture
+	loaded  bool
+}
+
+const paletteVertexShader = `#version 330
+in vec3 vertexPosition;
+in vec2 vertexTexCoord;
+in vec4 vertexColor;
+uniform mat4 mvp;
+out vec2 fragTexCoord;
+out vec4 fragColor;
+void main() {
+	fragTexCoord = vertexTexCoord;
+	fragColor = vertexColor;
+	gl_Position = mvp*vec4(vertexPosition, 1.0);
+}
+`
+
+const paletteFragmentShader = `#version 330
+in vec2 fragTexCoord;
+in vec4 fragColor;
+uniform sampler2D texture0;
+uniform sampler2D palette;
+uniform vec4 colDiffuse;
+out vec4 finalColor;
+void main() {
+	vec4 texel = texture(texture0, fragTexCoord);
+	if (texel.a < 0.5) {
+		finalColor = vec4(0.0);
+		return;
+	}
+	float index = floor(texel.r*255.0 + 0.5);
+	finalColor = texture(palette, vec2((index + 0.5)/256.0, 0.5))*colDiffuse*fragColor;
+	finalColor.rgb *= finalColor.a;
+}
+`
+
+// Start drawing index pages as the colours of a palette texture
+func beginPaletteShader(palette rl.Texture2D) {
+	if !paletteShader.loaded {
+		paletteShader.shader = rl.LoadShaderFromMemory(paletteVertexShader, paletteFragmentShader)
+		paletteShader.palette = rl.GetShaderLocation(paletteShader.shader, "palette")
+		paletteShader.loaded = true
+	}
+	rl.BeginShaderMode(paletteShader.shader)
+	rl.SetShaderValueTexture(paletteShader.shader, paletteShader.palette, palette)
+}
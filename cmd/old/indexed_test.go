@@ -0,0 +1,76 @@
+package main
+
+import (
+	"image"
+	"slices"
+	"testing"
+
+	rl "github.com/gen2brain/raylib-go/raylib"
+)
+
+func testDocPalette(t *testing.T, colors ...rl.Color) *docPalette {
+	t.Helper()
+	var entries []dpfColor
+	for _, c := range colors {
+		entries = append(entries, dpfColor{key: unusedDPFKey(entries), color: c})
+	}
+	p, err := dpfDocPalette(entries)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestPaletteRenumbering(t *testing.T) {
+	clear := rl.Color{}
+	black := rl.Color{0, 0, 0, 255}
+	red := rl.Color{255, 0, 0, 255}
+	darkRed := rl.Color{160, 0, 0, 255}
+	blue := rl.Color{0, 0, 255, 255}
+	white := rl.Color{255, 255, 255, 255}
+
+	for _, tc := range []struct {
+		name     string
+		old, new *docPalette
+		want     []int
+	}{
+		// The transparent entry is added at the end of either palette
+		{"same size", testDocPalette(t, black, red), testDocPalette(t, white, blue), []int{0, 1, 2}},
+		{"smaller", testDocPalette(t, black, red, blue, white), testDocPalette(t, darkRed, black),
+			[]int{0, 1, 1, 0, 2}},
+		{"transparent first", testDocPalette(t, clear, red, blue), testDocPalette(t, black, darkRed),
+			[]int{2, 1, 0}},
+		{"transparent moves into range", testDocPalette(t, black, red, blue), testDocPalette(t, clear, blue),
+			[]int{1, 1, 1, 0}},
+	} {
+		if got := paletteRenumbering(tc.old, tc.new); !slices.Equal(got, tc.want) {
+			t.Errorf("%s: renumbering is %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestRenumberImage(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 4, 1))
+	copy(img.Pix, []uint8{
+		0, 0, 0, 255,
+		3, 0, 0, 255,
+		1, 0, 0, 0, // clear
+		9, 0, 0, 255, // past the renumbering
+	})
+	renumberImage([]int{2, 1, 0, 1}, 0)(img)
+	want := []uint8{
+		2, 0, 0, 255,
+		1, 0, 0, 255,
+		1, 0, 0, 0,
+		9, 0, 0, 255,
+	}
+	if !slices.Equal(img.Pix, want) {
+		t.Errorf("renumbered pixels %v, want %v", img.Pix, want)
+	}
+	// Pixels renumbered to the transparent entry become clear
+	copy(img.Pix, []uint8{1, 0, 0, 255})
+	renumberImage([]int{0, 2, 1}, 2)(img)
+	if got := img.Pix[:4]; !slices.Equal(got, []uint8{0, 0, 0, 0}) {
+		t.Errorf("pixel renumbered to transparent is %v", got)
+	}
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"image"
+	"image/color"
+
+	rl "github.com/gen2brain/raylib-go/raylib"
+)
+
+// Canvas pixels covered by one GPU page (a square block of storage tiles)
+const (
+	pageSize     = 256
+	tilesPerPage = pageSize / tileSize
+	thumbSize    = 32
+)
+
+// A block of layer pixels resident on the GPU
+type gpuPage struct {
+	target rl.RenderTexture2D
+	dirty  bool // painted on the GPU, not yet written back to the tile store
+}
+
+// Number of pages across and down for a canvas size
+func pageGrid(width, height int) (int, int) {
+	return (width + pageSize - 1) / pageSize, (height + pageSize - 1) / pageSize
+}
+
+// Page coordinate range covering a canvas rectangle (end exclusive)
+func pageRange(rect rl.Rectangle, width, height int) (px0, py0, px1, py1 int) {
+	pagesX, pagesY := pageGrid(width, height)
+	px0 = max(int(rect.X)/pageSize, 0)
+	py0 = max(int(rect.Y)/pageSize, 0)
+	px1 = min(int(rect.X+rect.Width)/pageSize+1, pagesX)
+	py1 = min(int(rect.Y+rect.Height)/pageSize+1, pagesY)
+	if rect.X+rect.Width < 0 || rect.Y+rect.Height < 0 {
+		px1, py1 = 0, 0
+	}
+	return
+}
+
+func (l *Layer) pageKey(px, py int) int {
+	pagesX, _ := pageGrid(l.tiles.width, l.tiles.height)
+	return py*pagesX + px
+}
+
+// Check whether a page is resident
+func (l *Layer) hasPage(px, py int) bool {
+	_, ok := l.pages[l.pageKey(px, py)]
+	return ok
+}
+
+// Get a resident page, uploading it from the tile store if needed. Pages
+// without any stored tiles are only allocated when create is set.
+func (l *Layer) page(px, py int, create bool) *gpuPage {
+	key := l.pageKey(px, py)
+	if p, ok := l.pages[key]; ok {
+		return p
+	}
+
+	tx0, ty0 := px*tilesPerPage, py*tilesPerPage
+	hasTiles := l.tiles.AnyIn(tx0, ty0, tx0+tilesPerPage, ty0+tilesPerPage)
+	if !hasTiles && !create {
+		return nil
+	}
+
+	p := &gpuPage{target: rl.LoadRenderTexture(pageSize, pageSize)}
+	if hasTiles {
+		l.uploadPage(px, py, p)
+	} else {
+		rl.BeginTextureMode(p.target)
+		rl.ClearBackground(rl.Color{0, 0, 0, 0})
+		rl.EndTextureMode()
+	}
+	l.pages[key] = p
+	return p
+}
+
+// Copy stored tiles into a page texture
+func (l *Layer) uploadPage(px, py int, p *gpuPage) {
+	rl.UpdateTexture(p.target.Texture, bytesColors(l.tiles.pagePixels(px, py)))
+}
+
+// Write a page texture back into the tile store
+func (l *Layer) downloadPage(px, py int, p *gpuPage) {
+	img := rl.LoadImageFromTexture(p.target.Texture)
+	defer rl.UnloadImage(img)
+	cols := rl.LoadImageColors(img)
+	defer rl.UnloadImageColors(cols)
+
+	l.tiles.setPagePixels(px, py, colorBytes(cols))
+	p.dirty = false
+}
+
+// Lay out the tiles of a page as RGBA bytes in render texture order,
+// bottom row first
+func (s *TileStore) pagePixels(px, py int) []uint8 {
+	stride := pageSize * 4
+	buf := make([]uint8, pageSize*stride)
+	for ty := 0; ty < tilesPerPage; ty++ {
+		for tx := 0; tx < tilesPerPage; tx++ {
+			t := s.Tile(px*tilesPerPage+tx, py*tilesPerPage+ty)
+			if t == nil {
+				continue
+			}
+			dst := (pageSize-(ty+1)*tileSize)*stride + tx*tileSize*4
+			copyRows(buf[dst:], stride, t.pix, tileSize*4, tileSize*4, tileSize, true)
+		}
+	}
+	return buf
+}
+
+// Store a page's RGBA bytes, bottom row first, as tiles
+func (s *TileStore) setPagePixels(px, py int, src []uint8) {
+	stride := pageSize * 4
+	for ty := 0; ty < tilesPerPage; ty++ {
+		for tx := 0; tx < tilesPerPage; tx++ {
+			pix := make([]uint8, tileSize*tileSize*4)
+			offset := (pageSize-(ty+1)*tileSize)*stride + tx*tileSize*4
+			copyRows(pix, tileSize*4, src[offset:], stride, tileSize*4, tileSize, true)
+			s.SetTile(px*tilesPerPage+tx, py*tilesPerPage+ty, pix)
+		}
+	}
+}
+
+// Paint into the layer. area is the canvas region the drawing can touch;
+// draw is called once per affected page with a camera that maps canvas
+// coordinates onto that page.
+func (l *Layer) Paint(area rl.Rectangle, draw func()) {
+	px0, py0, px1, py1 := pageRange(area, l.tiles.width, l.tiles.height)
+	for py := py0; py < py1; py++ {
+		for px := px0; px < px1; px++ {
+			p := l.page(px, py, true)
+			rl.BeginTextureMode(p.target)
+			rl.BeginMode2D(rl.Camera2D{
+				Offset: rl.Vector2{X: -float32(px * pageSize), Y: -float32(py * pageSize)},
+				Zoom:   1,
+			})
+			draw()
+			rl.EndMode2D()
+			rl.EndTextureMode()
+			p.dirty = true
+		}
+	}
+}
+
+// Write all GPU changes back to the tile store
+func (l *Layer) Sync() {
+	pagesX, _ := pageGrid(l.tiles.width, l.tiles.height)
+	for key, p := range l.pages {
+		if p.dirty {
+			l.downloadPage(key%pagesX, key/pagesX, p)
+			l.thumbDirty = true
+		}
+	}
+}
+
+// Free pages outside keep (canvas coordinates), writing back changes first
+func (l *Layer) ReleasePages(keep rl.Rectangle) {
+	pagesX, _ := pageGrid(l.tiles.width, l.tiles.height)
+	px0, py0, px1, py1 := pageRange(keep, l.tiles.width, l.tiles.height)
+	for key := range l.pages {
+		px, py := key%pagesX, key/pagesX
+		if px >= px0 && px < px1 && py >= py0 && py < py1 {
+			continue
+		}
+		l.releasePage(px, py)
+	}
+}
+
+// Free one page, writing back changes first
+func (l *Layer) releasePage(px, py int) {
+	key := l.pageKey(px, py)
+	p, ok := l.pages[key]
+	if !ok {
+		return
+	}
+	if p.dirty {
+		l.downloadPage(px, py, p)
+		l.thumbDirty = true
+	}
+	rl.UnloadRenderTexture(p.target)
+	delete(l.pages, key)
+}
+
+// Replace the layer pixels; resident pages are discarded
+func (l *Layer) SetStore(store *TileStore) {
+	for key, p := range l.pages {
+		rl.UnloadRenderTexture(p.target)
+		delete(l.pages, key)
+	}
+	l.tiles = store
+	l.thumbDirty = true
+}
+
+// Snapshot of the layer pixels; cheap, since tiles are shared
+func (l *Layer) Snapshot() *TileStore {
+	l.Sync()
+	return l.tiles.Clone()
+}
+
+// Small preview texture of the whole layer, with pixels indexing palette
+// unless it is nil
+func (l *Layer) Thumbnail(palette []color.RGBA) rl.Texture2D {
+	if l.thumb.ID != 0 && !l.thumbDirty {
+		return l.thumb
+	}
+	if l.thumb.ID != 0 {
+		rl.UnloadTexture(l.thumb)
+	}
+
+	img := image.NewNRGBA(image.Rect(0, 0, thumbSize, thumbSize))
+	for y := 0; y < thumbSize; y++ {
+		for x := 0; x < thumbSize; x++ {
+			c := l.tiles.At(x*l.tiles.width/thumbSize, y*l.tiles.height/thumbSize)
+			if palette != nil {
+				c = indexColor(palette, c)
+			}
+			copy(img.Pix[img.PixOffset(x, y):], []uint8{c.R, c.G, c.B, c.A})
+		}
+	}
+	l.thumb = loadTextureNRGBA(img)
+	l.thumbDirty = false
+	return l.thumb
+}
+
+// Free all GPU resources of the layer
+func (l *Layer) Unload() {
+	for key, p := range l.pages {
+		rl.UnloadRenderTexture(p.target)
+		delete(l.pages, key)
+	}
+	if l.thumb.ID != 0 {
+		rl.UnloadTexture(l.thumb)
+		l.thumb = rl.Texture2D{}
+	}
+}
@@ -0,0 +1,388 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	rl "github.com/gen2brain/raylib-go/raylib"
+)
+
+// Layer properties panel geometry (inside the right panel, above the layer buttons)
+const (
+	layerPropsY      = screenHeight - 250
+	layerPropsHeight = 200
+)
+
+// Layer list geometry: rows from the top of the right panel down to the
+// properties panel, scrolling when there are more layers than fit
+const (
+	layerListY     = 100
+	layerRowHeight = 60
+	layerRows      = (layerPropsY-layerListY-50)/layerRowHeight + 1
+)
+
+// Blend modes used when compositing a layer
+type LayerBlendMode int
+
+const (
+	BlendNormal LayerBlendMode = iota
+	BlendMultiply
+	BlendAdd
+	BlendSubtract
+	BlendScreen
+)
+
+var blendModeNames = []string{"NORMAL", "MULTIPLY", "ADD", "SUBTRACT", "SCREEN"}
+
+func (m LayerBlendMode) String() string {
+	if int(m) >= 0 && int(m) < len(blendModeNames) {
+		return blendModeNames[m]
+	}
+	return "UNKNOWN"
+}
+
+// Parse a blend mode name as stored in project.json
+func ParseBlendMode(name string) (LayerBlendMode, error) {
+	if name == "" {
+		return BlendNormal, nil
+	}
+	for i, n := range blendModeNames {
+		if strings.EqualFold(n, name) {
+			return LayerBlendMode(i), nil
+		}
+	}
+	return BlendNormal, fmt.Errorf("unknown blend mode %q", name)
+}
+
+// GL blend state of a blend mode, as rl.SetBlendFactorsSeparate takes it
+type blendState struct {
+	srcRGB, dstRGB, srcAlpha, dstAlpha, eqRGB, eqAlpha int32
+}
+
+// Blend state of this mode for layer pages drawn premultiplied by alpha,
+// as the compositing shaders draw them. Every mode keeps the alpha of
+// normal blending, so transparent pixels leave what is under them alone.
+func (m LayerBlendMode) blendState() blendState {
+	s := blendState{rl.One, rl.OneMinusSrcAlpha, rl.SrcAlpha, rl.OneMinusSrcAlpha, rl.FuncAdd, rl.FuncAdd}
+	switch m {
+	case BlendMultiply:
+		// src*dst + dst*(1-srcAlpha)
+		s.srcRGB = rl.DstColor
+	case BlendAdd:
+		// src + dst
+		s.dstRGB = rl.One
+	case BlendSubtract:
+		// dst - src
+		s.dstRGB, s.eqRGB = rl.One, rl.FuncReverseSubtract
+	case BlendScreen:
+		// src + dst*(1-src)
+		s.dstRGB = rl.OneMinusSrcColor
+	}
+	return s
+}
+
+// Begin drawing with this blend mode; pair with rl.EndBlendMode
+func (m LayerBlendMode) Begin() {
+	s := m.blendState()
+	rl.SetBlendFactorsSeparate(s.srcRGB, s.dstRGB, s.srcAlpha, s.dstAlpha, s.eqRGB, s.eqAlpha)
+	rl.BeginBlendMode(rl.BlendCustomSeparate)
+}
+
+// Colour tags shown next to layer names (index 0 means no tag)
+var layerTagColors = []rl.Color{
+	{0, 0, 0, 0},
+	{229, 57, 53, 255},
+	{251, 140, 0, 255},
+	{251, 192, 45, 255},
+	{67, 160, 71, 255},
+	{30, 136, 229, 255},
+	{142, 36, 170, 255},
+	{158, 158, 158, 255},
+}
+
+// Begin the paint blend for a layer. With alpha lock the layer's alpha
+// channel is preserved, so paint only shows where pixels are already opaque;
+// colour still blends by the paint's own alpha, as it does unlocked.
+// Indices in an indexed document can't be mixed, so paint replaces them.
+func beginLayerPaint(layer *Layer, indexed bool) {
+	switch {
+	case layer.lockAlpha:
+		// rgb = src*srcAlpha + dst*(1-srcAlpha), alpha = dstAlpha
+		rl.SetBlendFactorsSeparate(rl.SrcAlpha, rl.OneMinusSrcAlpha, rl.Zero, rl.One, rl.FuncAdd, rl.FuncAdd)
+		rl.BeginBlendMode(rl.BlendCustomSeparate)
+	case indexed:
+		// Indices replace what is there, and the transparent one clears
+		rl.SetBlendFactors(rl.One, rl.Zero, rl.FuncAdd)
+		rl.BeginBlendMode(rl.BlendCustom)
+	default:
+		rl.BeginBlendMode(rl.BlendAlpha)
+	}
+}
+
+// Layer properties as stored in project.json (without pixels)
+func (l *Layer) Data() LayerData {
+	return LayerData{
+		ID:           l.uid,
+		Name:         l.name,
+		Visible:      l.visible,
+		Locked:       l.locked,
+		LockAlpha:    l.lockAlpha,
+		LockPosition: l.lockPosition,
+		Opacity:      l.opacity,
+		BlendMode:    l.blendMode.String(),
+		ColorTag:     l.colorTag,
+	}
+}
+
+// Set layer properties from project.json; unknown values fall back to defaults
+func (l *Layer) SetData(d LayerData) {
+	if d.ID != "" {
+		l.uid = d.ID
+	}
+	l.name = d.Name
+	l.visible = d.Visible
+	l.locked = d.Locked
+	l.lockAlpha = d.LockAlpha
+	l.lockPosition = d.LockPosition
+	l.opacity = d.Opacity
+	l.blendMode, _ = ParseBlendMode(d.BlendMode)
+	l.colorTag = 0
+	if d.ColorTag > 0 && d.ColorTag < len(layerTagColors) {
+		l.colorTag = d.ColorTag
+	}
+}
+
+// Top of layer i's row in the list, which is scrolled out of view when
+// outside layerListY to layerPropsY
+func (app *App) layerRowY(i int) float32 {
+	return layerListY + float32(len(app.layers)-1-i-app.layerScroll)*layerRowHeight
+}
+
+// Check whether layer i's row is in view
+func (app *App) layerRowShown(i int) bool {
+	row := len(app.layers) - 1 - i - app.layerScroll
+	return row >= 0 && row < layerRows
+}
+
+// Scroll the layer list so layer i shows
+func (app *App) showLayer(i int) {
+	row := len(app.layers) - 1 - i
+	if row < app.layerScroll {
+		app.layerScroll = row
+	}
+	if row >= app.layerScroll+layerRows {
+		app.layerScroll = row - layerRows + 1
+	}
+}
+
+// Scroll the layer list with the mouse wheel, also while dragging a layer
+func (app *App) updateLayerScroll(mousePos rl.Vector2) {
+	list := rl.Rectangle{X: screenWidth - rightPanel, Y: layerListY, Width: rightPanel, Height: layerPropsY - layerListY}
+	if wheel := rl.GetMouseWheelMove(); wheel != 0 && rl.CheckCollisionPointRec(mousePos, list) {
+		app.layerScroll -= int(wheel)
+	}
+	app.layerScroll = max(0, min(app.layerScroll, len(app.layers)-layerRows))
+}
+
+// Draw the layer list's scroll bar when not all layers fit
+func (app *App) drawLayerScrollBar() {
+	if len(app.layers) <= layerRows {
+		return
+	}
+	track := rl.Rectangle{X: screenWidth - 7, Y: layerListY, Width: 4, Height: layerRows*layerRowHeight - 10}
+	thumbH := maxf(16, track.Height*layerRows/float32(len(app.layers)))
+	thumbY := track.Y + float32(app.layerScroll)/float32(len(app.layers)-layerRows)*(track.Height-thumbH)
+	rl.DrawRectangleRec(track, rl.Color{60, 60, 60, 255})
+	rl.DrawRectangle(int32(track.X), int32(thumbY), int32(track.Width), int32(thumbH), rl.LightGray)
+}
+
+// Random layer ID for new layers
+func newLayerID() string {
+	var b [6]byte
+	rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+// Initialize layer properties widgets
+func (app *App) initLayerProps() {
+	x := float32(screenWidth - rightPanel + 10)
+
+	app.layerNameRect = rl.Rectangle{X: x, Y: layerPropsY + 28, Width: rightPanel - 20, Height: 20}
+	app.layerOpacitySlider = Slider{
+		rect:  rl.Rectangle{X: x, Y: layerPropsY + 70, Width: rightPanel - 60, Height: 16},
+		value: 1,
+		min:   0,
+		max:   1,
+		label: "OPACITY",
+	}
+	app.layerBlendButton = Button{
+		rect: rl.Rectangle{X: x, Y: layerPropsY + 94, Width: rightPanel - 20, Height: 20},
+	}
+	app.layerLockBoxes = []CheckBox{
+		{rect: rl.Rectangle{X: x, Y: layerPropsY + 160, Width: 12, Height: 12}, label: "PIXELS"},
+		{rect: rl.Rectangle{X: x + 62, Y: layerPropsY + 160, Width: 12, Height: 12}, label: "ALPHA"},
+		{rect: rl.Rectangle{X: x + 118, Y: layerPropsY + 160, Width: 12, Height: 12}, label: "POS"},
+	}
+}
+
+// Rectangle of colour tag swatch i
+func layerTagRect(i int) rl.Rectangle {
+	return rl.Rectangle{X: float32(screenWidth-rightPanel+10) + float32(i)*22, Y: layerPropsY + 132, Width: 18, Height: 12}
+}
+
+// Start inline rename of the active layer
+func (app *App) BeginRenameLayer() {
+	app.renamingLayer = true
+	app.renameBuffer = app.layers[app.activeLayer].name
+}
+
+// Finish inline rename, keeping the old name if the new one is blank
+func (app *App) CommitRenameLayer() {
+	name := strings.TrimSpace(app.renameBuffer)
+	if name != "" {
+		app.layers[app.activeLayer].name = name
+	}
+	app.renamingLayer = false
+	app.renameBuffer = ""
+}
+
+// Handle text input while renaming a layer
+func (app *App) updateRename(mousePos rl.Vector2) {
+	for ch := rl.GetCharPressed(); ch > 0; ch = rl.GetCharPressed() {
+		if ch >= 32 && ch < 127 && len(app.renameBuffer) < 24 {
+			app.renameBuffer += string(rune(ch))
+		}
+	}
+	if (rl.IsKeyPressed(rl.KeyBackspace) || rl.IsKeyPressedRepeat(rl.KeyBackspace)) && len(app.renameBuffer) > 0 {
+		app.renameBuffer = app.renameBuffer[:len(app.renameBuffer)-1]
+	}
+	if rl.IsKeyPressed(rl.KeyEnter) || rl.IsKeyPressed(rl.KeyKpEnter) {
+		app.CommitRenameLayer()
+	}
+	if rl.IsMouseButtonPressed(rl.MouseLeftButton) && !rl.CheckCollisionPointRec(mousePos, app.layerNameRect) {
+		app.CommitRenameLayer()
+	}
+}
+
+// Handle layer properties panel input
+func (app *App) updateLayerProps(mousePos rl.Vector2) {
+	panel := rl.Rectangle{X: screenWidth - rightPanel, Y: layerPropsY, Width: rightPanel, Height: layerPropsHeight}
+	if !rl.CheckCollisionPointRec(mousePos, panel) {
+		return
+	}
+
+	layer := app.layers[app.activeLayer]
+	pressed := rl.IsMouseButtonPressed(rl.MouseLeftButton)
+
+	// Name field
+	if pressed && rl.CheckCollisionPointRec(mousePos, app.layerNameRect) {
+		app.BeginRenameLayer()
+	}
+
+	// Opacity slider
+	if rl.CheckCollisionPointRec(mousePos, app.layerOpacitySlider.rect) && rl.IsMouseButtonDown(rl.MouseLeftButton) {
+		relX := mousePos.X - app.layerOpacitySlider.rect.X
+		layer.opacity = clamp(relX/app.layerOpacitySlider.rect.Width, 0, 1)
+	}
+
+	// Blend mode (click cycles, right click cycles backwards)
+	if rl.CheckCollisionPointRec(mousePos, app.layerBlendButton.rect) {
+		if pressed {
+			layer.blendMode = (layer.blendMode + 1) % LayerBlendMode(len(blendModeNames))
+		} else if rl.IsMouseButtonPressed(rl.MouseRightButton) {
+			layer.blendMode = (layer.blendMode + LayerBlendMode(len(blendModeNames)) - 1) % LayerBlendMode(len(blendModeNames))
+		}
+	}
+
+	// Colour tag
+	for i := range layerTagColors {
+		if pressed && rl.CheckCollisionPointRec(mousePos, layerTagRect(i)) {
+			layer.colorTag = i
+		}
+	}
+
+	// Locks
+	for i, box := range app.layerLockBoxes {
+		hit := rl.Rectangle{X: box.rect.X, Y: box.rect.Y, Width: 58, Height: box.rect.Height}
+		if pressed && rl.CheckCollisionPointRec(mousePos, hit) {
+			switch i {
+			case 0:
+				layer.locked = !layer.locked
+			case 1:
+				layer.lockAlpha = !layer.lockAlpha
+			case 2:
+				layer.lockPosition = !layer.lockPosition
+			}
+		}
+	}
+}
+
+// Draw layer properties panel for the active layer
+func (app *App) drawLayerProps() {
+	layer := app.layers[app.activeLayer]
+	x := int32(screenWidth - rightPanel + 10)
+
+	rl.DrawRectangle(screenWidth-rightPanel, layerPropsY, rightPanel, layerPropsHeight, rl.Color{45, 45, 45, 255})
+	rl.DrawLine(screenWidth-rightPanel, layerPropsY, screenWidth, layerPropsY, rl.Color{90, 90, 90, 255})
+	rl.DrawText("PROPERTIES", x, layerPropsY+8, fontSize, rl.White)
+
+	// Name field
+	name := layer.name
+	fieldColor := rl.Color{60, 60, 60, 255}
+	if app.renamingLayer {
+		name = app.renameBuffer
+		fieldColor = rl.Color{30, 30, 30, 255}
+		if int(rl.GetTime()*2)%2 == 0 {
+			name += "_"
+		}
+	}
+	rl.DrawRectangleRec(app.layerNameRect, fieldColor)
+	rl.DrawRectangleLinesEx(app.layerNameRect, 1, rl.Color{90, 90, 90, 255})
+	rl.DrawText(name, int32(app.layerNameRect.X)+4, int32(app.layerNameRect.Y)+6, fontSize, rl.White)
+
+	// Opacity slider
+	s := app.layerOpacitySlider
+	rl.DrawText(s.label, int32(s.rect.X), int32(s.rect.Y-12), fontSize, rl.LightGray)
+	rl.DrawRectangleRec(s.rect, rl.Color{60, 60, 60, 255})
+	rl.DrawRectangle(int32(s.rect.X), int32(s.rect.Y), int32(s.rect.Width*layer.opacity), int32(s.rect.Height), rl.Color{100, 100, 150, 255})
+	sliderPos := s.rect.X + layer.opacity*s.rect.Width
+	rl.DrawRectangle(int32(sliderPos-2), int32(s.rect.Y), 4, int32(s.rect.Height), rl.White)
+	rl.DrawText(fmt.Sprintf("%.0f%%", layer.opacity*100), int32(s.rect.X+s.rect.Width+6), int32(s.rect.Y+4), fontSize, rl.White)
+
+	// Blend mode
+	b := app.layerBlendButton
+	rl.DrawRectangleRec(b.rect, rl.Color{70, 70, 70, 255})
+	rl.DrawRectangleLinesEx(b.rect, 1, rl.Color{90, 90, 90, 255})
+	rl.DrawText("BLEND: "+layer.blendMode.String(), int32(b.rect.X)+4, int32(b.rect.Y)+6, fontSize, rl.White)
+
+	// Colour tags
+	rl.DrawText("TAG", x, layerPropsY+120, fontSize, rl.LightGray)
+	for i, c := range layerTagColors {
+		rect := layerTagRect(i)
+		if i == 0 {
+			rl.DrawRectangleRec(rect, rl.Color{60, 60, 60, 255})
+			rl.DrawLine(int32(rect.X), int32(rect.Y+rect.Height), int32(rect.X+rect.Width), int32(rect.Y), rl.Color{200, 80, 80, 255})
+		} else {
+			rl.DrawRectangleRec(rect, c)
+		}
+		if layer.colorTag == i {
+			rl.DrawRectangleLinesEx(rect, 2, rl.White)
+		} else {
+			rl.DrawRectangleLinesEx(rect, 1, rl.Color{70, 70, 70, 255})
+		}
+	}
+
+	// Locks
+	rl.DrawText("LOCK", x, layerPropsY+148, fontSize, rl.LightGray)
+	checked := []bool{layer.locked, layer.lockAlpha, layer.lockPosition}
+	for i, box := range app.layerLockBoxes {
+		rl.DrawRectangleRec(box.rect, rl.Color{40, 40, 40, 255})
+		rl.DrawRectangleLinesEx(box.rect, 1, rl.White)
+		if checked[i] {
+			rl.DrawRectangle(int32(box.rect.X)+3, int32(box.rect.Y)+3, int32(box.rect.Width)-6, int32(box.rect.Height)-6, rl.Yellow)
+		}
+		rl.DrawText(box.label, int32(box.rect.X+box.rect.Width)+4, int32(box.rect.Y)+2, fontSize, rl.White)
+	}
+}
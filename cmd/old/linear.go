@@ -0,0 +1,248 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	rl "github.com/gen2brain/raylib-go/raylib"
+)
+
+// How a document's layers are blended. Layer pixels are always stored as
+// 8-bit sRGB; in linear light they are decoded before blending and the
+// result is encoded again, so soft edges and partial opacity mix as light
+// does instead of darkening.
+type Compositing int
+
+// Linear light always blends in more than 8 bits per channel; 8 bits
+// would band the shadows.
+const (
+	CompositeSRGB     Compositing = iota // gamma-encoded sRGB in 8 bits, as drawn
+	CompositeLinear                      // linear light in half floats
+	CompositeLinear32                    // linear light in floats
+)
+
+// Names of the compositing modes, as project.json stores them
+var compositingNames = []string{"srgb", "linear", "linear32"}
+
+// Names shown in the status bar
+var compositingLabels = []string{"SRGB", "LINEAR", "LINEAR FLOAT"}
+
+func (m Compositing) String() string {
+	if int(m) >= 0 && int(m) < len(compositingNames) {
+		return compositingNames[m]
+	}
+	return "unknown"
+}
+
+// Parse a compositing mode; empty is sRGB
+func ParseCompositing(name string) (Compositing, error) {
+	if name == "" {
+		return CompositeSRGB, nil
+	}
+	for i, n := range compositingNames {
+		if strings.EqualFold(n, name) {
+			return Compositing(i), nil
+		}
+	}
+	return CompositeSRGB, fmt.Errorf("unknown compositing %q", name)
+}
+
+// Form saved in project.json; empty for sRGB, which older versions assume
+func (m Compositing) data() string {
+	if m == CompositeSRGB {
+		return ""
+	}
+	return m.String()
+}
+
+// Whether layers blend in linear light
+func (m Compositing) Linear() bool {
+	return m != CompositeSRGB
+}
+
+// Bits per channel of the working buffers
+func (m Compositing) depth() int {
+	switch m {
+	case CompositeLinear:
+		return 16
+	case CompositeLinear32:
+		return 32
+	}
+	return 8
+}
+
+// Linear light of each 8-bit sRGB value
+var linearLight = func() (t [256]float32) {
+	for i := range t {
+		t[i] = float32(decodeSRGB(float64(i) / 255))
+	}
+	return t
+}()
+
+// sRGB transfer function, 0-1 values
+func decodeSRGB(v float64) float64 {
+	if v <= 0.04045 {
+		return v / 12.92
+	}
+	return math.Pow((v+0.055)/1.055, 2.4)
+}
+
+func encodeSRGB(v float64) float64 {
+	if v <= 0.0031308 {
+		return v * 12.92
+	}
+	return 1.055*math.Pow(v, 1/2.4) - 0.055
+}
+
+// Round to the nearest half float, as a 16-bit working buffer stores it.
+// Values are assumed to be in the half float normal range.
+func roundHalf(v float32) float32 {
+	b := math.Float32bits(v)
+	b = (b + 0x0fff + (b>>13)&1) &^ 0x1fff
+	return math.Float32frombits(b)
+}
+
+// Switch the active document to the next compositing mode
+func (app *App) CycleCompositing() {
+	app.compositing = (app.compositing + 1) % Compositing(len(compositingNames))
+	app.compositor.SetCompositing(app.compositing)
+	app.unloadOnion()
+	app.SetStatus("COMPOSITING: " + compositingLabels[app.compositing])
+}
+
+// GLSL sRGB transfer functions, shared by the shaders working in linear light
+const srgbShaderFunctions = `
+vec3 decode(vec3 c) {
+	return mix(c/12.92, pow((c + 0.055)/1.055, vec3(2.4)), step(0.04045, c));
+}
+vec3 encode(vec3 c) {
+	c = clamp(c, 0.0, 1.0);
+	return mix(c*12.92, 1.055*pow(c, vec3(1.0/2.4)) - 0.055, step(0.0031308, c));
+}
+`
+
+// Shader drawing layer pages into a working page as linear light
+// premultiplied by alpha, looking indices up in the palette first for
+// indexed documents; loaded on first use
+var linearShader struct {
+	shader  rl.Shader
+	palette int32 // location of the palette texture
+	indexed int32 // location of the indexed flag
+	loaded  bool
+}
+
+const linearFragmentShader = `#version 330
+in vec2 fragTexCoord;
+in vec4 fragColor;
+uniform sampler2D texture0;
+uniform sampler2D palette;
+uniform float indexed;
+uniform vec4 colDiffuse;
+out vec4 finalColor;
+` + srgbShaderFunctions + `
+void main() {
+	vec4 texel = texture(texture0, fragTexCoord);
+	if (indexed > 0.5) {
+		if (texel.a < 0.5) {
+			finalColor = vec4(0.0);
+			return;
+		}
+		float index = floor(texel.r*255.0 + 0.5);
+		texel = texture(palette, vec2((index + 0.5)/256.0, 0.5));
+	}
+	finalColor = vec4(decode(texel.rgb), texel.a)*colDiffuse*fragColor;
+	finalColor.rgb *= finalColor.a;
+}
+`
+
+// Start drawing layer pages as linear light, with pixels indexing palette
+// when it is loaded
+func beginLinearShader(palette rl.Texture2D) {
+	if !linearShader.loaded {
+		linearShader.shader = rl.LoadShaderFromMemory(paletteVertexShader, linearFragmentShader)
+		linearShader.palette = rl.GetShaderLocation(linearShader.shader, "palette")
+		linearShader.indexed = rl.GetShaderLocation(linearShader.shader, "indexed")
+		linearShader.loaded = true
+	}
+	rl.BeginShaderMode(linearShader.shader)
+	indexed := float32(0)
+	if palette.ID != 0 {
+		indexed = 1
+		rl.SetShaderValueTexture(linearShader.shader, linearShader.palette, palette)
+	}
+	rl.SetShaderValue(linearShader.shader, linearShader.indexed, []float32{indexed}, rl.ShaderUniformFloat)
+}
+
+// Shader encoding a working page back to sRGB; loaded on first use
+var encodeShader struct {
+	shader rl.Shader
+	loaded bool
+}
+
+const encodeFragmentShader = `#version 330
+in vec2 fragTexCoord;
+in vec4 fragColor;
+uniform sampler2D texture0;
+out vec4 finalColor;
+` + srgbShaderFunctions + `
+void main() {
+	vec4 texel = texture(texture0, fragTexCoord);
+	finalColor = vec4(encode(texel.rgb), clamp(texel.a, 0.0, 1.0));
+}
+`
+
+// Encode a working page of linear light into an sRGB page
+func encodeWorkPage(work, target rl.RenderTexture2D) {
+	if !encodeShader.loaded {
+		encodeShader.shader = rl.LoadShaderFromMemory(paletteVertexShader, encodeFragmentShader)
+		encodeShader.loaded = true
+	}
+	rl.BeginTextureMode(target)
+	rl.ClearBackground(rl.Color{0, 0, 0, 0})
+	rl.BeginShaderMode(encodeShader.shader)
+	// Straight copy, no blending
+	rl.SetBlendFactors(rl.One, rl.Zero, rl.FuncAdd)
+	rl.BeginBlendMode(rl.BlendCustom)
+	rl.DrawTextureRec(
+		work.Texture,
+		rl.Rectangle{X: 0, Y: 0, Width: pageSize, Height: -pageSize},
+		rl.Vector2{X: 0, Y: 0},
+		rl.White,
+	)
+	rl.EndBlendMode()
+	rl.EndShaderMode()
+	rl.EndTextureMode()
+}
+
+// PIXELFORMAT_UNCOMPRESSED_R16G16B16A16, which raylib-go does not name
+const pixelFormatR16G16B16A16 rl.PixelFormat = 13
+
+// Load a page-sized working target with channels of the given depth: 8
+// bits, half floats or floats. Targets the GPU cannot render to fall back
+// to 8 bits.
+func loadWorkPage(depth int) rl.RenderTexture2D {
+	if depth == 8 {
+		return rl.LoadRenderTexture(pageSize, pageSize)
+	}
+	format, size := rl.UncompressedR32g32b32a32, 16
+	if depth == 16 {
+		format, size = pixelFormatR16G16B16A16, 8
+	}
+	img := rl.NewImage(make([]byte, pageSize*pageSize*size), pageSize, pageSize, 1, format)
+	tex := rl.LoadTextureFromImage(img)
+	fbo := rl.LoadFramebuffer()
+	if tex.ID != 0 && fbo != 0 {
+		rl.FramebufferAttach(fbo, tex.ID, rl.AttachmentColorChannel0, rl.AttachmentTexture2d, 0)
+		if rl.FramebufferComplete(fbo) {
+			return rl.RenderTexture2D{ID: fbo, Texture: tex}
+		}
+	}
+	if tex.ID != 0 {
+		rl.UnloadTexture(tex)
+	}
+	if fbo != 0 {
+		rl.UnloadFramebuffer(fbo)
+	}
+	return rl.LoadRenderTexture(pageSize, pageSize)
+}
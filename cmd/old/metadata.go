@@ -0,0 +1,230 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"image"
+	"io"
+	"os"
+	"os/user"
+	"strings"
+	"time"
+
+	rl "github.com/gen2brain/raylib-go/raylib"
+)
+
+// Version recorded in saved projects; release builds set it with
+// -ldflags "-X main.appVersion=1.2.3"
+var appVersion = "dev"
+
+const (
+	metadataEntry  = "metadata.json"
+	thumbnailEntry = "thumbnail.png"
+	thumbnailSize  = 128 // longest side of saved thumbnails
+
+	// Time without mouse input after which editing time stops counting
+	editIdleTimeout = 60.0
+)
+
+// Descriptive data saved next to project.json. It lives in its own entry
+// so that tools can read it without decoding layers, and so that saving
+// an unchanged project directory only touches this file.
+type ProjectMetadata struct {
+	Author      string    `json:"author,omitempty"`
+	Description string    `json:"description,omitempty"`
+	Tags        []string  `json:"tags,omitempty"`
+	Created     time.Time `json:"created"`
+	Modified    time.Time `json:"modified"`
+	AppVersion  string    `json:"app_version"`
+	EditTime    float64   `json:"edit_time"` // seconds spent editing
+}
+
+// Metadata for a new document
+func newProjectMetadata() ProjectMetadata {
+	author := os.Getenv("DELUXEDRAW_AUTHOR")
+	if author == "" {
+		if u, err := user.Current(); err == nil {
+			author = u.Name
+			if author == "" {
+				author = u.Username
+			}
+		}
+	}
+	return ProjectMetadata{Author: author, Created: time.Now().UTC().Truncate(time.Second)}
+}
+
+// Metadata as written by a save now
+func (m ProjectMetadata) forSave() ProjectMetadata {
+	now := time.Now().UTC().Truncate(time.Second)
+	if m.Created.IsZero() {
+		// Files from before metadata start counting at their first save
+		m.Created = now
+	}
+	m.Modified = now
+	m.AppVersion = appVersion
+	m.EditTime = float64(int64(m.EditTime))
+	m.Tags = append([]string(nil), m.Tags...)
+	return m
+}
+
+// Count time spent editing; call once per frame
+func (app *App) trackEditTime() {
+	now := rl.GetTime()
+	delta := rl.GetMouseDelta()
+	if delta.X != 0 || delta.Y != 0 || rl.GetMouseWheelMove() != 0 || app.isDrawing ||
+		rl.IsMouseButtonDown(rl.MouseLeftButton) || rl.IsMouseButtonDown(rl.MouseRightButton) {
+		app.lastInput = now
+	}
+	if now-app.lastInput < editIdleTimeout && rl.IsWindowFocused() {
+		app.metadata.EditTime += float64(rl.GetFrameTime())
+	}
+}
+
+// Decode metadata.json; problems are warnings, since metadata is optional
+func decodeMetadata(r io.Reader, report *ValidationReport) ProjectMetadata {
+	var meta ProjectMetadata
+	if err := json.NewDecoder(r).Decode(&meta); err != nil {
+		report.warnf(metadataEntry, "%v", err)
+		return ProjectMetadata{}
+	}
+	return meta
+}
+
+// Summary of a project read without decoding its layers
+type ProjectInfo struct {
+	File          string `json:"file"`
+	FormatVersion int    `json:"format_version"`
+	CanvasWidth   int    `json:"canvas_width"`
+	CanvasHeight  int    `json:"canvas_height"`
+	Layers        int    `json:"layers"`
+	Frames        int    `json:"frames"`
+	ColorMode     string `json:"color_mode"`  // "rgb" or "indexed"
+	Colors        int    `json:"colors"`      // palette entries
+	Compositing   string `json:"compositing"` // "srgb", "linear" or "linear32"
+	ProjectMetadata
+	ThumbnailWidth  int `json:"thumbnail_width,omitempty"`
+	ThumbnailHeight int `json:"thumbnail_height,omitempty"`
+}
+
+// Read project.json, metadata.json and the thumbnail size of a project
+func readProjectInfo(filename string) (*ProjectInfo, error) {
+	pending := pendingFiles(filename)
+	open := func(name string) (io.ReadCloser, error) {
+		return os.Open(projectDirFile(filename, pending, name))
+	}
+	if !isProjectDir(filename) {
+		reader, err := zip.OpenReader(filename)
+		if err != nil {
+			return nil, err
+		}
+		defer reader.Close()
+		open = func(name string) (io.ReadCloser, error) {
+			return reader.Open(name)
+		}
+	}
+
+	info := &ProjectInfo{File: filename}
+
+	rc, err := open("project.json")
+	if err != nil {
+		return nil, err
+	}
+	var project ProjectData
+	err = json.NewDecoder(rc).Decode(&project)
+	rc.Close()
+	if err != nil {
+		return nil, fmt.Errorf("project.json: %v", err)
+	}
+	info.FormatVersion = projectVersion(&project)
+	info.CanvasWidth = project.CanvasWidth
+	info.CanvasHeight = project.CanvasHeight
+	info.Layers = len(project.Layers)
+	info.Frames = max(1, len(project.Frames))
+	info.ColorMode = "rgb"
+	if project.ColorMode == colorModeIndexed {
+		info.ColorMode = colorModeIndexed
+	}
+	info.Colors = len(project.Palette)
+	info.Compositing = projectCompositing(project).String()
+
+	if rc, err := open(metadataEntry); err == nil {
+		err = json.NewDecoder(rc).Decode(&info.ProjectMetadata)
+		rc.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", metadataEntry, err)
+		}
+	}
+
+	if rc, err := open(thumbnailEntry); err == nil {
+		config, _, err := image.DecodeConfig(rc)
+		rc.Close()
+		if err == nil {
+			info.ThumbnailWidth = config.Width
+			info.ThumbnailHeight = config.Height
+		}
+	}
+
+	return info, nil
+}
+
+// Tags from a comma-separated list, blanks dropped
+func splitTags(list string) []string {
+	var tags []string
+	for _, tag := range strings.Split(list, ",") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+// Replace the metadata of a saved project, leaving everything else as is.
+// A directory takes it as a save would; an archive is rewritten with its
+// other entries copied without recompressing them.
+func writeProjectMetadata(filename string, meta ProjectMetadata) error {
+	data, err := json.MarshalIndent(meta, "", "  ")
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+
+	if isProjectDir(filename) {
+		if err := finishProjectDir(filename); err != nil {
+			return err
+		}
+		stage := &dirStage{dir: filename}
+		if err := stage.write(metadataEntry, data); err != nil {
+			return err
+		}
+		return stage.commit()
+	}
+
+	return writeFileAtomic(filename, func(w io.Writer) error {
+		// Opened here so it is closed before the rename, which some
+		// systems cannot do over an open file
+		reader, err := zip.OpenReader(filename)
+		if err != nil {
+			return err
+		}
+		defer reader.Close()
+
+		zipWriter := zip.NewWriter(w)
+		for _, file := range reader.File {
+			if file.Name == metadataEntry {
+				continue
+			}
+			if err := zipWriter.Copy(file); err != nil {
+				return err
+			}
+		}
+		metaFile, err := zipWriter.Create(metadataEntry)
+		if err != nil {
+			return err
+		}
+		if _, err := metaFile.Write(data); err != nil {
+			return err
+		}
+		return zipWriter.Close()
+	})
+}
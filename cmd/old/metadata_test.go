@@ -0,0 +1,66 @@
+package main
+
+import (
+	"image/color"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestWriteProjectMetadata(t *testing.T) {
+	created := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	for _, ext := range []string{".ddd", projectDirExt} {
+		dir := t.TempDir()
+		filename := filepath.Join(dir, "art"+ext)
+		snap := testDirSnapshot(4, 4, color.NRGBA{255, 0, 0, 255})
+		snap.project.TileSize = tileSize
+		snap.metadata = ProjectMetadata{Author: "First", Created: created, AppVersion: "1.0", EditTime: 90}
+		if err := writeProjectFile(filename, snap, nil); err != nil {
+			t.Fatal(err)
+		}
+
+		meta := snap.metadata
+		meta.Author, meta.Description, meta.Tags = "Second", "A red square", splitTags(" red, ,square ")
+		if err := writeProjectMetadata(filename, meta); err != nil {
+			t.Fatalf("%s: %v", ext, err)
+		}
+		info, err := readProjectInfo(filename)
+		if err != nil {
+			t.Fatalf("%s: %v", ext, err)
+		}
+		got := info.ProjectMetadata
+		if got.Author != "Second" || got.Description != "A red square" || !slices.Equal(got.Tags, []string{"red", "square"}) {
+			t.Errorf("%s: metadata %+v after editing", ext, got)
+		}
+		if !got.Created.Equal(created) || got.AppVersion != "1.0" || got.EditTime != 90 {
+			t.Errorf("%s: other metadata changed: %+v", ext, got)
+		}
+		if info.CanvasWidth != 4 || info.Layers != 1 || info.ThumbnailWidth == 0 {
+			t.Errorf("%s: project %+v after editing", ext, info)
+		}
+
+		// The rest of the project is left as it was
+		lp, report := openProject(filename)
+		if err := report.Err(); err != nil {
+			t.Fatalf("%s: %v", ext, err)
+		}
+		if c := lp.layers[0].At(3, 3); c != (color.RGBA{255, 0, 0, 255}) {
+			t.Errorf("%s: pixel %v after editing", ext, c)
+		}
+		if lp.metadata.Description != "A red square" {
+			t.Errorf("%s: loaded description %q", ext, lp.metadata.Description)
+		}
+
+		// Nothing staged or temporary is left behind
+		names, _ := filepath.Glob(filepath.Join(dir, ".*"))
+		more, _ := filepath.Glob(filepath.Join(filename, "*"+stagedExt))
+		if names = append(names, more...); len(names) > 0 {
+			t.Errorf("%s: files left: %v", ext, names)
+		}
+		if _, err := os.Stat(filepath.Join(filename, pendingFile)); ext == projectDirExt && !os.IsNotExist(err) {
+			t.Errorf("%s: pending file left: %v", ext, err)
+		}
+	}
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"fmt"
+
+	rl "github.com/gen2brain/raylib-go/raylib"
+)
+
+// Colour swatches in the left panel: the current colours, or the palette
+// of an indexed document with sliders to edit the entry being painted.
+
+// Area the swatches share, whatever their number
+const (
+	swatchX      = 10
+	swatchY      = 400
+	swatchWidth  = 80
+	swatchHeight = 215
+)
+
+// Swatch i of n, as large as lets all of them fit
+func swatchRect(i, n int) rl.Rectangle {
+	step := 25
+	for step > 5 && (swatchWidth/step)*(swatchHeight/step) < n {
+		step--
+	}
+	cols := swatchWidth / step
+	size := float32(step - max(1, step/5))
+	return rl.Rectangle{X: float32(swatchX + i%cols*step), Y: float32(swatchY + i/cols*step), Width: size, Height: size}
+}
+
+// Colours shown as swatches
+func (app *App) swatches() []rl.Color {
+	if app.palette == nil {
+		return app.colorPalette
+	}
+	colors := make([]rl.Color, len(app.palette.colors))
+	for i, c := range app.palette.colors {
+		colors[i] = c.color
+	}
+	return colors
+}
+
+// Box showing the colour being painted; clicking it opens the colour editor
+var currentColorRect = rl.Rectangle{X: 10, Y: 620, Width: 40, Height: 30}
+
+// Slider editing red, green or blue of the entry being painted
+func channelSliderRect(k int) rl.Rectangle {
+	return rl.Rectangle{X: 22, Y: float32(658 + k*16), Width: 68, Height: 12}
+}
+
+// Handle the palette picker button, the current colour, clicks on the
+// swatches and, for indexed documents, the channel sliders. Alt+click
+// makes an entry the transparent one; Shift+click cycles the entries from
+// the current one to the clicked one.
+func (app *App) updatePalette(mousePos rl.Vector2) {
+	if rl.CheckCollisionPointRec(mousePos, pickerButton.rect) && rl.IsMouseButtonPressed(rl.MouseLeftButton) {
+		app.OpenPalettePicker()
+		return
+	}
+	if rl.CheckCollisionPointRec(mousePos, currentColorRect) && rl.IsMouseButtonPressed(rl.MouseLeftButton) {
+		app.OpenColorEditor()
+		return
+	}
+	colors := app.swatches()
+	for i, color := range colors {
+		if !rl.CheckCollisionPointRec(mousePos, swatchRect(i, len(colors))) || !rl.IsMouseButtonPressed(rl.MouseLeftButton) {
+			continue
+		}
+		switch {
+		case app.palette == nil:
+			app.currentColor = color
+		case rl.IsKeyDown(rl.KeyLeftAlt) || rl.IsKeyDown(rl.KeyRightAlt):
+			app.SetTransparentIndex(i)
+		case rl.IsKeyDown(rl.KeyLeftShift) || rl.IsKeyDown(rl.KeyRightShift):
+			app.AddCycle(app.currentIndex, i)
+		default:
+			app.selectIndex(i)
+		}
+	}
+
+	if app.palette == nil || app.currentIndex >= len(app.palette.colors) {
+		return
+	}
+	for k := range 3 {
+		rect := channelSliderRect(k)
+		if !rl.CheckCollisionPointRec(mousePos, rect) || !rl.IsMouseButtonDown(rl.MouseLeftButton) {
+			continue
+		}
+		c := app.palette.colors[app.currentIndex].color
+		v := uint8(clamp((mousePos.X-rect.X)/rect.Width*255, 0, 255))
+		channels := []*uint8{&c.R, &c.G, &c.B}
+		if *channels[k] != v {
+			*channels[k] = v
+			app.SetPaletteColor(app.currentIndex, c)
+		}
+	}
+}
+
+// Draw the swatches, the current colour and the channel sliders
+func (app *App) drawPalette() {
+	colors := app.swatches()
+	label := "COLORS"
+	if app.palette != nil {
+		label = fmt.Sprintf("INDEXED %d", len(colors))
+	}
+	rl.DrawText(label, 10, 385, fontSize, rl.LightGray)
+	drawButton(pickerButton, rl.CheckCollisionPointRec(rl.GetMousePosition(), pickerButton.rect))
+	cycle, cycled := app.selectedCycle()
+	for i, color := range colors {
+		rect := swatchRect(i, len(colors))
+		rl.DrawRectangleRec(rect, color)
+		if app.palette != nil && i == app.palette.transparent {
+			// Crossed out: shows as clear
+			rl.DrawLineEx(rl.Vector2{X: rect.X, Y: rect.Y + rect.Height}, rl.Vector2{X: rect.X + rect.Width, Y: rect.Y}, 1, rl.Red)
+		}
+		selected := app.currentColor == color
+		if app.palette != nil {
+			selected = i == app.currentIndex
+		}
+		switch {
+		case selected:
+			rl.DrawRectangleLinesEx(rect, 2, rl.White)
+		case cycled && i >= cycle.start && i <= cycle.end:
+			rl.DrawRectangleLinesEx(rect, 1, rl.Yellow)
+		case rect.Width >= 10:
+			rl.DrawRectangleLinesEx(rect, 1, rl.Color{70, 70, 70, 255})
+		}
+	}
+
+	// Draw current color
+	if app.palette == nil || app.currentIndex >= len(app.palette.colors) {
+		rl.DrawRectangleRec(currentColorRect, app.currentColor)
+		rl.DrawRectangleLinesEx(currentColorRect, 1, rl.White)
+		return
+	}
+	entry := app.palette.colors[app.currentIndex]
+	rl.DrawRectangleRec(currentColorRect, entry.color)
+	rl.DrawRectangleLinesEx(currentColorRect, 1, rl.White)
+	info := fmt.Sprintf("#%d", app.currentIndex)
+	if app.currentIndex == app.palette.transparent {
+		info += " T"
+	}
+	rl.DrawText(info, 55, 622, fontSize, rl.White)
+	rl.DrawText(fmt.Sprintf("KEY %c", entry.key), 55, 638, fontSize, rl.LightGray)
+
+	values := []uint8{entry.color.R, entry.color.G, entry.color.B}
+	for k, name := range []string{"R", "G", "B"} {
+		rect := channelSliderRect(k)
+		rl.DrawText(name, 10, int32(rect.Y)+1, fontSize, rl.LightGray)
+		rl.DrawRectangleRec(rect, rl.Color{60, 60, 60, 255})
+		pos := rect.X + float32(values[k])/255*rect.Width
+		rl.DrawRectangle(int32(pos-2), int32(rect.Y), 4, int32(rect.Height), rl.White)
+	}
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"fmt"
+	"image/color"
+	"slices"
+	"unicode"
+
+	rl "github.com/gen2brain/raylib-go/raylib"
+	"github.com/ha1tch/deluxedraw/palette"
+)
+
+// Editing the list of colours: adding, removing, moving, naming and keying
+// entries. Truecolour documents only have the current colours; in indexed
+// documents pixels follow their entries as they move, which renumbers the
+// pixels and the undo steps alike.
+
+// Give the active document a palette with entries added, removed or moved.
+// moved[i] is where old entry i went, or -1 if it was removed; pixels of a
+// removed entry take the closest colour left. The transparent entry cannot
+// be removed. Cycling ranges whose entries are no longer side by side are
+// dropped. Undo steps are renumbered like the pixels, so they still undo.
+func (app *App) remapPalette(palette *docPalette, moved []int) {
+	old := app.palette
+	palette.transparent = moved[old.transparent]
+	to := make([]int, len(moved))
+	changed := false
+	for i, j := range moved {
+		if j < 0 {
+			c := old.colors[i].color
+			j = palette.nearest(color.NRGBA{c.R, c.G, c.B, 255})
+		}
+		to[i] = j
+		changed = changed || j != i
+	}
+
+	palette.cycles = nil
+	for _, c := range old.cycles {
+		lo, hi, kept := len(palette.colors), -1, 0
+		for k := c.start; k <= c.end; k++ {
+			if j := moved[k]; j >= 0 {
+				lo, hi, kept = min(lo, j), max(hi, j), kept+1
+			}
+		}
+		if kept >= 2 && hi-lo+1 == kept {
+			c.start, c.end = lo, hi
+			palette.cycles = append(palette.cycles, c)
+		}
+	}
+
+	if changed {
+		app.renumberPixels(to, palette.transparent)
+	}
+	app.setPalette(palette)
+	if app.currentIndex < len(to) {
+		app.selectIndex(to[app.currentIndex])
+	} else {
+		app.selectIndex(len(palette.colors) - 1)
+	}
+}
+
+// Number of colours to edit: the palette entries or the current colours
+func (app *App) colorCount() int {
+	if app.palette == nil {
+		return len(app.colorPalette)
+	}
+	return len(app.palette.colors)
+}
+
+// Change colour i of the palette or the current colours
+func (app *App) SetColor(i int, c rl.Color) {
+	if app.palette != nil {
+		app.SetPaletteColor(i, c)
+		return
+	}
+	app.colorPalette[i] = c
+}
+
+// Insert colours after entry i, or first for -1
+func (app *App) InsertColors(i int, colors []rl.Color) error {
+	at := i + 1
+	if app.palette == nil {
+		app.colorPalette = slices.Insert(app.colorPalette, at, colors...)
+		return nil
+	}
+	n := len(app.palette.colors)
+	if n+len(colors) > maxIndexedColors {
+		return fmt.Errorf("indexed palettes hold %d colors", maxIndexedColors)
+	}
+	palette := app.palette.clone()
+	for _, c := range colors {
+		entry := dpfColor{key: unusedDPFKey(palette.colors), color: c}
+		palette.colors = slices.Insert(palette.colors, at, entry)
+		at++
+	}
+	moved := make([]int, n)
+	for j := range moved {
+		moved[j] = j
+		if j > i {
+			moved[j] += len(colors)
+		}
+	}
+	app.remapPalette(palette, moved)
+	return nil
+}
+
+// Remove colour i; its pixels take the closest colour left
+func (app *App) DeleteColor(i int) error {
+	if app.palette == nil {
+		if len(app.colorPalette) <= 1 {
+			return fmt.Errorf("the last color stays")
+		}
+		app.colorPalette = slices.Delete(app.colorPalette, i, i+1)
+		return nil
+	}
+	n := len(app.palette.colors)
+	switch {
+	case i == app.palette.transparent:
+		return fmt.Errorf("the transparent entry stays")
+	case n <= 2:
+		return fmt.Errorf("the last color stays")
+	}
+	palette := app.palette.clone()
+	palette.colors = slices.Delete(palette.colors, i, i+1)
+	moved := make([]int, n)
+	for j := range moved {
+		switch {
+		case j < i:
+			moved[j] = j
+		case j == i:
+			moved[j] = -1
+		default:
+			moved[j] = j - 1
+		}
+	}
+	app.remapPalette(palette, moved)
+	return nil
+}
+
+// Swap colours i and j
+func (app *App) SwapColors(i, j int) {
+	if app.palette == nil {
+		app.colorPalette[i], app.colorPalette[j] = app.colorPalette[j], app.colorPalette[i]
+		return
+	}
+	palette := app.palette.clone()
+	palette.colors[i], palette.colors[j] = palette.colors[j], palette.colors[i]
+	moved := make([]int, len(palette.colors))
+	for k := range moved {
+		moved[k] = k
+	}
+	moved[i], moved[j] = j, i
+	app.remapPalette(palette, moved)
+}
+
+// Name palette entry i of the active document
+func (app *App) RenameColor(i int, name string) {
+	palette := app.palette.clone()
+	palette.colors[i].name = name
+	app.setPalette(palette)
+}
+
+// Give palette entry i of the active document the key character DPF
+// bitmaps use for it
+func (app *App) SetColorKey(i int, key rune) error {
+	if !unicode.IsPrint(key) || unicode.IsSpace(key) {
+		return fmt.Errorf("keys are printable characters other than space")
+	}
+	for j, c := range app.palette.colors {
+		if j != i && c.key == key {
+			return fmt.Errorf("key %c is used by #%d", key, j)
+		}
+	}
+	palette := app.palette.clone()
+	palette.colors[i].key = key
+	app.setPalette(palette)
+	return nil
+}
+
+// Put the colours in an order, the transparent entry staying where it is.
+// Keys move with their colours unless keysStay, when each entry keeps the
+// key it had; pixels follow their colours unless recolor, when they keep
+// their indices and show the colours now there.
+func (app *App) SortColors(order palette.Order, keysStay, recolor bool) {
+	if app.palette == nil {
+		sorted := make([]rl.Color, 0, len(app.colorPalette))
+		for _, i := range palette.SortOrder(swatchPalette(app.colorPalette).Colors, order) {
+			sorted = append(sorted, app.colorPalette[i])
+		}
+		app.colorPalette = sorted
+		return
+	}
+
+	sorted, moved := sortedPalette(app.palette, order, keysStay)
+	if recolor {
+		app.setPalette(sorted)
+		app.selectIndex(app.currentIndex)
+		return
+	}
+	app.remapPalette(sorted, moved)
+}
+
+// A palette with its colours in an order, the transparent entry staying
+// where it is, and where each old entry went
+func sortedPalette(old *docPalette, order palette.Order, keysStay bool) (*docPalette, []int) {
+	var entries []int // all but the transparent entry
+	var colors []rl.Color
+	for i, c := range old.colors {
+		if i != old.transparent {
+			entries = append(entries, i)
+			colors = append(colors, c.color)
+		}
+	}
+	sorted := old.clone()
+	moved := make([]int, len(old.colors))
+	moved[old.transparent] = old.transparent
+	for k, j := range palette.SortOrder(swatchPalette(colors).Colors, order) {
+		to, from := entries[k], entries[j]
+		sorted.colors[to] = old.colors[from]
+		if keysStay {
+			sorted.colors[to].key = old.colors[to].key
+		}
+		moved[from] = to
+	}
+	return sorted, moved
+}
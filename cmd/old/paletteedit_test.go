@@ -0,0 +1,62 @@
+package main
+
+import (
+	"image"
+	"slices"
+	"testing"
+
+	rl "github.com/gen2brain/raylib-go/raylib"
+	"github.com/ha1tch/deluxedraw/palette"
+)
+
+func TestSortedPaletteKeepsComposite(t *testing.T) {
+	// Transparent first, colours out of every order
+	p := testDocPalette(t,
+		rl.Color{},
+		rl.Color{0, 0, 255, 255},
+		rl.Color{255, 255, 255, 255},
+		rl.Color{255, 0, 0, 255},
+		rl.Color{0, 0, 0, 255},
+		rl.Color{128, 128, 128, 255},
+		rl.Color{0, 255, 0, 255},
+	)
+	img := image.NewNRGBA(image.Rect(0, 0, len(p.colors), 2))
+	for i := range p.colors {
+		img.SetNRGBA(i, 0, indexPixel(i, p.transparent))
+		img.SetNRGBA(len(p.colors)-1-i, 1, indexPixel(i, p.transparent))
+	}
+	store := TileStoreFromNRGBA(img)
+	composite := func(store *TileStore, p *docPalette) []uint8 {
+		stack := compositeStack{layers: []compositeLayer{{store, 1, BlendNormal, p.rgba()}}}
+		return compositeImage(stack, img.Rect.Dx(), img.Rect.Dy()).Pix
+	}
+	want := composite(store, p)
+
+	for order := range palette.Order(len(palette.OrderNames)) {
+		for _, keysStay := range []bool{false, true} {
+			name := palette.OrderNames[order]
+			sorted, moved := sortedPalette(p, order, keysStay)
+			if sorted.transparent != p.transparent || moved[p.transparent] != p.transparent {
+				t.Errorf("%s: transparent entry moved", name)
+			}
+			if sorted.equal(p) {
+				t.Errorf("%s: palette already in order", name)
+			}
+			for i, j := range moved {
+				c := sorted.colors[j]
+				if c.color != p.colors[i].color || (c.key == p.colors[i].key) == (keysStay && i != j) {
+					t.Errorf("%s, keys stay %v: entry %d moved to %d as %v", name, keysStay, i, j, c)
+				}
+			}
+
+			// Pixels following their colours show the same image
+			if got := composite(convertStore(store, renumberImage(moved, sorted.transparent)), sorted); !slices.Equal(got, want) {
+				t.Errorf("%s: composite changed by sorting", name)
+			}
+			// Pixels keeping their indices show the new colours
+			if got := composite(store, sorted); slices.Equal(got, want) {
+				t.Errorf("%s: recoloured composite unchanged", name)
+			}
+		}
+	}
+}
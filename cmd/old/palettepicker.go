@@ -0,0 +1,195 @@
+package main
+
+import (
+	"fmt"
+	"image/color"
+	"path/filepath"
+
+	rl "github.com/gen2brain/raylib-go/raylib"
+	"github.com/ha1tch/deluxedraw/palette"
+)
+
+// Palette files and the picker listing the bundled deluxe palettes. A
+// chosen palette replaces the active document's if it is indexed, or the
+// current colours otherwise.
+
+const pickerRowHeight = 34
+
+// State of the palette picker dialog
+type palettePicker struct {
+	palettes []*palette.Palette
+	box      rl.Rectangle
+	rows     []rl.Rectangle
+	buttons  []Button // FILE, CANCEL
+}
+
+// Button next to the colours label that opens the picker
+var pickerButton = Button{rect: rl.Rectangle{X: 74, Y: 383, Width: 16, Height: 12}, text: "..."}
+
+// Open the palette picker
+func (app *App) OpenPalettePicker() {
+	p := &palettePicker{palettes: palette.Collection()}
+	height := float32(len(p.palettes)*pickerRowHeight + 70)
+	p.box = rl.Rectangle{X: screenWidth/2 - 200, Y: screenHeight/2 - height/2, Width: 400, Height: height}
+	x, y := p.box.X, p.box.Y
+	for i := range p.palettes {
+		p.rows = append(p.rows, rl.Rectangle{X: x + 10, Y: y + 30 + float32(i*pickerRowHeight), Width: 380, Height: pickerRowHeight - 4})
+	}
+	p.buttons = []Button{
+		{rect: rl.Rectangle{X: x + 220, Y: y + height - 30, Width: 80, Height: 20}, text: "FILE..."},
+		{rect: rl.Rectangle{X: x + 310, Y: y + height - 30, Width: 80, Height: 20}, text: "CANCEL"},
+	}
+	app.picker = p
+}
+
+// Handle clicks in the palette picker
+func (app *App) updatePalettePicker(mousePos rl.Vector2) {
+	p := app.picker
+	if !rl.IsMouseButtonPressed(rl.MouseLeftButton) {
+		return
+	}
+	for i, row := range p.rows {
+		if rl.CheckCollisionPointRec(mousePos, row) {
+			app.picker = nil
+			if err := app.UsePalette(p.palettes[i]); err != nil {
+				app.SetStatus(fmt.Sprintf("PALETTE FAILED: %v", err))
+				return
+			}
+			app.SetStatus("PALETTE " + p.palettes[i].Name)
+			return
+		}
+	}
+	for i, btn := range p.buttons {
+		if !rl.CheckCollisionPointRec(mousePos, btn.rect) {
+			continue
+		}
+		app.picker = nil
+		if i == 0 {
+			app.OpenFileBrowser(browseOpen)
+			app.browser.filter = filterPalette
+			app.browser.readDir()
+		}
+		return
+	}
+}
+
+// Draw the palette picker over the whole window
+func (app *App) drawPalettePicker() {
+	p := app.picker
+	if p == nil {
+		return
+	}
+	mousePos := rl.GetMousePosition()
+	rl.DrawRectangle(0, 0, screenWidth, screenHeight, rl.Color{0, 0, 0, 160})
+	rl.DrawRectangleRec(p.box, rl.Color{50, 50, 50, 255})
+	rl.DrawRectangleLinesEx(p.box, 1, rl.Color{90, 90, 90, 255})
+	rl.DrawText("PALETTES", int32(p.box.X)+10, int32(p.box.Y)+10, fontSize, rl.White)
+
+	for i, row := range p.rows {
+		if rl.CheckCollisionPointRec(mousePos, row) {
+			rl.DrawRectangleRec(row, rl.Color{80, 80, 80, 255})
+		}
+		colors := p.palettes[i].Colors
+		rl.DrawText(fmt.Sprintf("%s  %d", p.palettes[i].Name, len(colors)), int32(row.X)+4, int32(row.Y)+3, fontSize, rl.White)
+
+		// A strip of every colour
+		strip := rl.Rectangle{X: row.X + 4, Y: row.Y + 14, Width: row.Width - 8, Height: row.Height - 17}
+		for j, c := range colors {
+			x0 := strip.X + strip.Width*float32(j)/float32(len(colors))
+			x1 := strip.X + strip.Width*float32(j+1)/float32(len(colors))
+			rl.DrawRectangleRec(rl.Rectangle{X: x0, Y: strip.Y, Width: x1 - x0, Height: strip.Height}, rl.Color{c.R, c.G, c.B, 255})
+		}
+	}
+	for _, btn := range p.buttons {
+		drawButton(btn, rl.CheckCollisionPointRec(mousePos, btn.rect))
+	}
+}
+
+// Palette entries with DPF keys in order, named "color n" where the file
+// has no names
+func paletteDPFColors(p *palette.Palette) []dpfColor {
+	keys := []rune(dpfKeys)
+	colors := make([]dpfColor, 0, len(p.Colors))
+	for i, c := range p.Colors {
+		entry := dpfColor{color: rl.Color{c.R, c.G, c.B, c.A}, name: c.Name}
+		if entry.name == "" {
+			entry.name = fmt.Sprintf("color %d", i+1)
+		}
+		if i < len(keys) {
+			entry.key = keys[i]
+		} else {
+			entry.key = unusedDPFKey(colors)
+		}
+		colors = append(colors, entry)
+	}
+	return colors
+}
+
+// Use a palette for the active document, if it is indexed, or as the
+// current colours
+func (app *App) UsePalette(p *palette.Palette) error {
+	return app.usePaletteColors(paletteDPFColors(p))
+}
+
+// Replace the active document's palette, if it is indexed, or the current
+// colours. Pixels keep their indices while the new palette has a colour
+// there; others take the closest colour, and transparent pixels stay
+// transparent. Cycling ranges that still fit keep cycling.
+func (app *App) usePaletteColors(colors []dpfColor) error {
+	if app.palette == nil {
+		app.colorPalette = nil
+		for _, c := range colors {
+			app.colorPalette = append(app.colorPalette, c.color)
+		}
+		return nil
+	}
+	doc, err := dpfDocPalette(colors)
+	if err != nil {
+		return err
+	}
+	for _, c := range app.palette.cycles {
+		if c.end < len(doc.colors) {
+			doc.cycles = append(doc.cycles, c)
+		}
+	}
+	to := paletteRenumbering(app.palette, doc)
+	for i, j := range to {
+		if j != i {
+			app.renumberPixels(to, doc.transparent)
+			break
+		}
+	}
+	app.setPalette(doc)
+	app.selectIndex(to[min(app.currentIndex, len(to)-1)])
+	return nil
+}
+
+// Where each entry of an old palette goes in a new one: to the same index
+// if that is a colour, to the closest colour otherwise, and the
+// transparent entry to the new transparent entry
+func paletteRenumbering(old, palette *docPalette) []int {
+	to := make([]int, len(old.colors))
+	for i, e := range old.colors {
+		switch {
+		case i == old.transparent:
+			to[i] = palette.transparent
+		case i < len(palette.colors) && i != palette.transparent:
+			to[i] = i
+		default:
+			to[i] = palette.nearest(color.NRGBA{e.color.R, e.color.G, e.color.B, 255})
+		}
+	}
+	return to
+}
+
+// Open a palette file in any format the palette package reads
+func (app *App) OpenPalette(filename string) error {
+	p, err := palette.Load(filename)
+	if err != nil {
+		return err
+	}
+	if err := app.UsePalette(p); err != nil {
+		return fmt.Errorf("%s: %v", filepath.Base(filename), err)
+	}
+	return nil
+}
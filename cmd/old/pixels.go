@@ -0,0 +1,109 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"unsafe"
+
+	rl "github.com/gen2brain/raylib-go/raylib"
+)
+
+// Bulk pixel transfer between raylib and Go images. All conversions move
+// whole rows with copy; render textures are stored bottom row first, so
+// the vertical flip happens while copying rows instead of per pixel.
+
+// View raylib colours as raw RGBA bytes without copying
+func colorBytes(cols []color.RGBA) []uint8 {
+	if len(cols) == 0 {
+		return nil
+	}
+	return unsafe.Slice((*uint8)(unsafe.Pointer(&cols[0])), len(cols)*4)
+}
+
+// View raw RGBA bytes as raylib colours without copying
+func bytesColors(pix []uint8) []color.RGBA {
+	if len(pix) == 0 {
+		return nil
+	}
+	return unsafe.Slice((*color.RGBA)(unsafe.Pointer(&pix[0])), len(pix)/4)
+}
+
+// Copy rows of rowBytes bytes, optionally reversing their order
+func copyRows(dst []uint8, dstStride int, src []uint8, srcStride int, rowBytes, rows int, flip bool) {
+	for y := 0; y < rows; y++ {
+		sy := y
+		if flip {
+			sy = rows - 1 - y
+		}
+		copy(dst[y*dstStride:y*dstStride+rowBytes], src[sy*srcStride:sy*srcStride+rowBytes])
+	}
+}
+
+// Read a render texture into a Go image (top row first)
+func readRenderTexture(tex rl.Texture2D) *image.NRGBA {
+	img := rl.LoadImageFromTexture(tex)
+	defer rl.UnloadImage(img)
+	return raylibNRGBA(img)
+}
+
+// Convert a raylib image read back from a render texture, bottom row
+// first, to a Go image
+func raylibNRGBA(img *rl.Image) *image.NRGBA {
+	cols := rl.LoadImageColors(img)
+	defer rl.UnloadImageColors(cols)
+
+	w, h := int(img.Width), int(img.Height)
+	out := image.NewNRGBA(image.Rect(0, 0, w, h))
+	copyRows(out.Pix, out.Stride, colorBytes(cols), w*4, w*4, h, true)
+	return out
+}
+
+// Write a Go image (top row first) into a render texture of the same size
+func writeRenderTexture(tex rl.Texture2D, img *image.NRGBA) {
+	rl.UpdateTexture(tex, bytesColors(textureBytes(img, true)))
+}
+
+// Make a plain texture from a Go image
+func loadTextureNRGBA(img *image.NRGBA) rl.Texture2D {
+	rlImg := rl.NewImage(textureBytes(img, false), int32(img.Rect.Dx()), int32(img.Rect.Dy()), 1, rl.UncompressedR8g8b8a8)
+	return rl.LoadTextureFromImage(rlImg)
+}
+
+// Pack a Go image's pixels as tightly strided RGBA bytes, with flip bottom
+// row first as render textures store them
+func textureBytes(img *image.NRGBA, flip bool) []uint8 {
+	w, h := img.Rect.Dx(), img.Rect.Dy()
+	buf := make([]uint8, w*h*4)
+	copyRows(buf, w*4, img.Pix[img.PixOffset(img.Rect.Min.X, img.Rect.Min.Y):], img.Stride, w*4, h, flip)
+	return buf
+}
+
+// Convert any image to NRGBA, without copying when it already is one
+func toNRGBA(img image.Image) *image.NRGBA {
+	if n, ok := img.(*image.NRGBA); ok && n.Rect.Min == (image.Point{}) {
+		return n
+	}
+	b := img.Bounds()
+	out := image.NewNRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(out, out.Bounds(), img, b.Min, draw.Src)
+	return out
+}
+
+// Flatten a straight-alpha image onto an opaque background colour
+func flattenNRGBA(img *image.NRGBA, bg color.RGBA) *image.RGBA {
+	b := img.Rect
+	out := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	for y := 0; y < b.Dy(); y++ {
+		src := img.Pix[img.PixOffset(b.Min.X, b.Min.Y+y):]
+		dst := out.Pix[y*out.Stride:]
+		for x := 0; x < b.Dx()*4; x += 4 {
+			a := uint32(src[x+3])
+			dst[x] = uint8((uint32(src[x])*a + uint32(bg.R)*(255-a) + 127) / 255)
+			dst[x+1] = uint8((uint32(src[x+1])*a + uint32(bg.G)*(255-a) + 127) / 255)
+			dst[x+2] = uint8((uint32(src[x+2])*a + uint32(bg.B)*(255-a) + 127) / 255)
+			dst[x+3] = 255
+		}
+	}
+	return out
+}
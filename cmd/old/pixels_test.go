@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"testing"
+
+	rl "github.com/gen2brain/raylib-go/raylib"
+)
+
+// Store two pages square with distinct pixels everywhere except one
+// transparent tile of page (1, 1)
+func testPageStore() *TileStore {
+	img := image.NewNRGBA(image.Rect(0, 0, 2*pageSize, 2*pageSize))
+	for y := 0; y < img.Rect.Dy(); y++ {
+		for x := 0; x < img.Rect.Dx(); x++ {
+			img.SetNRGBA(x, y, color.NRGBA{uint8(x), uint8(y), uint8(x>>8 | y>>8<<1), uint8(x + 2*y)})
+		}
+	}
+	empty := image.Rect(tileSize, 2*tileSize, 2*tileSize, 3*tileSize).Add(image.Pt(pageSize, pageSize))
+	for y := empty.Min.Y; y < empty.Max.Y; y++ {
+		for x := empty.Min.X; x < empty.Max.X; x++ {
+			img.SetNRGBA(x, y, color.NRGBA{})
+		}
+	}
+	return TileStoreFromNRGBA(img)
+}
+
+func TestPagePixelsFlip(t *testing.T) {
+	s := testPageStore()
+	img := s.ToNRGBA()
+	buf := s.pagePixels(1, 1)
+
+	// Render texture rows run bottom up
+	stride := pageSize * 4
+	for y := 0; y < pageSize; y++ {
+		want := img.Pix[img.PixOffset(pageSize, 2*pageSize-1-y):][:stride]
+		if got := buf[y*stride : (y+1)*stride]; !bytes.Equal(got, want) {
+			t.Fatalf("texture row %d is not canvas row %d", y, 2*pageSize-1-y)
+		}
+	}
+}
+
+func TestPagePixelsRoundTrip(t *testing.T) {
+	s := testPageStore()
+	out := NewTileStore(s.width, s.height)
+	for py := 0; py < 2; py++ {
+		for px := 0; px < 2; px++ {
+			out.setPagePixels(px, py, s.pagePixels(px, py))
+		}
+	}
+	if !bytes.Equal(out.ToNRGBA().Pix, s.ToNRGBA().Pix) {
+		t.Error("pixels changed on the way through a page")
+	}
+	if out.Tile(tilesPerPage+1, tilesPerPage+2) != nil {
+		t.Error("transparent tile stored")
+	}
+	if out.TileCount() != s.TileCount() {
+		t.Errorf("got %d tiles, want %d", out.TileCount(), s.TileCount())
+	}
+}
+
+func TestCopyRowsFlip(t *testing.T) {
+	// Three rows of two pixels into a wider image
+	src := []uint8{
+		1, 1, 1, 1, 2, 2, 2, 2,
+		3, 3, 3, 3, 4, 4, 4, 4,
+		5, 5, 5, 5, 6, 6, 6, 6,
+	}
+	dst := image.NewNRGBA(image.Rect(0, 0, 3, 3))
+	copyRows(dst.Pix, dst.Stride, src, 8, 8, 3, true)
+	back := make([]uint8, len(src))
+	copyRows(back, 8, dst.Pix, dst.Stride, 8, 3, true)
+	if got := dst.NRGBAAt(1, 0); got != (color.NRGBA{6, 6, 6, 6}) {
+		t.Errorf("top right pixel %v, want the source's bottom right", got)
+	}
+	if got := dst.NRGBAAt(2, 0); got != (color.NRGBA{}) {
+		t.Errorf("pixel past the row written: %v", got)
+	}
+	if !bytes.Equal(back, src) {
+		t.Errorf("flipped twice gives %v, want %v", back, src)
+	}
+}
+
+// Canvas-sized image with distinct pixels, as save and undo convert
+func testCanvas(w, h int) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.SetNRGBA(x, y, color.NRGBA{uint8(x), uint8(y), uint8(x ^ y), uint8(x+2*y) | 1})
+		}
+	}
+	return img
+}
+
+func TestRaylibRoundTrip(t *testing.T) {
+	img := testCanvas(37, 23)
+	w, h := img.Rect.Dx(), img.Rect.Dy()
+	rlImg := rl.NewImage(textureBytes(img, true), int32(w), int32(h), 1, rl.UncompressedR8g8b8a8)
+
+	// Texture rows run bottom up
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			c := img.NRGBAAt(x, y)
+			if got := rl.GetImageColor(*rlImg, int32(x), int32(h-1-y)); got != (color.RGBA{c.R, c.G, c.B, c.A}) {
+				t.Fatalf("texture pixel (%d, %d) is %v, want canvas pixel (%d, %d) %v", x, h-1-y, got, x, y, c)
+			}
+		}
+	}
+	if back := raylibNRGBA(rlImg); !bytes.Equal(back.Pix, img.Pix) {
+		t.Error("pixels changed on the way through a raylib image")
+	}
+}
+
+// Canvas read back from a render texture as save, export and undo did
+// before whole rows were copied
+func BenchmarkDownloadPerPixel(b *testing.B) {
+	src := testCanvas(512, 512)
+	img := rl.NewImage(textureBytes(src, true), 512, 512, 1, rl.UncompressedR8g8b8a8)
+	b.SetBytes(512 * 512 * 4)
+	for b.Loop() {
+		width, height := int(img.Width), int(img.Height)
+		goImg := image.NewRGBA(image.Rect(0, 0, width, height))
+		for y := 0; y < height; y++ {
+			for x := 0; x < width; x++ {
+				c := rl.GetImageColor(*img, int32(x), int32(y))
+				goImg.Set(x, height-1-y, color.RGBA{c.R, c.G, c.B, c.A}) // Flip Y
+			}
+		}
+	}
+}
+
+func BenchmarkDownloadRows(b *testing.B) {
+	src := testCanvas(512, 512)
+	img := rl.NewImage(textureBytes(src, true), 512, 512, 1, rl.UncompressedR8g8b8a8)
+	b.SetBytes(512 * 512 * 4)
+	for b.Loop() {
+		raylibNRGBA(img)
+	}
+}
+
+// Canvas made into a texture image as undo and loading did before whole
+// rows were copied
+func BenchmarkUploadPerPixel(b *testing.B) {
+	src := testCanvas(512, 512)
+	b.SetBytes(512 * 512 * 4)
+	for b.Loop() {
+		bounds := src.Bounds()
+		rlImg := rl.GenImageColor(bounds.Dx(), bounds.Dy(), rl.Blank)
+		for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+			for x := bounds.Min.X; x < bounds.Max.X; x++ {
+				r, g, b, a := src.At(x, y).RGBA()
+				c := rl.Color{uint8(r >> 8), uint8(g >> 8), uint8(b >> 8), uint8(a >> 8)}
+				rl.ImageDrawPixel(rlImg, int32(x), int32(bounds.Dy()-1-y), c) // Flip Y
+			}
+		}
+		rl.UnloadImage(rlImg)
+	}
+}
+
+func BenchmarkUploadRows(b *testing.B) {
+	src := testCanvas(512, 512)
+	b.SetBytes(512 * 512 * 4)
+	for b.Loop() {
+		rl.NewImage(textureBytes(src, true), 512, 512, 1, rl.UncompressedR8g8b8a8)
+	}
+}
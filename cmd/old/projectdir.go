@@ -0,0 +1,343 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"image/png"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Project directories are an unpacked alternative to .ddd archives that
+// suit version control:
+//
+//	name.ddproj/project.json     layers and palette, no pixels
+//	name.ddproj/layers/<id>.png  one canvas-sized PNG per layer
+//	name.ddproj/layers/<id>.<frame>.png  the layer in a later frame
+//	name.ddproj/metadata.json    author, times, tags and so on
+//	name.ddproj/thumbnail.png    small composite preview
+//
+// Layer files are named by layer ID, so reordering or renaming layers does
+// not touch them. Output depends only on the document, and files whose
+// bytes have not changed are not rewritten.
+//
+// A save writes changed files next to the ones they replace, as
+// <name>.new, then lists them in .pending, which commits the save, and
+// only then moves them into place. Readers take the staged files of a
+// save that stopped before it was done; the next save finishes it.
+
+// Extension of project directories
+const projectDirExt = ".ddproj"
+
+const (
+	stagedExt   = ".new"     // file staged to replace the one without it
+	pendingFile = ".pending" // staged files of a committed save, by slash path
+)
+
+// Check whether a path names a project directory rather than an archive
+func isProjectDir(filename string) bool {
+	if strings.EqualFold(filepath.Ext(filepath.Clean(filename)), projectDirExt) {
+		return true
+	}
+	info, err := os.Stat(filename)
+	return err == nil && info.IsDir()
+}
+
+// Files of a project directory save, staged next to the ones they replace
+// until the save commits
+type dirStage struct {
+	dir    string
+	staged []string // slash paths
+}
+
+// Stage a file unless it already holds data
+func (s *dirStage) write(name string, data []byte) error {
+	filename := filepath.Join(s.dir, filepath.FromSlash(name))
+	if old, err := os.ReadFile(filename); err == nil && bytes.Equal(old, data) {
+		return nil
+	}
+	err := writeFileAtomic(filename+stagedExt, func(w io.Writer) error {
+		_, err := w.Write(data)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	s.staged = append(s.staged, name)
+	return nil
+}
+
+// Commit the staged files by listing them in the pending file, then move
+// them into place
+func (s *dirStage) commit() error {
+	if len(s.staged) == 0 {
+		return nil
+	}
+	err := writeFileAtomic(filepath.Join(s.dir, pendingFile), func(w io.Writer) error {
+		_, err := io.WriteString(w, strings.Join(s.staged, "\n")+"\n")
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return finishProjectDir(s.dir)
+}
+
+// Staged files of a save that committed but did not finish, by slash path;
+// nil if there is none
+func pendingFiles(dir string) map[string]bool {
+	data, err := os.ReadFile(filepath.Join(dir, pendingFile))
+	if err != nil {
+		return nil
+	}
+	pending := make(map[string]bool)
+	for _, name := range strings.Fields(string(data)) {
+		if filepath.IsLocal(filepath.FromSlash(name)) {
+			pending[name] = true
+		}
+	}
+	return pending
+}
+
+// Path of a file in a project directory, or of the file staged to replace
+// it by a save that committed but did not finish
+func projectDirFile(dir string, pending map[string]bool, name string) string {
+	filename := filepath.Join(dir, filepath.FromSlash(name))
+	if pending[name] {
+		if _, err := os.Stat(filename + stagedExt); err == nil {
+			return filename + stagedExt
+		}
+	}
+	return filename
+}
+
+// Finish a committed save by moving its staged files into place. Staged
+// files of a save that never committed are removed.
+func finishProjectDir(dir string) error {
+	for name := range pendingFiles(dir) {
+		filename := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.Rename(filename+stagedExt, filename); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	for _, pattern := range []string{"*" + stagedExt, filepath.Join("layers", "*"+stagedExt)} {
+		names, _ := filepath.Glob(filepath.Join(dir, pattern))
+		for _, name := range names {
+			if err := os.Remove(name); err != nil {
+				return err
+			}
+		}
+	}
+	if err := os.Remove(filepath.Join(dir, pendingFile)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// Write a snapshot as a project directory. Changed files are staged and
+// all replaced once every one of them is written, so an interrupted save
+// leaves the previous version; files of deleted layers are removed last.
+func writeProjectDir(dir string, snap *projectSnapshot, progress func(done, total int)) error {
+	project := snap.project
+	project.TileSize = 0
+	project.Layers = append([]LayerData(nil), snap.project.Layers...)
+	total := len(snap.layers) + 2
+	report := func(done int) {
+		if progress != nil {
+			progress(done, total)
+		}
+	}
+
+	layersDir := filepath.Join(dir, "layers")
+	if err := os.MkdirAll(layersDir, 0o755); err != nil {
+		return err
+	}
+	if err := finishProjectDir(dir); err != nil {
+		return err
+	}
+	stage := &dirStage{dir: dir}
+
+	// Save layers; the PNG encoder's output depends only on the pixels
+	keep := make(map[string]bool)
+	for i, store := range snap.layers {
+		id := project.Layers[i].ID
+		if !validLayerID(id) {
+			return fmt.Errorf("layer %d has invalid id %q", i, id)
+		}
+		name := id + ".png"
+		if keep[name] {
+			return fmt.Errorf("layer %d has duplicate id %q", i, id)
+		}
+		keep[name] = true
+		project.Layers[i].Tiles = nil
+
+		if err := writeLayerFile(stage, name, store); err != nil {
+			return err
+		}
+		if i < len(snap.cels) {
+			cels, err := layerCels(snap.cels[i], func(frame int, store *TileStore) ([]TileRef, error) {
+				name := celFileName(id, frame)
+				keep[name] = true
+				return nil, writeLayerFile(stage, name, store)
+			})
+			if err != nil {
+				return err
+			}
+			project.Layers[i].Cels = cels
+		}
+		report(i + 1)
+	}
+
+	// Save project.json; struct fields keep the key order fixed
+	data, err := json.MarshalIndent(project, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := stage.write("project.json", append(data, '\n')); err != nil {
+		return err
+	}
+
+	// Save metadata and thumbnail
+	data, err = json.MarshalIndent(snap.metadata, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := stage.write(metadataEntry, append(data, '\n')); err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, snapshotThumbnail(snap)); err != nil {
+		return err
+	}
+	if err := stage.write(thumbnailEntry, buf.Bytes()); err != nil {
+		return err
+	}
+	if err := stage.commit(); err != nil {
+		return err
+	}
+	report(len(snap.layers) + 1)
+
+	// Remove files of deleted layers
+	entries, err := os.ReadDir(layersDir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if strings.HasSuffix(entry.Name(), ".png") && !keep[entry.Name()] {
+			if err := os.Remove(filepath.Join(layersDir, entry.Name())); err != nil {
+				return err
+			}
+		}
+	}
+	report(total)
+
+	return nil
+}
+
+// File holding a layer's pixels in a frame after the first
+func celFileName(id string, frame int) string {
+	return fmt.Sprintf("%s.%d.png", id, frame)
+}
+
+// Stage layer pixels as a canvas-sized PNG in layers/; the encoder's
+// output depends only on the pixels
+func writeLayerFile(stage *dirStage, name string, store *TileStore) error {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, store.ToNRGBA()); err != nil {
+		return err
+	}
+	return stage.write("layers/"+name, buf.Bytes())
+}
+
+// Decode and validate a project directory
+func decodeProjectDir(dir string) (*loadedProject, *ValidationReport) {
+	report := &ValidationReport{}
+	pending := pendingFiles(dir)
+	data, err := os.ReadFile(projectDirFile(dir, pending, "project.json"))
+	if err != nil {
+		report.errorf("", "%v", err)
+		return nil, report
+	}
+	project, version, ok := decodeProjectJSON(data, report)
+	if !ok {
+		return nil, report
+	}
+	if project.FormatVersion == 0 {
+		report.errorf("project.json", "format_version is missing")
+	} else if version < 2 {
+		report.errorf("project.json", "format version %d has no directory layout", version)
+	}
+	if project.TileSize != 0 {
+		report.warnf("project.json", "tile_size ignored in a project directory")
+	}
+
+	lp := &loadedProject{project: *project}
+	project = &lp.project
+	used := make(map[string]bool)
+	for i := range project.Layers {
+		layerData := &project.Layers[i]
+		where := fmt.Sprintf("layer %d (%q)", i, layerData.Name)
+		store := NewTileStore(project.CanvasWidth, project.CanvasHeight)
+
+		if len(layerData.Tiles) > 0 {
+			report.warnf(where, "tile references ignored in a project directory")
+			layerData.Tiles = nil
+		}
+		// Read a layer file into a store, or report why not
+		read := func(store *TileStore, name string) {
+			used[name] = true
+			name = "layers/" + name
+			if img, err := readPNGFile(projectDirFile(dir, pending, name)); err != nil {
+				report.errorf(where, "%v", err)
+			} else if img.Rect.Dx() != project.CanvasWidth || img.Rect.Dy() != project.CanvasHeight {
+				report.errorf(where, "%s is %dx%d, canvas is %dx%d", name, img.Rect.Dx(), img.Rect.Dy(), project.CanvasWidth, project.CanvasHeight)
+			} else {
+				store.SetNRGBA(img)
+			}
+		}
+		// IDs name files, so invalid ones, already reported, read nothing
+		validID := validLayerID(layerData.ID)
+		if layerData.ID == "" {
+			report.errorf(where, "id is missing")
+		} else if validID {
+			read(store, layerData.ID+".png")
+		}
+		lp.layers = append(lp.layers, store)
+		lp.cels = append(lp.cels, linkCels(store, layerData.Cels, report.Frames, func(cel CelData) *TileStore {
+			store := NewTileStore(project.CanvasWidth, project.CanvasHeight)
+			if len(cel.Tiles) > 0 {
+				report.warnf(where, "tile references of frame %d ignored in a project directory", cel.Frame)
+			}
+			if validID {
+				read(store, celFileName(layerData.ID, cel.Frame))
+			}
+			return store
+		}))
+		layerData.Cels = nil
+	}
+
+	// Files no layer refers to
+	entries, _ := os.ReadDir(filepath.Join(dir, "layers"))
+	var unused []string
+	for _, entry := range entries {
+		if strings.HasSuffix(entry.Name(), ".png") && !used[entry.Name()] {
+			unused = append(unused, entry.Name())
+		}
+	}
+	sort.Strings(unused)
+	for _, name := range unused {
+		report.warnf("layers/"+name, "not used by any layer")
+	}
+
+	if file, err := os.Open(projectDirFile(dir, pending, metadataEntry)); err == nil {
+		lp.metadata = decodeMetadata(file, report)
+		file.Close()
+	}
+
+	upgradeProject(project, version)
+	return lp, report
+}
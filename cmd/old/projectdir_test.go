@@ -0,0 +1,103 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Snapshot of a one-layer canvas filled with a colour
+func testDirSnapshot(width, height int, c color.NRGBA) *projectSnapshot {
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for i := 0; i < len(img.Pix); i += 4 {
+		img.Pix[i], img.Pix[i+1], img.Pix[i+2], img.Pix[i+3] = c.R, c.G, c.B, c.A
+	}
+	store := NewTileStore(width, height)
+	store.SetNRGBA(img)
+	return &projectSnapshot{
+		project: ProjectData{
+			FormatVersion: formatVersion,
+			CanvasWidth:   width,
+			CanvasHeight:  height,
+			Layers:        []LayerData{{ID: "bg", Name: "Background", Visible: true, Opacity: 1}},
+		},
+		layers: []*TileStore{store},
+	}
+}
+
+// Decode a project directory, failing the test on errors
+func testDecodeDir(t *testing.T, dir string) *loadedProject {
+	t.Helper()
+	lp, report := decodeProjectDir(dir)
+	if err := report.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return lp
+}
+
+func TestProjectDirInterruptedSave(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "art"+projectDirExt)
+	red := color.NRGBA{255, 0, 0, 255}
+	if err := writeProjectDir(dir, testDirSnapshot(4, 4, red), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// Stage a resize as a save does, then stop before committing
+	if err := writeProjectDir(dir, testDirSnapshot(8, 2, red), nil); err != nil {
+		t.Fatal(err)
+	}
+	project, _ := os.ReadFile(filepath.Join(dir, "project.json"))
+	layer, _ := os.ReadFile(filepath.Join(dir, "layers", "bg.png"))
+	if err := writeProjectDir(dir, testDirSnapshot(4, 4, red), nil); err != nil {
+		t.Fatal(err)
+	}
+	os.WriteFile(filepath.Join(dir, "project.json"+stagedExt), project, 0o644)
+	os.WriteFile(filepath.Join(dir, "layers", "bg.png"+stagedExt), layer, 0o644)
+	if lp := testDecodeDir(t, dir); lp.project.CanvasWidth != 4 {
+		t.Errorf("uncommitted save loaded, canvas width %d", lp.project.CanvasWidth)
+	}
+
+	// Commit it, then stop before the files are moved into place
+	os.WriteFile(filepath.Join(dir, pendingFile), []byte("layers/bg.png\nproject.json\n"), 0o644)
+	if lp := testDecodeDir(t, dir); lp.project.CanvasWidth != 8 || lp.layers[0].At(7, 1) != (color.RGBA{255, 0, 0, 255}) {
+		t.Errorf("committed save not loaded, canvas width %d", lp.project.CanvasWidth)
+	}
+
+	// The next save finishes the last one first
+	if err := writeProjectDir(dir, testDirSnapshot(8, 2, red), nil); err != nil {
+		t.Fatal(err)
+	}
+	names, _ := filepath.Glob(filepath.Join(dir, "*"+stagedExt))
+	more, _ := filepath.Glob(filepath.Join(dir, "layers", "*"+stagedExt))
+	if names = append(names, more...); len(names) > 0 {
+		t.Errorf("staged files left: %v", names)
+	}
+	if _, err := os.Stat(filepath.Join(dir, pendingFile)); !os.IsNotExist(err) {
+		t.Errorf("pending file left: %v", err)
+	}
+	testDecodeDir(t, dir)
+}
+
+func TestProjectDirInvalidID(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "art"+projectDirExt)
+	if err := writeProjectDir(dir, testDirSnapshot(4, 4, color.NRGBA{0, 0, 255, 255}), nil); err != nil {
+		t.Fatal(err)
+	}
+	// A layer file outside layers/
+	layer, _ := os.ReadFile(filepath.Join(dir, "layers", "bg.png"))
+	os.WriteFile(filepath.Join(dir, "outside.png"), layer, 0o644)
+	project, _ := os.ReadFile(filepath.Join(dir, "project.json"))
+	project = []byte(strings.Replace(string(project), `"id": "bg"`, `"id": "../outside"`, 1))
+	os.WriteFile(filepath.Join(dir, "project.json"), project, 0o644)
+
+	lp, report := decodeProjectDir(dir)
+	if report.Err() == nil {
+		t.Fatal("invalid id accepted")
+	}
+	if lp.layers[0].At(0, 0).A != 0 {
+		t.Error("read a layer file outside the project")
+	}
+}
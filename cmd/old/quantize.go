@@ -0,0 +1,411 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	rl "github.com/gen2brain/raylib-go/raylib"
+	"github.com/ha1tch/deluxedraw/palette"
+)
+
+// Quantizing: fitting the active layer, or an image file from the command
+// line, onto a palette. The palette is the current colours, a bundled
+// palette or one chosen for the pixels by median cut, octree or k-means.
+// In indexed documents any palette other than the document's own replaces
+// it, and the other layers take their closest colours in it.
+
+// Settings of the quantize dialog, kept between uses
+type QuantizeOptions struct {
+	Fixed   bool   // remap to a fixed palette instead of choosing new colours
+	Palette string // bundled palette to remap to; empty for the current colours
+	Method  palette.Method
+	Colors  int
+	Dither  palette.Dither
+	Metric  palette.Metric
+}
+
+// State of the quantize dialog
+type quantizeDialog struct {
+	box      rl.Rectangle
+	buttons  []Button // METHOD, PALETTE, -, +, DITHER, DISTANCE, APPLY, CANCEL
+	palettes []*palette.Palette
+}
+
+// Open the quantize dialog for the active layer
+func (app *App) OpenQuantize() {
+	q := &quantizeDialog{box: rl.Rectangle{X: screenWidth/2 - 150, Y: screenHeight/2 - 97, Width: 300, Height: 194}, palettes: palette.Collection()}
+	x, y := q.box.X, q.box.Y
+	q.buttons = []Button{
+		{rect: rl.Rectangle{X: x + 100, Y: y + 30, Width: 100, Height: 18}},
+		{rect: rl.Rectangle{X: x + 100, Y: y + 54, Width: 100, Height: 18}},
+		{rect: rl.Rectangle{X: x + 100, Y: y + 78, Width: 18, Height: 18}, text: "-"},
+		{rect: rl.Rectangle{X: x + 182, Y: y + 78, Width: 18, Height: 18}, text: "+"},
+		{rect: rl.Rectangle{X: x + 100, Y: y + 102, Width: 100, Height: 18}},
+		{rect: rl.Rectangle{X: x + 100, Y: y + 126, Width: 100, Height: 18}},
+		{rect: rl.Rectangle{X: x + 120, Y: y + 164, Width: 80, Height: 20}, text: "APPLY"},
+		{rect: rl.Rectangle{X: x + 210, Y: y + 164, Width: 80, Height: 20}, text: "CANCEL"},
+	}
+	app.quantizer = q
+}
+
+// Handle clicks in the quantize dialog
+func (app *App) updateQuantize(mousePos rl.Vector2) {
+	if !rl.IsMouseButtonPressed(rl.MouseLeftButton) {
+		return
+	}
+	q := app.quantizer
+	opts := &app.quantizeOptions
+	step := 1
+	if rl.IsKeyDown(rl.KeyLeftShift) || rl.IsKeyDown(rl.KeyRightShift) {
+		step = 16
+	}
+	for i, btn := range q.buttons {
+		if !rl.CheckCollisionPointRec(mousePos, btn.rect) {
+			continue
+		}
+		switch i {
+		case 0:
+			// A fixed palette, then each method
+			switch {
+			case opts.Fixed:
+				opts.Fixed, opts.Method = false, 0
+			case int(opts.Method) == len(palette.MethodNames)-1:
+				opts.Fixed = true
+			default:
+				opts.Method++
+			}
+		case 1:
+			// The current colours, then each bundled palette
+			if !opts.Fixed {
+				return
+			}
+			next := 0
+			for k, p := range q.palettes {
+				if p.Name == opts.Palette {
+					next = k + 1
+				}
+			}
+			opts.Palette = ""
+			if next < len(q.palettes) {
+				opts.Palette = q.palettes[next].Name
+			}
+		case 2:
+			opts.Colors = max(2, opts.Colors-step)
+		case 3:
+			opts.Colors = min(opts.Colors+step, maxIndexedColors-1)
+		case 4:
+			opts.Dither = (opts.Dither + 1) % palette.Dither(len(palette.DitherNames))
+		case 5:
+			opts.Metric = (opts.Metric + 1) % palette.Metric(len(palette.MetricNames))
+		case 6:
+			app.quantizer = nil
+			n, err := app.QuantizeLayer(*opts)
+			if err != nil {
+				app.SetStatus(fmt.Sprintf("QUANTIZE FAILED: %v", err))
+				return
+			}
+			app.SetStatus(fmt.Sprintf("QUANTIZED %s TO %d COLORS", app.layers[app.activeLayer].name, n))
+		case 7:
+			app.quantizer = nil
+		}
+		return
+	}
+}
+
+// Draw the quantize dialog over the whole window
+func (app *App) drawQuantize() {
+	q := app.quantizer
+	if q == nil {
+		return
+	}
+	opts := app.quantizeOptions
+	mousePos := rl.GetMousePosition()
+	x, y := int32(q.box.X), int32(q.box.Y)
+	rl.DrawRectangle(0, 0, screenWidth, screenHeight, rl.Color{0, 0, 0, 160})
+	rl.DrawRectangleRec(q.box, rl.Color{50, 50, 50, 255})
+	rl.DrawRectangleLinesEx(q.box, 1, rl.Color{90, 90, 90, 255})
+	rl.DrawText("QUANTIZE "+app.layers[app.activeLayer].name, x+10, y+10, fontSize, rl.White)
+	rl.DrawText("METHOD", x+10, y+35, fontSize, rl.LightGray)
+	rl.DrawText("PALETTE", x+10, y+59, fontSize, rl.LightGray)
+	rl.DrawText("COLORS", x+10, y+83, fontSize, rl.LightGray)
+	rl.DrawText("DITHER", x+10, y+107, fontSize, rl.LightGray)
+	rl.DrawText("DISTANCE", x+10, y+131, fontSize, rl.LightGray)
+	if opts.Fixed {
+		n := app.colorCount()
+		if app.palette != nil {
+			n-- // the transparent entry
+		}
+		for _, p := range q.palettes {
+			if p.Name == opts.Palette {
+				n = len(p.Colors)
+			}
+		}
+		rl.DrawText(fmt.Sprintf("%d", n), x+130, y+83, fontSize, rl.Gray)
+	} else {
+		rl.DrawText(fmt.Sprintf("%d", opts.Colors), x+130, y+83, fontSize, rl.White)
+	}
+
+	for i, btn := range q.buttons {
+		switch i {
+		case 0:
+			btn.text = "FIXED"
+			if !opts.Fixed {
+				btn.text = strings.ToUpper(palette.MethodNames[opts.Method])
+			}
+		case 1:
+			if !opts.Fixed {
+				continue
+			}
+			btn.text = "CURRENT COLORS"
+			if opts.Palette != "" {
+				btn.text = strings.ToUpper(opts.Palette)
+			}
+		case 2, 3:
+			if opts.Fixed {
+				continue
+			}
+		case 4:
+			btn.text = strings.ToUpper(palette.DitherNames[opts.Dither])
+		case 5:
+			btn.text = strings.ToUpper(palette.MetricNames[opts.Metric])
+		}
+		drawButton(btn, rl.CheckCollisionPointRec(mousePos, btn.rect))
+	}
+}
+
+// Fit the active layer, within the selection if there is one, onto the
+// palette the options give, and return its number of colours. A palette
+// other than the current colours becomes the current colours, or in an
+// indexed document its palette. Alpha is kept.
+func (app *App) QuantizeLayer(opts QuantizeOptions) (int, error) {
+	layer := app.layers[app.activeLayer]
+	if layer.locked {
+		return 0, fmt.Errorf("layer is locked")
+	}
+	r := app.copyRect()
+	src := cropNRGBA(app.colorStore(layer.Snapshot()).ToNRGBA(), r)
+	current := opts.Fixed && opts.Palette == ""
+
+	var target *palette.Palette
+	switch {
+	case current && app.palette != nil:
+		target = app.palette.asPalette()
+	case current:
+		target = &palette.Palette{}
+		for _, c := range app.colorPalette {
+			if c.A > 0 {
+				target.Colors = append(target.Colors, palette.Color{R: c.R, G: c.G, B: c.B, A: 255})
+			}
+		}
+	case opts.Fixed:
+		p, ok := palette.Bundled(opts.Palette)
+		if !ok {
+			return 0, fmt.Errorf("no palette %s", opts.Palette)
+		}
+		target = &palette.Palette{Name: p.Name}
+		for _, c := range p.Colors {
+			if c.A > 0 {
+				c.A = 255
+				target.Colors = append(target.Colors, c)
+			}
+		}
+	default:
+		var err error
+		if target, err = palette.Quantize(src, opts.Colors, opts.Method, opts.Metric); err != nil {
+			return 0, err
+		}
+	}
+
+	// Indexed documents take the target as their palette, the transparent
+	// entry added to it if need be, and layers are remapped to its entries
+	doc, transparent := app.palette, -1
+	if doc != nil {
+		if !current {
+			var err error
+			if doc, err = dpfDocPalette(paletteDPFColors(target)); err != nil {
+				return 0, err
+			}
+		}
+		target, transparent = doc.asPalette(), doc.transparent
+	}
+	indexed, err := palette.Remap(src, target, palette.RemapOptions{Dither: opts.Dither, Metric: opts.Metric, Transparent: transparent})
+	if err != nil {
+		return 0, err
+	}
+	if doc != app.palette {
+		app.renumberPixels(nearestRenumbering(app.palette, doc), doc.transparent)
+		app.setPalette(doc)
+		app.selectIndex(doc.nearest(color.NRGBA{app.currentColor.R, app.currentColor.G, app.currentColor.B, 255}))
+	}
+
+	app.SaveLayerState(app.activeLayer, "quantize")
+	app.editLayer(layer, func(img *image.NRGBA) {
+		for y := r.Min.Y; y < r.Max.Y; y++ {
+			for x := r.Min.X; x < r.Max.X; x++ {
+				o := img.PixOffset(x, y)
+				if img.Pix[o+3] == 0 {
+					continue
+				}
+				k := int(indexed.ColorIndexAt(x, y))
+				if doc != nil {
+					c := indexPixel(k, transparent)
+					img.Pix[o], img.Pix[o+1], img.Pix[o+2], img.Pix[o+3] = c.R, c.G, c.B, c.A
+					continue
+				}
+				c := target.Colors[k]
+				img.Pix[o], img.Pix[o+1], img.Pix[o+2] = c.R, c.G, c.B
+			}
+		}
+	})
+	if doc == nil && !current {
+		app.colorPalette = nil
+		for _, c := range target.Colors {
+			app.colorPalette = append(app.colorPalette, rl.Color{c.R, c.G, c.B, 255})
+		}
+	}
+	if doc != nil {
+		return len(doc.colors) - 1, nil
+	}
+	return len(target.Colors), nil
+}
+
+// Where each entry of an old palette goes in a new one: to the entry of
+// the closest colour, and the transparent entry to the new transparent one
+func nearestRenumbering(old, palette *docPalette) []int {
+	to := make([]int, len(old.colors))
+	for i, e := range old.colors {
+		to[i] = palette.transparent
+		if i != old.transparent {
+			to[i] = palette.nearest(color.NRGBA{e.color.R, e.color.G, e.color.B, 255})
+		}
+	}
+	return to
+}
+
+// Colours of the palette's entries, the transparent one clear
+func (p *docPalette) asPalette() *palette.Palette {
+	out := &palette.Palette{}
+	for i, c := range p.colors {
+		entry := palette.Color{Name: c.name, R: c.color.R, G: c.color.G, B: c.color.B, A: c.color.A}
+		if i == p.transparent {
+			entry.A = 0
+		}
+		out.Colors = append(out.Colors, entry)
+	}
+	return out
+}
+
+// Stored pixels for an image of palette indices
+func indexedNRGBA(img *image.Paletted, transparent int) *image.NRGBA {
+	out := image.NewNRGBA(img.Rect)
+	for y := img.Rect.Min.Y; y < img.Rect.Max.Y; y++ {
+		for x := img.Rect.Min.X; x < img.Rect.Max.X; x++ {
+			out.SetNRGBA(x, y, indexPixel(int(img.ColorIndexAt(x, y)), transparent))
+		}
+	}
+	return out
+}
+
+// Palette named on the command line: a bundled one, such as deluxe32, or
+// a palette file
+func namedPalette(name string) (*palette.Palette, error) {
+	if p, ok := palette.Bundled(name); ok {
+		return p, nil
+	}
+	if fileFilters[filterDPF].match(name) {
+		dpf, err := loadDPF(name)
+		if err != nil {
+			return nil, err
+		}
+		p := &palette.Palette{}
+		for _, c := range dpf.palette {
+			if c.color.A > 0 {
+				p.Colors = append(p.Colors, palette.Color{Name: c.name, R: c.color.R, G: c.color.G, B: c.color.B, A: 255})
+			}
+		}
+		return p, nil
+	}
+	return palette.Load(name)
+}
+
+// dd quantize: fit an image onto a palette and write it as an indexed PNG
+// or a DPF icon
+func cmdQuantize(args []string) int {
+	flags := flag.NewFlagSet("quantize", flag.ExitOnError)
+	maxColors := flags.Int("max", 16, "most colours to choose, as pngtodpf -max")
+	paletteName := flags.String("palette", "", "fit to this palette instead: a bundled one, such as deluxe32, or a palette file")
+	method := flags.String("method", "median", "how colours are chosen: "+strings.Join(palette.MethodNames, ", "))
+	dither := flags.String("dither", "none", "dithering: "+strings.Join(palette.DitherNames, ", "))
+	distance := flags.String("distance", "oklab", "colour distance: "+strings.Join(palette.MetricNames, ", "))
+	icc := flags.Bool("icc", false, "embed an sRGB ICC profile in PNGs instead of the sRGB chunk")
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: dd "+quantizeUsage)
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	m := slices.Index(palette.MethodNames, *method)
+	d := slices.Index(palette.DitherNames, *dither)
+	metric := slices.Index(palette.MetricNames, *distance)
+	if flags.NArg() != 2 || m < 0 || d < 0 || metric < 0 {
+		flags.Usage()
+		return 2
+	}
+	if *maxColors < 2 || *maxColors > maxIndexedColors-1 {
+		fmt.Fprintf(os.Stderr, "dd: -max must be 2-%d, leaving an entry for transparency\n", maxIndexedColors-1)
+		return 2
+	}
+
+	input, out := flags.Arg(0), flags.Arg(1)
+	data, err := os.ReadFile(input)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "dd: %v\n", err)
+		return 1
+	}
+	img, err := decodeImage(data)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "dd: %s: %v\n", input, err)
+		return 1
+	}
+
+	var target *palette.Palette
+	if *paletteName != "" {
+		target, err = namedPalette(*paletteName)
+	} else {
+		target, err = palette.Quantize(img, *maxColors, palette.Method(m), palette.Metric(metric))
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "dd: %v\n", err)
+		return 1
+	}
+	doc, err := dpfDocPalette(paletteDPFColors(target))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "dd: %v\n", err)
+		return 1
+	}
+	indexed, err := palette.Remap(img, doc.asPalette(), palette.RemapOptions{Dither: palette.Dither(d), Metric: palette.Metric(metric), Transparent: doc.transparent})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "dd: %v\n", err)
+		return 1
+	}
+
+	err = writeFileAtomic(out, func(w io.Writer) error {
+		if !fileFilters[filterDPF].match(out) {
+			return encodeTaggedPNG(w, indexed, *icc)
+		}
+		name := strings.TrimSuffix(filepath.Base(input), filepath.Ext(input))
+		icon := dpfIndexIcon(name, indexedNRGBA(indexed, doc.transparent), doc)
+		return writeDPF(w, name, doc.colors, []*dpfIcon{icon})
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "dd: %s: %v\n", out, err)
+		return 1
+	}
+	return 0
+}
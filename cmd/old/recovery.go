@@ -0,0 +1,805 @@
+package main
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image/png"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"time"
+
+	rl "github.com/gen2brain/raylib-go/raylib"
+)
+
+// Crash recovery. Each running session keeps a directory under the user
+// cache dir, with a subdirectory per open document holding a full
+// checkpoint of it (a .ddd archive with the undo history added) and a
+// journal of tile and layer changes made since. The running editor holds a
+// lock on its session file; a session directory nobody holds the lock of
+// belongs to an editor that did not shut down cleanly, and is offered for
+// restore.
+
+const (
+	autosaveInterval   = 2.0              // seconds between journal updates
+	checkpointInterval = 120.0            // seconds between checkpoints while editing
+	journalLimit       = 8 << 20          // journal bytes that force a checkpoint
+	staleSession       = 30 * time.Second // age after which a session without its PID is abandoned
+
+	sessionFile    = "session"
+	checkpointFile = "checkpoint.ddd"
+	recoveryEntry  = "recovery.json"
+)
+
+// Session state stored in a checkpoint next to project.json
+type recoveryData struct {
+	FilePath     string        `json:"file_path,omitempty"`
+	Journal      int           `json:"journal"` // first journal written after the checkpoint
+	LayerIDs     []int         `json:"layer_ids"`
+	ActiveLayer  int           `json:"active_layer"`
+	Frame        int           `json:"frame,omitempty"` // frame being shown
+	LayerCounter int           `json:"layer_counter"`
+	History      []historyData `json:"history,omitempty"`
+	HistoryIndex int           `json:"history_index"`
+}
+
+// One undo step in a checkpoint
+type historyData struct {
+	Action string    `json:"action"`
+	Layer  int       `json:"layer"`
+	Frame  int       `json:"frame,omitempty"`
+	Tiles  []TileRef `json:"tiles,omitempty"`
+}
+
+// One line of the journal. Records hold whole tile states rather than
+// deltas, so replaying them in order always ends at the last journaled state.
+type journalRecord struct {
+	Op     string         `json:"op"`              // "layers" or "tiles"
+	Layer  int            `json:"layer,omitempty"` // layer ID for "tiles"
+	Frame  int            `json:"frame,omitempty"` // frame for "tiles"
+	Tiles  []journalTile  `json:"tiles,omitempty"`
+	Layers []journalLayer `json:"layers,omitempty"` // the whole stack for "layers"
+	Active int            `json:"active,omitempty"`
+}
+
+type journalTile struct {
+	X   int    `json:"x"`
+	Y   int    `json:"y"`
+	PNG []byte `json:"png,omitempty"` // empty: tile cleared
+}
+
+type journalLayer struct {
+	Layer  int `json:"layer"`
+	Source int `json:"source,omitempty"` // layer it was duplicated from, when new
+	LayerData
+}
+
+// A layer's cel in one frame, by layer ID
+type celKey struct {
+	layer int
+	frame int
+}
+
+// The session file is locked by a running editor
+var errSessionLocked = errors.New("session in use")
+
+// Recovery state of the running session
+type recovery struct {
+	dir      string
+	lock     *os.File // session file, locked while the session runs
+	lastTick float64
+	nextDoc  int // number of the next document directory
+
+	// Crashed session offered for restore, locked while it is offered
+	prompt        string
+	promptLock    *os.File
+	promptTime    time.Time
+	promptButtons []Button
+}
+
+// Recovery state of one document
+type docRecovery struct {
+	dir         string
+	journal     *os.File
+	journalSeq  int
+	journalSize int64
+	lastSave    float64
+	changed     bool // journal has records not yet covered by a checkpoint
+
+	// Document as of the last journal record or checkpoint
+	width   int
+	height  int
+	active  int
+	stack   []journalLayer
+	layout  []int // frameLayout
+	palette *docPalette
+	stores  map[celKey]*TileStore
+
+	// Running checkpoint, and journals it makes obsolete
+	checkpoint chan error
+	obsolete   []string
+}
+
+// Directory holding all session directories
+func recoveryRoot() (string, error) {
+	cache, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(cache, "deluxedraw", "recovery"), nil
+}
+
+func journalName(seq int) string {
+	return fmt.Sprintf("journal-%06d.log", seq)
+}
+
+// Directories of a session holding checkpoints, one per document
+func sessionCheckpoints(dir string) []string {
+	var dirs []string
+	names, _ := filepath.Glob(filepath.Join(dir, "doc-*", checkpointFile))
+	sort.Strings(names)
+	for _, name := range names {
+		dirs = append(dirs, filepath.Dir(name))
+	}
+	return dirs
+}
+
+// Find the newest session left behind by an editor that did not exit
+// cleanly, and lock it. Crashed sessions without a checkpoint are removed.
+func findCrashedSession(root string) (string, time.Time, *os.File) {
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		return "", time.Time{}, nil
+	}
+
+	var found string
+	var foundTime time.Time
+	var foundLock *os.File
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		dir := filepath.Join(root, entry.Name())
+		// Sessions write their PID once they hold the lock, so one without
+		// it may be starting up
+		starting := false
+		if info, err := entry.Info(); err == nil && time.Since(info.ModTime()) < staleSession {
+			starting = true
+		}
+		lock, err := lockSessionFile(filepath.Join(dir, sessionFile), false)
+		if errors.Is(err, os.ErrNotExist) && !starting {
+			os.RemoveAll(dir)
+			continue
+		}
+		if err != nil {
+			continue // still running
+		}
+		if info, err := lock.Stat(); err == nil && info.Size() == 0 && starting {
+			lock.Close()
+			continue
+		}
+		checkpoints := sessionCheckpoints(dir)
+		if len(checkpoints) == 0 {
+			removeSession(dir, lock)
+			continue
+		}
+		newer := false
+		for _, cp := range checkpoints {
+			info, err := os.Stat(filepath.Join(cp, checkpointFile))
+			if err == nil && (found == "" || info.ModTime().After(foundTime)) {
+				found, foundTime, newer = dir, info.ModTime(), true
+			}
+		}
+		if !newer {
+			lock.Close()
+			continue
+		}
+		if foundLock != nil {
+			foundLock.Close()
+		}
+		foundLock = lock
+	}
+	return found, foundTime, foundLock
+}
+
+// Delete a session directory and release its lock
+func removeSession(dir string, lock *os.File) {
+	// Windows can't delete the session file while it is open
+	lock.Close()
+	os.RemoveAll(dir)
+}
+
+// Start crash recovery for this session. Recovery is disabled, with a
+// status message, if the session directory cannot be created.
+func (app *App) StartRecovery() {
+	root, err := recoveryRoot()
+	if err == nil {
+		err = os.MkdirAll(root, 0o755)
+	}
+	if err != nil {
+		app.SetStatus(fmt.Sprintf("AUTOSAVE DISABLED: %v", err))
+		return
+	}
+
+	r := &recovery{dir: filepath.Join(root, fmt.Sprintf("%d-%d", os.Getpid(), time.Now().UnixNano()))}
+	if err := os.MkdirAll(r.dir, 0o755); err != nil {
+		app.SetStatus(fmt.Sprintf("AUTOSAVE DISABLED: %v", err))
+		return
+	}
+	r.lock, err = lockSessionFile(filepath.Join(r.dir, sessionFile), true)
+	if err == nil {
+		_, err = fmt.Fprintf(r.lock, "%d\n", os.Getpid())
+	}
+	if err != nil {
+		app.SetStatus(fmt.Sprintf("AUTOSAVE DISABLED: %v", err))
+		if r.lock != nil {
+			r.lock.Close()
+		}
+		os.RemoveAll(r.dir)
+		return
+	}
+	r.prompt, r.promptTime, r.promptLock = findCrashedSession(root)
+
+	if r.prompt != "" {
+		x := float32(screenWidth/2 - 100)
+		y := float32(screenHeight/2 + 10)
+		r.promptButtons = []Button{
+			{rect: rl.Rectangle{X: x, Y: y, Width: 90, Height: 30}, text: "RESTORE"},
+			{rect: rl.Rectangle{X: x + 110, Y: y, Width: 90, Height: 30}, text: "DISCARD"},
+		}
+	}
+
+	app.recovery = r
+	for _, doc := range app.documents {
+		app.startCheckpoint(doc)
+	}
+}
+
+// End the session cleanly; the recovery files are no longer needed
+func (app *App) StopRecovery() {
+	r := app.recovery
+	if r == nil {
+		return
+	}
+	for _, doc := range app.documents {
+		app.dropRecovery(doc)
+	}
+	if r.promptLock != nil {
+		r.promptLock.Close()
+	}
+	removeSession(r.dir, r.lock)
+	app.recovery = nil
+}
+
+// Remove the recovery files of a document that is being closed
+func (app *App) dropRecovery(doc *Document) {
+	dr := doc.autosave
+	if dr == nil {
+		return
+	}
+	if dr.checkpoint != nil {
+		<-dr.checkpoint
+	}
+	if dr.journal != nil {
+		dr.journal.Close()
+	}
+	os.RemoveAll(dr.dir)
+	doc.autosave = nil
+}
+
+// Journal recent changes and take checkpoints as needed; call once per frame
+func (app *App) updateRecovery() {
+	r := app.recovery
+	if r == nil {
+		return
+	}
+
+	// Finish running checkpoints
+	for _, doc := range app.documents {
+		dr := doc.autosave
+		if dr == nil || dr.checkpoint == nil {
+			continue
+		}
+		select {
+		case err := <-dr.checkpoint:
+			dr.checkpoint = nil
+			if err != nil {
+				app.SetStatus(fmt.Sprintf("AUTOSAVE FAILED: %v", err))
+				dr.changed = true
+				break
+			}
+			for _, name := range dr.obsolete {
+				os.Remove(name)
+			}
+			dr.obsolete = nil
+		default:
+		}
+	}
+
+	now := rl.GetTime()
+	if now-r.lastTick < autosaveInterval {
+		return
+	}
+	r.lastTick = now
+
+	for _, doc := range app.documents {
+		// Strokes are journaled once finished
+		if doc == app.Document && app.isDrawing {
+			continue
+		}
+		dr := doc.autosave
+		if dr == nil {
+			app.startCheckpoint(doc)
+			continue
+		}
+
+		full, err := app.journalChanges(doc)
+		if err != nil {
+			app.SetStatus(fmt.Sprintf("AUTOSAVE FAILED: %v", err))
+			full = true
+		}
+		if dr.journal != nil {
+			dr.journal.Sync()
+		}
+		if full || dr.changed && (now-dr.lastSave >= checkpointInterval || dr.journalSize >= journalLimit) {
+			app.startCheckpoint(doc)
+		}
+	}
+}
+
+// Layer stack as journaled
+func (d *Document) journalStack() []journalLayer {
+	stack := make([]journalLayer, len(d.layers))
+	for i, layer := range d.layers {
+		stack[i] = journalLayer{Layer: layer.id, LayerData: layer.Data()}
+	}
+	return stack
+}
+
+// Append a document's changes since its last record to its journal.
+// Reports full when the changes cannot be journaled (new canvas, changed
+// frames or palette, or a loaded document) and a checkpoint is needed
+// instead.
+func (app *App) journalChanges(doc *Document) (full bool, err error) {
+	r := doc.autosave
+	if r == nil || r.journal == nil || doc.canvasWidth != r.width || doc.canvasHeight != r.height {
+		return true, nil
+	}
+	if !reflect.DeepEqual(doc.frameLayout(), r.layout) || !doc.palette.equal(r.palette) {
+		return true, nil
+	}
+
+	// Layer stack and properties
+	stack := doc.journalStack()
+	if !reflect.DeepEqual(stack, r.stack) || doc.activeLayer != r.active {
+		for i, layer := range doc.layers {
+			if _, ok := r.stores[celKey{layer.id, 0}]; ok {
+				continue
+			}
+			if _, ok := r.stores[celKey{layer.source, 0}]; ok && layer.source != 0 {
+				stack[i].Source = layer.source
+				for f := range doc.frames {
+					r.stores[celKey{layer.id, f}] = r.stores[celKey{layer.source, f}]
+				}
+			} else if layer.tiles.TileCount() > 0 || len(layer.cels) > 0 {
+				// Content from outside the session, e.g. a loaded file
+				return true, nil
+			} else {
+				for f := range doc.frames {
+					r.stores[celKey{layer.id, f}] = NewTileStore(doc.canvasWidth, doc.canvasHeight)
+				}
+			}
+		}
+		if err := r.write(journalRecord{Op: "layers", Layers: stack, Active: doc.activeLayer}); err != nil {
+			return false, err
+		}
+		for i := range stack {
+			stack[i].Source = 0
+		}
+		r.stack = stack
+		r.active = doc.activeLayer
+	}
+
+	// Changed tiles of every frame, found by comparing the shared tile
+	// pointers. Frames other than the one shown may have been painted
+	// before switching away. Linked frames share a cel and one record.
+	live := make(map[int]bool)
+	for _, layer := range doc.layers {
+		live[layer.id] = true
+		shown := layer.cel(doc.frame)
+		done := make(map[*Cel]bool)
+		for f := range doc.frames {
+			c := layer.cel(f)
+			if c != nil && done[c] {
+				continue
+			}
+			done[c] = c != nil
+			base := r.stores[celKey{layer.id, f}]
+			if base == nil {
+				return true, nil
+			}
+			var store *TileStore
+			switch {
+			case f == doc.frame || c != nil && c == shown:
+				store = layer.tiles
+			case c != nil:
+				store = c.tiles
+			default:
+				store = NewTileStore(doc.canvasWidth, doc.canvasHeight)
+			}
+			var tiles []journalTile
+			for ty := 0; ty < store.tilesY; ty++ {
+				for tx := 0; tx < store.tilesX; tx++ {
+					t := store.Tile(tx, ty)
+					if t == base.Tile(tx, ty) {
+						continue
+					}
+					jt := journalTile{X: tx, Y: ty}
+					if t != nil {
+						var buf bytes.Buffer
+						if err := png.Encode(&buf, tileImage(t)); err != nil {
+							return false, err
+						}
+						jt.PNG = buf.Bytes()
+					}
+					tiles = append(tiles, jt)
+				}
+			}
+			if len(tiles) == 0 {
+				continue
+			}
+			if err := r.write(journalRecord{Op: "tiles", Layer: layer.id, Frame: f, Tiles: tiles}); err != nil {
+				return false, err
+			}
+
+			// Replaying the record changes linked frames too
+			base = store.Clone()
+			for g := range doc.frames {
+				if g == f || c != nil && layer.cel(g) == c {
+					r.stores[celKey{layer.id, g}] = base
+				}
+			}
+		}
+	}
+	for key := range r.stores {
+		if !live[key.layer] {
+			delete(r.stores, key)
+		}
+	}
+
+	return false, nil
+}
+
+// Append one record to the journal
+func (r *docRecovery) write(rec journalRecord) error {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	n, err := r.journal.Write(append(data, '\n'))
+	r.journalSize += int64(n)
+	r.changed = true
+	return err
+}
+
+// Capture a whole document and write it as a checkpoint in the
+// background. A new journal is started; the old ones are removed once the
+// checkpoint is safely on disk.
+func (app *App) startCheckpoint(doc *Document) {
+	if app.recovery == nil {
+		return
+	}
+	r := doc.autosave
+	if r == nil {
+		r = &docRecovery{dir: filepath.Join(app.recovery.dir, fmt.Sprintf("doc-%06d", app.recovery.nextDoc))}
+		app.recovery.nextDoc++
+		if err := os.MkdirAll(r.dir, 0o755); err != nil {
+			app.SetStatus(fmt.Sprintf("AUTOSAVE FAILED: %v", err))
+			return
+		}
+		doc.autosave = r
+	}
+	if r.checkpoint != nil {
+		return
+	}
+
+	// Start the next journal, bringing the current one up to date first
+	// so it stays complete should this checkpoint fail
+	if r.journal != nil {
+		if _, err := app.journalChanges(doc); err != nil {
+			app.SetStatus(fmt.Sprintf("AUTOSAVE FAILED: %v", err))
+		}
+		r.journal.Sync()
+		r.journal.Close()
+		r.obsolete = append(r.obsolete, r.journal.Name())
+		r.journal = nil
+	}
+	r.journalSeq++
+	journal, err := os.OpenFile(filepath.Join(r.dir, journalName(r.journalSeq)), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		app.SetStatus(fmt.Sprintf("AUTOSAVE FAILED: %v", err))
+		return
+	}
+	r.journal = journal
+	r.journalSize = 0
+
+	snap := app.snapshotProject(doc)
+	snap.recovery = &recoveryData{
+		FilePath:     doc.currentFilePath,
+		Journal:      r.journalSeq,
+		ActiveLayer:  doc.activeLayer,
+		Frame:        doc.frame,
+		LayerCounter: doc.layerCounter,
+		HistoryIndex: doc.historyIndex,
+	}
+	for _, layer := range doc.layers {
+		snap.recovery.LayerIDs = append(snap.recovery.LayerIDs, layer.id)
+	}
+	for _, action := range doc.history {
+		snap.recovery.History = append(snap.recovery.History, historyData{Action: action.actionType, Layer: action.layerIndex, Frame: action.frame})
+		snap.history = append(snap.history, action.layerData)
+	}
+
+	// The journal continues from the checkpointed state
+	r.width, r.height = doc.canvasWidth, doc.canvasHeight
+	r.active = doc.activeLayer
+	r.stack = doc.journalStack()
+	r.layout = doc.frameLayout()
+	r.palette = doc.palette.clone()
+	r.stores = make(map[celKey]*TileStore)
+	for i, layer := range doc.layers {
+		for f, store := range snap.cels[i] {
+			if store == nil {
+				store = NewTileStore(doc.canvasWidth, doc.canvasHeight)
+			}
+			r.stores[celKey{layer.id, f}] = store
+		}
+	}
+	r.changed = false
+	r.lastSave = rl.GetTime()
+
+	done := make(chan error, 1)
+	r.checkpoint = done
+	filename := filepath.Join(r.dir, checkpointFile)
+	go func() {
+		done <- writeFileAtomic(filename, func(w io.Writer) error {
+			return writeProjectArchive(w, snap, nil)
+		})
+	}()
+}
+
+// Restore the documents of a crashed session, each in its own tab
+func (app *App) RestoreSession(dir string) error {
+	var firstErr error
+	for _, docDir := range sessionCheckpoints(dir) {
+		if err := app.restoreDocument(docDir); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// Restore a document, its undo history and journaled changes
+func (app *App) restoreDocument(dir string) error {
+	reader, err := zip.OpenReader(filepath.Join(dir, checkpointFile))
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	lp, err := readProjectArchive(&reader.Reader)
+	if err != nil {
+		return err
+	}
+	recFile := lp.files[recoveryEntry]
+	if recFile == nil {
+		return fmt.Errorf("%s not found in checkpoint", recoveryEntry)
+	}
+	rc, err := recFile.Open()
+	if err != nil {
+		return err
+	}
+	var rec recoveryData
+	err = json.NewDecoder(rc).Decode(&rec)
+	rc.Close()
+	if err != nil {
+		return err
+	}
+	if len(rec.LayerIDs) != len(lp.layers) {
+		return fmt.Errorf("checkpoint has %d layers but %d layer IDs", len(lp.layers), len(rec.LayerIDs))
+	}
+
+	// Replay journals written after the checkpoint, oldest first
+	names, _ := filepath.Glob(filepath.Join(dir, "journal-*.log"))
+	sort.Strings(names)
+	ids := rec.LayerIDs
+	active := rec.ActiveLayer
+	for _, name := range names {
+		var seq int
+		fmt.Sscanf(filepath.Base(name), "journal-%d.log", &seq)
+		if seq < rec.Journal {
+			continue
+		}
+		if err := replayJournal(lp, &ids, &active, name); err != nil {
+			return err
+		}
+	}
+
+	// Restored work is unsaved, even if it matches its file
+	app.openDocument()
+	app.applyProject(lp)
+	app.currentFilePath = rec.FilePath
+	app.saved = nil
+	app.layerCounter = max(app.layerCounter, rec.LayerCounter)
+	if active >= 0 && active < len(app.layers) {
+		app.activeLayer = active
+	}
+	app.SetFrame(rec.Frame)
+
+	// Restore undo history
+	for _, h := range rec.History {
+		store := NewTileStore(app.canvasWidth, app.canvasHeight)
+		lp.tiles.load(store, h.Tiles)
+		app.history = append(app.history, HistoryAction{actionType: h.Action, layerIndex: h.Layer, frame: h.Frame, layerData: store})
+	}
+	app.historyIndex = -1
+	if rec.HistoryIndex < len(app.history) {
+		app.historyIndex = max(rec.HistoryIndex, -1)
+	}
+
+	return nil
+}
+
+// Apply the records of one journal file to a loaded checkpoint. A torn
+// last line, from a crash mid-write, ends the replay.
+func replayJournal(lp *loadedProject, ids *[]int, active *int, name string) error {
+	file, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	in := bufio.NewReader(file)
+	for {
+		line, err := in.ReadBytes('\n')
+		if err != nil {
+			return nil
+		}
+		var rec journalRecord
+		if json.Unmarshal(line, &rec) != nil {
+			return nil
+		}
+
+		switch rec.Op {
+		case "layers":
+			stores := make(map[int][]*TileStore)
+			for i, id := range *ids {
+				stores[id] = lp.cels[i]
+			}
+			var layers []*TileStore
+			var cels [][]*TileStore
+			var data []LayerData
+			var newIDs []int
+			for _, jl := range rec.Layers {
+				frames, ok := stores[jl.Layer]
+				if !ok {
+					frames = make([]*TileStore, max(1, len(lp.project.Frames)))
+					if source, found := stores[jl.Source]; found && jl.Source != 0 {
+						// Copies of the source's cels, linked the same way
+						copies := make(map[*TileStore]*TileStore)
+						for f, store := range source {
+							if store != nil && copies[store] == nil {
+								copies[store] = store.Clone()
+							}
+							frames[f] = copies[store]
+						}
+					} else {
+						frames[0] = NewTileStore(lp.project.CanvasWidth, lp.project.CanvasHeight)
+					}
+				}
+				layers = append(layers, frames[0])
+				cels = append(cels, frames)
+				data = append(data, jl.LayerData)
+				newIDs = append(newIDs, jl.Layer)
+			}
+			lp.layers = layers
+			lp.cels = cels
+			lp.project.Layers = data
+			*ids = newIDs
+			*active = rec.Active
+
+		case "tiles":
+			idx := -1
+			for i, id := range *ids {
+				if id == rec.Layer {
+					idx = i
+				}
+			}
+			if idx < 0 || rec.Frame < 0 || rec.Frame >= len(lp.cels[idx]) {
+				continue
+			}
+			store := lp.cels[idx][rec.Frame]
+			if store == nil {
+				store = NewTileStore(lp.project.CanvasWidth, lp.project.CanvasHeight)
+				lp.cels[idx][rec.Frame] = store
+			}
+			for _, jt := range rec.Tiles {
+				if len(jt.PNG) == 0 {
+					store.PutTile(jt.X, jt.Y, nil)
+					continue
+				}
+				tile, err := decodeTile(bytes.NewReader(jt.PNG))
+				if err != nil {
+					return fmt.Errorf("%s: %v", filepath.Base(name), err)
+				}
+				store.PutTile(jt.X, jt.Y, tile)
+			}
+		}
+	}
+}
+
+// Handle the restore prompt; it takes all input while shown
+func (app *App) updateRecoveryPrompt(mousePos rl.Vector2) {
+	r := app.recovery
+	if !rl.IsMouseButtonPressed(rl.MouseLeftButton) {
+		return
+	}
+	for i, btn := range r.promptButtons {
+		if !rl.CheckCollisionPointRec(mousePos, btn.rect) {
+			continue
+		}
+		if i == 0 {
+			if err := app.RestoreSession(r.prompt); err != nil {
+				app.SetStatus(fmt.Sprintf("RESTORE FAILED: %v", err))
+				return
+			}
+			app.SetStatus("RESTORED UNSAVED WORK")
+			for _, doc := range app.documents {
+				app.startCheckpoint(doc)
+			}
+		}
+		removeSession(r.prompt, r.promptLock)
+		r.prompt, r.promptLock = "", nil
+		return
+	}
+}
+
+// Draw the restore prompt over the whole window
+func (app *App) drawRecoveryPrompt() {
+	r := app.recovery
+	if r == nil || r.prompt == "" {
+		return
+	}
+	mousePos := rl.GetMousePosition()
+
+	rl.DrawRectangle(0, 0, screenWidth, screenHeight, rl.Color{0, 0, 0, 160})
+	box := rl.Rectangle{X: screenWidth/2 - 220, Y: screenHeight/2 - 60, Width: 440, Height: 110}
+	rl.DrawRectangleRec(box, rl.Color{50, 50, 50, 255})
+	rl.DrawRectangleLinesEx(box, 1, rl.Color{90, 90, 90, 255})
+
+	lines := []string{
+		"THE EDITOR DID NOT SHUT DOWN CLEANLY.",
+		"RESTORE UNSAVED WORK AUTOSAVED " + r.promptTime.Format("2006-01-02 15:04") + "?",
+	}
+	for i, line := range lines {
+		rl.DrawText(line, int32(box.X)+12, int32(box.Y)+12+int32(i)*14, fontSize, rl.White)
+	}
+
+	for _, btn := range r.promptButtons {
+		color := rl.Color{70, 70, 70, 255}
+		if rl.CheckCollisionPointRec(mousePos, btn.rect) {
+			color = rl.Color{80, 80, 80, 255}
+		}
+		rl.DrawRectangleRec(btn.rect, color)
+		rl.DrawRectangleLinesEx(btn.rect, 1, rl.Color{90, 90, 90, 255})
+
+		textW := rl.MeasureText(btn.text, fontSize)
+		rl.DrawText(btn.text, int32(btn.rect.X+btn.rect.Width/2-float32(textW)/2), int32(btn.rect.Y+btn.rect.Height/2-4), fontSize, rl.White)
+	}
+}
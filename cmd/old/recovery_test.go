@@ -0,0 +1,157 @@
+package main
+
+import (
+	"image/color"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	rl "github.com/gen2brain/raylib-go/raylib"
+)
+
+// Create a session directory with a session file and, if checkpoint is
+// set, one document checkpoint, all last modified at mtime
+func testSession(t *testing.T, root, name string, checkpoint bool, mtime time.Time) string {
+	dir := filepath.Join(root, name)
+	files := []string{filepath.Join(dir, sessionFile)}
+	if checkpoint {
+		files = append(files, filepath.Join(dir, "doc-0", checkpointFile))
+	}
+	for _, f := range files {
+		if err := os.MkdirAll(filepath.Dir(f), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(f, []byte("1\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		os.Chtimes(f, mtime, mtime)
+	}
+	os.Chtimes(dir, mtime, mtime)
+	return dir
+}
+
+func TestFindCrashedSession(t *testing.T) {
+	root := t.TempDir()
+	old := time.Now().Add(-time.Hour)
+
+	// A live session stays put however long ago it last wrote anything
+	live := testSession(t, root, "live", true, time.Now())
+	lock, err := lockSessionFile(filepath.Join(live, sessionFile), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lock.Close()
+	os.Chtimes(filepath.Join(live, "doc-0", checkpointFile), time.Now(), time.Now())
+	os.Chtimes(live, old, old)
+
+	crashed := testSession(t, root, "crashed", true, old)
+	older := testSession(t, root, "older", true, old.Add(-time.Hour))
+	empty := testSession(t, root, "empty", false, old)
+
+	dir, _, found := findCrashedSession(root)
+	if dir != crashed {
+		t.Fatalf("found %q, want %q", dir, crashed)
+	}
+	if found == nil {
+		t.Fatal("crashed session not locked")
+	}
+	if _, err := lockSessionFile(filepath.Join(crashed, sessionFile), false); err != errSessionLocked {
+		t.Errorf("locking the offered session: %v, want %v", err, errSessionLocked)
+	}
+	removeSession(dir, found)
+
+	for _, d := range []string{live, older} {
+		if _, err := os.Stat(d); err != nil {
+			t.Errorf("%s removed: %v", filepath.Base(d), err)
+		}
+	}
+	for _, d := range []string{crashed, empty} {
+		if _, err := os.Stat(d); !os.IsNotExist(err) {
+			t.Errorf("%s not removed", filepath.Base(d))
+		}
+	}
+}
+
+// A tile of one colour
+func testTile(c color.NRGBA) *Tile {
+	pix := make([]uint8, tileSize*tileSize*4)
+	for i := 0; i < len(pix); i += 4 {
+		pix[i], pix[i+1], pix[i+2], pix[i+3] = c.R, c.G, c.B, c.A
+	}
+	return &Tile{pix: pix}
+}
+
+func TestJournalOtherFrames(t *testing.T) {
+	// A one-layer, two-frame document just checkpointed, blank
+	w, h := 2*tileSize, tileSize
+	layer := &Layer{id: 1, uid: "bg", visible: true, opacity: 1, tiles: NewTileStore(w, h)}
+	doc := &Document{
+		layers:       []*Layer{layer},
+		canvasWidth:  w,
+		canvasHeight: h,
+		frames:       []Frame{{100}, {100}},
+		compositor:   &Compositor{layerDirty: make(map[*Layer]rl.Rectangle)},
+	}
+	name := filepath.Join(t.TempDir(), journalName(1))
+	journal, err := os.Create(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer journal.Close()
+	doc.autosave = &docRecovery{
+		journal: journal,
+		width:   w,
+		height:  h,
+		stack:   doc.journalStack(),
+		layout:  doc.frameLayout(),
+		stores:  map[celKey]*TileStore{{1, 0}: NewTileStore(w, h), {1, 1}: NewTileStore(w, h)},
+	}
+	app := &App{Document: doc, documents: []*Document{doc}}
+
+	// Paint frame 0, then frame 1, without a journal update between
+	red, blue := color.NRGBA{255, 0, 0, 255}, color.NRGBA{0, 0, 255, 255}
+	layer.tiles.PutTile(0, 0, testTile(red))
+	doc.SetFrame(1)
+	layer.tiles.PutTile(1, 0, testTile(blue))
+	if full, err := app.journalChanges(doc); full || err != nil {
+		t.Fatalf("journaling: full %v, error %v", full, err)
+	}
+	if !doc.autosave.changed {
+		t.Error("journal not marked changed")
+	}
+	// Nothing has changed since
+	size := doc.autosave.journalSize
+	if _, err := app.journalChanges(doc); err != nil || doc.autosave.journalSize != size {
+		t.Errorf("unchanged document journaled again: %v", err)
+	}
+
+	// Replay onto the checkpoint
+	lp := &loadedProject{
+		project: ProjectData{CanvasWidth: w, CanvasHeight: h, Frames: []FrameData{{100}, {100}}, Layers: []LayerData{layer.Data()}},
+		layers:  []*TileStore{NewTileStore(w, h)},
+	}
+	lp.cels = [][]*TileStore{{lp.layers[0], nil}}
+	ids, active := []int{1}, 0
+	if err := replayJournal(lp, &ids, &active, name); err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		frame, x int
+		want     color.RGBA
+	}{
+		{0, 0, color.RGBA{255, 0, 0, 255}},
+		{0, tileSize, color.RGBA{}},
+		{1, 0, color.RGBA{}},
+		{1, tileSize, color.RGBA{0, 0, 255, 255}},
+	} {
+		store := lp.cels[0][tc.frame]
+		if store == nil {
+			t.Errorf("frame %d not restored", tc.frame)
+			continue
+		}
+		if got := store.At(tc.x, 0); got != tc.want {
+			t.Errorf("frame %d at x %d: %v, want %v", tc.frame, tc.x, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,33 @@
+//go:build windows
+
+package main
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+const errorSharingViolation syscall.Errno = 32
+
+// Open a session file and lock it for as long as it stays open, creating
+// it if create is set. The handle is opened without sharing, so it fails
+// with errSessionLocked while another process has it open.
+func lockSessionFile(name string, create bool) (*os.File, error) {
+	path, err := syscall.UTF16PtrFromString(name)
+	if err != nil {
+		return nil, err
+	}
+	var disposition uint32 = syscall.OPEN_EXISTING
+	if create {
+		disposition = syscall.CREATE_ALWAYS
+	}
+	h, err := syscall.CreateFile(path, syscall.GENERIC_READ|syscall.GENERIC_WRITE, 0, nil, disposition, syscall.FILE_ATTRIBUTE_NORMAL, 0)
+	if err != nil {
+		if errors.Is(err, errorSharingViolation) {
+			return nil, errSessionLocked
+		}
+		return nil, &os.PathError{Op: "open", Path: name, Err: err}
+	}
+	return os.NewFile(uintptr(h), name), nil
+}
@@ -0,0 +1,365 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+
+	rl "github.com/gen2brain/raylib-go/raylib"
+)
+
+// How long status messages stay on screen, in seconds
+const statusDuration = 4.0
+
+// Project state captured on the UI thread, ready to be encoded elsewhere.
+// Layer stores are clones, so later painting does not affect the save.
+type projectSnapshot struct {
+	project ProjectData
+	layers  []*TileStore   // first frame
+	cels    [][]*TileStore // by layer and frame; nil for a single frame
+
+	metadata ProjectMetadata
+
+	// Session state for recovery checkpoints; nil for normal saves
+	recovery *recoveryData
+	history  []*TileStore
+}
+
+// A save running on a worker goroutine
+type saveJob struct {
+	doc      *Document
+	state    *savedState // layers as saved, once the save succeeds
+	filename string
+	done     atomic.Int64
+	total    atomic.Int64
+	result   chan error
+}
+
+// Capture a document, with its palette or the shared one, for saving
+func (app *App) snapshotProject(doc *Document) *projectSnapshot {
+	snap := &projectSnapshot{
+		project: ProjectData{
+			FormatVersion: formatVersion,
+			CanvasWidth:   doc.canvasWidth,
+			CanvasHeight:  doc.canvasHeight,
+			TileSize:      tileSize,
+			Layers:        make([]LayerData, len(doc.layers)),
+			Frames:        doc.frameData(),
+			Compositing:   doc.compositing.data(),
+		},
+		layers: make([]*TileStore, len(doc.layers)),
+		cels:   doc.celStores((*Layer).Snapshot),
+	}
+
+	// Fill layer data
+	for i, layer := range doc.layers {
+		snap.layers[i] = snap.cels[i][0]
+		snap.project.Layers[i] = layer.Data()
+	}
+
+	// Stamp metadata
+	snap.metadata = doc.metadata.forSave()
+	doc.metadata.Created = snap.metadata.Created
+
+	// Fill palette data; an indexed document saves its own
+	if doc.palette != nil {
+		doc.palette.save(&snap.project)
+		return snap
+	}
+	snap.project.Palette = make([]ColorData, len(app.colorPalette))
+	for i, color := range app.colorPalette {
+		snap.project.Palette[i] = ColorData{
+			R: color.R,
+			G: color.G,
+			B: color.B,
+			A: color.A,
+		}
+	}
+
+	return snap
+}
+
+// Composite preview of a snapshot
+func snapshotThumbnail(snap *projectSnapshot) *image.NRGBA {
+	layers := compositeLayers(snap.project.Layers, snap.layers, projectColors(snap.project), projectCompositing(snap.project))
+	return compositeThumbnail(layers, snap.project.CanvasWidth, snap.project.CanvasHeight, thumbnailSize)
+}
+
+// Encode a snapshot as a .ddd archive. progress, if set, is called after
+// each entry with the number of entries written and the total.
+func writeProjectArchive(w io.Writer, snap *projectSnapshot, progress func(done, total int)) error {
+	project := snap.project
+	project.Layers = append([]LayerData(nil), snap.project.Layers...)
+
+	// Collect tiles; identical tiles (e.g. in duplicated layers) are stored once
+	var tiles []savedTile
+	ids := make(map[*Tile]string)
+	for i, store := range snap.layers {
+		refs, layerTiles := tileRefs(store, ids)
+		project.Layers[i].Tiles = refs
+		tiles = append(tiles, layerTiles...)
+		if i < len(snap.cels) {
+			project.Layers[i].Cels, _ = layerCels(snap.cels[i], func(frame int, store *TileStore) ([]TileRef, error) {
+				refs, celTiles := tileRefs(store, ids)
+				tiles = append(tiles, celTiles...)
+				return refs, nil
+			})
+		}
+	}
+	var recovery *recoveryData
+	if snap.recovery != nil {
+		rec := *snap.recovery
+		rec.History = append([]historyData(nil), snap.recovery.History...)
+		for i, store := range snap.history {
+			refs, historyTiles := tileRefs(store, ids)
+			rec.History[i].Tiles = refs
+			tiles = append(tiles, historyTiles...)
+		}
+		recovery = &rec
+	}
+	written := make(map[string]bool)
+	unique := tiles[:0]
+	for _, tile := range tiles {
+		if !written[tile.id] {
+			written[tile.id] = true
+			unique = append(unique, tile)
+		}
+	}
+	total := len(unique) + 3
+	if recovery != nil {
+		total++
+	}
+	report := func(done int) {
+		if progress != nil {
+			progress(done, total)
+		}
+	}
+
+	zipWriter := zip.NewWriter(w)
+
+	// Save project.json
+	jsonData, err := json.MarshalIndent(project, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	jsonFile, err := zipWriter.Create("project.json")
+	if err != nil {
+		return err
+	}
+	_, err = jsonFile.Write(jsonData)
+	if err != nil {
+		return err
+	}
+	report(1)
+
+	// Save metadata and thumbnail
+	jsonData, err = json.MarshalIndent(snap.metadata, "", "  ")
+	if err != nil {
+		return err
+	}
+	jsonFile, err = zipWriter.Create(metadataEntry)
+	if err != nil {
+		return err
+	}
+	_, err = jsonFile.Write(jsonData)
+	if err != nil {
+		return err
+	}
+	report(2)
+
+	thumbFile, err := zipWriter.Create(thumbnailEntry)
+	if err != nil {
+		return err
+	}
+	err = png.Encode(thumbFile, snapshotThumbnail(snap))
+	if err != nil {
+		return err
+	}
+	report(3)
+
+	// Save session state
+	if recovery != nil {
+		jsonData, err = json.MarshalIndent(recovery, "", "  ")
+		if err != nil {
+			return err
+		}
+		jsonFile, err = zipWriter.Create(recoveryEntry)
+		if err != nil {
+			return err
+		}
+		_, err = jsonFile.Write(jsonData)
+		if err != nil {
+			return err
+		}
+		report(4)
+	}
+
+	// Save layer tiles
+	headers := total - len(unique)
+	for i, tile := range unique {
+		pngFile, err := zipWriter.Create("tiles/" + tile.id + ".png")
+		if err != nil {
+			return err
+		}
+
+		err = png.Encode(pngFile, tileImage(tile.tile))
+		if err != nil {
+			return err
+		}
+		report(headers + i + 1)
+	}
+
+	return zipWriter.Close()
+}
+
+// Write a file atomically: data goes to a temporary file in the same
+// directory, which is synced and renamed over filename only once complete.
+// On failure the previous file is left untouched.
+func writeFileAtomic(filename string, write func(io.Writer) error) (err error) {
+	dir := filepath.Dir(filename)
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(filename)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			tmp.Close()
+			os.Remove(tmp.Name())
+		}
+	}()
+
+	if err = write(tmp); err != nil {
+		return err
+	}
+
+	// Temporary files are private; keep the mode of the file being replaced
+	mode := os.FileMode(0o644)
+	if info, serr := os.Stat(filename); serr == nil {
+		mode = info.Mode().Perm()
+	}
+	if err = tmp.Chmod(mode); err != nil {
+		return err
+	}
+	if err = tmp.Sync(); err != nil {
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	if err = os.Rename(tmp.Name(), filename); err != nil {
+		return err
+	}
+
+	// Make the rename durable; not all platforms can sync a directory
+	if d, derr := os.Open(dir); derr == nil {
+		d.Sync()
+		d.Close()
+	}
+	return nil
+}
+
+// Write a snapshot as a .ddd archive or, for a .ddproj path, a project directory
+func writeProjectFile(filename string, snap *projectSnapshot, progress func(done, total int)) error {
+	if isProjectDir(filename) {
+		return writeProjectDir(filename, snap, progress)
+	}
+	return writeFileAtomic(filename, func(w io.Writer) error {
+		return writeProjectArchive(w, snap, progress)
+	})
+}
+
+// Save the active document as .ddd or .ddproj, blocking until done
+func (app *App) SaveProject(filename string) error {
+	snap := app.snapshotProject(app.Document)
+	err := writeProjectFile(filename, snap, nil)
+	if err != nil {
+		return err
+	}
+	app.currentFilePath = filename
+	app.markSaved()
+	return nil
+}
+
+// Start saving in the background. Pixels are captured now; encoding and
+// writing happen on a worker goroutine.
+func (app *App) StartSave(filename string) {
+	if app.saveJob != nil {
+		app.SetStatus("SAVE ALREADY IN PROGRESS")
+		return
+	}
+
+	snap := app.snapshotProject(app.Document)
+	job := &saveJob{doc: app.Document, state: app.savedState(), filename: filename, result: make(chan error, 1)}
+	app.saveJob = job
+
+	go func() {
+		job.result <- writeProjectFile(filename, snap, func(done, total int) {
+			job.done.Store(int64(done))
+			job.total.Store(int64(total))
+		})
+	}()
+}
+
+// Check for a finished background save
+func (app *App) pollSave() {
+	if app.saveJob == nil {
+		return
+	}
+	select {
+	case err := <-app.saveJob.result:
+		job := app.saveJob
+		app.saveJob = nil
+		if err != nil {
+			app.SetStatus(fmt.Sprintf("SAVE FAILED: %v", err))
+			if app.closeAfterSave == job.doc {
+				app.closeAfterSave = nil
+				app.quitting = false
+			}
+			return
+		}
+		job.doc.currentFilePath = job.filename
+		job.doc.saved = job.state
+		app.addRecentFile(job.filename)
+		app.SetStatus("SAVED " + filepath.Base(job.filename))
+		if app.closeAfterSave == job.doc {
+			app.removeDocument(job.doc)
+		}
+	default:
+	}
+}
+
+// Show a message in the top bar for a while
+func (app *App) SetStatus(msg string) {
+	app.statusMessage = msg
+	app.statusUntil = rl.GetTime() + statusDuration
+}
+
+// Draw save progress or the current status message
+func (app *App) drawStatus() {
+	x := int32(leftPanel + 280)
+	y := int32(34)
+
+	if job := app.saveJob; job != nil {
+		done, total := job.done.Load(), job.total.Load()
+		progress := float32(0)
+		if total > 0 {
+			progress = float32(done) / float32(total)
+		}
+		rl.DrawText("SAVING", x, y, fontSize, rl.Yellow)
+		rl.DrawRectangle(x+50, y, 150, 8, rl.Color{40, 40, 40, 255})
+		rl.DrawRectangle(x+50, y, int32(150*progress), 8, rl.Color{100, 100, 150, 255})
+		rl.DrawRectangleLines(x+50, y, 150, 8, rl.Color{90, 90, 90, 255})
+		return
+	}
+
+	if app.statusMessage != "" && rl.GetTime() < app.statusUntil {
+		rl.DrawText(app.statusMessage, x, y, fontSize, rl.Yellow)
+	}
+}
@@ -0,0 +1,593 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// Project format versions:
+//
+//	1: one layer_N.png per layer, straight pixels in a premultiplied PNG
+//	2: layers as deduplicated 64x64 tiles, layer properties and locks
+//	3: animation frames, with a cel per layer and frame
+//	4: indexed colour, with palette indices as pixels
+//	5: colour cycling ranges in indexed palettes
+//	6: compositing in linear light
+const formatVersion = 6
+
+// Largest canvas side accepted when loading
+const maxCanvasSize = 16384
+
+// Longest layer ID accepted
+const maxLayerIDLength = 64
+
+// Schema upgrades; migrations[v-1] turns a version v project into version
+// v+1. Pixels are decoded per version before migrating.
+var migrations = []func(p *ProjectData){
+	// 1 -> 2: pixels now live in tiles
+	func(p *ProjectData) {
+		p.TileSize = tileSize
+	},
+	// 2 -> 3: the layers are the only frame
+	func(p *ProjectData) {
+		p.Frames = []FrameData{{Duration: defaultFrameDuration}}
+	},
+	// 3 -> 4: earlier projects are all truecolour
+	func(p *ProjectData) {},
+	// 4 -> 5: nothing cycles yet
+	func(p *ProjectData) {},
+	// 5 -> 6: earlier projects all composite in sRGB
+	func(p *ProjectData) {},
+}
+
+// A problem found while validating a project
+type Problem struct {
+	Severity string `json:"severity"` // "error" or "warning"
+	Where    string `json:"where,omitempty"`
+	Message  string `json:"message"`
+}
+
+func (p Problem) String() string {
+	if p.Where == "" {
+		return p.Severity + ": " + p.Message
+	}
+	return p.Severity + ": " + p.Where + ": " + p.Message
+}
+
+// Result of validating a project
+type ValidationReport struct {
+	File          string    `json:"file,omitempty"`
+	FormatVersion int       `json:"format_version"`
+	CanvasWidth   int       `json:"canvas_width"`
+	CanvasHeight  int       `json:"canvas_height"`
+	Layers        int       `json:"layers"`
+	Frames        int       `json:"frames"`
+	Problems      []Problem `json:"problems"`
+}
+
+func (r *ValidationReport) errorf(where, format string, args ...any) {
+	r.Problems = append(r.Problems, Problem{"error", where, fmt.Sprintf(format, args...)})
+}
+
+func (r *ValidationReport) warnf(where, format string, args ...any) {
+	r.Problems = append(r.Problems, Problem{"warning", where, fmt.Sprintf(format, args...)})
+}
+
+// Number of problems with the given severity
+func (r *ValidationReport) Count(severity string) int {
+	n := 0
+	for _, p := range r.Problems {
+		if p.Severity == severity {
+			n++
+		}
+	}
+	return n
+}
+
+// The errors as a single error, nil if there are none
+func (r *ValidationReport) Err() error {
+	var errs []Problem
+	for _, p := range r.Problems {
+		if p.Severity == "error" {
+			errs = append(errs, p)
+		}
+	}
+	switch len(errs) {
+	case 0:
+		return nil
+	case 1:
+		return fmt.Errorf("%s", strings.TrimPrefix(errs[0].String(), "error: "))
+	}
+	return fmt.Errorf("%s (and %d more errors)", strings.TrimPrefix(errs[0].String(), "error: "), len(errs)-1)
+}
+
+// Palette entry as written in project.json, checked before conversion
+type rawColor struct {
+	R *int `json:"r"`
+	G *int `json:"g"`
+	B *int `json:"b"`
+	A *int `json:"a"`
+}
+
+// project.json with the palette kept raw for validation
+type rawProject struct {
+	ProjectData
+	Palette []rawColor `json:"palette"`
+}
+
+// Layer IDs name files in project directories, so they are kept to
+// characters that are safe everywhere, in lower case for file systems
+// that ignore case
+func validLayerID(id string) bool {
+	if id == "" || len(id) > maxLayerIDLength {
+		return false
+	}
+	for _, c := range id {
+		if !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '-' || c == '_') {
+			return false
+		}
+	}
+	return true
+}
+
+// Check DPF keys have one distinct printable character per palette entry
+func validPaletteKeys(keys string, n int) bool {
+	seen := make(map[rune]bool)
+	for _, r := range keys {
+		if seen[r] || !unicode.IsPrint(r) || unicode.IsSpace(r) {
+			return false
+		}
+		seen[r] = true
+	}
+	return len(seen) == n
+}
+
+// Format version of a project; files written before versioning are
+// recognised by their layout
+func projectVersion(p *ProjectData) int {
+	if p.FormatVersion != 0 {
+		return p.FormatVersion
+	}
+	if p.TileSize == 0 {
+		return 1
+	}
+	return 2
+}
+
+// Parse and check project.json. Checks that do not depend on how pixels
+// are stored happen here; ok is false if the project cannot be used at all.
+func decodeProjectJSON(data []byte, report *ValidationReport) (project *ProjectData, version int, ok bool) {
+	var raw rawProject
+	if err := json.Unmarshal(data, &raw); err != nil {
+		report.errorf("project.json", "%v", err)
+		return nil, 0, false
+	}
+	project = &raw.ProjectData
+	version = projectVersion(project)
+	report.FormatVersion = version
+	report.CanvasWidth = project.CanvasWidth
+	report.CanvasHeight = project.CanvasHeight
+	report.Layers = len(project.Layers)
+
+	// Header
+	if version < 1 || version > formatVersion {
+		report.errorf("project.json", "format version %d is not supported (this build reads 1 to %d)", version, formatVersion)
+		return nil, version, false
+	}
+	if project.CanvasWidth <= 0 || project.CanvasHeight <= 0 || project.CanvasWidth > maxCanvasSize || project.CanvasHeight > maxCanvasSize {
+		report.errorf("project.json", "canvas size %dx%d is outside 1x1 to %dx%d", project.CanvasWidth, project.CanvasHeight, maxCanvasSize, maxCanvasSize)
+		return nil, version, false
+	}
+	if len(project.Layers) == 0 {
+		report.errorf("project.json", "no layers")
+	}
+
+	// Frames
+	if version < 3 && len(project.Frames) > 0 {
+		report.warnf("project.json", "frames ignored in a version %d project", version)
+		project.Frames = nil
+	}
+	if len(project.Frames) > maxFrames {
+		report.errorf("project.json", "%d frames, at most %d are allowed", len(project.Frames), maxFrames)
+		return nil, version, false
+	}
+	for i, frame := range project.Frames {
+		if frame.Duration < 1 || frame.Duration > maxFrameDuration {
+			report.errorf(fmt.Sprintf("frame %d", i), "duration %d ms is outside 1-%d", frame.Duration, maxFrameDuration)
+		}
+	}
+	report.Frames = max(1, len(project.Frames))
+
+	// Palette
+	for i, c := range raw.Palette {
+		where := fmt.Sprintf("palette %d", i)
+		channels := []struct {
+			name  string
+			value *int
+		}{{"red", c.R}, {"green", c.G}, {"blue", c.B}, {"alpha", c.A}}
+		valid := true
+		for _, ch := range channels {
+			if ch.value == nil {
+				report.errorf(where, "%s is missing", ch.name)
+				valid = false
+			} else if *ch.value < 0 || *ch.value > 255 {
+				report.errorf(where, "%s %d is outside 0-255", ch.name, *ch.value)
+				valid = false
+			}
+		}
+		if !valid {
+			continue
+		}
+		if *c.A == 0 && !(project.ColorMode == colorModeIndexed && i == project.Transparent) {
+			report.warnf(where, "colour is fully transparent")
+		}
+		project.Palette = append(project.Palette, ColorData{uint8(*c.R), uint8(*c.G), uint8(*c.B), uint8(*c.A)})
+	}
+
+	// Colour mode
+	switch {
+	case project.ColorMode == "":
+	case project.ColorMode != colorModeIndexed:
+		report.errorf("project.json", "colour mode %q is unknown", project.ColorMode)
+		return nil, version, false
+	case version < 4:
+		report.warnf("project.json", "indexed colour ignored in a version %d project", version)
+		project.ColorMode = ""
+	case len(raw.Palette) == 0 || len(raw.Palette) > maxIndexedColors:
+		report.errorf("project.json", "indexed palette has %d colours, 1-%d are allowed", len(raw.Palette), maxIndexedColors)
+		return nil, version, false
+	case project.Transparent < 0 || project.Transparent >= len(raw.Palette):
+		report.errorf("project.json", "transparent index %d is outside the palette", project.Transparent)
+	case project.PaletteKeys != "" && !validPaletteKeys(project.PaletteKeys, len(raw.Palette)):
+		report.warnf("project.json", "palette keys %q do not match the palette and will be reassigned", project.PaletteKeys)
+		project.PaletteKeys = ""
+	}
+
+	// Colour cycling
+	switch {
+	case len(project.Cycles) == 0:
+	case version < 5 || project.ColorMode != colorModeIndexed:
+		report.warnf("project.json", "colour cycling ignored outside an indexed version 5 project")
+		project.Cycles = nil
+	case len(project.Cycles) > maxCycles:
+		report.errorf("project.json", "%d colour cycling ranges, at most %d are allowed", len(project.Cycles), maxCycles)
+		project.Cycles = nil
+	}
+	cycles := project.Cycles[:0]
+	for i, c := range project.Cycles {
+		where := fmt.Sprintf("cycle %d", i)
+		switch {
+		case c.Start < 0 || c.End >= len(project.Palette) || c.Start >= c.End:
+			report.errorf(where, "range %d-%d is not two or more entries of the palette", c.Start, c.End)
+		case c.Rate <= 0 || c.Rate > maxCycleRate:
+			report.errorf(where, "rate %g is outside 0-%d steps per second", c.Rate, maxCycleRate)
+		default:
+			cycles = append(cycles, c)
+		}
+	}
+	project.Cycles = cycles
+
+	// Compositing
+	if _, err := ParseCompositing(project.Compositing); err != nil {
+		report.errorf("project.json", "%v", err)
+		project.Compositing = ""
+	} else if project.Compositing != "" && version < 6 {
+		report.warnf("project.json", "compositing %q ignored in a version %d project", project.Compositing, version)
+		project.Compositing = ""
+	}
+
+	// Layer properties
+	ids := make(map[string]int)
+	for i := range project.Layers {
+		layerData := &project.Layers[i]
+		where := fmt.Sprintf("layer %d (%q)", i, layerData.Name)
+
+		if layerData.ID != "" {
+			if !validLayerID(layerData.ID) {
+				report.errorf(where, "id %q must be 1-%d lower case letters, digits, '-' or '_'", layerData.ID, maxLayerIDLength)
+			} else if prev, dup := ids[layerData.ID]; dup {
+				report.errorf(where, "id %q is also used by layer %d", layerData.ID, prev)
+			}
+			ids[layerData.ID] = i
+		}
+		if layerData.Opacity < 0 || layerData.Opacity > 1 {
+			report.errorf(where, "opacity %g is outside 0-1", layerData.Opacity)
+		}
+		if _, err := ParseBlendMode(layerData.BlendMode); err != nil {
+			report.errorf(where, "%v", err)
+		}
+		if layerData.ColorTag < 0 || layerData.ColorTag >= len(layerTagColors) {
+			report.warnf(where, "colour tag %d is unknown and will be cleared", layerData.ColorTag)
+		}
+		if version < 3 && len(layerData.Cels) > 0 {
+			report.warnf(where, "cels ignored in a version %d project", version)
+			layerData.Cels = nil
+		}
+		layerData.Cels = checkCels(layerData.Cels, report.Frames, where, report)
+	}
+
+	return project, version, true
+}
+
+// Check a layer's cels against the frames, dropping the ones that cannot
+// be used. The rest are returned in frame order, so links always refer to
+// a cel already seen.
+func checkCels(cels []CelData, frames int, where string, report *ValidationReport) []CelData {
+	var valid []CelData
+	seen := make(map[int]bool)
+	for _, cel := range cels {
+		switch {
+		case cel.Frame < 1 || cel.Frame >= frames:
+			report.errorf(where, "cel for frame %d, the project has frames 0-%d and frame 0 is in tiles", cel.Frame, frames-1)
+			continue
+		case seen[cel.Frame]:
+			report.errorf(where, "frame %d has two cels", cel.Frame)
+			continue
+		case cel.Link != nil && (*cel.Link < 0 || *cel.Link >= cel.Frame):
+			report.errorf(where, "cel for frame %d links to frame %d, which is not an earlier frame", cel.Frame, *cel.Link)
+			continue
+		case cel.Link != nil && len(cel.Tiles) > 0:
+			report.warnf(where, "tiles of the linked cel for frame %d ignored", cel.Frame)
+			cel.Tiles = nil
+		}
+		seen[cel.Frame] = true
+		valid = append(valid, cel)
+	}
+	sort.Slice(valid, func(i, j int) bool {
+		return valid[i].Frame < valid[j].Frame
+	})
+	return valid
+}
+
+// Pixels of a layer in every frame, from its first frame and its checked
+// cels. load reads an unlinked cel; linked frames share a store, and
+// frames without a cel are nil.
+func linkCels(first *TileStore, cels []CelData, frames int, load func(cel CelData) *TileStore) []*TileStore {
+	stores := make([]*TileStore, frames)
+	stores[0] = first
+	for _, cel := range cels {
+		if cel.Link == nil {
+			stores[cel.Frame] = load(cel)
+			continue
+		}
+		if stores[*cel.Link] == nil {
+			stores[*cel.Link] = NewTileStore(first.width, first.height)
+		}
+		stores[cel.Frame] = stores[*cel.Link]
+	}
+	return stores
+}
+
+// Cel entries of a layer's frames after the first, from its pixels per
+// frame. tiles stores an unlinked cel and returns its tile references;
+// frames sharing a store are linked to the first of them.
+func layerCels(stores []*TileStore, tiles func(frame int, store *TileStore) ([]TileRef, error)) ([]CelData, error) {
+	var cels []CelData
+	for f := 1; f < len(stores); f++ {
+		store := stores[f]
+		if store == nil {
+			continue
+		}
+		link := -1
+		for g := 0; g < f; g++ {
+			if stores[g] == store {
+				link = g
+				break
+			}
+		}
+		if link >= 0 {
+			cels = append(cels, CelData{Frame: f, Link: &link})
+			continue
+		}
+		if store.TileCount() == 0 {
+			continue
+		}
+		refs, err := tiles(f, store)
+		if err != nil {
+			return nil, err
+		}
+		cels = append(cels, CelData{Frame: f, Tiles: refs})
+	}
+	return cels, nil
+}
+
+// Give layers from files written before layer IDs predictable ones
+func assignLayerIDs(project *ProjectData) {
+	ids := make(map[string]bool)
+	for _, layerData := range project.Layers {
+		ids[layerData.ID] = true
+	}
+	for i := range project.Layers {
+		if project.Layers[i].ID != "" {
+			continue
+		}
+		id := fmt.Sprintf("layer-%d", i)
+		if _, taken := ids[id]; taken {
+			id = newLayerID()
+		}
+		project.Layers[i].ID = id
+		ids[id] = true
+	}
+}
+
+// Decode and validate a .ddd archive, upgrading it to the current format.
+// Everything wrong is reported; the project is only usable if the report
+// has no errors.
+func decodeProjectArchive(zr *zip.Reader) (*loadedProject, *ValidationReport) {
+	report := &ValidationReport{}
+	files := make(map[string]*zip.File)
+	layerFiles := make(map[int]*zip.File)
+	tiles := &archiveTiles{files: make(map[string]*zip.File), loaded: make(map[string]*Tile)}
+
+	for _, file := range zr.File {
+		if _, dup := files[file.Name]; dup {
+			report.errorf(file.Name, "duplicate archive entry")
+			continue
+		}
+		files[file.Name] = file
+
+		if strings.HasPrefix(file.Name, "tiles/") && strings.HasSuffix(file.Name, ".png") {
+			tiles.files[strings.TrimSuffix(strings.TrimPrefix(file.Name, "tiles/"), ".png")] = file
+		} else if strings.HasPrefix(file.Name, "layer_") && strings.HasSuffix(file.Name, ".png") {
+			// Extract layer index
+			var idx int
+			if n, _ := fmt.Sscanf(file.Name, "layer_%d.png", &idx); n != 1 || idx < 0 {
+				report.errorf(file.Name, "cannot read layer index from name")
+				continue
+			}
+			if prev, dup := layerFiles[idx]; dup {
+				report.errorf(file.Name, "duplicate layer index %d (also %s)", idx, prev.Name)
+				continue
+			}
+			layerFiles[idx] = file
+		}
+	}
+
+	// Read project data
+	projectFile := files["project.json"]
+	if projectFile == nil {
+		report.errorf("", "project.json not found in archive")
+		return nil, report
+	}
+	rc, err := projectFile.Open()
+	if err != nil {
+		report.errorf("project.json", "%v", err)
+		return nil, report
+	}
+	data, err := io.ReadAll(rc)
+	rc.Close()
+	if err != nil {
+		report.errorf("project.json", "%v", err)
+		return nil, report
+	}
+	project, version, ok := decodeProjectJSON(data, report)
+	if !ok {
+		return nil, report
+	}
+	if version == 1 && project.TileSize != 0 {
+		report.errorf("project.json", "tile_size %d in a version 1 project", project.TileSize)
+	}
+	if version >= 2 && project.TileSize != tileSize {
+		report.errorf("project.json", "tile_size is %d, want %d", project.TileSize, tileSize)
+		return nil, report
+	}
+	assignLayerIDs(project)
+	lp := &loadedProject{project: *project, files: files, tiles: tiles}
+	project = &lp.project
+
+	// Layer pixels
+	for i := range project.Layers {
+		layerData := &project.Layers[i]
+		where := fmt.Sprintf("layer %d (%q)", i, layerData.Name)
+		store := NewTileStore(project.CanvasWidth, project.CanvasHeight)
+
+		if version == 1 {
+			if len(layerData.Tiles) > 0 {
+				report.warnf(where, "tile references ignored in a version 1 project")
+			}
+			layerFile, ok := layerFiles[i]
+			if !ok {
+				report.errorf(where, "layer_%d.png not found in archive", i)
+			} else if img, err := readLayerPNG(layerFile); err != nil {
+				report.errorf(where, "%s: %v", layerFile.Name, err)
+			} else if img.Rect.Dx() != project.CanvasWidth || img.Rect.Dy() != project.CanvasHeight {
+				report.errorf(where, "%s is %dx%d, canvas is %dx%d", layerFile.Name, img.Rect.Dx(), img.Rect.Dy(), project.CanvasWidth, project.CanvasHeight)
+			} else {
+				store.SetNRGBA(img)
+			}
+		} else {
+			loadTileRefs(store, layerData.Tiles, tiles, where, report)
+		}
+		lp.layers = append(lp.layers, store)
+		lp.cels = append(lp.cels, linkCels(store, layerData.Cels, report.Frames, func(cel CelData) *TileStore {
+			store := NewTileStore(project.CanvasWidth, project.CanvasHeight)
+			loadTileRefs(store, cel.Tiles, tiles, fmt.Sprintf("%s frame %d", where, cel.Frame), report)
+			return store
+		}))
+		layerData.Tiles = nil
+		layerData.Cels = nil
+	}
+	if version == 1 {
+		var extra []int
+		for idx := range layerFiles {
+			if idx >= len(project.Layers) {
+				extra = append(extra, idx)
+			}
+		}
+		sort.Ints(extra)
+		for _, idx := range extra {
+			report.errorf(layerFiles[idx].Name, "no layer %d in project.json, which lists %d layers", idx, len(project.Layers))
+		}
+	} else if len(layerFiles) > 0 {
+		report.warnf("", "%d layer_N.png entries ignored in a version %d project", len(layerFiles), version)
+	}
+
+	if file := files[metadataEntry]; file != nil {
+		if rc, err := file.Open(); err != nil {
+			report.warnf(metadataEntry, "%v", err)
+		} else {
+			lp.metadata = decodeMetadata(rc, report)
+			rc.Close()
+		}
+	}
+
+	upgradeProject(project, version)
+	return lp, report
+}
+
+// Put the tiles a layer or cel lists into its store, reporting bad
+// references
+func loadTileRefs(store *TileStore, refs []TileRef, tiles *archiveTiles, where string, report *ValidationReport) {
+	seen := make(map[[2]int]bool)
+	for _, ref := range refs {
+		if ref.X < 0 || ref.Y < 0 || ref.X >= store.tilesX || ref.Y >= store.tilesY {
+			report.errorf(where, "tile (%d,%d) is outside the %dx%d tile grid", ref.X, ref.Y, store.tilesX, store.tilesY)
+			continue
+		}
+		if seen[[2]int{ref.X, ref.Y}] {
+			report.errorf(where, "tile (%d,%d) is listed twice", ref.X, ref.Y)
+			continue
+		}
+		seen[[2]int{ref.X, ref.Y}] = true
+		tile, err := tiles.tile(ref.ID)
+		if err != nil {
+			report.errorf(where, "tile (%d,%d): %v", ref.X, ref.Y, err)
+			continue
+		}
+		store.PutTile(ref.X, ref.Y, tile)
+	}
+}
+
+// Upgrade project data to the current format
+func upgradeProject(project *ProjectData, version int) {
+	for v := version; v < formatVersion; v++ {
+		migrations[v-1](project)
+	}
+	project.FormatVersion = formatVersion
+}
+
+// Open a project file or directory and validate it
+func openProject(filename string) (*loadedProject, *ValidationReport) {
+	if isProjectDir(filename) {
+		lp, report := decodeProjectDir(filename)
+		report.File = filename
+		return lp, report
+	}
+
+	reader, err := zip.OpenReader(filename)
+	if err != nil {
+		report := &ValidationReport{File: filename}
+		report.errorf("", "not a project archive: %v", err)
+		return nil, report
+	}
+	defer reader.Close()
+
+	lp, report := decodeProjectArchive(&reader.Reader)
+	report.File = filename
+	return lp, report
+}
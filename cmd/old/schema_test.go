@@ -0,0 +1,186 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"image"
+	"image/png"
+	"strings"
+	"testing"
+)
+
+// Archive of named entries, in the order given
+func testArchive(t *testing.T, entries ...[2][]byte) *zip.Reader {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, e := range entries {
+		w, err := zw.Create(string(e[0]))
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write(e[1])
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return zr
+}
+
+// project.json of a current one-layer 128x64 project whose first tile is
+// "red", changed by edit
+func testProjectJSON(t *testing.T, edit func(p *ProjectData)) []byte {
+	t.Helper()
+	p := ProjectData{
+		FormatVersion: formatVersion,
+		CanvasWidth:   128,
+		CanvasHeight:  64,
+		TileSize:      tileSize,
+		Layers: []LayerData{{ID: "bg", Name: "Background", Visible: true, Opacity: 1,
+			Tiles: []TileRef{{X: 0, Y: 0, ID: "red"}}}},
+	}
+	if edit != nil {
+		edit(&p)
+	}
+	data, err := json.Marshal(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func testTilePNG(t *testing.T) []byte {
+	t.Helper()
+	img := image.NewNRGBA(image.Rect(0, 0, tileSize, tileSize))
+	for i := 0; i < len(img.Pix); i += 4 {
+		img.Pix[i], img.Pix[i+3] = 255, 255
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestDecodeProjectArchive(t *testing.T) {
+	entry := func(name string, data []byte) [2][]byte {
+		return [2][]byte{[]byte(name), data}
+	}
+	red := entry("tiles/red.png", testTilePNG(t))
+	project := func(edit func(p *ProjectData)) [2][]byte {
+		return entry("project.json", testProjectJSON(t, edit))
+	}
+
+	for _, tc := range []struct {
+		name     string
+		entries  [][2][]byte
+		ok       bool
+		errors   []string // parts of each error, in order
+		warnings []string
+	}{
+		{"valid", [][2][]byte{project(nil), red}, true, nil, nil},
+		{"linear compositing", [][2][]byte{project(func(p *ProjectData) { p.Compositing = "linear" }), red}, true, nil, nil},
+
+		// Malformed project.json
+		{"no project.json", [][2][]byte{red}, false, []string{"project.json not found"}, nil},
+		{"project.json not json", [][2][]byte{entry("project.json", []byte(`{"format_version": 6,`)), red}, false,
+			[]string{"unexpected end of JSON input"}, nil},
+		{"project.json wrong types", [][2][]byte{entry("project.json", []byte(`{"canvas_width": "wide"}`)), red}, false,
+			[]string{"cannot unmarshal string"}, nil},
+		{"newer version", [][2][]byte{project(func(p *ProjectData) { p.FormatVersion = formatVersion + 1 }), red}, false,
+			[]string{"format version 7 is not supported"}, nil},
+		{"empty canvas", [][2][]byte{project(func(p *ProjectData) { p.CanvasHeight = 0 }), red}, false,
+			[]string{"canvas size 128x0"}, nil},
+		{"wrong tile size", [][2][]byte{project(func(p *ProjectData) { p.TileSize = 32 }), red}, false,
+			[]string{"tile_size is 32"}, nil},
+		{"palette channel out of range", [][2][]byte{
+			entry("project.json", bytes.Replace(testProjectJSON(t, nil), []byte(`"palette":null`),
+				[]byte(`"palette":[{"r":1,"g":2,"b":300,"a":255},{"r":1,"g":2,"b":3}]`), 1)), red}, true,
+			[]string{"blue 300 is outside 0-255", "alpha is missing"}, nil},
+		{"duplicate layer ids", [][2][]byte{project(func(p *ProjectData) { p.Layers = append(p.Layers, p.Layers[0]) }), red}, true,
+			[]string{`id "bg" is also used by layer 0`}, nil},
+		{"unknown blend mode", [][2][]byte{project(func(p *ProjectData) { p.Layers[0].BlendMode = "dodgy" }), red}, true,
+			[]string{"dodgy"}, nil},
+
+		// Missing and bad tiles
+		{"missing tile", [][2][]byte{project(nil)}, true, []string{"tiles/red.png not found"}, nil},
+		{"missing tile of a cel", [][2][]byte{project(func(p *ProjectData) {
+			p.Frames = []FrameData{{Duration: 100}, {Duration: 100}}
+			p.Layers[0].Cels = []CelData{{Frame: 1, Tiles: []TileRef{{X: 1, Y: 0, ID: "blue"}}}}
+		}), red}, true, []string{"tiles/blue.png not found"}, nil},
+		{"tile outside the grid", [][2][]byte{project(func(p *ProjectData) {
+			p.Layers[0].Tiles = append(p.Layers[0].Tiles, TileRef{X: 2, Y: 0, ID: "red"})
+		}), red}, true, []string{"tile (2,0) is outside the 2x1 tile grid"}, nil},
+		{"tile listed twice", [][2][]byte{project(func(p *ProjectData) {
+			p.Layers[0].Tiles = append(p.Layers[0].Tiles, TileRef{X: 0, Y: 0, ID: "red"})
+		}), red}, true, []string{"tile (0,0) is listed twice"}, nil},
+		{"tile not a png", [][2][]byte{project(nil), entry("tiles/red.png", []byte("red"))}, true,
+			[]string{"tiles/red.png"}, nil},
+		{"missing layer png", [][2][]byte{project(func(p *ProjectData) {
+			p.FormatVersion, p.TileSize, p.Layers[0].Tiles = 1, 0, nil
+		})}, true, []string{"layer_0.png not found"}, nil},
+		{"duplicate entry", [][2][]byte{project(nil), red, red}, true, []string{"duplicate archive entry"}, nil},
+
+		// Fields newer than the project's version
+		{"compositing before version 6", [][2][]byte{project(func(p *ProjectData) {
+			p.FormatVersion, p.Compositing = 5, "linear"
+		}), red}, true, nil, []string{`compositing "linear" ignored in a version 5 project`}},
+		{"unknown compositing", [][2][]byte{project(func(p *ProjectData) { p.Compositing = "gamma" }), red}, true,
+			[]string{`unknown compositing "gamma"`}, nil},
+		{"cycles before version 5", [][2][]byte{project(func(p *ProjectData) {
+			p.FormatVersion, p.ColorMode = 4, colorModeIndexed
+			p.Palette = []ColorData{{}, {A: 255}, {R: 128, A: 255}, {R: 255, A: 255}}
+			p.Cycles = []CycleData{{Start: 1, End: 3, Rate: 1}}
+		}), red}, true, nil, []string{"colour cycling ignored"}},
+		{"indexed before version 4", [][2][]byte{project(func(p *ProjectData) {
+			p.FormatVersion, p.ColorMode = 3, colorModeIndexed
+		}), red}, true, nil, []string{"indexed colour ignored in a version 3 project"}},
+		{"frames before version 3", [][2][]byte{project(func(p *ProjectData) {
+			p.FormatVersion, p.Frames = 2, []FrameData{{Duration: 100}, {Duration: 100}}
+		}), red}, true, nil, []string{"frames ignored in a version 2 project"}},
+	} {
+		lp, report := decodeProjectArchive(testArchive(t, tc.entries...))
+		if (lp != nil) != tc.ok {
+			t.Errorf("%s: project decoded is %v, want %v", tc.name, lp != nil, tc.ok)
+		}
+		for _, severity := range []string{"error", "warning"} {
+			want := tc.errors
+			if severity == "warning" {
+				want = tc.warnings
+			}
+			var got []string
+			for _, p := range report.Problems {
+				if p.Severity == severity {
+					got = append(got, p.String())
+				}
+			}
+			if len(got) != len(want) {
+				t.Errorf("%s: %ss %q, want %d", tc.name, severity, got, len(want))
+				continue
+			}
+			for i := range want {
+				if !strings.Contains(got[i], want[i]) {
+					t.Errorf("%s: %s %q, want one about %q", tc.name, severity, got[i], want[i])
+				}
+			}
+		}
+		if lp == nil || report.Err() != nil {
+			continue
+		}
+		// What is ignored is dropped, and the project is upgraded
+		if lp.project.FormatVersion != formatVersion {
+			t.Errorf("%s: format version %d after loading", tc.name, lp.project.FormatVersion)
+		}
+		if len(tc.warnings) > 0 && (lp.project.Compositing != "" || lp.project.Cycles != nil || len(lp.project.Frames) > 1) {
+			t.Errorf("%s: ignored fields kept: %+v", tc.name, lp.project)
+		}
+		if got := lp.layers[0].At(0, 0); got.R != 255 || got.A != 255 {
+			t.Errorf("%s: first pixel %v, want red", tc.name, got)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"image"
+
+	rl "github.com/gen2brain/raylib-go/raylib"
+)
+
+// Drag out a rectangle selection with the select tool. A click without
+// dragging clears it.
+func (app *App) updateSelect(mousePos rl.Vector2) {
+	x, y := app.ScreenToCanvas(mousePos.X, mousePos.Y)
+	if rl.IsMouseButtonPressed(rl.MouseLeftButton) && mousePos.X > leftPanel && mousePos.X < screenWidth-rightPanel && mousePos.Y > topBar && mousePos.Y < timelineY {
+		app.selecting = true
+		app.selectStart = image.Pt(x, y)
+	}
+	if !app.selecting {
+		return
+	}
+
+	// Cover the pixels under both corners
+	r := image.Rectangle{Min: app.selectStart, Max: image.Pt(x, y)}.Canon()
+	r.Max = r.Max.Add(image.Pt(1, 1))
+	app.selection = r.Intersect(app.canvasBounds())
+
+	if rl.IsMouseButtonReleased(rl.MouseLeftButton) {
+		app.selecting = false
+		if app.selectStart == image.Pt(x, y) {
+			app.selection = image.Rectangle{}
+		}
+	}
+}
+
+// The whole canvas in pixels
+func (d *Document) canvasBounds() image.Rectangle {
+	return image.Rect(0, 0, d.canvasWidth, d.canvasHeight)
+}
+
+// Select the whole canvas
+func (app *App) SelectAll() {
+	app.selection = app.canvasBounds()
+}
+
+// Clear the selection
+func (app *App) Deselect() {
+	app.selection = image.Rectangle{}
+	app.selecting = false
+}
+
+// Draw the selection outline over the canvas
+func (app *App) drawSelection() {
+	if app.selection.Empty() {
+		return
+	}
+	r := app.selection
+	rect := rl.Rectangle{
+		X:      leftPanel + app.panX + float32(r.Min.X)*app.zoom,
+		Y:      topBar + app.panY + float32(r.Min.Y)*app.zoom,
+		Width:  float32(r.Dx()) * app.zoom,
+		Height: float32(r.Dy()) * app.zoom,
+	}
+	rl.DrawRectangleLinesEx(rect, 1, rl.Black)
+
+	// Dashes that crawl along the edge
+	dash := float32(4)
+	offset := float32(int(rl.GetTime()*8)%8) - dash*2
+	for x := rect.X + offset; x < rect.X+rect.Width; x += dash * 2 {
+		x0, x1 := maxf(x, rect.X), minf(x+dash, rect.X+rect.Width)
+		if x1 > x0 {
+			rl.DrawLineV(rl.Vector2{X: x0, Y: rect.Y}, rl.Vector2{X: x1, Y: rect.Y}, rl.White)
+			rl.DrawLineV(rl.Vector2{X: x0, Y: rect.Y + rect.Height - 1}, rl.Vector2{X: x1, Y: rect.Y + rect.Height - 1}, rl.White)
+		}
+	}
+	for y := rect.Y + offset; y < rect.Y+rect.Height; y += dash * 2 {
+		y0, y1 := maxf(y, rect.Y), minf(y+dash, rect.Y+rect.Height)
+		if y1 > y0 {
+			rl.DrawLineV(rl.Vector2{X: rect.X, Y: y0}, rl.Vector2{X: rect.X, Y: y1}, rl.White)
+			rl.DrawLineV(rl.Vector2{X: rect.X + rect.Width - 1, Y: y0}, rl.Vector2{X: rect.X + rect.Width - 1, Y: y1}, rl.White)
+		}
+	}
+}
@@ -0,0 +1,499 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"image"
+	"image/draw"
+	"io"
+	"math"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Sprite sheets: frames, layers or DPF icons packed into one PNG, with an
+// atlas saying where each one went. The same input and options always give
+// the same sheet, byte for byte.
+
+var sheetPackings = []string{"maxrects", "grid"}
+var atlasFormats = []string{"hash", "plain", "xml"}
+
+// Choices for sprite sheet export
+type SheetOptions struct {
+	Packing string // "maxrects" or "grid"
+	Columns int    // grid columns; 0 for about square
+	Padding int    // transparent pixels between sprites
+	Extrude int    // edge pixels repeated around each sprite
+	Trim    bool   // drop transparent borders, keeping the offsets
+	Format  string // atlas: "hash" (TexturePacker JSON hash), "plain" (pngtodpf text) or "xml"
+	ICC     bool   // embed an sRGB ICC profile in the sheet instead of the sRGB chunk
+}
+
+// A picture to place on a sheet
+type sheetSprite struct {
+	name     string
+	img      *image.NRGBA
+	duration int // milliseconds, for animation frames
+}
+
+// Where a sprite went on the sheet
+type sheetPlacement struct {
+	sprite sheetSprite
+	frame  image.Rectangle // on the sheet, without extrusion
+	trim   image.Rectangle // part of the sprite image kept
+}
+
+// Sprites for a project's animation frames, or its visible layers
+func projectSprites(project ProjectData, layers []*TileStore, cels [][]*TileStore, layerFrames bool) []sheetSprite {
+	frames := animationFrames(project, layers, cels, AnimExportOptions{LayerFrames: layerFrames})
+	var names []string
+	if layerFrames {
+		for i, data := range project.Layers {
+			if data.Visible && i < len(layers) {
+				names = append(names, data.Name)
+			}
+		}
+	}
+	sprites := make([]sheetSprite, len(frames))
+	for i, f := range frames {
+		sprites[i] = sheetSprite{name: fmt.Sprintf("frame%03d", i+1), img: f.img}
+		if layerFrames {
+			sprites[i].name = names[i]
+		} else {
+			sprites[i].duration = f.delay
+		}
+	}
+	return uniqueSpriteNames(sprites)
+}
+
+// Sprites for the icons of a DPF file
+func dpfSprites(dpf *dpfFile) []sheetSprite {
+	sprites := make([]sheetSprite, len(dpf.icons))
+	for i, icon := range dpf.icons {
+		sprites[i] = sheetSprite{name: icon.name, img: icon.Image(dpf.palette)}
+		if icon.name == "" {
+			sprites[i].name = fmt.Sprintf("icon%03d", i+1)
+		}
+	}
+	return uniqueSpriteNames(sprites)
+}
+
+// Number repeated names, since atlases look sprites up by name
+func uniqueSpriteNames(sprites []sheetSprite) []sheetSprite {
+	seen := make(map[string]int)
+	for i := range sprites {
+		name := sprites[i].name
+		seen[name]++
+		for n := seen[name]; n > 1; n++ {
+			candidate := fmt.Sprintf("%s_%d", name, n)
+			if seen[candidate] == 0 {
+				sprites[i].name = candidate
+				seen[name] = n
+				seen[candidate]++
+				break
+			}
+		}
+	}
+	return sprites
+}
+
+// Smallest rectangle holding the visible pixels of an image; a fully
+// transparent image keeps a single pixel
+func opaqueBounds(img *image.NRGBA) image.Rectangle {
+	r := image.Rectangle{}
+	b := img.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			if img.Pix[img.PixOffset(x, y)+3] != 0 {
+				r = r.Union(image.Rect(x, y, x+1, y+1))
+			}
+		}
+	}
+	if r.Empty() {
+		return image.Rectangle{Min: b.Min, Max: b.Min.Add(image.Pt(1, 1))}
+	}
+	return r
+}
+
+// Lay sprites out and draw the sheet
+func packSpriteSheet(sprites []sheetSprite, opts SheetOptions) (*image.NRGBA, []sheetPlacement, error) {
+	if len(sprites) == 0 {
+		return nil, nil, fmt.Errorf("no sprites to pack")
+	}
+	if opts.Padding < 0 || opts.Extrude < 0 {
+		return nil, nil, fmt.Errorf("padding and extrusion can't be negative")
+	}
+
+	// Space each sprite takes, with extrusion and the padding after it
+	placements := make([]sheetPlacement, len(sprites))
+	sizes := make([]image.Point, len(sprites))
+	for i, s := range sprites {
+		trim := s.img.Bounds()
+		if opts.Trim {
+			trim = opaqueBounds(s.img)
+		}
+		placements[i] = sheetPlacement{sprite: s, trim: trim}
+		sizes[i] = trim.Size().Add(image.Pt(2*opts.Extrude+opts.Padding, 2*opts.Extrude+opts.Padding))
+	}
+
+	var pos []image.Point
+	switch opts.Packing {
+	case "grid":
+		pos = gridPack(sizes, opts.Columns)
+	case "maxrects", "":
+		pos = maxRectsPack(sizes)
+	default:
+		return nil, nil, fmt.Errorf("unknown packing %q", opts.Packing)
+	}
+
+	// The padding after the last row and column is dropped
+	var size image.Point
+	for i, p := range pos {
+		size.X = max(size.X, p.X+sizes[i].X-opts.Padding)
+		size.Y = max(size.Y, p.Y+sizes[i].Y-opts.Padding)
+	}
+	sheet := image.NewNRGBA(image.Rectangle{Max: size})
+	for i := range placements {
+		pl := &placements[i]
+		at := pos[i].Add(image.Pt(opts.Extrude, opts.Extrude))
+		pl.frame = image.Rectangle{Min: at, Max: at.Add(pl.trim.Size())}
+		draw.Draw(sheet, pl.frame, pl.sprite.img, pl.trim.Min, draw.Src)
+		extrude(sheet, pl.frame, opts.Extrude)
+	}
+	return sheet, placements, nil
+}
+
+// Repeat the edge pixels of r outwards by n pixels
+func extrude(img *image.NRGBA, r image.Rectangle, n int) {
+	if n == 0 {
+		return
+	}
+	outer := r.Inset(-n).Intersect(img.Bounds())
+	for y := outer.Min.Y; y < outer.Max.Y; y++ {
+		for x := outer.Min.X; x < outer.Max.X; x++ {
+			if (image.Point{x, y}).In(r) {
+				continue
+			}
+			sx := max(r.Min.X, min(x, r.Max.X-1))
+			sy := max(r.Min.Y, min(y, r.Max.Y-1))
+			copy(img.Pix[img.PixOffset(x, y):][:4], img.Pix[img.PixOffset(sx, sy):][:4])
+		}
+	}
+}
+
+// Place sprites in input order on a grid of equal cells
+func gridPack(sizes []image.Point, columns int) []image.Point {
+	var cell image.Point
+	for _, s := range sizes {
+		cell.X = max(cell.X, s.X)
+		cell.Y = max(cell.Y, s.Y)
+	}
+	if columns <= 0 {
+		columns = int(math.Ceil(math.Sqrt(float64(len(sizes)))))
+	}
+	pos := make([]image.Point, len(sizes))
+	for i := range sizes {
+		pos[i] = image.Pt(i%columns*cell.X, i/columns*cell.Y)
+	}
+	return pos
+}
+
+// Place sprites with the MaxRects best short side fit heuristic, trying a
+// few sheet widths and keeping the smallest, squarest result
+func maxRectsPack(sizes []image.Point) []image.Point {
+	// Biggest first; ties keep input order
+	order := make([]int, len(sizes))
+	var area, widest, total int
+	for i, s := range sizes {
+		order[i] = i
+		area += s.X * s.Y
+		widest = max(widest, s.X)
+		total += s.X
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		sa, sb := sizes[order[a]], sizes[order[b]]
+		if ma, mb := max(sa.X, sa.Y), max(sb.X, sb.Y); ma != mb {
+			return ma > mb
+		}
+		return sa.X*sa.Y > sb.X*sb.Y
+	})
+
+	widths := []int{widest}
+	for w := 16; w < total; w *= 2 {
+		if w > widest && w*w < area*16 {
+			widths = append(widths, w)
+		}
+	}
+
+	var best []image.Point
+	var bestArea, bestSide int
+	for _, width := range widths {
+		pos, used := maxRectsPlace(sizes, order, width)
+		a, side := used.X*used.Y, max(used.X, used.Y)
+		if best == nil || a < bestArea || a == bestArea && side < bestSide {
+			best, bestArea, bestSide = pos, a, side
+		}
+	}
+	return best
+}
+
+// Place sprites in order into a strip of the given width, returning the
+// positions and the size used
+func maxRectsPlace(sizes []image.Point, order []int, width int) ([]image.Point, image.Point) {
+	height := 0
+	for _, s := range sizes {
+		height += s.Y
+	}
+	free := []image.Rectangle{image.Rect(0, 0, width, height)}
+	pos := make([]image.Point, len(sizes))
+	var used image.Point
+
+	for _, i := range order {
+		s := sizes[i]
+		best, bestShort, bestLong := -1, 0, 0
+		for j, f := range free {
+			dx, dy := f.Dx()-s.X, f.Dy()-s.Y
+			if dx < 0 || dy < 0 {
+				continue
+			}
+			short, long := min(dx, dy), max(dx, dy)
+			if best < 0 || short < bestShort || short == bestShort && long < bestLong {
+				best, bestShort, bestLong = j, short, long
+			}
+		}
+		placed := image.Rectangle{Min: free[best].Min, Max: free[best].Min.Add(s)}
+		pos[i] = placed.Min
+		used.X = max(used.X, placed.Max.X)
+		used.Y = max(used.Y, placed.Max.Y)
+
+		// Split every free rectangle the sprite overlaps into the parts
+		// around it
+		var next []image.Rectangle
+		for _, f := range free {
+			if !f.Overlaps(placed) {
+				next = append(next, f)
+				continue
+			}
+			parts := []image.Rectangle{
+				image.Rect(f.Min.X, f.Min.Y, placed.Min.X, f.Max.Y),
+				image.Rect(placed.Max.X, f.Min.Y, f.Max.X, f.Max.Y),
+				image.Rect(f.Min.X, f.Min.Y, f.Max.X, placed.Min.Y),
+				image.Rect(f.Min.X, placed.Max.Y, f.Max.X, f.Max.Y),
+			}
+			for _, p := range parts {
+				if p = p.Intersect(f); !p.Empty() {
+					next = append(next, p)
+				}
+			}
+		}
+		free = pruneFreeRects(next)
+	}
+	return pos, used
+}
+
+// Drop free rectangles inside others; of equal ones the first is kept
+func pruneFreeRects(free []image.Rectangle) []image.Rectangle {
+	var out []image.Rectangle
+	for i, a := range free {
+		inside := false
+		for j, b := range free {
+			if i != j && a.In(b) && (a != b || j < i) {
+				inside = true
+				break
+			}
+		}
+		if !inside {
+			out = append(out, a)
+		}
+	}
+	return out
+}
+
+// Rectangle and size objects of the TexturePacker JSON formats
+type tpRect struct {
+	X int `json:"x"`
+	Y int `json:"y"`
+	W int `json:"w"`
+	H int `json:"h"`
+}
+
+type tpSize struct {
+	W int `json:"w"`
+	H int `json:"h"`
+}
+
+type tpFrame struct {
+	Frame            tpRect `json:"frame"`
+	Rotated          bool   `json:"rotated"`
+	Trimmed          bool   `json:"trimmed"`
+	SpriteSourceSize tpRect `json:"spriteSourceSize"`
+	SourceSize       tpSize `json:"sourceSize"`
+	Duration         int    `json:"duration,omitempty"`
+}
+
+// Frames by name, kept in sheet order rather than sorted
+type tpFrames struct {
+	names  []string
+	frames []tpFrame
+}
+
+func (f tpFrames) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, name := range f.names {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		key, _ := json.Marshal(name)
+		value, err := json.Marshal(f.frames[i])
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(key)
+		buf.WriteByte(':')
+		buf.Write(value)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+type tpAtlas struct {
+	Frames tpFrames `json:"frames"`
+	Meta   struct {
+		App     string `json:"app"`
+		Version string `json:"version"`
+		Image   string `json:"image"`
+		Format  string `json:"format"`
+		Size    tpSize `json:"size"`
+		Scale   string `json:"scale"`
+	} `json:"meta"`
+}
+
+// TexturePacker generic XML
+type xmlAtlas struct {
+	XMLName   xml.Name    `xml:"TextureAtlas"`
+	ImagePath string      `xml:"imagePath,attr"`
+	Width     int         `xml:"width,attr"`
+	Height    int         `xml:"height,attr"`
+	Sprites   []xmlSprite `xml:"sprite"`
+}
+
+type xmlSprite struct {
+	Name    string `xml:"n,attr"`
+	X       int    `xml:"x,attr"`
+	Y       int    `xml:"y,attr"`
+	W       int    `xml:"w,attr"`
+	H       int    `xml:"h,attr"`
+	OffsetX int    `xml:"oX,attr,omitempty"`
+	OffsetY int    `xml:"oY,attr,omitempty"`
+	OrigW   int    `xml:"oW,attr,omitempty"`
+	OrigH   int    `xml:"oH,attr,omitempty"`
+}
+
+// Write the atlas describing a packed sheet
+func writeAtlas(w io.Writer, format, imagePath string, size image.Point, placements []sheetPlacement) error {
+	switch format {
+	case "hash", "":
+		var atlas tpAtlas
+		for _, pl := range placements {
+			src := pl.sprite.img.Bounds()
+			atlas.Frames.names = append(atlas.Frames.names, pl.sprite.name)
+			atlas.Frames.frames = append(atlas.Frames.frames, tpFrame{
+				Frame:            tpRect{pl.frame.Min.X, pl.frame.Min.Y, pl.frame.Dx(), pl.frame.Dy()},
+				Trimmed:          pl.trim != src,
+				SpriteSourceSize: tpRect{pl.trim.Min.X - src.Min.X, pl.trim.Min.Y - src.Min.Y, pl.trim.Dx(), pl.trim.Dy()},
+				SourceSize:       tpSize{src.Dx(), src.Dy()},
+				Duration:         pl.sprite.duration,
+			})
+		}
+		atlas.Meta.App = "DeluxeDraw"
+		atlas.Meta.Version = "1.0"
+		atlas.Meta.Image = imagePath
+		atlas.Meta.Format = "RGBA8888"
+		atlas.Meta.Size = tpSize{size.X, size.Y}
+		atlas.Meta.Scale = "1"
+		return writeJSON(w, atlas)
+
+	case "plain":
+		// The text pngtodpf -m atlas reads: "name x y w h" per line and
+		// '#' comments. Names can't hold spaces, and trim offsets are lost.
+		var buf bytes.Buffer
+		fmt.Fprintf(&buf, "# %s %dx%d\n", imagePath, size.X, size.Y)
+		for _, pl := range placements {
+			name := strings.Join(strings.Fields(pl.sprite.name), "_")
+			if name == "" || strings.HasPrefix(name, "#") {
+				name = "_" + name
+			}
+			fmt.Fprintf(&buf, "%s %d %d %d %d\n", name, pl.frame.Min.X, pl.frame.Min.Y, pl.frame.Dx(), pl.frame.Dy())
+		}
+		_, err := w.Write(buf.Bytes())
+		return err
+
+	case "xml":
+		atlas := xmlAtlas{ImagePath: imagePath, Width: size.X, Height: size.Y}
+		for _, pl := range placements {
+			src := pl.sprite.img.Bounds()
+			s := xmlSprite{Name: pl.sprite.name, X: pl.frame.Min.X, Y: pl.frame.Min.Y, W: pl.frame.Dx(), H: pl.frame.Dy()}
+			if pl.trim != src {
+				s.OffsetX, s.OffsetY = pl.trim.Min.X-src.Min.X, pl.trim.Min.Y-src.Min.Y
+				s.OrigW, s.OrigH = src.Dx(), src.Dy()
+			}
+			atlas.Sprites = append(atlas.Sprites, s)
+		}
+		if _, err := io.WriteString(w, xml.Header); err != nil {
+			return err
+		}
+		enc := xml.NewEncoder(w)
+		enc.Indent("", "  ")
+		if err := enc.Encode(atlas); err != nil {
+			return err
+		}
+		_, err := io.WriteString(w, "\n")
+		return err
+	}
+	return fmt.Errorf("unknown atlas format %q", format)
+}
+
+func writeJSON(w io.Writer, v any) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+// Pack sprites and write the sheet PNG and its atlas
+func writeSpriteSheet(sheetName, atlasName string, sprites []sheetSprite, opts SheetOptions) error {
+	sheet, placements, err := packSpriteSheet(sprites, opts)
+	if err != nil {
+		return err
+	}
+	// The atlas refers to the sheet relative to itself
+	imagePath, err := filepath.Rel(filepath.Dir(atlasName), sheetName)
+	if err != nil {
+		imagePath = sheetName
+	}
+	if err := writeFileAtomic(sheetName, func(w io.Writer) error {
+		return encodeTaggedPNG(w, sheet, opts.ICC)
+	}); err != nil {
+		return err
+	}
+	return writeFileAtomic(atlasName, func(w io.Writer) error {
+		return writeAtlas(w, opts.Format, filepath.ToSlash(imagePath), sheet.Bounds().Size(), placements)
+	})
+}
+
+// Export the current document's frames, or layers as set by the export
+// options, as a sheet next to the atlas. An .xml atlas is TexturePacker
+// generic XML, anything else a TexturePacker JSON hash.
+func (app *App) ExportSpriteSheet(atlasName string) error {
+	snap := app.snapshotProject(app.Document)
+	sprites := projectSprites(snap.project, snap.layers, snap.cels, app.exportOptions.LayerFrames)
+	opts := SheetOptions{Packing: "maxrects", Padding: 1, Trim: true, Format: "hash", ICC: app.exportOptions.ICC}
+	if strings.EqualFold(filepath.Ext(atlasName), ".xml") {
+		opts.Format = "xml"
+	}
+	sheetName := strings.TrimSuffix(atlasName, filepath.Ext(atlasName)) + ".png"
+	return writeSpriteSheet(sheetName, atlasName, sprites, opts)
+}
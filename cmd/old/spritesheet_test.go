@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"testing"
+)
+
+// Sprites of assorted sizes with transparent borders to trim
+func testSprites() []sheetSprite {
+	var sprites []sheetSprite
+	for i := 0; i < 12; i++ {
+		w, h := 3+i*5%11, 2+i*7%9
+		img := image.NewNRGBA(image.Rect(0, 0, w+2, h+2))
+		for y := 1; y <= h; y++ {
+			for x := 1; x <= w; x++ {
+				img.SetNRGBA(x, y, color.NRGBA{uint8(i * 20), uint8(x * 10), uint8(y * 10), 255})
+			}
+		}
+		sprites = append(sprites, sheetSprite{name: fmt.Sprintf("sprite %d", i), img: img, duration: 100})
+	}
+	return sprites
+}
+
+func TestPackSpriteSheetDeterministic(t *testing.T) {
+	for _, opts := range []SheetOptions{
+		{Packing: "maxrects", Padding: 1, Trim: true},
+		{Packing: "maxrects", Extrude: 2},
+		{Packing: "grid", Columns: 3, Padding: 2, Trim: true},
+	} {
+		sheet1, placements1, err := packSpriteSheet(testSprites(), opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sheet2, placements2, err := packSpriteSheet(testSprites(), opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if sheet1.Rect != sheet2.Rect || !bytes.Equal(sheet1.Pix, sheet2.Pix) {
+			t.Errorf("%+v: sheets differ", opts)
+		}
+		if len(placements1) != len(placements2) {
+			t.Fatalf("%+v: %d placements, then %d", opts, len(placements1), len(placements2))
+		}
+		for i := range placements1 {
+			a, b := placements1[i], placements2[i]
+			if a.sprite.name != b.sprite.name || a.frame != b.frame || a.trim != b.trim {
+				t.Errorf("%+v: placement %d is %s %v %v, then %s %v %v", opts, i, a.sprite.name, a.frame, a.trim, b.sprite.name, b.frame, b.trim)
+			}
+		}
+	}
+}
+
+func TestPlainAtlas(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 4, 4))
+	placements := []sheetPlacement{
+		{sprite: sheetSprite{name: "walk 1", img: img}, frame: image.Rect(0, 0, 4, 4), trim: img.Rect},
+		{sprite: sheetSprite{name: "#2", img: img}, frame: image.Rect(5, 1, 8, 3), trim: image.Rect(1, 1, 4, 3)},
+	}
+	var buf bytes.Buffer
+	if err := writeAtlas(&buf, "plain", "sheet.png", image.Pt(8, 4), placements); err != nil {
+		t.Fatal(err)
+	}
+	want := "# sheet.png 8x4\nwalk_1 0 0 4 4\n_#2 5 1 3 2\n"
+	if buf.String() != want {
+		t.Errorf("atlas\n%s\nwant\n%s", buf.String(), want)
+	}
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"archive/zip"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/png"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// A tile to be written to a project, with its content ID
+type savedTile struct {
+	id   string
+	tile *Tile
+}
+
+// Tiles of a project archive, decoded on first use and shared by ID
+type archiveTiles struct {
+	files  map[string]*zip.File
+	loaded map[string]*Tile
+}
+
+// Get a tile by content ID
+func (a *archiveTiles) tile(id string) (*Tile, error) {
+	if tile, ok := a.loaded[id]; ok {
+		return tile, nil
+	}
+	file, ok := a.files[id]
+	if !ok {
+		return nil, fmt.Errorf("tiles/%s.png not found", id)
+	}
+	tile, err := readTile(file)
+	if err != nil {
+		return nil, err
+	}
+	a.loaded[id] = tile
+	return tile, nil
+}
+
+// Put the referenced tiles into a store. Missing or unreadable tiles are
+// left empty and the first such error is returned.
+func (a *archiveTiles) load(store *TileStore, refs []TileRef) error {
+	var first error
+	for _, ref := range refs {
+		tile, err := a.tile(ref.ID)
+		if err != nil {
+			if first == nil {
+				first = err
+			}
+			continue
+		}
+		store.PutTile(ref.X, ref.Y, tile)
+	}
+	return first
+}
+
+// Content ID of a tile
+func tileID(t *Tile) string {
+	sum := sha1.Sum(t.pix)
+	return hex.EncodeToString(sum[:])
+}
+
+// List the non-empty tiles of a store. ids caches IDs of tiles already
+// seen, so shared tiles are hashed once.
+func tileRefs(store *TileStore, ids map[*Tile]string) ([]TileRef, []savedTile) {
+	var refs []TileRef
+	var tiles []savedTile
+	for ty := 0; ty < store.tilesY; ty++ {
+		for tx := 0; tx < store.tilesX; tx++ {
+			t := store.Tile(tx, ty)
+			if t == nil {
+				continue
+			}
+			id, ok := ids[t]
+			if !ok {
+				id = tileID(t)
+				ids[t] = id
+				tiles = append(tiles, savedTile{id: id, tile: t})
+			}
+			refs = append(refs, TileRef{X: tx, Y: ty, ID: id})
+		}
+	}
+	return refs, tiles
+}
+
+// Wrap tile pixels as an image without copying
+func tileImage(t *Tile) *image.NRGBA {
+	return &image.NRGBA{
+		Pix:    t.pix,
+		Stride: tileSize * 4,
+		Rect:   image.Rect(0, 0, tileSize, tileSize),
+	}
+}
+
+// Read a tile PNG from a project archive
+func readTile(file *zip.File) (*Tile, error) {
+	rc, err := file.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	tile, err := decodeTile(rc)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", file.Name, err)
+	}
+	return tile, nil
+}
+
+// Decode a tile PNG
+func decodeTile(r io.Reader) (*Tile, error) {
+	img, err := png.Decode(r)
+	if err != nil {
+		return nil, err
+	}
+	if img.Bounds().Dx() != tileSize || img.Bounds().Dy() != tileSize {
+		return nil, fmt.Errorf("tile is %dx%d, want %dx%d", img.Bounds().Dx(), img.Bounds().Dy(), tileSize, tileSize)
+	}
+
+	return &Tile{pix: toNRGBA(img).Pix}, nil
+}
+
+// Read a PNG file as straight-alpha pixels
+func readPNGFile(filename string) (*image.NRGBA, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	img, err := png.Decode(file)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", filepath.Base(filename), err)
+	}
+	return toNRGBA(img), nil
+}
+
+// Read a whole-layer PNG from a project archive (files without tile_size).
+// These were written with straight-alpha pixels in a premultiplied image,
+// so the premultiplied values are the layer pixels.
+func readLayerPNG(file *zip.File) (*image.NRGBA, error) {
+	rc, err := file.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	img, err := png.Decode(rc)
+	if err != nil {
+		return nil, err
+	}
+
+	rgba := image.NewRGBA(image.Rect(0, 0, img.Bounds().Dx(), img.Bounds().Dy()))
+	draw.Draw(rgba, rgba.Bounds(), img, img.Bounds().Min, draw.Src)
+	return &image.NRGBA{Pix: rgba.Pix, Stride: rgba.Stride, Rect: rgba.Rect}, nil
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+)
+
+// Size of a layer storage tile in pixels
+const tileSize = 64
+
+// Tile is a tileSize x tileSize block of straight-alpha RGBA pixels, top
+// row first. Tiles are never modified once stored, so they can be shared.
+type Tile struct {
+	pix []uint8
+}
+
+// TileStore holds a layer's pixels as a sparse grid of tiles. Fully
+// transparent tiles are not allocated. Writes replace whole tiles, so
+// cloning a store only copies the grid and the tiles stay shared
+// copy-on-write between the clones.
+type TileStore struct {
+	width  int
+	height int
+	tilesX int
+	tilesY int
+	tiles  []*Tile
+}
+
+// Create an empty (fully transparent) store
+func NewTileStore(width, height int) *TileStore {
+	tilesX := (width + tileSize - 1) / tileSize
+	tilesY := (height + tileSize - 1) / tileSize
+	return &TileStore{
+		width:  width,
+		height: height,
+		tilesX: tilesX,
+		tilesY: tilesY,
+		tiles:  make([]*Tile, tilesX*tilesY),
+	}
+}
+
+// Create a store holding the pixels of img
+func TileStoreFromNRGBA(img *image.NRGBA) *TileStore {
+	b := img.Bounds()
+	s := NewTileStore(b.Dx(), b.Dy())
+	s.SetNRGBA(img)
+	return s
+}
+
+// Copy the grid; tiles are shared
+func (s *TileStore) Clone() *TileStore {
+	c := *s
+	c.tiles = make([]*Tile, len(s.tiles))
+	copy(c.tiles, s.tiles)
+	return &c
+}
+
+// Get the tile at tile coordinates, nil if empty or out of range
+func (s *TileStore) Tile(tx, ty int) *Tile {
+	if tx < 0 || ty < 0 || tx >= s.tilesX || ty >= s.tilesY {
+		return nil
+	}
+	return s.tiles[ty*s.tilesX+tx]
+}
+
+// Store pixels for a tile, taking ownership of pix. Fully transparent
+// tiles are dropped, and an unchanged tile is kept so it stays shared.
+func (s *TileStore) SetTile(tx, ty int, pix []uint8) {
+	if tx < 0 || ty < 0 || tx >= s.tilesX || ty >= s.tilesY {
+		return
+	}
+	if pix == nil || isTransparent(pix) {
+		s.tiles[ty*s.tilesX+tx] = nil
+		return
+	}
+	if old := s.tiles[ty*s.tilesX+tx]; old != nil && bytes.Equal(old.pix, pix) {
+		return
+	}
+	s.tiles[ty*s.tilesX+tx] = &Tile{pix: pix}
+}
+
+// Share an existing tile at tile coordinates
+func (s *TileStore) PutTile(tx, ty int, tile *Tile) {
+	if tx < 0 || ty < 0 || tx >= s.tilesX || ty >= s.tilesY {
+		return
+	}
+	s.tiles[ty*s.tilesX+tx] = tile
+}
+
+// Check whether two stores hold the very same tiles
+func (s *TileStore) SameTiles(other *TileStore) bool {
+	if s.width != other.width || s.height != other.height {
+		return false
+	}
+	for i, t := range s.tiles {
+		if t != other.tiles[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Number of allocated tiles
+func (s *TileStore) TileCount() int {
+	n := 0
+	for _, t := range s.tiles {
+		if t != nil {
+			n++
+		}
+	}
+	return n
+}
+
+// Check whether any tile in a tile-coordinate rectangle is allocated
+func (s *TileStore) AnyIn(tx0, ty0, tx1, ty1 int) bool {
+	for ty := max(ty0, 0); ty < min(ty1, s.tilesY); ty++ {
+		for tx := max(tx0, 0); tx < min(tx1, s.tilesX); tx++ {
+			if s.tiles[ty*s.tilesX+tx] != nil {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Fill the whole store with one colour; all tiles share the same pixels
+func (s *TileStore) Fill(c color.RGBA) {
+	if c.A == 0 {
+		for i := range s.tiles {
+			s.tiles[i] = nil
+		}
+		return
+	}
+	pix := make([]uint8, tileSize*tileSize*4)
+	for i := 0; i < len(pix); i += 4 {
+		pix[i], pix[i+1], pix[i+2], pix[i+3] = c.R, c.G, c.B, c.A
+	}
+	tile := &Tile{pix: pix}
+	for i := range s.tiles {
+		s.tiles[i] = tile
+	}
+}
+
+// Read one pixel (straight alpha, as stored)
+func (s *TileStore) At(x, y int) color.RGBA {
+	if x < 0 || y < 0 || x >= s.width || y >= s.height {
+		return color.RGBA{}
+	}
+	t := s.tiles[(y/tileSize)*s.tilesX+x/tileSize]
+	if t == nil {
+		return color.RGBA{}
+	}
+	i := ((y%tileSize)*tileSize + x%tileSize) * 4
+	return color.RGBA{t.pix[i], t.pix[i+1], t.pix[i+2], t.pix[i+3]}
+}
+
+// Render the store into a full image
+func (s *TileStore) ToNRGBA() *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, s.width, s.height))
+	for ty := 0; ty < s.tilesY; ty++ {
+		for tx := 0; tx < s.tilesX; tx++ {
+			t := s.tiles[ty*s.tilesX+tx]
+			if t == nil {
+				continue
+			}
+			x0, y0 := tx*tileSize, ty*tileSize
+			w := min(tileSize, s.width-x0)
+			h := min(tileSize, s.height-y0)
+			for row := 0; row < h; row++ {
+				copy(img.Pix[(y0+row)*img.Stride+x0*4:], t.pix[row*tileSize*4:row*tileSize*4+w*4])
+			}
+		}
+	}
+	return img
+}
+
+// Replace the store contents with the pixels of img (same size as the store)
+func (s *TileStore) SetNRGBA(img *image.NRGBA) {
+	b := img.Bounds()
+	for ty := 0; ty < s.tilesY; ty++ {
+		for tx := 0; tx < s.tilesX; tx++ {
+			x0, y0 := tx*tileSize, ty*tileSize
+			w := min(min(tileSize, s.width-x0), b.Dx()-x0)
+			h := min(min(tileSize, s.height-y0), b.Dy()-y0)
+			if w <= 0 || h <= 0 {
+				s.tiles[ty*s.tilesX+tx] = nil
+				continue
+			}
+			pix := make([]uint8, tileSize*tileSize*4)
+			for row := 0; row < h; row++ {
+				src := img.PixOffset(b.Min.X+x0, b.Min.Y+y0+row)
+				copy(pix[row*tileSize*4:row*tileSize*4+w*4], img.Pix[src:src+w*4])
+			}
+			s.SetTile(tx, ty, pix)
+		}
+	}
+}
+
+func isTransparent(pix []uint8) bool {
+	for i := 3; i < len(pix); i += 4 {
+		if pix[i] != 0 {
+			return false
+		}
+	}
+	return true
+}
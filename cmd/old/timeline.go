@@ -0,0 +1,393 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+
+	rl "github.com/gen2brain/raylib-go/raylib"
+)
+
+// Timeline strip under the canvas: frame controls on top, a cell per
+// frame below
+const (
+	timelineHeight = 64
+	timelineY      = screenHeight - 24 - timelineHeight
+	frameCellWidth = 44
+	onionAlpha     = 144 // tint alpha of the nearest onion skin
+	maxOnionFrames = 5
+)
+
+// How playback continues past the last frame
+type PlayMode int
+
+const (
+	PlayLoop PlayMode = iota
+	PlayPingPong
+	PlayOnce
+)
+
+var playModeNames = []string{"LOOP", "PING-PONG", "ONCE"}
+
+// Composite of another frame shown under the current one, kept while its
+// cels and the layer properties stay the same
+type onionSkin struct {
+	key []onionKey
+	tex rl.Texture2D
+}
+
+type onionKey struct {
+	tiles   *TileStore
+	opacity float32
+	mode    LayerBlendMode
+}
+
+func (app *App) initTimeline() {
+	x := float32(leftPanel + 8)
+	add := func(text string, width, gap float32) {
+		app.timelineButtons = append(app.timelineButtons, Button{
+			rect: rl.Rectangle{X: x, Y: timelineY + 4, Width: width, Height: 18},
+			text: text,
+		})
+		x += width + gap
+	}
+	add("PLAY", 40, 4)
+	add(playModeNames[PlayLoop], 70, 12)
+	add("NEW", 36, 4)
+	add("DUP", 36, 4)
+	add("LINK", 36, 4)
+	add("UNLINK", 50, 4)
+	add("DEL", 36, 12)
+	add("-10MS", 44, 4)
+	add("+10MS", 44, 12)
+	add("ONION", 50, 64)
+	add("-", 18, 2)
+	add("+", 18, 58)
+	add("-", 18, 2)
+	add("+", 18, 8)
+
+	app.playDir = 1
+	app.onionBefore = 1
+	app.onionAfter = 1
+	app.onionTints = [2]rl.Color{{255, 80, 80, 255}, {80, 160, 255, 255}}
+}
+
+// Swatch of the onion tint for frames before (0) or after (1)
+func timelineTintRect(i int) rl.Rectangle {
+	return rl.Rectangle{X: leftPanel + 720 + float32(i)*22, Y: timelineY + 4, Width: 18, Height: 18}
+}
+
+// Number of frame cells that fit in the strip, and the first one shown,
+// keeping the current frame in view
+func (app *App) frameCells() (first, count int) {
+	count = (screenWidth - leftPanel - rightPanel - 16) / (frameCellWidth + 2)
+	first = max(0, min(app.frame-count/2, len(app.frames)-count))
+	return first, count
+}
+
+// Screen rectangle of frame f's cell
+func (app *App) frameCellRect(f int) rl.Rectangle {
+	first, _ := app.frameCells()
+	return rl.Rectangle{
+		X:      float32(leftPanel + 8 + (f-first)*(frameCellWidth+2)),
+		Y:      timelineY + 26,
+		Width:  frameCellWidth,
+		Height: timelineHeight - 30,
+	}
+}
+
+// Handle timeline clicks and frame keys
+func (app *App) updateTimeline(mousePos rl.Vector2) {
+	ctrl := rl.IsKeyDown(rl.KeyLeftControl) || rl.IsKeyDown(rl.KeyRightControl)
+	if !ctrl && !app.isDrawing {
+		if rl.IsKeyPressed(rl.KeyComma) {
+			app.StopPlayback()
+			app.SetFrame(app.frame - 1)
+		}
+		if rl.IsKeyPressed(rl.KeyPeriod) {
+			app.StopPlayback()
+			app.SetFrame(app.frame + 1)
+		}
+		if rl.IsKeyPressed(rl.KeyEnter) {
+			app.TogglePlayback()
+		}
+	}
+
+	if !rl.IsMouseButtonPressed(rl.MouseLeftButton) || app.isDrawing {
+		return
+	}
+	for i, btn := range app.timelineButtons {
+		if !rl.CheckCollisionPointRec(mousePos, btn.rect) {
+			continue
+		}
+		if i >= 2 && i <= 8 {
+			app.StopPlayback()
+		}
+		switch i {
+		case 0:
+			app.TogglePlayback()
+		case 1:
+			app.playMode = (app.playMode + 1) % PlayMode(len(playModeNames))
+			app.playDir = 1
+		case 2:
+			app.NewFrame()
+		case 3:
+			app.DuplicateFrame()
+		case 4:
+			app.LinkedFrame()
+		case 5:
+			app.UnlinkCel()
+		case 6:
+			app.DeleteFrame()
+		case 7:
+			app.AdjustFrameDuration(-10)
+		case 8:
+			app.AdjustFrameDuration(10)
+		case 9:
+			app.onionSkin = !app.onionSkin
+		case 10:
+			app.onionBefore = max(0, app.onionBefore-1)
+		case 11:
+			app.onionBefore = min(app.onionBefore+1, maxOnionFrames)
+		case 12:
+			app.onionAfter = max(0, app.onionAfter-1)
+		case 13:
+			app.onionAfter = min(app.onionAfter+1, maxOnionFrames)
+		}
+		return
+	}
+
+	// Clicking a tint swatch sets it to the current colour
+	for i := range app.onionTints {
+		if rl.CheckCollisionPointRec(mousePos, timelineTintRect(i)) {
+			app.onionTints[i] = app.currentColor
+			app.onionTints[i].A = 255
+			return
+		}
+	}
+
+	first, count := app.frameCells()
+	for f := first; f < min(first+count, len(app.frames)); f++ {
+		if rl.CheckCollisionPointRec(mousePos, app.frameCellRect(f)) {
+			app.StopPlayback()
+			app.SetFrame(f)
+			return
+		}
+	}
+}
+
+// Start or stop previewing the animation
+func (app *App) TogglePlayback() {
+	if app.playing {
+		app.StopPlayback()
+		return
+	}
+	if len(app.frames) < 2 {
+		app.SetStatus("ONLY ONE FRAME")
+		return
+	}
+	if app.playMode == PlayOnce && app.frame == len(app.frames)-1 {
+		app.SetFrame(0)
+	}
+	app.playing = true
+	app.playDir = 1
+	app.playTime = 0
+}
+
+// Stop previewing the animation, staying on the frame shown
+func (app *App) StopPlayback() {
+	app.playing = false
+}
+
+// Advance the preview by the time since the last update
+func (app *App) updatePlayback() {
+	if !app.playing || app.isDrawing {
+		return
+	}
+	app.playTime += rl.GetFrameTime() * 1000
+	for app.playing && app.playTime >= float32(app.frames[app.frame].duration) {
+		app.playTime -= float32(app.frames[app.frame].duration)
+		app.SetFrame(app.nextPlayFrame())
+	}
+}
+
+// The frame shown after the current one while playing
+func (app *App) nextPlayFrame() int {
+	n := len(app.frames)
+	next := app.frame + app.playDir
+	if next >= 0 && next < n {
+		return next
+	}
+	switch app.playMode {
+	case PlayLoop:
+		return (next + n) % n
+	case PlayPingPong:
+		app.playDir = -app.playDir
+		return max(0, min(app.frame+app.playDir, n-1))
+	}
+	app.playing = false
+	return app.frame
+}
+
+// Pixels of a layer in a frame as they look now; nil for an empty frame
+func (d *Document) frameTiles(layer *Layer, frame int) *TileStore {
+	c := layer.cel(frame)
+	switch {
+	case frame == d.frame || c != nil && c == layer.cel(d.frame):
+		return layer.tiles
+	case c == nil:
+		return nil
+	}
+	return c.tiles
+}
+
+// Texture of another frame for onion skinning. Layers that look the same
+// as in the current frame are left out, so a shared background doesn't
+// cover the canvas.
+func (d *Document) onionTexture(frame int) rl.Texture2D {
+	var key []onionKey
+	stack := compositeStack{mode: d.compositing}
+	palette := d.paletteColors()
+	for _, layer := range d.layers {
+		tiles := d.frameTiles(layer, frame)
+		if !layer.visible || tiles == nil || sameCel(tiles, layer.tiles) {
+			key = append(key, onionKey{})
+			continue
+		}
+		key = append(key, onionKey{tiles, layer.opacity, layer.blendMode})
+		stack.layers = append(stack.layers, compositeLayer{tiles, layer.opacity, layer.blendMode, palette})
+	}
+
+	skin := d.onion[frame]
+	if skin != nil && slices.Equal(skin.key, key) {
+		return skin.tex
+	}
+	if skin != nil {
+		rl.UnloadTexture(skin.tex)
+	}
+	if d.onion == nil {
+		d.onion = make(map[int]*onionSkin)
+	}
+	skin = &onionSkin{key: key, tex: loadTextureNRGBA(compositeImage(stack, d.canvasWidth, d.canvasHeight))}
+	d.onion[frame] = skin
+	return skin.tex
+}
+
+// Free the onion skin textures
+func (d *Document) unloadOnion() {
+	for f, skin := range d.onion {
+		rl.UnloadTexture(skin.tex)
+		delete(d.onion, f)
+	}
+}
+
+// Draw nearby frames over the canvas, fainter with distance
+func (app *App) drawOnionSkins() {
+	if !app.onionSkin || app.playing {
+		app.unloadOnion()
+		return
+	}
+	shown := make(map[int]bool)
+	draw := func(frame, dist int, tint rl.Color) {
+		if frame < 0 || frame >= len(app.frames) {
+			return
+		}
+		shown[frame] = true
+		tex := app.onionTexture(frame)
+		tint.A = uint8(onionAlpha / dist)
+		src := rl.Rectangle{Width: float32(app.canvasWidth), Height: float32(app.canvasHeight)}
+		dst := rl.Rectangle{
+			X:      leftPanel + app.panX,
+			Y:      topBar + app.panY,
+			Width:  float32(app.canvasWidth) * app.zoom,
+			Height: float32(app.canvasHeight) * app.zoom,
+		}
+		rl.DrawTexturePro(tex, src, dst, rl.Vector2{}, 0, tint)
+	}
+	// Farthest first, so the nearest frames are on top
+	for dist := max(app.onionBefore, app.onionAfter); dist > 0; dist-- {
+		if dist <= app.onionBefore {
+			draw(app.frame-dist, dist, app.onionTints[0])
+		}
+		if dist <= app.onionAfter {
+			draw(app.frame+dist, dist, app.onionTints[1])
+		}
+	}
+
+	for f, skin := range app.onion {
+		if !shown[f] {
+			rl.UnloadTexture(skin.tex)
+			delete(app.onion, f)
+		}
+	}
+}
+
+// Draw the timeline strip
+func (app *App) drawTimeline(mousePos rl.Vector2) {
+	rl.DrawRectangle(leftPanel, timelineY, screenWidth-leftPanel-rightPanel, timelineHeight, rl.Color{45, 45, 45, 255})
+	rl.DrawLine(leftPanel, timelineY, screenWidth-rightPanel, timelineY, rl.Color{90, 90, 90, 255})
+
+	for i, btn := range app.timelineButtons {
+		switch i {
+		case 0:
+			btn.text = "PLAY"
+			if app.playing {
+				btn.text = "STOP"
+			}
+			btn.selected = app.playing
+		case 1:
+			btn.text = playModeNames[app.playMode]
+		case 9:
+			btn.selected = app.onionSkin
+		}
+		drawButton(btn, rl.CheckCollisionPointRec(mousePos, btn.rect))
+	}
+	before, after := app.timelineButtons[10].rect, app.timelineButtons[12].rect
+	rl.DrawText(fmt.Sprintf("BEFORE %d", app.onionBefore), int32(before.X)-58, int32(before.Y)+5, fontSize, rl.LightGray)
+	rl.DrawText(fmt.Sprintf("AFTER %d", app.onionAfter), int32(after.X)-52, int32(after.Y)+5, fontSize, rl.LightGray)
+	for i, tint := range app.onionTints {
+		rect := timelineTintRect(i)
+		rl.DrawRectangleRec(rect, tint)
+		rl.DrawRectangleLinesEx(rect, 1, rl.Color{90, 90, 90, 255})
+	}
+
+	info := fmt.Sprintf("FRAME %d/%d  %dMS", app.frame+1, len(app.frames), app.frames[app.frame].duration)
+	rl.DrawText(info, screenWidth-rightPanel-8-rl.MeasureText(info, fontSize), timelineY+9, fontSize, rl.White)
+
+	// Frame cells, with the active layer's cel in each
+	layer := app.layers[app.activeLayer]
+	first, count := app.frameCells()
+	for f := first; f < min(first+count, len(app.frames)); f++ {
+		rect := app.frameCellRect(f)
+		color := rl.Color{60, 60, 60, 255}
+		switch {
+		case f == app.frame:
+			color = rl.Color{100, 100, 150, 255}
+		case rl.CheckCollisionPointRec(mousePos, rect):
+			color = rl.Color{75, 75, 75, 255}
+		}
+		rl.DrawRectangleRec(rect, color)
+
+		// Onion skinned frames get their tint along the top
+		if app.onionSkin && f < app.frame && app.frame-f <= app.onionBefore {
+			rl.DrawRectangle(int32(rect.X), int32(rect.Y), int32(rect.Width), 2, app.onionTints[0])
+		}
+		if app.onionSkin && f > app.frame && f-app.frame <= app.onionAfter {
+			rl.DrawRectangle(int32(rect.X), int32(rect.Y), int32(rect.Width), 2, app.onionTints[1])
+		}
+
+		rl.DrawText(fmt.Sprintf("%d", f+1), int32(rect.X)+4, int32(rect.Y)+4, fontSize, rl.White)
+		rl.DrawText(fmt.Sprintf("%d", app.frames[f].duration), int32(rect.X)+4, int32(rect.Y)+14, fontSize, rl.Gray)
+
+		marker := rl.Rectangle{X: rect.X + 4, Y: rect.Y + rect.Height - 9, Width: 10, Height: 6}
+		if tiles := app.frameTiles(layer, f); tiles != nil && tiles.TileCount() > 0 {
+			rl.DrawRectangleRec(marker, rl.LightGray)
+		} else {
+			rl.DrawRectangleLinesEx(marker, 1, rl.Gray)
+		}
+		// A line joins cels linked to the previous frame
+		if c := layer.cel(f); c != nil && c == layer.cel(f-1) && f > first {
+			y := int32(marker.Y + marker.Height/2)
+			rl.DrawLine(int32(marker.X)-36, y, int32(marker.X), y, rl.LightGray)
+		}
+	}
+}
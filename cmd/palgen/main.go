@@ -0,0 +1,285 @@
+// Command palgen writes palettes in every format the palette package
+// exports, a directory per palette, with an index.html linking them for
+// releases. With no files it writes the bundled deluxe collection.
+//
+// Usage:
+//
+//	palgen [flags] [palette files...]
+//
+// Output depends only on the palettes, so release builds can be checked
+// byte for byte.
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"go/token"
+	"html"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/ha1tch/deluxedraw/palette"
+)
+
+func main() {
+	os.Exit(run(os.Args[1:]))
+}
+
+// Run palgen with command line arguments and return its exit status
+func run(args []string) int {
+	flags := flag.NewFlagSet("palgen", flag.ExitOnError)
+	out := flags.String("out", "palettes", "output `directory`")
+	formats := flags.String("formats", "", "comma-separated exporters to run, all if empty")
+	index := flags.Bool("html", true, "write index.html linking every file")
+	goPackage := flags.String("gopkg", "palettes", "package `name` of generated Go files")
+	verbose := flags.Bool("v", false, "list each colour with its luminance")
+	sortBy := flags.String("sort", "", "write colours in this `order`: "+strings.Join(palette.OrderNames, ", ")+"; as given if empty")
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: palgen [flags] [palette files...]\n\nExporters:")
+		for _, e := range palette.Exporters {
+			fmt.Fprintf(os.Stderr, "  %-10s %s\n", e.Name(), e.Label())
+		}
+		fmt.Fprintln(os.Stderr, "\nFlags:")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if !token.IsIdentifier(*goPackage) {
+		fmt.Fprintf(os.Stderr, "palgen: %q is not a Go package name\n", *goPackage)
+		return 2
+	}
+	exporters, err := chooseExporters(*formats, *goPackage)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "palgen: %v\n", err)
+		return 2
+	}
+	order := slices.Index(palette.OrderNames, *sortBy)
+	if *sortBy != "" && order < 0 {
+		fmt.Fprintf(os.Stderr, "palgen: unknown order %q\n", *sortBy)
+		return 2
+	}
+
+	palettes := palette.Collection()
+	if flags.NArg() > 0 {
+		palettes = nil
+		for _, filename := range flags.Args() {
+			p, err := palette.Load(filename)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "palgen: %v\n", err)
+				return 1
+			}
+			palettes = append(palettes, p)
+		}
+	}
+	if order >= 0 {
+		for i, p := range palettes {
+			palettes[i] = p.Sorted(palette.Order(order))
+		}
+	}
+
+	status := 0
+	var written [][]string // files of each palette, by exporter
+	for _, p := range palettes {
+		files, err := writePalette(*out, p, exporters, *verbose)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "palgen: %s: %v\n", p.Name, err)
+			status = 1
+		}
+		written = append(written, files)
+	}
+	if *index {
+		if err := writeFile(filepath.Join(*out, "index.html"), indexHTML(palettes, exporters, written)); err != nil {
+			fmt.Fprintf(os.Stderr, "palgen: %v\n", err)
+			status = 1
+		}
+	}
+	fmt.Printf("Output directory: %s/\n", *out)
+	return status
+}
+
+// Exporters named on the command line, in the order given, or all
+func chooseExporters(names, goPackage string) ([]palette.Exporter, error) {
+	var exporters []palette.Exporter
+	if names == "" {
+		exporters = slices.Clone(palette.Exporters)
+	} else {
+		for _, name := range strings.Split(names, ",") {
+			e, ok := palette.ExporterFor(strings.TrimSpace(name))
+			if !ok {
+				return nil, fmt.Errorf("unknown exporter %q", name)
+			}
+			exporters = append(exporters, e)
+		}
+	}
+	for i, e := range exporters {
+		if _, ok := e.(palette.GoExporter); ok {
+			exporters[i] = palette.GoExporter{Package: goPackage}
+		}
+	}
+	return exporters, nil
+}
+
+// Write a palette in every format into a directory named after it, and
+// return the files written, empty where a format cannot hold it
+func writePalette(out string, p *palette.Palette, exporters []palette.Exporter, verbose bool) ([]string, error) {
+	files := make([]string, len(exporters))
+	// Names come from palette files, and must not lead out of the
+	// output directory
+	if filepath.Base(p.Name) != p.Name || p.Name == "." || !filepath.IsLocal(p.Name) {
+		return files, fmt.Errorf("%q cannot name a directory", p.Name)
+	}
+	fmt.Printf("# %s - %d colors\n", p.Name, len(p.Colors))
+	if verbose {
+		for _, c := range p.Colors {
+			fmt.Printf("   %s (L=%.2f) - %s\n", c.Name, c.Luminance(), c.Hex())
+		}
+	}
+	dir := filepath.Join(out, p.Name)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return files, err
+	}
+	count := 0
+	for i, e := range exporters {
+		var buf bytes.Buffer
+		err := e.Export(&buf, p)
+		if errors.Is(err, palette.ErrNotRepresentable) {
+			fmt.Printf("   skipped %s: %v\n", e.Label(), err)
+			continue
+		}
+		if err != nil {
+			return files, fmt.Errorf("%s: %v", e.Label(), err)
+		}
+		files[i] = e.File(p)
+		if filepath.Base(files[i]) != files[i] || !filepath.IsLocal(files[i]) {
+			return files, fmt.Errorf("%s: %q cannot name a file", e.Label(), files[i])
+		}
+		if err := writeFile(filepath.Join(dir, files[i]), buf.Bytes()); err != nil {
+			return files, err
+		}
+		count++
+	}
+	fmt.Printf("Exported palette: %s (%d colors) - %d formats\n", p.Name, len(p.Colors), count)
+	return files, nil
+}
+
+// Write a file whole, replacing any old one
+func writeFile(filename string, data []byte) error {
+	tmp := filename + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filename)
+}
+
+const indexHead = `<!DOCTYPE html>
+<html lang="en">
+<head>
+    <meta charset="UTF-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1.0">
+    <title>Deluxe Draw Color Palettes</title>
+    <style>
+        body {
+            font-family: -apple-system, BlinkMacSystemFont, 'Segoe UI', Roboto, sans-serif;
+            margin: 0;
+            padding: 20px;
+            background-color: #f5f5f5;
+        }
+        .container {
+            max-width: 1200px;
+            margin: 0 auto;
+        }
+        h1 {
+            text-align: center;
+            color: #333;
+            margin-bottom: 10px;
+        }
+        .subtitle {
+            text-align: center;
+            color: #666;
+            margin-bottom: 40px;
+        }
+        .palette {
+            background: white;
+            border-radius: 8px;
+            padding: 20px;
+            margin-bottom: 30px;
+            box-shadow: 0 2px 4px rgba(0,0,0,0.1);
+        }
+        .palette h2 {
+            margin-top: 0;
+            color: #333;
+        }
+        .palette-info {
+            color: #666;
+            font-size: 14px;
+            margin-bottom: 10px;
+        }
+        .preview {
+            margin: 20px 0;
+        }
+        .preview img {
+            max-width: 100%;
+            height: auto;
+            border: 1px solid #ddd;
+        }
+        .downloads {
+            display: flex;
+            flex-wrap: wrap;
+            gap: 10px;
+        }
+        .download-link {
+            padding: 6px 12px;
+            background: #007bff;
+            color: white;
+            text-decoration: none;
+            border-radius: 4px;
+            font-size: 14px;
+        }
+        .download-link:hover {
+            background: #0056b3;
+        }
+    </style>
+</head>
+<body>
+    <div class="container">
+        <h1>Deluxe Draw Color Palettes</h1>
+        <p class="subtitle">Professional color palettes in multiple formats</p>
+
+`
+
+// The release page: each palette with its swatch sheet, if written, and
+// links to its files
+func indexHTML(palettes []*palette.Palette, exporters []palette.Exporter, written [][]string) []byte {
+	var b bytes.Buffer
+	b.WriteString(indexHead)
+	for i, p := range palettes {
+		name := html.EscapeString(p.Name)
+		title := p.Title
+		if title == "" {
+			title = p.Name
+		}
+		fmt.Fprintf(&b, "        <div class=\"palette\">\n            <h2>%s</h2>\n", html.EscapeString(title))
+		fmt.Fprintf(&b, "            <div class=\"palette-info\">%s • %d colors</div>\n", name, len(p.Colors))
+		for j, e := range exporters {
+			if e.Name() == "png" && written[i][j] != "" {
+				fmt.Fprintf(&b, "            <div class=\"preview\">\n                <img src=\"%s/%s\" alt=\"%s palette\">\n            </div>\n",
+					name, html.EscapeString(written[i][j]), name)
+			}
+		}
+		b.WriteString("            <div class=\"downloads\">\n")
+		for j, e := range exporters {
+			if written[i][j] == "" {
+				continue
+			}
+			fmt.Fprintf(&b, "                <a href=\"%s/%s\" class=\"download-link\">%s</a>\n",
+				name, html.EscapeString(written[i][j]), html.EscapeString(e.Label()))
+		}
+		b.WriteString("            </div>\n        </div>\n\n")
+	}
+	b.WriteString("    </div>\n</body>\n</html>")
+	return b.Bytes()
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bytes"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/ha1tch/deluxedraw/palette"
+)
+
+// Every file under a directory, by slash path
+func readTree(t *testing.T, dir string) map[string][]byte {
+	t.Helper()
+	files := make(map[string][]byte)
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		rel, _ := filepath.Rel(dir, path)
+		files[filepath.ToSlash(rel)], err = os.ReadFile(path)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+func TestRunDeterministic(t *testing.T) {
+	gpl := filepath.Join(t.TempDir(), "mine.gpl")
+	if err := os.WriteFile(gpl, []byte("GIMP Palette\nName: Mine\n#\n255 0 0 Red\n0 128 255 Sky\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	for _, args := range [][]string{nil, {"-sort", "hue", gpl}} {
+		a, b := t.TempDir(), t.TempDir()
+		if status := run(append([]string{"-out", a}, args...)); status != 0 {
+			t.Fatalf("palgen %v exited with %d", args, status)
+		}
+		first := readTree(t, a)
+		run(append([]string{"-out", b}, args...))
+		// Writing over earlier output leaves the same files
+		run(append([]string{"-out", a}, args...))
+		for _, dir := range []string{a, b} {
+			files := readTree(t, dir)
+			if len(files) != len(first) {
+				t.Errorf("palgen %v: %d files, then %d", args, len(first), len(files))
+			}
+			for name, data := range first {
+				if !bytes.Equal(files[name], data) {
+					t.Errorf("palgen %v: %s differs between runs", args, name)
+				}
+			}
+		}
+		if _, ok := first["index.html"]; !ok || len(first) < 2 {
+			t.Errorf("palgen %v wrote only %d files", args, len(first))
+		}
+	}
+}
+
+func TestWritePaletteName(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "out")
+	colors := []palette.Color{{R: 255, A: 255}}
+	for _, name := range []string{"../escape", "..", ".", "", "a/b", "/abs"} {
+		if _, err := writePalette(out, &palette.Palette{Name: name, Colors: colors}, palette.Exporters, false); err == nil {
+			t.Errorf("palette named %q written", name)
+		}
+	}
+	if entries, _ := os.ReadDir(filepath.Dir(out)); len(entries) != 0 {
+		t.Errorf("files written: %v", entries)
+	}
+	if _, err := writePalette(out, &palette.Palette{Name: "fine", Colors: colors}, palette.Exporters, false); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestChooseExportersGoPackage(t *testing.T) {
+	for _, names := range []string{"", "go,gpl"} {
+		exporters, err := chooseExporters(names, "mine")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !slices.Contains(exporters, palette.Exporter(palette.GoExporter{Package: "mine"})) {
+			t.Errorf("%q: package not set in %v", names, exporters)
+		}
+	}
+	// The package's own list is left alone for later runs
+	for _, e := range palette.Exporters {
+		if g, ok := e.(palette.GoExporter); ok && g.Package != "palettes" {
+			t.Errorf("palette.Exporters has Go package %q", g.Package)
+		}
+	}
+}
@@ -0,0 +1,577 @@
+package palette
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"slices"
+	"unicode/utf16"
+)
+
+// Adobe swatch files, as described in cmd/attic/palette/adobe_palettes.md:
+// ACO from Photoshop and ASE from Illustrator and the rest of the suite.
+// Swatches keep the colour model and values the file stores, so reading
+// and writing a file gives it back unchanged.
+
+// Colour model of a swatch
+type Model string
+
+const (
+	ModelRGB  Model = "RGB"
+	ModelHSB  Model = "HSB" // ACO only
+	ModelCMYK Model = "CMYK"
+	ModelLab  Model = "LAB"
+	ModelGray Model = "Gray"
+)
+
+// How an ASE swatch behaves in print
+type SwatchType uint16
+
+const (
+	Global  SwatchType = 0
+	Spot    SwatchType = 1
+	Process SwatchType = 2
+)
+
+// A colour as an Adobe file stores it. Values hold the model's channels,
+// unused ones 0:
+//
+//	RGB, HSB  0-1 each
+//	CMYK      ink coverage 0-1
+//	LAB       L 0-100, a and b -128 to 127, D50
+//	Gray      0 black to 1 white
+type Swatch struct {
+	Name   string
+	Model  Model
+	Values [4]float64
+	Type   SwatchType // ASE only
+}
+
+// A run of swatches, in file order. A run that starts a group holds the
+// swatches before any group nested in it; the swatches after a nested
+// group ends are a run of their own, at the depth of the group they are in.
+type SwatchGroup struct {
+	Name     string // of the group the run starts
+	Start    bool   // the run starts a group, which may be unnamed; implied by a name
+	Depth    int    // groups the run is nested in; 0 for loose swatches and top groups
+	Swatches []Swatch
+}
+
+// Whether the run starts a group
+func (g SwatchGroup) group() bool {
+	return g.Start || g.Name != ""
+}
+
+// The swatches of an Adobe file, in order
+type SwatchBook struct {
+	Groups []SwatchGroup
+}
+
+// Writers refuse books without swatches rather than emit empty files
+var ErrNoSwatches = errors.New("no swatches")
+
+// RGBSwatch returns a colour as a global RGB swatch.
+func RGBSwatch(c Color) Swatch {
+	return Swatch{Name: c.Name, Model: ModelRGB, Values: [4]float64{float64(c.R) / 255, float64(c.G) / 255, float64(c.B) / 255}}
+}
+
+// Color returns the swatch in sRGB. CMYK converts without a colour
+// profile.
+func (s Swatch) Color() Color {
+	v := s.Values
+	var c Color
+	switch s.Model {
+	case ModelRGB:
+		c = Color{R: unit8(v[0]), G: unit8(v[1]), B: unit8(v[2]), A: 255}
+	case ModelHSB:
+		c = FromHSV(v[0], v[1], v[2])
+	case ModelCMYK:
+		c = cmyk(v[0], v[1], v[2], v[3])
+	case ModelLab:
+		c = lab(v[0], v[1], v[2])
+	case ModelGray:
+		g := unit8(v[0])
+		c = Color{R: g, G: g, B: g, A: 255}
+	}
+	c.Name = s.Name
+	return c
+}
+
+// BookOf returns a palette's colours as ungrouped RGB swatches.
+func BookOf(p *Palette) *SwatchBook {
+	group := SwatchGroup{}
+	for _, c := range p.Colors {
+		group.Swatches = append(group.Swatches, RGBSwatch(c))
+	}
+	return &SwatchBook{Groups: []SwatchGroup{group}}
+}
+
+// Palette returns every swatch of the book in sRGB, groups flattened.
+func (b *SwatchBook) Palette() *Palette {
+	p := &Palette{}
+	for _, g := range b.Groups {
+		for _, s := range g.Swatches {
+			p.Colors = append(p.Colors, s.Color())
+		}
+	}
+	return p
+}
+
+// Number of swatches in all groups
+func (b *SwatchBook) count() int {
+	n := 0
+	for _, g := range b.Groups {
+		n += len(g.Swatches)
+	}
+	return n
+}
+
+// Colour from an 8-bit channel value in 0-1
+func unit8(v float64) uint8 {
+	return uint8(math.Round(min(1, max(0, v)) * 255))
+}
+
+// Colour from CMYK ink coverage, each 0-1, without a colour profile
+func cmyk(c, m, y, k float64) Color {
+	return Color{R: unit8((1 - c) * (1 - k)), G: unit8((1 - m) * (1 - k)), B: unit8((1 - y) * (1 - k)), A: 255}
+}
+
+// Colour from CIELAB under D50, as Adobe files use it
+func lab(l, a, b float64) Color {
+	fy := (l + 16) / 116
+	fx, fz := fy+a/500, fy-b/200
+	finv := func(t float64) float64 {
+		if t > 6.0/29 {
+			return t * t * t
+		}
+		return 3 * (6.0 / 29) * (6.0 / 29) * (t - 4.0/29)
+	}
+	x, y, z := 0.96422*finv(fx), finv(fy), 0.82521*finv(fz)
+	// D50 XYZ to linear sRGB, Bradford adapted
+	r := 3.1338561*x - 1.6168667*y - 0.4906146*z
+	g := -0.9787684*x + 1.9161415*y + 0.0334540*z
+	bl := 0.0719453*x - 0.2289914*y + 1.4052427*z
+	encode := func(v float64) uint8 {
+		if v <= 0.0031308 {
+			return unit8(12.92 * v)
+		}
+		return unit8(1.055*math.Pow(v, 1/2.4) - 0.055)
+	}
+	return Color{R: encode(r), G: encode(g), B: encode(bl), A: 255}
+}
+
+// Read a big-endian value
+func readBE[T uint16 | uint32](r io.Reader) (T, error) {
+	var v T
+	err := binary.Read(r, binary.BigEndian, &v)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return v, err
+}
+
+// Decode UTF-16 code units, dropping a trailing NUL
+func fromUTF16(units []uint16) string {
+	if n := len(units); n > 0 && units[n-1] == 0 {
+		units = units[:n-1]
+	}
+	return string(utf16.Decode(units))
+}
+
+// UTF-16 code units of a name with its NUL
+func toUTF16(name string) []uint16 {
+	return append(utf16.Encode([]rune(name)), 0)
+}
+
+// ACO colour spaces
+const (
+	acoRGB  = 0
+	acoHSB  = 1
+	acoCMYK = 2
+	acoLab  = 7
+	acoGray = 8
+)
+
+// ReadACO reads a Photoshop colour swatch file as a palette.
+func ReadACO(r io.Reader) (*Palette, error) {
+	book, err := DecodeACO(r)
+	if err != nil {
+		return nil, err
+	}
+	return book.Palette().check()
+}
+
+// DecodeACO reads a Photoshop colour swatch file: version 1, optionally
+// followed by version 2 with the same colours and their names. The
+// swatches are in one ungrouped run.
+func DecodeACO(r io.Reader) (*SwatchBook, error) {
+	br := bufio.NewReader(r)
+	var swatches []Swatch
+	for version := uint16(1); version <= 2; version++ {
+		v, err := readBE[uint16](br)
+		if err != nil {
+			if version == 2 && err == io.ErrUnexpectedEOF {
+				// Version 1 only
+				break
+			}
+			return nil, err
+		}
+		if v != version {
+			if version == 1 && v == 2 {
+				// Some writers leave version 1 out
+				version = 2
+			} else {
+				return nil, fmt.Errorf("version %d where %d was expected", v, version)
+			}
+		}
+		count, err := readBE[uint16](br)
+		if err != nil {
+			return nil, err
+		}
+		if int(count) > MaxColors {
+			return nil, fmt.Errorf("more than %d colors", MaxColors)
+		}
+		swatches = make([]Swatch, count)
+		for i := range swatches {
+			if swatches[i], err = readACOSwatch(br); err != nil {
+				return nil, fmt.Errorf("color %d: %v", i, err)
+			}
+			if version == 1 {
+				continue
+			}
+			// Length in UTF-16 units, counting a final NUL
+			n, err := readBE[uint32](br)
+			if err != nil {
+				return nil, err
+			}
+			if n > 1024 {
+				return nil, fmt.Errorf("color %d: name of %d characters", i, n)
+			}
+			units := make([]uint16, n)
+			if err := binary.Read(br, binary.BigEndian, units); err != nil {
+				return nil, io.ErrUnexpectedEOF
+			}
+			swatches[i].Name = fromUTF16(units)
+		}
+	}
+	return &SwatchBook{Groups: []SwatchGroup{{Swatches: swatches}}}, nil
+}
+
+// Read one 10-byte ACO colour record
+func readACOSwatch(r io.Reader) (Swatch, error) {
+	var rec [5]uint16
+	if err := binary.Read(r, binary.BigEndian, &rec); err != nil {
+		return Swatch{}, io.ErrUnexpectedEOF
+	}
+	u := func(i int) float64 { return float64(rec[i]) / 65535 }
+	switch rec[0] {
+	case acoRGB:
+		return Swatch{Model: ModelRGB, Values: [4]float64{u(1), u(2), u(3)}}, nil
+	case acoHSB:
+		return Swatch{Model: ModelHSB, Values: [4]float64{u(1), u(2), u(3)}}, nil
+	case acoCMYK:
+		// 65535 for no ink
+		return Swatch{Model: ModelCMYK, Values: [4]float64{1 - u(1), 1 - u(2), 1 - u(3), 1 - u(4)}}, nil
+	case acoLab:
+		// L in hundredths, a and b signed in hundredths
+		return Swatch{Model: ModelLab, Values: [4]float64{float64(rec[1]) / 100, float64(int16(rec[2])) / 100, float64(int16(rec[3])) / 100}}, nil
+	case acoGray:
+		// 0-10000 of black ink
+		return Swatch{Model: ModelGray, Values: [4]float64{1 - float64(rec[1])/10000}}, nil
+	}
+	return Swatch{}, fmt.Errorf("colour space %d is not supported", rec[0])
+}
+
+// WriteACO writes a palette as a Photoshop colour swatch file.
+func WriteACO(w io.Writer, p *Palette) error {
+	return EncodeACO(w, BookOf(p))
+}
+
+// EncodeACO writes swatches as a Photoshop colour swatch file, version 1
+// then version 2 with names. ACO has no groups or swatch types; groups
+// are flattened.
+func EncodeACO(w io.Writer, book *SwatchBook) error {
+	n := book.count()
+	switch {
+	case n == 0:
+		return ErrNoSwatches
+	case n > math.MaxUint16:
+		return fmt.Errorf("%d swatches, ACO allows %d", n, math.MaxUint16)
+	}
+	var buf bytes.Buffer
+	for version := uint16(1); version <= 2; version++ {
+		binary.Write(&buf, binary.BigEndian, [2]uint16{version, uint16(n)})
+		for _, g := range book.Groups {
+			for _, s := range g.Swatches {
+				rec, err := acoRecord(s)
+				if err != nil {
+					return fmt.Errorf("%q: %v", s.Name, err)
+				}
+				binary.Write(&buf, binary.BigEndian, rec)
+				if version == 2 {
+					name := toUTF16(s.Name)
+					binary.Write(&buf, binary.BigEndian, uint32(len(name)))
+					binary.Write(&buf, binary.BigEndian, name)
+				}
+			}
+		}
+	}
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// The 10-byte ACO record of a swatch
+func acoRecord(s Swatch) ([5]uint16, error) {
+	v := s.Values
+	u := func(x float64) uint16 { return uint16(math.Round(min(1, max(0, x)) * 65535)) }
+	hundredths := func(x float64) uint16 { return uint16(int16(math.Round(min(127, max(-128, x)) * 100))) }
+	switch s.Model {
+	case ModelRGB:
+		return [5]uint16{acoRGB, u(v[0]), u(v[1]), u(v[2])}, nil
+	case ModelHSB:
+		return [5]uint16{acoHSB, u(v[0]), u(v[1]), u(v[2])}, nil
+	case ModelCMYK:
+		return [5]uint16{acoCMYK, u(1 - v[0]), u(1 - v[1]), u(1 - v[2]), u(1 - v[3])}, nil
+	case ModelLab:
+		return [5]uint16{acoLab, uint16(math.Round(min(100, max(0, v[0])) * 100)), hundredths(v[1]), hundredths(v[2])}, nil
+	case ModelGray:
+		return [5]uint16{acoGray, uint16(math.Round((1 - min(1, max(0, v[0]))) * 10000))}, nil
+	}
+	return [5]uint16{}, fmt.Errorf("colour model %q is not supported", s.Model)
+}
+
+// ASE block types
+const (
+	aseGroupStart = 0xC001
+	aseGroupEnd   = 0xC002
+	aseColor      = 0x0001
+)
+
+// ASE model tag and channel count
+type aseModel struct {
+	tag      string
+	model    Model
+	channels int
+}
+
+var aseModels = []aseModel{
+	{"RGB ", ModelRGB, 3},
+	{"CMYK", ModelCMYK, 4},
+	{"LAB ", ModelLab, 3},
+	{"Gray", ModelGray, 1},
+}
+
+// ReadASE reads an Adobe Swatch Exchange file as a palette, groups
+// flattened.
+func ReadASE(r io.Reader) (*Palette, error) {
+	book, err := DecodeASE(r)
+	if err != nil {
+		return nil, err
+	}
+	return book.Palette().check()
+}
+
+// DecodeASE reads an Adobe Swatch Exchange file.
+func DecodeASE(r io.Reader) (*SwatchBook, error) {
+	br := bufio.NewReader(r)
+	var header struct {
+		Magic        [4]byte
+		Major, Minor uint16
+		Blocks       uint32
+	}
+	if err := binary.Read(br, binary.BigEndian, &header); err != nil || string(header.Magic[:]) != "ASEF" {
+		return nil, fmt.Errorf("not an ASE file")
+	}
+	if header.Major != 1 {
+		return nil, fmt.Errorf("version %d.%d is not supported", header.Major, header.Minor)
+	}
+
+	book := &SwatchBook{}
+	var open []string // names of the groups swatches are in
+	run := false      // the last run takes swatches at this point
+	swatches := 0
+	for i := range header.Blocks {
+		kind, err := readBE[uint16](br)
+		if err != nil {
+			return nil, err
+		}
+		length, err := readBE[uint32](br)
+		if err != nil {
+			return nil, err
+		}
+		if length > 1<<16 {
+			return nil, fmt.Errorf("block %d: %d bytes long", i, length)
+		}
+		block := make([]byte, length)
+		if _, err := io.ReadFull(br, block); err != nil {
+			return nil, io.ErrUnexpectedEOF
+		}
+
+		switch kind {
+		case aseGroupStart:
+			name, _, err := aseName(block)
+			if err != nil {
+				return nil, fmt.Errorf("block %d: %v", i, err)
+			}
+			book.Groups = append(book.Groups, SwatchGroup{Name: name, Start: true, Depth: len(open)})
+			open = append(open, name)
+			run = true
+		case aseGroupEnd:
+			if len(open) == 0 {
+				return nil, fmt.Errorf("block %d: group end outside a group", i)
+			}
+			open = open[:len(open)-1]
+			run = false
+		case aseColor:
+			s, err := parseASESwatch(block)
+			if err != nil {
+				return nil, fmt.Errorf("block %d: %v", i, err)
+			}
+			if swatches++; swatches > MaxColors {
+				return nil, fmt.Errorf("more than %d colors", MaxColors)
+			}
+			// Swatches after a group ends start a run of their own
+			if !run {
+				book.Groups = append(book.Groups, SwatchGroup{Depth: len(open)})
+				run = true
+			}
+			g := &book.Groups[len(book.Groups)-1]
+			g.Swatches = append(g.Swatches, s)
+		default:
+			return nil, fmt.Errorf("block %d: unknown type %#04x", i, kind)
+		}
+	}
+	if len(open) > 0 {
+		return nil, fmt.Errorf("group %q is not closed", open[len(open)-1])
+	}
+	return book, nil
+}
+
+// Name at the start of an ASE block, and the rest of the block
+func aseName(block []byte) (string, []byte, error) {
+	if len(block) < 2 {
+		return "", nil, io.ErrUnexpectedEOF
+	}
+	n := int(binary.BigEndian.Uint16(block))
+	if len(block) < 2+2*n {
+		return "", nil, io.ErrUnexpectedEOF
+	}
+	units := make([]uint16, n)
+	for i := range units {
+		units[i] = binary.BigEndian.Uint16(block[2+2*i:])
+	}
+	return fromUTF16(units), block[2+2*n:], nil
+}
+
+// Parse the body of an ASE colour block: name, model, values, type
+func parseASESwatch(block []byte) (Swatch, error) {
+	name, rest, err := aseName(block)
+	if err != nil {
+		return Swatch{}, err
+	}
+	if len(rest) < 4 {
+		return Swatch{}, io.ErrUnexpectedEOF
+	}
+	tag := string(rest[:4])
+	rest = rest[4:]
+	for _, m := range aseModels {
+		if m.tag != tag {
+			continue
+		}
+		if len(rest) < 4*m.channels+2 {
+			return Swatch{}, io.ErrUnexpectedEOF
+		}
+		s := Swatch{Name: name, Model: m.model}
+		for k := range m.channels {
+			s.Values[k] = float64(math.Float32frombits(binary.BigEndian.Uint32(rest[4*k:])))
+		}
+		if m.model == ModelLab {
+			// L is stored 0-1
+			s.Values[0] *= 100
+		}
+		s.Type = SwatchType(binary.BigEndian.Uint16(rest[4*m.channels:]))
+		if s.Type > Process {
+			return Swatch{}, fmt.Errorf("swatch type %d is unknown", s.Type)
+		}
+		return s, nil
+	}
+	return Swatch{}, fmt.Errorf("colour model %q is not supported", tag)
+}
+
+// WriteASE writes a palette as an Adobe Swatch Exchange file.
+func WriteASE(w io.Writer, p *Palette) error {
+	return EncodeASE(w, BookOf(p))
+}
+
+// EncodeASE writes swatches as an Adobe Swatch Exchange file, groups
+// between group start and end blocks, nested as their depths say.
+func EncodeASE(w io.Writer, book *SwatchBook) error {
+	if book.count() == 0 {
+		return ErrNoSwatches
+	}
+	var body bytes.Buffer
+	blocks := uint32(0)
+	block := func(kind uint16, payload []byte) {
+		binary.Write(&body, binary.BigEndian, kind)
+		binary.Write(&body, binary.BigEndian, uint32(len(payload)))
+		body.Write(payload)
+		blocks++
+	}
+	name := func(buf *bytes.Buffer, s string) {
+		units := toUTF16(s)
+		binary.Write(buf, binary.BigEndian, uint16(len(units)))
+		binary.Write(buf, binary.BigEndian, units)
+	}
+
+	open := 0 // groups started and not yet ended
+	for i, g := range book.Groups {
+		if g.Depth < 0 || g.Depth > open {
+			return fmt.Errorf("run %d: depth %d, but %d groups are open", i, g.Depth, open)
+		}
+		for ; open > g.Depth; open-- {
+			block(aseGroupEnd, nil)
+		}
+		if g.group() {
+			var payload bytes.Buffer
+			name(&payload, g.Name)
+			block(aseGroupStart, payload.Bytes())
+			open++
+		}
+		for _, s := range g.Swatches {
+			i := slices.IndexFunc(aseModels, func(m aseModel) bool { return m.model == s.Model })
+			if i < 0 {
+				return fmt.Errorf("%q: colour model %q is not supported by ASE", s.Name, s.Model)
+			}
+			m := aseModels[i]
+			var payload bytes.Buffer
+			name(&payload, s.Name)
+			payload.WriteString(m.tag)
+			for k := range m.channels {
+				v := s.Values[k]
+				if m.model == ModelLab && k == 0 {
+					v /= 100
+				}
+				binary.Write(&payload, binary.BigEndian, float32(v))
+			}
+			binary.Write(&payload, binary.BigEndian, uint16(s.Type))
+			block(aseColor, payload.Bytes())
+		}
+	}
+	for ; open > 0; open-- {
+		block(aseGroupEnd, nil)
+	}
+
+	var header bytes.Buffer
+	header.WriteString("ASEF")
+	binary.Write(&header, binary.BigEndian, [2]uint16{1, 0})
+	binary.Write(&header, binary.BigEndian, blocks)
+	if _, err := w.Write(header.Bytes()); err != nil {
+		return err
+	}
+	_, err := w.Write(body.Bytes())
+	return err
+}
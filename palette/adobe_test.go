@@ -0,0 +1,301 @@
+package palette
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"strings"
+	"testing"
+	"unicode/utf16"
+)
+
+// Build big-endian file contents from uint16, uint32, float32, string
+// (raw bytes) and []uint16 values
+func beBytes(values ...any) []byte {
+	var buf bytes.Buffer
+	for _, v := range values {
+		if s, ok := v.(string); ok {
+			buf.WriteString(s)
+			continue
+		}
+		binary.Write(&buf, binary.BigEndian, v)
+	}
+	return buf.Bytes()
+}
+
+// Name with its NUL as UTF-16 code units
+func utf16Name(s string) []uint16 {
+	return append(utf16.Encode([]rune(s)), 0)
+}
+
+// One swatch of each ACO colour space, with its record and expected
+// decoding
+var acoTests = []struct {
+	rec    [5]uint16
+	swatch Swatch
+}{
+	{[5]uint16{acoRGB, 65535, 32768, 0, 0}, Swatch{Name: "Red", Model: ModelRGB, Values: [4]float64{1, 32768.0 / 65535, 0}}},
+	{[5]uint16{acoHSB, 16384, 65535, 49152, 0}, Swatch{Name: "Hue", Model: ModelHSB, Values: [4]float64{16384.0 / 65535, 1, 49152.0 / 65535}}},
+	{[5]uint16{acoCMYK, 0, 65535, 32768, 65535}, Swatch{Name: "Magenta ink", Model: ModelCMYK, Values: [4]float64{1, 0, 1 - 32768.0/65535, 0}}},
+	{[5]uint16{acoLab, 5000, uint16(0x10000 - 1234), 2500, 0}, Swatch{Name: "Lab", Model: ModelLab, Values: [4]float64{50, -12.34, 25}}},
+	{[5]uint16{acoGray, 2500, 0, 0, 0}, Swatch{Name: "Grau 25 % ü 🎨", Model: ModelGray, Values: [4]float64{0.75}}},
+}
+
+// ACO file of acoTests: version 1, then version 2 with names when named
+func acoFile(named bool) []byte {
+	var values []any
+	values = append(values, uint16(1), uint16(len(acoTests)))
+	for _, tc := range acoTests {
+		values = append(values, tc.rec)
+	}
+	if named {
+		values = append(values, uint16(2), uint16(len(acoTests)))
+		for _, tc := range acoTests {
+			name := utf16Name(tc.swatch.Name)
+			values = append(values, tc.rec, uint32(len(name)), name)
+		}
+	}
+	return beBytes(values...)
+}
+
+// Compare swatches, values to within float rounding
+func checkSwatches(t *testing.T, got []Swatch, want []Swatch) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("%d swatches, want %d", len(got), len(want))
+	}
+	for i := range want {
+		g, w := got[i], want[i]
+		if g.Name != w.Name || g.Model != w.Model || g.Type != w.Type {
+			t.Errorf("swatch %d is %q %s type %d, want %q %s type %d", i, g.Name, g.Model, g.Type, w.Name, w.Model, w.Type)
+		}
+		for k := range w.Values {
+			if math.Abs(g.Values[k]-w.Values[k]) > 1e-6 {
+				t.Errorf("swatch %d %q values %v, want %v", i, w.Name, g.Values, w.Values)
+				break
+			}
+		}
+	}
+}
+
+func TestACORoundTrip(t *testing.T) {
+	data := acoFile(true)
+	book, err := DecodeACO(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(book.Groups) != 1 || book.Groups[0].Name != "" {
+		t.Fatalf("got %d groups, want one unnamed", len(book.Groups))
+	}
+	var want []Swatch
+	for _, tc := range acoTests {
+		want = append(want, tc.swatch)
+	}
+	checkSwatches(t, book.Groups[0].Swatches, want)
+
+	var buf bytes.Buffer
+	if err := EncodeACO(&buf, book); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf.Bytes(), data) {
+		t.Errorf("re-encoded file differs:\n got %x\nwant %x", buf.Bytes(), data)
+	}
+}
+
+func TestACOVersions(t *testing.T) {
+	var want []Swatch
+	for _, tc := range acoTests {
+		s := tc.swatch
+		s.Name = ""
+		want = append(want, s)
+	}
+
+	// Version 1 alone has no names; writing adds version 2
+	v1 := acoFile(false)
+	book, err := DecodeACO(bytes.NewReader(v1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkSwatches(t, book.Groups[0].Swatches, want)
+	var buf bytes.Buffer
+	if err := EncodeACO(&buf, book); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(buf.Bytes(), v1) {
+		t.Error("version 1 part differs when re-encoded")
+	}
+
+	// Version 2 alone
+	named := acoFile(true)
+	book, err = DecodeACO(bytes.NewReader(named[len(v1):]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := book.Groups[0].Swatches[4].Name; got != acoTests[4].swatch.Name {
+		t.Errorf("name %q, want %q", got, acoTests[4].swatch.Name)
+	}
+}
+
+func TestACOErrors(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		data []byte
+		err  string
+	}{
+		{"wrong version", beBytes(uint16(3), uint16(0)), "version 3"},
+		{"unknown colour space", beBytes(uint16(1), uint16(1), [5]uint16{3}), "colour space 3"},
+		{"truncated", acoFile(true)[:30], "unexpected EOF"},
+	} {
+		if _, err := DecodeACO(bytes.NewReader(tc.data)); err == nil || !strings.Contains(err.Error(), tc.err) {
+			t.Errorf("%s: error %v, want one mentioning %q", tc.name, err, tc.err)
+		}
+	}
+	if err := EncodeACO(&bytes.Buffer{}, &SwatchBook{}); err != ErrNoSwatches {
+		t.Errorf("empty book: error %v, want ErrNoSwatches", err)
+	}
+}
+
+// ASE colour block
+func aseColorBlock(name, tag string, values []float32, kind SwatchType) []any {
+	units := utf16Name(name)
+	payload := beBytes(uint16(len(units)), units, tag, values, uint16(kind))
+	return []any{uint16(aseColor), uint32(len(payload)), payload}
+}
+
+// ASE group start block
+func aseGroupBlock(name string) []any {
+	units := utf16Name(name)
+	payload := beBytes(uint16(len(units)), units)
+	return []any{uint16(aseGroupStart), uint32(len(payload)), payload}
+}
+
+// ASE file of blocks, each from aseColorBlock, aseGroupBlock or a group end
+func aseFile(blocks ...[]any) []byte {
+	values := []any{"ASEF", uint16(1), uint16(0), uint32(len(blocks))}
+	for _, b := range blocks {
+		values = append(values, b...)
+	}
+	return beBytes(values...)
+}
+
+var aseGroupEndBlock = []any{uint16(aseGroupEnd), uint32(0)}
+
+func TestASERoundTrip(t *testing.T) {
+	data := aseFile(
+		aseColorBlock("Paper", "RGB ", []float32{1, 0.5, 0.25}, Global),
+		aseGroupBlock("Brand"),
+		aseColorBlock("Pantone 185 C", "CMYK", []float32{0, 0.875, 0.75, 0.0625}, Spot),
+		aseColorBlock("Sky", "LAB ", []float32{0.5, -12.5, -40}, Process),
+		aseColorBlock("Ink", "Gray", []float32{0.125}, Global),
+		aseGroupEndBlock,
+		aseColorBlock("Loose", "RGB ", []float32{0, 0, 1}, Process),
+	)
+	book, err := DecodeASE(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	checkGroups(t, book.Groups, []SwatchGroup{
+		{Swatches: []Swatch{{Name: "Paper", Model: ModelRGB, Values: [4]float64{1, 0.5, 0.25}, Type: Global}}},
+		{Name: "Brand", Start: true, Swatches: []Swatch{
+			{Name: "Pantone 185 C", Model: ModelCMYK, Values: [4]float64{0, 0.875, 0.75, 0.0625}, Type: Spot},
+			{Name: "Sky", Model: ModelLab, Values: [4]float64{50, -12.5, -40}, Type: Process},
+			{Name: "Ink", Model: ModelGray, Values: [4]float64{0.125}, Type: Global},
+		}},
+		{Swatches: []Swatch{{Name: "Loose", Model: ModelRGB, Values: [4]float64{0, 0, 1}, Type: Process}}},
+	})
+	checkASEReencode(t, book, data)
+}
+
+// Compare runs of swatches
+func checkGroups(t *testing.T, got, want []SwatchGroup) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("%d groups, want %d", len(got), len(want))
+	}
+	for i, w := range want {
+		g := got[i]
+		if g.Name != w.Name || g.Start != w.Start || g.Depth != w.Depth {
+			t.Errorf("group %d is %q start %v depth %d, want %q start %v depth %d", i, g.Name, g.Start, g.Depth, w.Name, w.Start, w.Depth)
+		}
+		checkSwatches(t, g.Swatches, w.Swatches)
+	}
+}
+
+// Check that a book encodes to the file it was decoded from
+func checkASEReencode(t *testing.T, book *SwatchBook, data []byte) {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := EncodeASE(&buf, book); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf.Bytes(), data) {
+		t.Errorf("re-encoded file differs:\n got %x\nwant %x", buf.Bytes(), data)
+	}
+}
+
+func TestASENestedGroups(t *testing.T) {
+	color := func(name string) []any { return aseColorBlock(name, "RGB ", []float32{0, 0.5, 1}, Global) }
+	data := aseFile(
+		aseGroupBlock("Brand"),
+		color("a"),
+		aseGroupBlock("Print"),
+		color("b"),
+		aseGroupBlock(""),
+		aseGroupEndBlock,
+		aseGroupEndBlock,
+		color("c"),
+		aseGroupEndBlock,
+		aseGroupBlock(""),
+		color("d"),
+		aseGroupEndBlock,
+		color("e"),
+	)
+	book, err := DecodeASE(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	swatch := func(name string) []Swatch {
+		return []Swatch{{Name: name, Model: ModelRGB, Values: [4]float64{0, 0.5, 1}}}
+	}
+	checkGroups(t, book.Groups, []SwatchGroup{
+		{Name: "Brand", Start: true, Swatches: swatch("a")},
+		{Name: "Print", Start: true, Depth: 1, Swatches: swatch("b")},
+		{Start: true, Depth: 2},
+		{Depth: 1, Swatches: swatch("c")},
+		{Start: true, Swatches: swatch("d")},
+		{Swatches: swatch("e")},
+	})
+	checkASEReencode(t, book, data)
+
+	// Runs can't skip a level
+	deep := &SwatchBook{Groups: []SwatchGroup{{Depth: 1, Swatches: swatch("a")}}}
+	if err := EncodeASE(&bytes.Buffer{}, deep); err == nil {
+		t.Error("run nested in a group that was never started written")
+	}
+}
+
+func TestASEErrors(t *testing.T) {
+	rgb := aseColorBlock("C", "RGB ", []float32{0, 0, 0}, Global)
+	for _, tc := range []struct {
+		name string
+		data []byte
+		err  string
+	}{
+		{"not ASE", []byte("ACOF\x00\x01\x00\x00\x00\x00\x00\x00"), "not an ASE file"},
+		{"unclosed group", aseFile(aseGroupBlock("A"), rgb), `group "A" is not closed`},
+		{"unclosed inner group", aseFile(aseGroupBlock("A"), aseGroupBlock("B"), rgb, aseGroupEndBlock), `group "A" is not closed`},
+		{"stray group end", aseFile(aseGroupEndBlock), "group end outside a group"},
+		{"unknown model", aseFile(aseColorBlock("C", "HSB ", []float32{0, 0, 0}, Global)), `"HSB "`},
+		{"unknown type", aseFile(aseColorBlock("C", "RGB ", []float32{0, 0, 0}, 3)), "swatch type 3"},
+	} {
+		if _, err := DecodeASE(bytes.NewReader(tc.data)); err == nil || !strings.Contains(err.Error(), tc.err) {
+			t.Errorf("%s: error %v, want one mentioning %q", tc.name, err, tc.err)
+		}
+	}
+
+	hsb := &SwatchBook{Groups: []SwatchGroup{{Swatches: []Swatch{{Model: ModelHSB}}}}}
+	if err := EncodeASE(&bytes.Buffer{}, hsb); err == nil {
+		t.Error("HSB swatch written to ASE")
+	}
+}
@@ -0,0 +1,130 @@
+package palette
+
+import (
+	"cmp"
+	"slices"
+)
+
+// Palette analysis: how well colours read against each other, which are
+// too close to tell apart, and how they look to people with colour vision
+// deficiencies.
+
+// WCAG 2 contrast ratios for text
+const (
+	ContrastAALarge = 3.0 // large text, AA
+	ContrastAA      = 4.5 // body text, AA; large text, AAA
+	ContrastAAA     = 7.0 // body text, AAA
+)
+
+// A kind of colour vision to simulate
+type Vision int
+
+const (
+	NormalVision Vision = iota
+	Protanopia          // no long-wavelength cones: reds darken and meet greens
+	Deuteranopia        // no medium-wavelength cones: reds and greens meet
+	Tritanopia          // no short-wavelength cones: blues meet greens, yellows pink
+)
+
+// Names of the kinds of vision, as the command line takes them
+var VisionNames = []string{"normal", "protanopia", "deuteranopia", "tritanopia"}
+
+// Linear RGB transforms from Machado, Oliveira and Fernandes (2009), at
+// full severity
+var visionMatrices = [...][3][3]float64{
+	NormalVision: {{1, 0, 0}, {0, 1, 0}, {0, 0, 1}},
+	Protanopia: {
+		{0.152286, 1.052583, -0.204868},
+		{0.114503, 0.786281, 0.099216},
+		{-0.003882, -0.048116, 1.051998},
+	},
+	Deuteranopia: {
+		{0.367322, 0.860646, -0.227968},
+		{0.280085, 0.672501, 0.047413},
+		{-0.011820, 0.042940, 0.968881},
+	},
+	Tritanopia: {
+		{1.255528, -0.076749, -0.178779},
+		{-0.078411, 0.930809, 0.147602},
+		{0.004733, 0.691367, 0.303900},
+	},
+}
+
+// Matrix returns the transform of linear RGB that simulates the vision.
+func (v Vision) Matrix() [3][3]float64 {
+	return visionMatrices[v]
+}
+
+// Simulate returns a colour as it looks with the vision, alpha and name
+// kept.
+func (v Vision) Simulate(c Color) Color {
+	if v == NormalVision {
+		return c
+	}
+	m := visionMatrices[v]
+	r, g, b := linearize(c.R), linearize(c.G), linearize(c.B)
+	out := c
+	out.R = delinearize(m[0][0]*r + m[0][1]*g + m[0][2]*b)
+	out.G = delinearize(m[1][0]*r + m[1][1]*g + m[1][2]*b)
+	out.B = delinearize(m[2][0]*r + m[2][1]*g + m[2][2]*b)
+	return out
+}
+
+// SimulatePalette returns a copy of a palette as it looks with the vision.
+func (v Vision) SimulatePalette(p *Palette) *Palette {
+	out := &Palette{Name: p.Name, Title: p.Title, Colors: make([]Color, len(p.Colors))}
+	for i, c := range p.Colors {
+		out.Colors[i] = v.Simulate(c)
+	}
+	return out
+}
+
+// Contrast returns the WCAG contrast ratio of two colours, from 1 for the
+// same luminance to 21 for black on white.
+func Contrast(a, b Color) float64 {
+	la, lb := a.Luminance(), b.Luminance()
+	return (max(la, lb) + 0.05) / (min(la, lb) + 0.05)
+}
+
+// ContrastMatrix returns the contrast ratio of every pair of colours.
+func ContrastMatrix(p *Palette) [][]float64 {
+	n := len(p.Colors)
+	m := make([][]float64, n)
+	for i := range m {
+		m[i] = make([]float64, n)
+	}
+	for i := range n {
+		for j := i; j < n; j++ {
+			m[i][j] = Contrast(p.Colors[i], p.Colors[j])
+			m[j][i] = m[i][j]
+		}
+	}
+	return m
+}
+
+// Two colours of a palette that are hard to tell apart
+type Duplicate struct {
+	A, B   int     // entries, A first
+	DeltaE float64 // CIEDE2000 difference
+}
+
+// NearDuplicates returns the pairs of colours less than threshold apart
+// by CIEDE2000, closest first. Alpha is ignored.
+func NearDuplicates(p *Palette, threshold float64) []Duplicate {
+	labs := make([]Lab, len(p.Colors))
+	for i, c := range p.Colors {
+		labs[i] = c.Lab()
+	}
+	var dups []Duplicate
+	for i := range labs {
+		for j := i + 1; j < len(labs); j++ {
+			if d := DeltaE2000(labs[i], labs[j]); d < threshold {
+				dups = append(dups, Duplicate{A: i, B: j, DeltaE: d})
+			}
+		}
+	}
+	slices.SortStableFunc(dups, func(x, y Duplicate) int {
+		return cmp.Compare(x.DeltaE, y.DeltaE)
+	})
+	return dups
+}
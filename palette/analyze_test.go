@@ -0,0 +1,101 @@
+package palette
+
+import (
+	"math"
+	"testing"
+)
+
+func TestContrast(t *testing.T) {
+	black, white := rgb(0, 0, 0), rgb(255, 255, 255)
+	for _, tc := range []struct {
+		name string
+		a, b Color
+		want float64
+	}{
+		{"black on white", black, white, 21},
+		{"white on black", white, black, 21},
+		{"same colour", rgb(90, 30, 200), rgb(90, 30, 200), 1},
+		// Luminance 0.2126, 0.7152 and 0.0722 of white
+		{"red on black", rgb(255, 0, 0), black, 5.252},
+		{"green on white", rgb(0, 255, 0), white, 1.372},
+		{"blue on white", rgb(0, 0, 255), white, 8.592},
+		// Alpha is ignored
+		{"clear black on white", Color{}, white, 21},
+	} {
+		if got := Contrast(tc.a, tc.b); math.Abs(got-tc.want) > 0.001 {
+			t.Errorf("%s: contrast %.3f, want %.3f", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestContrastMatrix(t *testing.T) {
+	p := &Palette{Colors: []Color{rgb(0, 0, 0), rgb(255, 255, 255), rgb(119, 119, 119), rgb(200, 40, 90)}}
+	m := ContrastMatrix(p)
+	if len(m) != len(p.Colors) {
+		t.Fatalf("%d rows, want %d", len(m), len(p.Colors))
+	}
+	for i, row := range m {
+		if len(row) != len(p.Colors) {
+			t.Fatalf("row %d has %d entries, want %d", i, len(row), len(p.Colors))
+		}
+		for j, got := range row {
+			if want := Contrast(p.Colors[i], p.Colors[j]); got != want || got != m[j][i] {
+				t.Errorf("m[%d][%d] = %g, m[%d][%d] = %g, want %g", i, j, got, j, i, m[j][i], want)
+			}
+		}
+	}
+	if m[0][1] != 21 {
+		t.Errorf("black on white %g, want 21", m[0][1])
+	}
+	if len(ContrastMatrix(&Palette{})) != 0 {
+		t.Error("empty palette has contrasts")
+	}
+}
+
+func TestNearDuplicates(t *testing.T) {
+	p := &Palette{Colors: []Color{
+		rgb(200, 30, 30),
+		rgb(20, 20, 200),
+		rgb(202, 31, 30), // a shade off entry 0
+		rgb(255, 255, 255),
+		rgb(210, 34, 30), // further off entry 0, nearer entry 2
+		{R: 20, G: 20, B: 200, A: 40},
+	}}
+	dups := NearDuplicates(p, 2)
+	want := [][2]int{{1, 5}, {0, 2}, {2, 4}}
+	if len(dups) != len(want) {
+		t.Fatalf("duplicates %v, want pairs %v", dups, want)
+	}
+	for i, d := range dups {
+		if d.A != want[i][0] || d.B != want[i][1] {
+			t.Errorf("duplicate %d: %d and %d, want %d and %d", i, d.A, d.B, want[i][0], want[i][1])
+		}
+		if got := DeltaE2000(p.Colors[d.A].Lab(), p.Colors[d.B].Lab()); d.DeltaE != got {
+			t.Errorf("duplicate %d: difference %g, want %g", i, d.DeltaE, got)
+		}
+		if i > 0 && d.DeltaE < dups[i-1].DeltaE {
+			t.Errorf("duplicate %d closer than the one before", i)
+		}
+	}
+	// Alpha is ignored, so the clear copy is the same colour
+	if dups[0].DeltaE != 0 {
+		t.Errorf("colours differing in alpha %g apart", dups[0].DeltaE)
+	}
+	if dups := NearDuplicates(p, 0.25); len(dups) != 1 {
+		t.Errorf("under 0.25: %v, want the pair differing in alpha", dups)
+	}
+	if dups := NearDuplicates(p, 3); len(dups) != 4 || dups[3].A != 0 || dups[3].B != 4 {
+		t.Errorf("under 3: %v, want entries 0 and 4 last", dups)
+	}
+}
+
+func TestSimulateGreys(t *testing.T) {
+	// Every row of the matrices sums to one, so greys stay grey
+	for v := range Vision(len(VisionNames)) {
+		for _, c := range []Color{rgb(0, 0, 0), rgb(128, 128, 128), rgb(255, 255, 255)} {
+			if got := v.Simulate(c); !near(got, c, 1) {
+				t.Errorf("%s: %v becomes %v", VisionNames[v], c, got)
+			}
+		}
+	}
+}
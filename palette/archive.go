@@ -0,0 +1,109 @@
+package palette
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// Zipped palette formats: Krita's .kpl and Procreate's .swatches. Entries
+// carry a fixed time so the archives do not change between runs.
+
+const procreateMaxColors = 30 // one swatch page
+
+// Time stamped on zip entries, the earliest zip can hold
+var zipTime = time.Date(1980, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// A file to zip
+type zipEntry struct {
+	name  string
+	data  []byte
+	store bool // uncompressed, as mimetype files must be
+}
+
+// Write entries to a zip archive in order
+func writeZip(w io.Writer, entries []zipEntry) error {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, e := range entries {
+		header := &zip.FileHeader{Name: e.name, Method: zip.Deflate, Modified: zipTime}
+		if e.store {
+			header.Method = zip.Store
+		}
+		f, err := zw.CreateHeader(header)
+		if err != nil {
+			return err
+		}
+		if _, err := f.Write(e.data); err != nil {
+			return err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// Text escaped for an XML attribute value
+func xmlAttr(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+// WriteKPL writes a Krita palette: a zip holding colorset.xml with sRGB
+// entries laid out eight to a row.
+func WriteKPL(w io.Writer, p *Palette) error {
+	columns := max(1, min(len(p.Colors), swatchColumns))
+	rows := (len(p.Colors) + columns - 1) / columns
+	var set strings.Builder
+	fmt.Fprintf(&set, "<?xml version=\"1.0\" encoding=\"UTF-8\"?>\n<ColorSet version=\"1.0\" name=\"%s\" comment=\"\" columns=\"%d\" rows=\"%d\" readonly=\"false\">\n",
+		xmlAttr(p.title()), columns, rows)
+	for i, c := range p.Colors {
+		fmt.Fprintf(&set, " <ColorSetEntry name=\"%s\" id=\"%d\" spot=\"false\" bitdepth=\"U8\">\n", xmlAttr(p.colorName(i)), i+1)
+		fmt.Fprintf(&set, "  <RGB space=\"sRGB-elle-V2-srgbtrc.icc\" r=\"%g\" g=\"%g\" b=\"%g\"/>\n",
+			float32(c.R)/255, float32(c.G)/255, float32(c.B)/255)
+		fmt.Fprintf(&set, "  <Position row=\"%d\" column=\"%d\"/>\n </ColorSetEntry>\n", i/columns, i%columns)
+	}
+	set.WriteString("</ColorSet>\n")
+	return writeZip(w, []zipEntry{
+		{"mimetype", []byte("krita/x-colorset"), true},
+		{"colorset.xml", []byte(set.String()), false},
+		{"profiles.xml", []byte("<?xml version=\"1.0\" encoding=\"UTF-8\"?>\n<Profiles/>\n"), false},
+	})
+}
+
+// WriteProcreate writes a Procreate swatch file: a zip holding
+// Swatches.json with the colours as HSB. Procreate holds thirty colours to
+// a file.
+func WriteProcreate(w io.Writer, p *Palette) error {
+	if len(p.Colors) > procreateMaxColors {
+		return fmt.Errorf("%w: %d colors, Procreate holds %d", ErrNotRepresentable, len(p.Colors), procreateMaxColors)
+	}
+	type swatch struct {
+		Hue        float64 `json:"hue"`
+		Saturation float64 `json:"saturation"`
+		Brightness float64 `json:"brightness"`
+		Alpha      float64 `json:"alpha"`
+		ColorSpace int     `json:"colorSpace"` // 0 for sRGB
+	}
+	set := struct {
+		Name     string   `json:"name"`
+		Swatches []swatch `json:"swatches"`
+	}{Name: p.title()}
+	for _, c := range p.Colors {
+		h, s, v := c.HSV()
+		set.Swatches = append(set.Swatches, swatch{h, s, v, float64(c.A) / 255, 0})
+	}
+	data, err := json.Marshal([]any{set})
+	if err != nil {
+		return err
+	}
+	return writeZip(w, []zipEntry{{"Swatches.json", data, false}})
+}
@@ -0,0 +1,120 @@
+package palette
+
+import (
+	"math"
+	"math/rand/v2"
+	"sync"
+)
+
+// Blue noise threshold matrix for ordered dithering, made once by Ulichney's
+// void-and-cluster method from a fixed seed, so it is the same every run.
+
+const blueNoiseSize = 64
+
+var (
+	blueNoiseOnce sync.Once
+	blueNoise     []float64 // thresholds -0.5 to 0.5, row by row
+)
+
+// Ordered dither offset at a pixel, -0.5 to 0.5, from the blue noise matrix
+func blueNoiseThreshold(x, y int) float64 {
+	blueNoiseOnce.Do(makeBlueNoise)
+	return blueNoise[(y&(blueNoiseSize-1))*blueNoiseSize+(x&(blueNoiseSize-1))]
+}
+
+// A binary pattern on a torus with the energy each cell gets from the set
+// cells around it
+type voidCluster struct {
+	set    []bool
+	energy []float64
+	kernel []float64 // by wrapped offset
+	count  int
+}
+
+// Set or clear a cell, updating the energy of every cell
+func (v *voidCluster) toggle(i int) {
+	sign := 1.0
+	if v.set[i] {
+		sign = -1
+	}
+	v.set[i] = !v.set[i]
+	v.count += int(sign)
+	const n = blueNoiseSize
+	x0, y0 := i%n, i/n
+	for j := range v.energy {
+		dx, dy := (j%n-x0+n)%n, (j/n-y0+n)%n
+		v.energy[j] += sign * v.kernel[dy*n+dx]
+	}
+}
+
+// Set cell with the most energy, the tightest cluster, or the clear cell
+// with the least, the largest void
+func (v *voidCluster) find(set bool) int {
+	best := -1
+	for i, s := range v.set {
+		if s != set {
+			continue
+		}
+		if best < 0 || (set && v.energy[i] > v.energy[best]) || (!set && v.energy[i] < v.energy[best]) {
+			best = i
+		}
+	}
+	return best
+}
+
+func (v *voidCluster) clone() *voidCluster {
+	c := *v
+	c.set = append([]bool(nil), v.set...)
+	c.energy = append([]float64(nil), v.energy...)
+	return &c
+}
+
+func makeBlueNoise() {
+	const n = blueNoiseSize
+	const sigma = 1.5
+	v := &voidCluster{set: make([]bool, n*n), energy: make([]float64, n*n), kernel: make([]float64, n*n)}
+	for dy := range n {
+		for dx := range n {
+			wx, wy := float64(min(dx, n-dx)), float64(min(dy, n-dy))
+			v.kernel[dy*n+dx] = math.Exp(-(wx*wx + wy*wy) / (2 * sigma * sigma))
+		}
+	}
+
+	// A random tenth of the cells, then evened out by moving the tightest
+	// cluster into the largest void until it would move straight back
+	rng := rand.New(rand.NewPCG(1, 2))
+	for v.count < n*n/10 {
+		if i := rng.IntN(n * n); !v.set[i] {
+			v.toggle(i)
+		}
+	}
+	for range n * n {
+		cluster := v.find(true)
+		v.toggle(cluster)
+		void := v.find(false)
+		v.toggle(void)
+		if void == cluster {
+			break
+		}
+	}
+
+	// Rank the initial cells by taking clusters away, then the rest by
+	// filling voids
+	rank := make([]int, n*n)
+	w := v.clone()
+	for w.count > 0 {
+		i := w.find(true)
+		w.toggle(i)
+		rank[i] = w.count
+	}
+	for v.count < n*n {
+		i := v.find(false)
+		rank[i] = v.count
+		v.toggle(i)
+	}
+
+	blueNoise = make([]float64, n*n)
+	for i, r := range rank {
+		blueNoise[i] = (float64(r)+0.5)/(n*n) - 0.5
+	}
+}
@@ -0,0 +1,175 @@
+package palette
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Exporters for source code: style sheets, a Tailwind config, and colour
+// tables for Unity, Go and C.
+
+// Lines naming the palette in comments at the top of style sheets
+func (p *Palette) sheetHeader() []string {
+	return []string{p.title(), fmt.Sprintf("Generated Color Palette: %s (%d colors)", p.Name, len(p.Colors))}
+}
+
+// WriteCSS writes the colours as custom properties on :root, with
+// background and text classes for each.
+func WriteCSS(w io.Writer, p *Palette) error {
+	var b strings.Builder
+	ids := p.identifiers(func(s string) string { return joinLower(s, "-") }, "-")
+	for _, line := range p.sheetHeader() {
+		fmt.Fprintf(&b, "/* %s */\n", line)
+	}
+	b.WriteString("\n:root {\n")
+	for i, c := range p.Colors {
+		fmt.Fprintf(&b, "  --color-%s: %s;\n", ids[i], c.hexAlpha())
+	}
+	b.WriteString("}\n\n/* Utility Classes */\n")
+	for i, c := range p.Colors {
+		fmt.Fprintf(&b, ".bg-%s { background-color: %s; }\n", ids[i], c.hexAlpha())
+		fmt.Fprintf(&b, ".text-%s { color: %s; }\n", ids[i], c.hexAlpha())
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// WriteSCSS writes the colours as Sass variables and a map of them named
+// after the palette.
+func WriteSCSS(w io.Writer, p *Palette) error {
+	var b strings.Builder
+	ids := p.identifiers(func(s string) string { return joinLower(s, "-") }, "-")
+	for _, line := range p.sheetHeader() {
+		fmt.Fprintf(&b, "// %s\n", line)
+	}
+	b.WriteString("\n")
+	for i, c := range p.Colors {
+		fmt.Fprintf(&b, "$color-%s: %s;\n", ids[i], c.hexAlpha())
+	}
+	fmt.Fprintf(&b, "\n$%s: (\n", joinLower(p.Name, "-"))
+	for _, id := range ids {
+		fmt.Fprintf(&b, "  \"%s\": $color-%s,\n", id, id)
+	}
+	b.WriteString(");\n")
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// WriteLESS writes the colours as LESS variables.
+func WriteLESS(w io.Writer, p *Palette) error {
+	var b strings.Builder
+	ids := p.identifiers(func(s string) string { return joinLower(s, "-") }, "-")
+	for _, line := range p.sheetHeader() {
+		fmt.Fprintf(&b, "// %s\n", line)
+	}
+	b.WriteString("\n")
+	for i, c := range p.Colors {
+		fmt.Fprintf(&b, "@color-%s: %s;\n", ids[i], c.hexAlpha())
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// WriteTailwind writes a Tailwind CSS config adding the colours under the
+// palette's name, as in bg-deluxe08-red.
+func WriteTailwind(w io.Writer, p *Palette) error {
+	var b strings.Builder
+	ids := p.identifiers(func(s string) string { return joinLower(s, "-") }, "-")
+	for _, line := range p.sheetHeader() {
+		fmt.Fprintf(&b, "// %s\n", line)
+	}
+	fmt.Fprintf(&b, "module.exports = {\n  theme: {\n    extend: {\n      colors: {\n        '%s': {\n", joinLower(p.Name, "-"))
+	for i, c := range p.Colors {
+		fmt.Fprintf(&b, "          '%s': '%s',\n", ids[i], c.hexAlpha())
+	}
+	b.WriteString("        },\n      },\n    },\n  },\n};\n")
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// Alpha as a C# float literal, 1f when opaque as Unity writes it
+func unityAlpha(v uint8) string {
+	if v == 255 {
+		return "1f"
+	}
+	return fmt.Sprintf("%.3ff", float64(v)/255)
+}
+
+// WriteUnity writes a C# class for Unity with a Color field for each
+// colour, and arrays of them all and their names.
+func WriteUnity(w io.Writer, p *Palette) error {
+	var b strings.Builder
+	ids := p.identifiers(pascal, "")
+	fmt.Fprintf(&b, "using UnityEngine;\n\n/// <summary>\n/// %s\n/// Auto-generated color palette with %d colors\n/// </summary>\n", p.title(), len(p.Colors))
+	fmt.Fprintf(&b, "public static class %sPalette\n{\n", pascal(p.Name))
+	for i, c := range p.Colors {
+		fmt.Fprintf(&b, "    /// <summary>%s (%s)</summary>\n", p.colorName(i), c.Hex())
+		fmt.Fprintf(&b, "    public static readonly Color %s = new Color(%.3ff, %.3ff, %.3ff, %s);\n\n",
+			ids[i], float64(c.R)/255, float64(c.G)/255, float64(c.B)/255, unityAlpha(c.A))
+	}
+	b.WriteString("    /// <summary>Array containing all palette colors</summary>\n    public static readonly Color[] Colors = new Color[]\n    {\n")
+	b.WriteString("        " + strings.Join(ids, ",\n        ") + "\n    };\n\n")
+	b.WriteString("    /// <summary>Names of all colors in the palette</summary>\n    public static readonly string[] ColorNames = new string[]\n    {\n")
+	names := make([]string, len(p.Colors))
+	for i := range p.Colors {
+		names[i] = strconv.Quote(p.colorName(i))
+	}
+	b.WriteString("        " + strings.Join(names, ",\n        ") + "\n    };\n}\n")
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// Writes a Go source file declaring the palette as a slice of
+// color.NRGBA, with the colour names in another, in a package of a name
+type GoExporter struct {
+	Package string
+}
+
+func (e GoExporter) Name() string           { return "go" }
+func (e GoExporter) Label() string          { return "Go" }
+func (e GoExporter) File(p *Palette) string { return p.Name + ".go" }
+
+// Export writes the Go source for a palette.
+func (e GoExporter) Export(w io.Writer, p *Palette) error {
+	var b strings.Builder
+	name := pascal(p.Name)
+	fmt.Fprintf(&b, "// Code generated by palgen. DO NOT EDIT.\n\npackage %s\n\nimport \"image/color\"\n\n", e.Package)
+	fmt.Fprintf(&b, "// %s is %s, %d colors.\nvar %s = []color.NRGBA{\n", name, p.title(), len(p.Colors), name)
+	for i, c := range p.Colors {
+		fmt.Fprintf(&b, "\t{0x%02X, 0x%02X, 0x%02X, 0x%02X}, // %s\n", c.R, c.G, c.B, c.A, p.colorName(i))
+	}
+	fmt.Fprintf(&b, "}\n\n// %sNames names the colors of %s.\nvar %sNames = []string{\n", name, name, name)
+	for i := range p.Colors {
+		fmt.Fprintf(&b, "\t%s,\n", strconv.Quote(p.colorName(i)))
+	}
+	b.WriteString("}\n")
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// WriteCHeader writes a C header with the colours as 0xRRGGBBAA and their
+// names.
+func WriteCHeader(w io.Writer, p *Palette) error {
+	var b strings.Builder
+	prefix := joinLower(p.Name, "_")
+	if prefix == "" || (prefix[0] >= '0' && prefix[0] <= '9') {
+		prefix = "palette_" + prefix
+	}
+	guard := strings.ToUpper(prefix) + "_PALETTE_H"
+	count := strings.ToUpper(prefix) + "_COUNT"
+	fmt.Fprintf(&b, "/* %s, %d colors. Generated by palgen. */\n", strings.ReplaceAll(p.title(), "*/", "* /"), len(p.Colors))
+	fmt.Fprintf(&b, "#ifndef %s\n#define %s\n\n#include <stdint.h>\n\n#define %s %d\n\n", guard, guard, count, len(p.Colors))
+	fmt.Fprintf(&b, "/* 0xRRGGBBAA */\nstatic const uint32_t %s_colors[%s] = {\n", prefix, count)
+	for _, c := range p.Colors {
+		fmt.Fprintf(&b, "    0x%02X%02X%02X%02X,\n", c.R, c.G, c.B, c.A)
+	}
+	fmt.Fprintf(&b, "};\n\nstatic const char *const %s_names[%s] = {\n", prefix, count)
+	for i := range p.Colors {
+		fmt.Fprintf(&b, "    %s,\n", strconv.Quote(p.colorName(i)))
+	}
+	fmt.Fprintf(&b, "};\n\n#endif /* %s */\n", guard)
+	_, err := io.WriteString(w, b.String())
+	return err
+}
@@ -0,0 +1,258 @@
+package palette
+
+import "math"
+
+// Perceptual colour spaces and distances. OKLab is cheap and even enough
+// for averaging and sorting; CIEDE2000 is the slower, more exact measure
+// of how different two colours look.
+
+// A colour in OKLab: lightness 0-1 and two opponent axes, about -0.4-0.4
+type OKLab struct {
+	L, A, B float64
+}
+
+// A colour in CIELAB under D65: lightness 0-100 and two opponent axes
+type Lab struct {
+	L, A, B float64
+}
+
+// Linear light from an 8-bit sRGB channel
+func linearize(v uint8) float64 {
+	s := float64(v) / 255
+	if s <= 0.04045 {
+		return s / 12.92
+	}
+	return math.Pow((s+0.055)/1.055, 2.4)
+}
+
+// 8-bit sRGB channel from linear light, clipped
+func delinearize(v float64) uint8 {
+	v = min(1, max(0, v))
+	if v <= 0.0031308 {
+		v *= 12.92
+	} else {
+		v = 1.055*math.Pow(v, 1/2.4) - 0.055
+	}
+	return uint8(math.Round(v * 255))
+}
+
+// OKLab returns the colour in OKLab, ignoring alpha.
+func (c Color) OKLab() OKLab {
+	r, g, b := linearize(c.R), linearize(c.G), linearize(c.B)
+	l := math.Cbrt(0.4122214708*r + 0.5363325363*g + 0.0514459929*b)
+	m := math.Cbrt(0.2119034982*r + 0.6806995451*g + 0.1073969566*b)
+	s := math.Cbrt(0.0883024619*r + 0.2817188376*g + 0.6299787005*b)
+	return OKLab{
+		L: 0.2104542553*l + 0.7936177850*m - 0.0040720468*s,
+		A: 1.9779984951*l - 2.4285922050*m + 0.4505937099*s,
+		B: 0.0259040371*l + 0.7827717662*m - 0.8086757660*s,
+	}
+}
+
+// Color returns the opaque sRGB colour closest to an OKLab one, clipping
+// channels out of range.
+func (o OKLab) Color() Color {
+	l := o.L + 0.3963377774*o.A + 0.2158037573*o.B
+	m := o.L - 0.1055613458*o.A - 0.0638541728*o.B
+	s := o.L - 0.0894841775*o.A - 1.2914855480*o.B
+	l, m, s = l*l*l, m*m*m, s*s*s
+	return Color{
+		R: delinearize(4.0767416621*l - 3.3077115913*m + 0.2309699292*s),
+		G: delinearize(-1.2684380046*l + 2.6097574011*m - 0.3413193965*s),
+		B: delinearize(-0.0041960863*l - 0.7034186147*m + 1.7076147010*s),
+		A: 255,
+	}
+}
+
+// Squared distance between two OKLab colours
+func (o OKLab) dist2(p OKLab) float64 {
+	dl, da, db := o.L-p.L, o.A-p.A, o.B-p.B
+	return dl*dl + da*da + db*db
+}
+
+// Lab returns the colour in CIELAB under D65, ignoring alpha.
+func (c Color) Lab() Lab {
+	r, g, b := linearize(c.R), linearize(c.G), linearize(c.B)
+	x := (0.4124564*r + 0.3575761*g + 0.1804375*b) / 0.95047
+	y := 0.2126729*r + 0.7151522*g + 0.0721750*b
+	z := (0.0193339*r + 0.1191920*g + 0.9503041*b) / 1.08883
+	f := func(t float64) float64 {
+		if t > 216.0/24389 {
+			return math.Cbrt(t)
+		}
+		return (24389.0/27*t + 16) / 116
+	}
+	fx, fy, fz := f(x), f(y), f(z)
+	return Lab{L: 116*fy - 16, A: 500 * (fx - fy), B: 200 * (fy - fz)}
+}
+
+// DeltaE2000 returns the CIEDE2000 difference between two colours: under
+// 1 is hard to see, above 10 is plainly another colour.
+func DeltaE2000(x, y Lab) float64 {
+	const deg = math.Pi / 180
+	c1, c2 := math.Hypot(x.A, x.B), math.Hypot(y.A, y.B)
+	cMean := (c1 + c2) / 2
+	c7 := math.Pow(cMean, 7)
+	g := 0.5 * (1 - math.Sqrt(c7/(c7+math.Pow(25, 7))))
+	a1, a2 := (1+g)*x.A, (1+g)*y.A
+	c1p, c2p := math.Hypot(a1, x.B), math.Hypot(a2, y.B)
+	hue := func(b, a float64) float64 {
+		if a == 0 && b == 0 {
+			return 0
+		}
+		h := math.Atan2(b, a) / deg
+		if h < 0 {
+			h += 360
+		}
+		return h
+	}
+	h1p, h2p := hue(x.B, a1), hue(y.B, a2)
+
+	// Hues half a turn apart sit where the formula changes branch; rounding
+	// must not push them across it
+	hDiff := h2p - h1p
+	if math.Abs(math.Abs(hDiff)-180) < 1e-9 {
+		hDiff = math.Copysign(180, hDiff)
+	}
+
+	dL := y.L - x.L
+	dC := c2p - c1p
+	dh := 0.0
+	if c1p*c2p != 0 {
+		dh = hDiff
+		switch {
+		case dh > 180:
+			dh -= 360
+		case dh < -180:
+			dh += 360
+		}
+	}
+	dH := 2 * math.Sqrt(c1p*c2p) * math.Sin(dh/2*deg)
+
+	lMean := (x.L + y.L) / 2
+	cMeanP := (c1p + c2p) / 2
+	hMean := h1p + h2p
+	if c1p*c2p != 0 {
+		switch {
+		case math.Abs(hDiff) <= 180:
+			hMean /= 2
+		case hMean < 360:
+			hMean = (hMean + 360) / 2
+		default:
+			hMean = (hMean - 360) / 2
+		}
+	}
+	t := 1 - 0.17*math.Cos((hMean-30)*deg) + 0.24*math.Cos(2*hMean*deg) +
+		0.32*math.Cos((3*hMean+6)*deg) - 0.20*math.Cos((4*hMean-63)*deg)
+	dTheta := 30 * math.Exp(-math.Pow((hMean-275)/25, 2))
+	c7p := math.Pow(cMeanP, 7)
+	rC := 2 * math.Sqrt(c7p/(c7p+math.Pow(25, 7)))
+	l50 := (lMean - 50) * (lMean - 50)
+	sL := 1 + 0.015*l50/math.Sqrt(20+l50)
+	sC := 1 + 0.045*cMeanP
+	sH := 1 + 0.015*cMeanP*t
+	rT := -math.Sin(2*dTheta*deg) * rC
+
+	l, c, h := dL/sL, dC/sC, dH/sH
+	return math.Sqrt(l*l + c*c + h*h + rT*c*h)
+}
+
+// A colour in OKLCH, OKLab in polar form: lightness 0-1, chroma from 0 to
+// about 0.32 within sRGB and hue in degrees
+type OKLCH struct {
+	L, C, H float64
+}
+
+// LCH returns the colour in polar form, hue 0 for greys.
+func (o OKLab) LCH() OKLCH {
+	c := math.Hypot(o.A, o.B)
+	if c < 1e-6 {
+		return OKLCH{L: o.L}
+	}
+	h := math.Atan2(o.B, o.A) * 180 / math.Pi
+	if h < 0 {
+		h += 360
+	}
+	return OKLCH{L: o.L, C: c, H: h}
+}
+
+// OKLab returns the colour with its hue as opponent axes.
+func (o OKLCH) OKLab() OKLab {
+	h := o.H * math.Pi / 180
+	return OKLab{L: o.L, A: o.C * math.Cos(h), B: o.C * math.Sin(h)}
+}
+
+// HSV returns hue, saturation and value, each 0-1, hue 0 for greys.
+func (c Color) HSV() (h, s, v float64) {
+	r, g, b := float64(c.R)/255, float64(c.G)/255, float64(c.B)/255
+	hi, lo := max(r, g, b), min(r, g, b)
+	v = hi
+	if hi == 0 || hi == lo {
+		return 0, 0, v
+	}
+	s = (hi - lo) / hi
+	return hue(r, g, b), s, v
+}
+
+// HSL returns hue, saturation and lightness, each 0-1, hue 0 for greys.
+func (c Color) HSL() (h, s, l float64) {
+	r, g, b := float64(c.R)/255, float64(c.G)/255, float64(c.B)/255
+	hi, lo := max(r, g, b), min(r, g, b)
+	l = (hi + lo) / 2
+	if hi == lo {
+		return 0, 0, l
+	}
+	s = (hi - lo) / (1 - math.Abs(2*l-1))
+	return hue(r, g, b), s, l
+}
+
+// Hue of an RGB colour that is not grey, 0-1
+func hue(r, g, b float64) float64 {
+	hi, lo := max(r, g, b), min(r, g, b)
+	d := hi - lo
+	var h float64
+	switch hi {
+	case r:
+		h = (g - b) / d
+	case g:
+		h = 2 + (b-r)/d
+	default:
+		h = 4 + (r-g)/d
+	}
+	return math.Mod(h/6+1, 1)
+}
+
+// FromHSV returns the opaque colour of a hue, saturation and value, each
+// 0-1.
+func FromHSV(h, s, v float64) Color {
+	h = math.Mod(math.Mod(h, 1)+1, 1) * 6
+	i := math.Floor(h)
+	f := h - i
+	p, q, t := v*(1-s), v*(1-s*f), v*(1-s*(1-f))
+	var r, g, b float64
+	switch int(i) {
+	case 0:
+		r, g, b = v, t, p
+	case 1:
+		r, g, b = q, v, p
+	case 2:
+		r, g, b = p, v, t
+	case 3:
+		r, g, b = p, q, v
+	case 4:
+		r, g, b = t, p, v
+	default:
+		r, g, b = v, p, q
+	}
+	return Color{R: unit8(r), G: unit8(g), B: unit8(b), A: 255}
+}
+
+// FromHSL returns the opaque colour of a hue, saturation and lightness,
+// each 0-1.
+func FromHSL(h, s, l float64) Color {
+	v := l + s*min(l, 1-l)
+	if v == 0 {
+		return Color{A: 255}
+	}
+	return FromHSV(h, 2*(1-l/v), v)
+}
@@ -0,0 +1,184 @@
+package palette
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"unicode"
+)
+
+// Palette exporters, as palgen writes them for releases. Exporters write
+// only what the palette holds, never times or paths, so the same palette
+// always gives the same bytes.
+
+// A file format palettes can be written in
+type Exporter interface {
+	Name() string           // short, for command lines, such as gpl
+	Label() string          // for people, such as GIMP
+	File(p *Palette) string // file name for a palette, such as deluxe08.gpl
+	Export(w io.Writer, p *Palette) error
+}
+
+// Exporters return this, wrapped, for palettes their format cannot hold
+var ErrNotRepresentable = errors.New("palette does not fit the format")
+
+// Exporter from a function, writing files named by the palette and a
+// suffix
+type exporter struct {
+	name, label, suffix string
+	export              func(w io.Writer, p *Palette) error
+}
+
+func (e exporter) Name() string                         { return e.name }
+func (e exporter) Label() string                        { return e.label }
+func (e exporter) File(p *Palette) string               { return p.Name + e.suffix }
+func (e exporter) Export(w io.Writer, p *Palette) error { return e.export(w, p) }
+
+// NewExporter returns an exporter writing files named after the palette
+// with a suffix, such as ".gpl" or "-strip.png".
+func NewExporter(name, label, suffix string, export func(w io.Writer, p *Palette) error) Exporter {
+	return exporter{name, label, suffix, export}
+}
+
+// Exporters palgen knows, the formats of the original release first.
+// Append to add formats.
+var Exporters = []Exporter{
+	NewExporter("png", "PNG Swatch", ".png", WriteSwatchPNG),
+	NewExporter("strip", "PNG Strip", "-strip.png", WriteStripPNG),
+	NewExporter("gpl", "GIMP", ".gpl", WriteGPL),
+	NewExporter("hex", "HEX", ".hex", WriteHex),
+	NewExporter("css", "CSS", ".css", WriteCSS),
+	NewExporter("aco", "Photoshop", ".aco", WriteACO),
+	NewExporter("ase", "Adobe CC", ".ase", WriteASE),
+	NewExporter("unity", "Unity C#", ".cs", WriteUnity),
+	NewExporter("json", "Unity JSON", ".json", WriteJSON),
+	NewExporter("scss", "SCSS", ".scss", WriteSCSS),
+	NewExporter("less", "LESS", ".less", WriteLESS),
+	NewExporter("tailwind", "Tailwind", ".tailwind.js", WriteTailwind),
+	NewExporter("kpl", "Krita", ".kpl", WriteKPL),
+	NewExporter("aseprite", "Aseprite", "-aseprite.gpl", WriteAsepriteGPL),
+	NewExporter("procreate", "Procreate", ".swatches", WriteProcreate),
+	GoExporter{Package: "palettes"},
+	NewExporter("c", "C header", ".h", WriteCHeader),
+}
+
+// ExporterFor returns the exporter with a name.
+func ExporterFor(name string) (Exporter, bool) {
+	for _, e := range Exporters {
+		if e.Name() == name {
+			return e, true
+		}
+	}
+	return nil, false
+}
+
+// Words of a name, split at anything but letters and digits
+func words(name string) []string {
+	return strings.FieldsFunc(name, func(r rune) bool { return !unicode.IsLetter(r) && !unicode.IsDigit(r) })
+}
+
+// Name in lower case joined by a separator, such as sea-green-02
+func joinLower(name, sep string) string {
+	return strings.ToLower(strings.Join(words(name), sep))
+}
+
+// Name as a Go, C# or C identifier in PascalCase, such as SeaGreen02
+func pascal(name string) string {
+	var b strings.Builder
+	for _, w := range words(name) {
+		r := []rune(w)
+		b.WriteString(strings.ToUpper(string(r[0])) + string(r[1:]))
+	}
+	s := b.String()
+	if s == "" || unicode.IsDigit([]rune(s)[0]) {
+		s = "Color" + s
+	}
+	return s
+}
+
+// Names of the colours made into identifiers by a function, the second
+// and later of a name numbered after a separator
+func (p *Palette) identifiers(ident func(string) string, sep string) []string {
+	seen := map[string]bool{}
+	ids := make([]string, len(p.Colors))
+	for i := range p.Colors {
+		id := ident(p.colorName(i))
+		for n := 2; seen[id]; n++ {
+			id = fmt.Sprintf("%s%s%d", ident(p.colorName(i)), sep, n)
+		}
+		seen[id] = true
+		ids[i] = id
+	}
+	return ids
+}
+
+// Hex with alpha after blue when the colour is not opaque, as CSS takes it
+func (c Color) hexAlpha() string {
+	if c.A == 255 {
+		return c.Hex()
+	}
+	return fmt.Sprintf("%s%02X", c.Hex(), c.A)
+}
+
+// WriteGPL writes a GIMP palette.
+func WriteGPL(w io.Writer, p *Palette) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "GIMP Palette\nName: %s\nColumns: 0\n#\n# %s - %d colors\n#\n", p.title(), p.Name, len(p.Colors))
+	for i, c := range p.Colors {
+		fmt.Fprintf(&b, "%3d %3d %3d %s\n", c.R, c.G, c.B, p.colorName(i))
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// WriteAsepriteGPL writes a GIMP palette with alpha, as Aseprite reads and
+// writes them.
+func WriteAsepriteGPL(w io.Writer, p *Palette) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "GIMP Palette\nChannels: RGBA\nName: %s\nColumns: 0\n#\n", p.title())
+	for i, c := range p.Colors {
+		fmt.Fprintf(&b, "%3d %3d %3d %3d %s\n", c.R, c.G, c.B, c.A, p.colorName(i))
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// WriteHex writes a colour per line as #RRGGBB, or #RRGGBBAA if not
+// opaque, after the title.
+func WriteHex(w io.Writer, p *Palette) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# %s\n# %d colors\n\n", p.title(), len(p.Colors))
+	for _, c := range p.Colors {
+		b.WriteString(c.hexAlpha() + "\n")
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// WriteJSON writes the deluxe palette collection's JSON, which ReadJSON
+// reads and Unity scripts load.
+func WriteJSON(w io.Writer, p *Palette) error {
+	type jsonOut struct {
+		Name string  `json:"name"`
+		R    float32 `json:"r"`
+		G    float32 `json:"g"`
+		B    float32 `json:"b"`
+		A    float32 `json:"a"`
+		Hex  string  `json:"hex"`
+	}
+	file := struct {
+		Name      string    `json:"name"`
+		ShortName string    `json:"shortName"`
+		Count     int       `json:"count"`
+		Colors    []jsonOut `json:"colors"`
+	}{p.title(), p.Name, len(p.Colors), []jsonOut{}}
+	for i, c := range p.Colors {
+		file.Colors = append(file.Colors, jsonOut{p.colorName(i), float32(c.R) / 255, float32(c.G) / 255, float32(c.B) / 255, float32(c.A) / 255, c.Hex()})
+	}
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	return enc.Encode(file)
+}
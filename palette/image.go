@@ -0,0 +1,124 @@
+package palette
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"io"
+	"strings"
+)
+
+// PNG exporters: a swatch sheet labelled with names and hex values, and a
+// strip of plain squares. Labels use a built-in 5x7 font so the images
+// come out the same everywhere.
+
+const (
+	swatchCell    = 100 // pixels per colour on the swatch sheet
+	swatchColumns = 8
+	stripCell     = 32
+	glyphAdvance  = 6
+)
+
+// Glyphs of the label font, rows top down, # for ink
+var glyphs = map[rune][7]string{
+	'0': {".###.", "#...#", "#..##", "#.#.#", "##..#", "#...#", ".###."},
+	'1': {"..#..", ".##..", "..#..", "..#..", "..#..", "..#..", ".###."},
+	'2': {".###.", "#...#", "....#", "...#.", "..#..", ".#...", "#####"},
+	'3': {"#####", "...#.", "..#..", "...#.", "....#", "#...#", ".###."},
+	'4': {"...#.", "..##.", ".#.#.", "#..#.", "#####", "...#.", "...#."},
+	'5': {"#####", "#....", "####.", "....#", "....#", "#...#", ".###."},
+	'6': {"..##.", ".#...", "#....", "####.", "#...#", "#...#", ".###."},
+	'7': {"#####", "....#", "...#.", "..#..", ".#...", ".#...", ".#..."},
+	'8': {".###.", "#...#", "#...#", ".###.", "#...#", "#...#", ".###."},
+	'9': {".###.", "#...#", "#...#", ".####", "....#", "...#.", ".##.."},
+	'A': {".###.", "#...#", "#...#", "#####", "#...#", "#...#", "#...#"},
+	'B': {"####.", "#...#", "#...#", "####.", "#...#", "#...#", "####."},
+	'C': {".###.", "#...#", "#....", "#....", "#....", "#...#", ".###."},
+	'D': {"###..", "#..#.", "#...#", "#...#", "#...#", "#..#.", "###.."},
+	'E': {"#####", "#....", "#....", "####.", "#....", "#....", "#####"},
+	'F': {"#####", "#....", "#....", "####.", "#....", "#....", "#...."},
+	'G': {".###.", "#...#", "#....", "#.###", "#...#", "#...#", ".####"},
+	'H': {"#...#", "#...#", "#...#", "#####", "#...#", "#...#", "#...#"},
+	'I': {".###.", "..#..", "..#..", "..#..", "..#..", "..#..", ".###."},
+	'J': {"..###", "...#.", "...#.", "...#.", "...#.", "#..#.", ".##.."},
+	'K': {"#...#", "#..#.", "#.#..", "##...", "#.#..", "#..#.", "#...#"},
+	'L': {"#....", "#....", "#....", "#....", "#....", "#....", "#####"},
+	'M': {"#...#", "##.##", "#.#.#", "#.#.#", "#...#", "#...#", "#...#"},
+	'N': {"#...#", "#...#", "##..#", "#.#.#", "#..##", "#...#", "#...#"},
+	'O': {".###.", "#...#", "#...#", "#...#", "#...#", "#...#", ".###."},
+	'P': {"####.", "#...#", "#...#", "####.", "#....", "#....", "#...."},
+	'Q': {".###.", "#...#", "#...#", "#...#", "#.#.#", "#..#.", ".##.#"},
+	'R': {"####.", "#...#", "#...#", "####.", "#.#..", "#..#.", "#...#"},
+	'S': {".####", "#....", "#....", ".###.", "....#", "....#", "####."},
+	'T': {"#####", "..#..", "..#..", "..#..", "..#..", "..#..", "..#.."},
+	'U': {"#...#", "#...#", "#...#", "#...#", "#...#", "#...#", ".###."},
+	'V': {"#...#", "#...#", "#...#", "#...#", "#...#", ".#.#.", "..#.."},
+	'W': {"#...#", "#...#", "#...#", "#.#.#", "#.#.#", "#.#.#", ".#.#."},
+	'X': {"#...#", "#...#", ".#.#.", "..#..", ".#.#.", "#...#", "#...#"},
+	'Y': {"#...#", "#...#", ".#.#.", "..#..", "..#..", "..#..", "..#.."},
+	'Z': {"#####", "....#", "...#.", "..#..", ".#...", "#....", "#####"},
+	'#': {".#.#.", ".#.#.", "#####", ".#.#.", "#####", ".#.#.", ".#.#."},
+	'-': {".....", ".....", ".....", "#####", ".....", ".....", "....."},
+	'_': {".....", ".....", ".....", ".....", ".....", ".....", "#####"},
+}
+
+// Draw upper-cased text with its top left at x, y, cut off at a width;
+// characters without a glyph are spaces
+func drawLabel(img *image.NRGBA, x, y, width int, text string, ink color.NRGBA) {
+	for _, r := range strings.ToUpper(text) {
+		if width < glyphAdvance-1 {
+			return
+		}
+		for row, line := range glyphs[r] {
+			for col, dot := range line {
+				if dot == '#' {
+					img.SetNRGBA(x+col, y+row, ink)
+				}
+			}
+		}
+		x += glyphAdvance
+		width -= glyphAdvance
+	}
+}
+
+// Black or white, whichever reads better on a colour
+func labelInk(c Color) color.NRGBA {
+	// Where contrast with black and white is equal
+	if c.Luminance() > 0.179 {
+		return color.NRGBA{0, 0, 0, 255}
+	}
+	return color.NRGBA{255, 255, 255, 255}
+}
+
+// WriteSwatchPNG writes a sheet of squares, up to eight a row, each
+// labelled with the colour's name and hex value.
+func WriteSwatchPNG(w io.Writer, p *Palette) error {
+	if len(p.Colors) == 0 {
+		return fmt.Errorf("no colors")
+	}
+	columns := min(len(p.Colors), swatchColumns)
+	rows := (len(p.Colors) + columns - 1) / columns
+	img := image.NewNRGBA(image.Rect(0, 0, columns*swatchCell, rows*swatchCell))
+	for i, c := range p.Colors {
+		x, y := i%columns*swatchCell, i/columns*swatchCell
+		draw.Draw(img, image.Rect(x, y, x+swatchCell, y+swatchCell), image.NewUniform(c.NRGBA()), image.Point{}, draw.Src)
+		ink := labelInk(c)
+		drawLabel(img, x+6, y+swatchCell-30, swatchCell-12, p.colorName(i), ink)
+		drawLabel(img, x+6, y+swatchCell-16, swatchCell-12, c.Hex(), ink)
+	}
+	return png.Encode(w, img)
+}
+
+// WriteStripPNG writes the colours as a single row of plain squares.
+func WriteStripPNG(w io.Writer, p *Palette) error {
+	if len(p.Colors) == 0 {
+		return fmt.Errorf("no colors")
+	}
+	img := image.NewNRGBA(image.Rect(0, 0, len(p.Colors)*stripCell, stripCell))
+	for i, c := range p.Colors {
+		draw.Draw(img, image.Rect(i*stripCell, 0, (i+1)*stripCell, stripCell), image.NewUniform(c.NRGBA()), image.Point{}, draw.Src)
+	}
+	return png.Encode(w, img)
+}
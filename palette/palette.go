@@ -0,0 +1,180 @@
+// Package palette reads colour palettes in the formats paint programs and
+// design tools exchange them in, writes Adobe swatch files, and bundles
+// the deluxe palette collection.
+package palette
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	"image/color"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// One palette entry; Name may be empty
+type Color struct {
+	Name       string
+	R, G, B, A uint8
+}
+
+// NRGBA returns the colour without its name.
+func (c Color) NRGBA() color.NRGBA {
+	return color.NRGBA{c.R, c.G, c.B, c.A}
+}
+
+// Hex returns the colour as #RRGGBB.
+func (c Color) Hex() string {
+	return fmt.Sprintf("#%02X%02X%02X", c.R, c.G, c.B)
+}
+
+// Luminance returns the WCAG relative luminance of the colour, 0 for
+// black to 1 for white.
+func (c Color) Luminance() float64 {
+	return 0.2126*linearize(c.R) + 0.7152*linearize(c.G) + 0.0722*linearize(c.B)
+}
+
+// A named list of colours
+type Palette struct {
+	Name   string // short, usable in file names, such as deluxe08
+	Title  string // for people, such as Deluxe Draw palette 08
+	Colors []Color
+}
+
+// Title, or the name without one
+func (p *Palette) title() string {
+	if p.Title != "" {
+		return p.Title
+	}
+	return p.Name
+}
+
+// Name of a colour, or "color n" without one
+func (p *Palette) colorName(i int) string {
+	if p.Colors[i].Name != "" {
+		return p.Colors[i].Name
+	}
+	return fmt.Sprintf("color %d", i+1)
+}
+
+// Most colours a palette file may hold; more means a broken file
+const MaxColors = 4096
+
+// A palette file format
+type Format struct {
+	Name string
+	Exts []string // lower case, with the dot
+	Read func(r io.Reader) (*Palette, error)
+}
+
+// Formats Load recognises, by file extension. DPF files are left to the
+// editor, which also reads their icons.
+var Formats = []Format{
+	{"GIMP", []string{".gpl"}, ReadGPL},
+	{"hex", []string{".hex"}, ReadHex},
+	{"JSON", []string{".json"}, ReadJSON},
+	{"Adobe Color Swatch", []string{".aco"}, ReadACO},
+	{"Adobe Swatch Exchange", []string{".ase"}, ReadASE},
+	{"JASC-PAL", []string{".pal"}, ReadJASC},
+	{"Paint.NET", []string{".txt"}, ReadPaintNET},
+}
+
+// Extensions returns the file extensions of all formats.
+func Extensions() []string {
+	var exts []string
+	for _, f := range Formats {
+		exts = append(exts, f.Exts...)
+	}
+	return exts
+}
+
+// FormatFor returns the format of a file name, by its extension.
+func FormatFor(filename string) (Format, bool) {
+	ext := strings.ToLower(filepath.Ext(filename))
+	for _, f := range Formats {
+		for _, e := range f.Exts {
+			if e == ext {
+				return f, true
+			}
+		}
+	}
+	return Format{}, false
+}
+
+// Load reads a palette file in the format its extension names. A palette
+// without a short name is named after the file.
+func Load(filename string) (*Palette, error) {
+	format, ok := FormatFor(filename)
+	if !ok {
+		return nil, fmt.Errorf("%s: unknown palette format", filepath.Base(filename))
+	}
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	p, err := format.Read(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", filepath.Base(filename), err)
+	}
+	if p.Name == "" {
+		p.Name = strings.TrimSuffix(filepath.Base(filename), filepath.Ext(filename))
+	}
+	return p, nil
+}
+
+// Add a colour, failing once there are too many
+func (p *Palette) add(c Color) error {
+	if len(p.Colors) >= MaxColors {
+		return fmt.Errorf("more than %d colors", MaxColors)
+	}
+	p.Colors = append(p.Colors, c)
+	return nil
+}
+
+// Palette with no colours is no palette
+func (p *Palette) check() (*Palette, error) {
+	if len(p.Colors) == 0 {
+		return nil, fmt.Errorf("no colors")
+	}
+	return p, nil
+}
+
+//go:embed collection/*.gpl
+var collectionFS embed.FS
+
+// Collection returns the bundled deluxe palettes, smallest first, each
+// named by its short name, such as deluxe32.
+func Collection() []*Palette {
+	entries, _ := collectionFS.ReadDir("collection")
+	var palettes []*Palette
+	for _, e := range entries {
+		data, err := collectionFS.ReadFile(path.Join("collection", e.Name()))
+		if err != nil {
+			continue
+		}
+		p, err := ReadGPL(bytes.NewReader(data))
+		if err != nil {
+			// The files are part of the build
+			panic(fmt.Sprintf("palette: bundled %s: %v", e.Name(), err))
+		}
+		p.Name = strings.TrimSuffix(e.Name(), ".gpl")
+		palettes = append(palettes, p)
+	}
+	sort.SliceStable(palettes, func(i, j int) bool { return len(palettes[i].Colors) < len(palettes[j].Colors) })
+	return palettes
+}
+
+// Bundled returns the bundled palette with a short name.
+func Bundled(name string) (*Palette, bool) {
+	for _, p := range Collection() {
+		if p.Name == name {
+			return p, true
+		}
+	}
+	return nil, false
+}
@@ -0,0 +1,488 @@
+package palette
+
+import (
+	"cmp"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"slices"
+)
+
+// Quantization: choosing a few colours for an image, by median cut, octree
+// or k-means, and remapping the image onto a palette with optional
+// dithering. Colours less than half opaque are left out of quantizing, and
+// everything is deterministic: the same image gives the same palette.
+
+// A way of choosing colours for an image
+type Method int
+
+const (
+	MedianCut Method = iota // split the colour space at medians, in OKLab
+	Octree                  // merge the rarest branches of an RGB octree
+	KMeans                  // refine median cut by k-means clustering
+)
+
+// Names of the methods, for command lines
+var MethodNames = []string{"median", "octree", "kmeans"}
+
+// How colour differences are measured
+type Metric int
+
+const (
+	MetricOKLab     Metric = iota // distance in OKLab, fast
+	MetricCIEDE2000               // CIEDE2000, slower and more exact
+)
+
+// Names of the metrics, for command lines
+var MetricNames = []string{"oklab", "ciede2000"}
+
+// A way of spreading the difference between colours and their matches
+type Dither int
+
+const (
+	DitherNone     Dither = iota
+	FloydSteinberg        // error diffusion to four neighbours
+	Atkinson              // error diffusion, three quarters of it, to six
+	Bayer                 // ordered, by an 8x8 threshold matrix
+	BlueNoise             // ordered, by a 64x64 blue noise matrix
+)
+
+// Names of the dithers, for command lines
+var DitherNames = []string{"none", "floyd", "atkinson", "bayer", "bluenoise"}
+
+// Options for Remap
+type RemapOptions struct {
+	Dither Dither
+	Metric Metric
+	// Entry that pixels less than half opaque take, and no other pixel
+	// does; -1 matches them like the rest
+	Transparent int
+}
+
+// Unique colours fewer than this are quantized exactly; more are first
+// binned at lower precision
+const maxHistogram = 32768
+
+// A colour of the image and how many pixels have it
+type weighted struct {
+	c  Color
+	n  int
+	ok OKLab
+}
+
+// Colours of the opaque pixels of an image and their counts, in RGB
+// order, binned until there are at most maxHistogram
+func histogram(img *image.NRGBA) []weighted {
+	type sum struct{ r, g, b, n int }
+	for bits := 8; ; bits-- {
+		mask := uint32(0xFF) << (8 - bits) & 0xFF
+		bins := make(map[uint32]*sum)
+		for i := 0; i < len(img.Pix); i += 4 {
+			if img.Pix[i+3] < 128 {
+				continue
+			}
+			r, g, b := uint32(img.Pix[i]), uint32(img.Pix[i+1]), uint32(img.Pix[i+2])
+			key := (r&mask)<<16 | (g&mask)<<8 | b&mask
+			s := bins[key]
+			if s == nil {
+				s = &sum{}
+				bins[key] = s
+			}
+			s.r, s.g, s.b, s.n = s.r+int(r), s.g+int(g), s.b+int(b), s.n+1
+		}
+		if len(bins) > maxHistogram && bits > 4 {
+			continue
+		}
+		keys := make([]uint32, 0, len(bins))
+		for k := range bins {
+			keys = append(keys, k)
+		}
+		slices.Sort(keys)
+		hist := make([]weighted, len(keys))
+		for i, k := range keys {
+			s := bins[k]
+			c := Color{R: uint8((s.r + s.n/2) / s.n), G: uint8((s.g + s.n/2) / s.n), B: uint8((s.b + s.n/2) / s.n), A: 255}
+			hist[i] = weighted{c, s.n, c.OKLab()}
+		}
+		return hist
+	}
+}
+
+// Image as NRGBA, copied unless it already is one
+func asNRGBA(img image.Image) *image.NRGBA {
+	if n, ok := img.(*image.NRGBA); ok {
+		return n
+	}
+	b := img.Bounds()
+	n := image.NewNRGBA(b)
+	draw.Draw(n, b, img, b.Min, draw.Src)
+	return n
+}
+
+// Quantize chooses at most n colours for the opaque pixels of an image.
+// The metric matters to k-means only; median cut works in OKLab and
+// octree in RGB.
+func Quantize(img image.Image, n int, method Method, metric Metric) (*Palette, error) {
+	if n < 1 || n > MaxColors {
+		return nil, fmt.Errorf("%d colors, must be 1-%d", n, MaxColors)
+	}
+	hist := histogram(asNRGBA(img))
+	if len(hist) == 0 {
+		return nil, fmt.Errorf("no opaque pixels")
+	}
+	var colors []Color
+	switch method {
+	case MedianCut:
+		colors = medianCut(hist, n)
+	case Octree:
+		colors = octree(hist, n)
+	case KMeans:
+		colors = kMeans(hist, medianCut(hist, n), metric)
+	default:
+		return nil, fmt.Errorf("unknown method %d", method)
+	}
+	return &Palette{Colors: colors}, nil
+}
+
+// Weighted mean of colours in OKLab
+func meanOKLab(colors []weighted) OKLab {
+	var m OKLab
+	total := 0
+	for _, w := range colors {
+		m.L += w.ok.L * float64(w.n)
+		m.A += w.ok.A * float64(w.n)
+		m.B += w.ok.B * float64(w.n)
+		total += w.n
+	}
+	t := float64(total)
+	return OKLab{m.L / t, m.A / t, m.B / t}
+}
+
+// Axis of OKLab as an index, 0 L, 1 a, 2 b
+func (o OKLab) axis(i int) float64 {
+	return [3]float64{o.L, o.A, o.B}[i]
+}
+
+// A box of median cut: its colours, the axis of most spread and the
+// weighted squared error along it
+type cutBox struct {
+	colors []weighted
+	axis   int
+	err    float64
+}
+
+func newCutBox(colors []weighted) cutBox {
+	b := cutBox{colors: colors}
+	m := meanOKLab(colors)
+	for axis := range 3 {
+		e := 0.0
+		for _, w := range colors {
+			d := w.ok.axis(axis) - m.axis(axis)
+			e += d * d * float64(w.n)
+		}
+		if e > b.err {
+			b.axis, b.err = axis, e
+		}
+	}
+	return b
+}
+
+// Median cut: split the box with the most error at the weighted median of
+// its widest axis until there are n boxes, then average each
+func medianCut(hist []weighted, n int) []Color {
+	boxes := []cutBox{newCutBox(slices.Clone(hist))}
+	for len(boxes) < n {
+		best := -1
+		for i, b := range boxes {
+			if len(b.colors) > 1 && b.err > 0 && (best < 0 || b.err > boxes[best].err) {
+				best = i
+			}
+		}
+		if best < 0 {
+			break
+		}
+		b := boxes[best]
+		slices.SortStableFunc(b.colors, func(x, y weighted) int {
+			return cmp.Compare(x.ok.axis(b.axis), y.ok.axis(b.axis))
+		})
+		total := 0
+		for _, w := range b.colors {
+			total += w.n
+		}
+		split, count := 1, b.colors[0].n
+		for split < len(b.colors)-1 && count+b.colors[split].n <= total/2 {
+			count += b.colors[split].n
+			split++
+		}
+		boxes[best] = newCutBox(b.colors[:split])
+		boxes = append(boxes, newCutBox(b.colors[split:]))
+	}
+	colors := make([]Color, len(boxes))
+	for i, b := range boxes {
+		colors[i] = meanOKLab(b.colors).Color()
+	}
+	return colors
+}
+
+// A node of the colour octree, with the sums of the colours under it
+type octNode struct {
+	r, g, b, n int
+	children   [8]*octNode
+	leaf       bool
+}
+
+// Octree: file colours down eight levels by the bits of their channels,
+// then merge the nodes holding fewest pixels, deepest first, until at
+// most n leaves remain
+func octree(hist []weighted, n int) []Color {
+	root := &octNode{}
+	var levels [8][]*octNode // nodes with children, by depth
+	levels[0] = []*octNode{root}
+	leaves := 0
+	for _, w := range hist {
+		node := root
+		for depth := 0; depth < 8; depth++ {
+			node.r, node.g, node.b, node.n = node.r+int(w.c.R)*w.n, node.g+int(w.c.G)*w.n, node.b+int(w.c.B)*w.n, node.n+w.n
+			shift := 7 - depth
+			i := int(w.c.R>>shift&1)<<2 | int(w.c.G>>shift&1)<<1 | int(w.c.B>>shift&1)
+			if node.children[i] == nil {
+				node.children[i] = &octNode{}
+				if depth < 7 {
+					levels[depth+1] = append(levels[depth+1], node.children[i])
+				} else {
+					node.children[i].leaf = true
+					leaves++
+				}
+			}
+			node = node.children[i]
+		}
+		node.r, node.g, node.b, node.n = node.r+int(w.c.R)*w.n, node.g+int(w.c.G)*w.n, node.b+int(w.c.B)*w.n, node.n+w.n
+	}
+
+	for depth := 7; depth >= 0 && leaves > n; depth-- {
+		nodes := slices.Clone(levels[depth])
+		slices.SortStableFunc(nodes, func(x, y *octNode) int { return x.n - y.n })
+		for _, node := range nodes {
+			if leaves <= n {
+				break
+			}
+			children := 0
+			for i, c := range node.children {
+				if c != nil {
+					children++
+					node.children[i] = nil
+				}
+			}
+			node.leaf = true
+			leaves -= children - 1
+		}
+	}
+
+	var colors []Color
+	var walk func(node *octNode)
+	walk = func(node *octNode) {
+		if node.leaf {
+			colors = append(colors, Color{R: uint8((node.r + node.n/2) / node.n), G: uint8((node.g + node.n/2) / node.n), B: uint8((node.b + node.n/2) / node.n), A: 255})
+			return
+		}
+		for _, c := range node.children {
+			if c != nil {
+				walk(c)
+			}
+		}
+	}
+	walk(root)
+	return colors
+}
+
+// Most rounds of k-means
+const kMeansRounds = 16
+
+// K-means: move each colour to its nearest centre and each centre to the
+// mean of its colours until nothing moves
+func kMeans(hist []weighted, centres []Color, metric Metric) []Color {
+	assigned := make([]int, len(hist))
+	for i := range assigned {
+		assigned[i] = -1
+	}
+	for range kMeansRounds {
+		m := newMatcher(centres, metric, -1)
+		moved := false
+		for i, w := range hist {
+			if c := m.nearest(w.c); c != assigned[i] {
+				assigned[i], moved = c, true
+			}
+		}
+		if !moved {
+			break
+		}
+		groups := make([][]weighted, len(centres))
+		for i, w := range hist {
+			groups[assigned[i]] = append(groups[assigned[i]], w)
+		}
+		for i, g := range groups {
+			// A centre nothing is nearest keeps its place
+			if len(g) > 0 {
+				centres[i] = meanOKLab(g).Color()
+			}
+		}
+	}
+	// Centres that met are one colour
+	var colors []Color
+	for _, c := range centres {
+		if !slices.Contains(colors, c) {
+			colors = append(colors, c)
+		}
+	}
+	return colors
+}
+
+// Finds the closest palette entry to colours by a metric
+type matcher struct {
+	metric      Metric
+	ok          []OKLab
+	lab         []Lab
+	transparent int // never matched
+	cache       map[Color]int
+}
+
+func newMatcher(colors []Color, metric Metric, transparent int) *matcher {
+	m := &matcher{metric: metric, transparent: transparent, cache: make(map[Color]int)}
+	for _, c := range colors {
+		m.ok = append(m.ok, c.OKLab())
+		m.lab = append(m.lab, c.Lab())
+	}
+	return m
+}
+
+// Index of the closest entry to an opaque colour
+func (m *matcher) nearest(c Color) int {
+	c.Name, c.A = "", 255
+	if i, ok := m.cache[c]; ok {
+		return i
+	}
+	best, bestDist := -1, math.Inf(1)
+	var ok OKLab
+	var lab Lab
+	if m.metric == MetricCIEDE2000 {
+		lab = c.Lab()
+	} else {
+		ok = c.OKLab()
+	}
+	for i := range m.ok {
+		if i == m.transparent {
+			continue
+		}
+		var d float64
+		if m.metric == MetricCIEDE2000 {
+			d = DeltaE2000(lab, m.lab[i])
+		} else {
+			d = ok.dist2(m.ok[i])
+		}
+		if d < bestDist {
+			best, bestDist = i, d
+		}
+	}
+	m.cache[c] = best
+	return best
+}
+
+// Remap returns an image as indices into a palette of at most 256
+// colours, dithered as the options say.
+func Remap(img image.Image, p *Palette, opts RemapOptions) (*image.Paletted, error) {
+	switch {
+	case len(p.Colors) == 0:
+		return nil, fmt.Errorf("no colors")
+	case len(p.Colors) > 256:
+		return nil, fmt.Errorf("%d colors, at most 256 can be remapped to", len(p.Colors))
+	case opts.Transparent >= len(p.Colors):
+		return nil, fmt.Errorf("transparent entry %d of %d colors", opts.Transparent, len(p.Colors))
+	case opts.Transparent >= 0 && len(p.Colors) == 1:
+		return nil, fmt.Errorf("no colors besides the transparent one")
+	}
+	src := asNRGBA(img)
+	b := src.Bounds()
+	pal := make(color.Palette, len(p.Colors))
+	for i, c := range p.Colors {
+		pal[i] = c.NRGBA()
+	}
+	out := image.NewPaletted(b, pal)
+	m := newMatcher(p.Colors, opts.Metric, opts.Transparent)
+
+	// Ordered dithers nudge each pixel by up to half the usual step
+	// between palette colours
+	var threshold func(x, y int) float64
+	switch opts.Dither {
+	case Bayer:
+		threshold = bayerThreshold
+	case BlueNoise:
+		threshold = blueNoiseThreshold
+	}
+	spread := 255 / math.Cbrt(float64(len(p.Colors)))
+
+	// Error carried to this row and the two below, per channel
+	w := b.Dx()
+	errs := [3][]float64{make([]float64, 3*(w+4)), make([]float64, 3*(w+4)), make([]float64, 3*(w+4))}
+	diffuse := func(x, dy, dx int, e [3]float64, f float64) {
+		row := errs[dy]
+		i := 3 * (x + dx + 2)
+		row[i] += e[0] * f
+		row[i+1] += e[1] * f
+		row[i+2] += e[2] * f
+	}
+
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := 0; x < w; x++ {
+			o := src.PixOffset(b.Min.X+x, y)
+			px := src.Pix[o : o+4 : o+4]
+			if px[3] < 128 {
+				if opts.Transparent >= 0 {
+					out.Pix[out.PixOffset(b.Min.X+x, y)] = uint8(opts.Transparent)
+					continue
+				}
+				// Clear pixels neither take nor give error
+				out.Pix[out.PixOffset(b.Min.X+x, y)] = uint8(m.nearest(Color{R: px[0], G: px[1], B: px[2]}))
+				continue
+			}
+			var v [3]float64
+			for ch := range 3 {
+				v[ch] = float64(px[ch]) + errs[0][3*(x+2)+ch]
+				if threshold != nil {
+					v[ch] += threshold(b.Min.X+x, y) * spread
+				}
+				v[ch] = min(255, max(0, v[ch]))
+			}
+			c := Color{R: uint8(math.Round(v[0])), G: uint8(math.Round(v[1])), B: uint8(math.Round(v[2]))}
+			i := m.nearest(c)
+			out.Pix[out.PixOffset(b.Min.X+x, y)] = uint8(i)
+
+			e := [3]float64{v[0] - float64(p.Colors[i].R), v[1] - float64(p.Colors[i].G), v[2] - float64(p.Colors[i].B)}
+			switch opts.Dither {
+			case FloydSteinberg:
+				diffuse(x, 0, 1, e, 7.0/16)
+				diffuse(x, 1, -1, e, 3.0/16)
+				diffuse(x, 1, 0, e, 5.0/16)
+				diffuse(x, 1, 1, e, 1.0/16)
+			case Atkinson:
+				for _, d := range [][2]int{{0, 1}, {0, 2}, {1, -1}, {1, 0}, {1, 1}, {2, 0}} {
+					diffuse(x, d[0], d[1], e, 1.0/8)
+				}
+			}
+		}
+		errs[0], errs[1], errs[2] = errs[1], errs[2], errs[0]
+		clear(errs[2])
+	}
+	return out, nil
+}
+
+// Ordered dither offset at a pixel, -0.5 to 0.5, from the 8x8 Bayer matrix
+func bayerThreshold(x, y int) float64 {
+	v := 0
+	for bit := range 3 {
+		xb, yb := x>>bit&1, y>>bit&1
+		v += (2*(xb^yb) + yb) << (2 * (2 - bit))
+	}
+	return (float64(v)+0.5)/64 - 0.5
+}
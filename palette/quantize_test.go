@@ -0,0 +1,210 @@
+package palette
+
+import (
+	"image"
+	"image/color"
+	"slices"
+	"testing"
+)
+
+// Image of runs of colours, n pixels of each, in one row
+func testRuns(runs ...any) *image.NRGBA {
+	var pix []uint8
+	for i := 0; i < len(runs); i += 2 {
+		c, n := runs[i].(Color), runs[i+1].(int)
+		for range n {
+			pix = append(pix, c.R, c.G, c.B, c.A)
+		}
+	}
+	img := image.NewNRGBA(image.Rect(0, 0, len(pix)/4, 1))
+	copy(img.Pix, pix)
+	return img
+}
+
+func rgb(r, g, b uint8) Color {
+	return Color{R: r, G: g, B: b, A: 255}
+}
+
+// Colours sorted, so results compare whatever order they come in
+func sortedColors(colors []Color) []Color {
+	colors = slices.Clone(colors)
+	slices.SortFunc(colors, func(x, y Color) int {
+		return int(x.R)<<16 | int(x.G)<<8 | int(x.B) - (int(y.R)<<16 | int(y.G)<<8 | int(y.B))
+	})
+	return colors
+}
+
+func near(a, b Color, d int) bool {
+	return max(int(a.R)-int(b.R), int(b.R)-int(a.R)) <= d &&
+		max(int(a.G)-int(b.G), int(b.G)-int(a.G)) <= d &&
+		max(int(a.B)-int(b.B), int(b.B)-int(a.B)) <= d
+}
+
+func TestQuantizeExact(t *testing.T) {
+	// As many colours as asked for, or fewer, come back as they are;
+	// clear pixels are left out
+	red, green, blue, white := rgb(255, 0, 0), rgb(0, 255, 0), rgb(0, 0, 255), rgb(255, 255, 255)
+	img := testRuns(red, 5, green, 3, blue, 2, white, 1, Color{R: 9, G: 9, B: 9, A: 100}, 4)
+	want := sortedColors([]Color{red, green, blue, white})
+	for method := range Method(len(MethodNames)) {
+		for _, n := range []int{4, 16} {
+			p, err := Quantize(img, n, method, MetricOKLab)
+			if err != nil {
+				t.Fatalf("%s: %v", MethodNames[method], err)
+			}
+			if got := sortedColors(p.Colors); !slices.Equal(got, want) {
+				t.Errorf("%s to %d: %v, want %v", MethodNames[method], n, got, want)
+			}
+		}
+	}
+}
+
+func TestQuantizeClusters(t *testing.T) {
+	// Two clusters of reds and blues, weighted towards their first colour
+	img := testRuns(rgb(200, 20, 20), 6, rgb(210, 30, 30), 2, rgb(20, 20, 200), 6, rgb(30, 30, 210), 2)
+	reds, blues := rgb(202, 22, 22), rgb(22, 22, 202)
+	for method := range Method(len(MethodNames)) {
+		for metric := range Metric(len(MetricNames)) {
+			p, err := Quantize(img, 2, method, metric)
+			if err != nil {
+				t.Fatalf("%s: %v", MethodNames[method], err)
+			}
+			got := sortedColors(p.Colors)
+			if len(got) != 2 || !near(got[0], blues, 3) || !near(got[1], reds, 3) {
+				t.Errorf("%s, %s: %v, want about %v", MethodNames[method], MetricNames[metric], got, []Color{blues, reds})
+			}
+		}
+	}
+}
+
+func TestQuantizeOctreeMerges(t *testing.T) {
+	// Merged leaves take the mean of their pixels in RGB
+	img := testRuns(rgb(0, 0, 0), 3, rgb(8, 8, 8), 1, rgb(255, 255, 255), 4)
+	p, err := Quantize(img, 2, Octree, MetricOKLab)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Color{rgb(2, 2, 2), rgb(255, 255, 255)}
+	if got := sortedColors(p.Colors); !slices.Equal(got, want) {
+		t.Errorf("octree: %v, want %v", got, want)
+	}
+}
+
+func TestQuantizeDeterministic(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 64, 64))
+	for i := range img.Pix {
+		img.Pix[i] = uint8(i * 7919 >> 3)
+	}
+	for method := range Method(len(MethodNames)) {
+		a, err := Quantize(img, 12, method, MetricOKLab)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, _ := Quantize(img, 12, method, MetricOKLab)
+		if !slices.Equal(a.Colors, b.Colors) || len(a.Colors) > 12 {
+			t.Errorf("%s: %v, then %v", MethodNames[method], a.Colors, b.Colors)
+		}
+	}
+}
+
+func TestQuantizeErrors(t *testing.T) {
+	img := testRuns(rgb(1, 2, 3), 2)
+	for _, n := range []int{0, MaxColors + 1} {
+		if _, err := Quantize(img, n, MedianCut, MetricOKLab); err == nil {
+			t.Errorf("%d colours quantized", n)
+		}
+	}
+	if _, err := Quantize(testRuns(Color{R: 1}, 4), 4, MedianCut, MetricOKLab); err == nil {
+		t.Error("image of clear pixels quantized")
+	}
+	if _, err := Quantize(img, 4, Method(len(MethodNames)), MetricOKLab); err == nil {
+		t.Error("unknown method quantized")
+	}
+}
+
+func TestRemap(t *testing.T) {
+	p := &Palette{Colors: []Color{rgb(0, 0, 0), {}, rgb(255, 0, 0), rgb(255, 255, 255)}}
+	img := testRuns(rgb(250, 10, 10), 1, rgb(20, 20, 20), 1, rgb(240, 240, 240), 1, Color{R: 255, A: 50}, 1, Color{}, 1)
+	for _, tc := range []struct {
+		name        string
+		transparent int
+		want        []uint8
+	}{
+		// Clear pixels are matched by colour like the rest
+		{"no transparent entry", -1, []uint8{2, 0, 3, 2, 0}},
+		// The clear entry black would be closest to is never matched
+		{"transparent entry", 1, []uint8{2, 0, 3, 1, 1}},
+	} {
+		for metric := range Metric(len(MetricNames)) {
+			out, err := Remap(img, p, RemapOptions{Metric: metric, Transparent: tc.transparent})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(out.Pix, tc.want) {
+				t.Errorf("%s, %s: %v, want %v", tc.name, MetricNames[metric], out.Pix, tc.want)
+			}
+			if out.Palette[2] != (color.NRGBA{255, 0, 0, 255}) {
+				t.Errorf("%s: palette %v", tc.name, out.Palette)
+			}
+		}
+	}
+}
+
+func TestRemapDither(t *testing.T) {
+	// A mid grey field between black and white. Error diffusion keeps its
+	// mean value, so about half its pixels are white; ordered dithers
+	// offset the grey before matching, which is in OKLab, where it is
+	// lighter than halfway, so more are.
+	bw := &Palette{Colors: []Color{rgb(0, 0, 0), rgb(255, 255, 255)}}
+	img := image.NewNRGBA(image.Rect(0, 0, 64, 64))
+	for i := 0; i < len(img.Pix); i += 4 {
+		img.Pix[i], img.Pix[i+1], img.Pix[i+2], img.Pix[i+3] = 128, 128, 128, 255
+	}
+	for _, tc := range []struct {
+		dither   Dither
+		min, max float64 // share of white pixels
+	}{
+		{DitherNone, 1, 1},
+		{FloydSteinberg, 0.48, 0.52},
+		// A quarter of the error is dropped
+		{Atkinson, 0.45, 0.58},
+		{Bayer, 0.6, 0.68},
+		{BlueNoise, 0.6, 0.68},
+	} {
+		out, err := Remap(img, bw, RemapOptions{Dither: tc.dither, Transparent: -1})
+		if err != nil {
+			t.Fatal(err)
+		}
+		white := 0
+		for _, i := range out.Pix {
+			white += int(i)
+		}
+		if share := float64(white) / float64(len(out.Pix)); share < tc.min || share > tc.max {
+			t.Errorf("%s: %.3f of the pixels white, want %.2f-%.2f", DitherNames[tc.dither], share, tc.min, tc.max)
+		}
+		again, _ := Remap(img, bw, RemapOptions{Dither: tc.dither, Transparent: -1})
+		if !slices.Equal(out.Pix, again.Pix) {
+			t.Errorf("%s: remapping twice differs", DitherNames[tc.dither])
+		}
+	}
+}
+
+func TestRemapErrors(t *testing.T) {
+	img := testRuns(rgb(1, 2, 3), 1)
+	many := &Palette{Colors: make([]Color, 257)}
+	two := &Palette{Colors: []Color{rgb(0, 0, 0), rgb(255, 255, 255)}}
+	for _, tc := range []struct {
+		name string
+		p    *Palette
+		opts RemapOptions
+	}{
+		{"no colours", &Palette{}, RemapOptions{Transparent: -1}},
+		{"too many colours", many, RemapOptions{Transparent: -1}},
+		{"transparent past the colours", two, RemapOptions{Transparent: 2}},
+		{"only the transparent entry", &Palette{Colors: []Color{{}}}, RemapOptions{Transparent: 0}},
+	} {
+		if _, err := Remap(img, tc.p, tc.opts); err == nil {
+			t.Errorf("%s: remapped", tc.name)
+		}
+	}
+}
@@ -0,0 +1,37 @@
+package palette
+
+import "math"
+
+// Ramp returns n colours from a to b, both included, evenly spaced in
+// OKLCH and turning the short way round the hue circle. hueShift bends the
+// hue by up to that many degrees midway, as painters warm the lights and
+// cool the shadows, while the ends stay exact. Alpha is interpolated
+// straight.
+func Ramp(a, b Color, n int, hueShift float64) []Color {
+	if n < 2 {
+		return []Color{a}[:max(n, 0)]
+	}
+	from, to := a.OKLab().LCH(), b.OKLab().LCH()
+	// A grey has no hue of its own; take the other end's
+	switch {
+	case from.C == 0:
+		from.H = to.H
+	case to.C == 0:
+		to.H = from.H
+	}
+	turn := math.Mod(to.H-from.H+540, 360) - 180
+
+	ramp := make([]Color, n)
+	ramp[0], ramp[n-1] = a, b
+	for i := 1; i < n-1; i++ {
+		t := float64(i) / float64(n-1)
+		c := OKLCH{
+			L: from.L + (to.L-from.L)*t,
+			C: from.C + (to.C-from.C)*t,
+			H: from.H + turn*t + hueShift*math.Sin(math.Pi*t),
+		}.OKLab().Color()
+		c.A = uint8(math.Round(float64(a.A) + (float64(b.A)-float64(a.A))*t))
+		ramp[i] = c
+	}
+	return ramp
+}
@@ -0,0 +1,96 @@
+package palette
+
+import (
+	"cmp"
+	"slices"
+)
+
+// Orders for sorting colours
+type Order int
+
+const (
+	ByHue       Order = iota // greys first, dark to light, then round the OKLCH hue circle
+	ByLightness              // OKLab lightness, dark to light
+	ByChroma                 // OKLCH chroma, dull to vivid
+	SmoothPath               // from the darkest colour, always on to the nearest left in OKLab
+)
+
+// Names of the orders, as palgen takes them
+var OrderNames = []string{"hue", "lightness", "chroma", "smooth"}
+
+// Chroma under which a colour counts as grey when sorting by hue
+const greyChroma = 0.02
+
+// SortOrder returns the colours' indices in the order: colour i of the
+// sorted list is colors[order[i]]. Ties keep their places.
+func SortOrder(colors []Color, o Order) []int {
+	if o == SmoothPath {
+		return smoothPath(colors)
+	}
+	lch := make([]OKLCH, len(colors))
+	for i, c := range colors {
+		lch[i] = c.OKLab().LCH()
+	}
+	order := make([]int, len(colors))
+	for i := range order {
+		order[i] = i
+	}
+	slices.SortStableFunc(order, func(a, b int) int {
+		x, y := lch[a], lch[b]
+		switch o {
+		case ByLightness:
+			return cmp.Or(cmp.Compare(x.L, y.L), cmp.Compare(x.C, y.C))
+		case ByChroma:
+			return cmp.Or(cmp.Compare(x.C, y.C), cmp.Compare(x.L, y.L))
+		}
+		greyX, greyY := x.C < greyChroma, y.C < greyChroma
+		switch {
+		case greyX && greyY:
+			return cmp.Compare(x.L, y.L)
+		case greyX:
+			return -1
+		case greyY:
+			return 1
+		}
+		return cmp.Or(cmp.Compare(x.H, y.H), cmp.Compare(x.L, y.L))
+	})
+	return order
+}
+
+// Greedy nearest-neighbour path through OKLab from the darkest colour
+func smoothPath(colors []Color) []int {
+	if len(colors) == 0 {
+		return nil
+	}
+	labs := make([]OKLab, len(colors))
+	start := 0
+	for i, c := range colors {
+		labs[i] = c.OKLab()
+		if labs[i].L < labs[start].L {
+			start = i
+		}
+	}
+	used := make([]bool, len(colors))
+	order := []int{start}
+	used[start] = true
+	for len(order) < len(colors) {
+		last, next := labs[order[len(order)-1]], -1
+		for i, lab := range labs {
+			if !used[i] && (next < 0 || lab.dist2(last) < labs[next].dist2(last)) {
+				next = i
+			}
+		}
+		order = append(order, next)
+		used[next] = true
+	}
+	return order
+}
+
+// Sorted returns a copy of the palette with its colours in the order.
+func (p *Palette) Sorted(o Order) *Palette {
+	out := &Palette{Name: p.Name, Title: p.Title}
+	for _, i := range SortOrder(p.Colors, o) {
+		out.Colors = append(out.Colors, p.Colors[i])
+	}
+	return out
+}
@@ -0,0 +1,55 @@
+package palette
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestSortOrder(t *testing.T) {
+	colors := []Color{
+		rgb(0, 0, 255),     // 0 blue: OKLCH L 0.45, C 0.31, hue 264
+		rgb(255, 255, 255), // 1 white
+		rgb(255, 0, 0),     // 2 red: L 0.63, C 0.26, hue 29
+		rgb(0, 0, 0),       // 3 black
+		rgb(128, 128, 128), // 4 grey: L 0.60
+		rgb(0, 255, 0),     // 5 green: L 0.87, C 0.29, hue 142
+		rgb(255, 255, 0),   // 6 yellow: L 0.97, C 0.21, hue 110
+	}
+	for _, tc := range []struct {
+		order Order
+		want  []int
+	}{
+		{ByHue, []int{3, 4, 1, 2, 6, 5, 0}},
+		{ByLightness, []int{3, 0, 4, 2, 5, 6, 1}},
+		// Greys have no chroma and go dark to light
+		{ByChroma, []int{3, 4, 1, 6, 2, 5, 0}},
+		// Black, then blue, grey and red each nearest the last; white is
+		// just nearer red than yellow is
+		{SmoothPath, []int{3, 0, 4, 2, 1, 6, 5}},
+	} {
+		name := OrderNames[tc.order]
+		if got := SortOrder(colors, tc.order); !slices.Equal(got, tc.want) {
+			t.Errorf("%s: %v, want %v", name, got, tc.want)
+		}
+
+		// Equal colours keep their places
+		same := append(slices.Clone(colors), colors[2], colors[4])
+		got := SortOrder(same, tc.order)
+		if i, j := slices.Index(got, 2), slices.Index(got, 7); i < 0 || j != i+1 {
+			t.Errorf("%s: reds at %d and %d in %v", name, i, j, got)
+		}
+		if i, j := slices.Index(got, 4), slices.Index(got, 8); i < 0 || j != i+1 {
+			t.Errorf("%s: greys at %d and %d in %v", name, i, j, got)
+		}
+
+		if got := SortOrder(nil, tc.order); len(got) != 0 {
+			t.Errorf("%s: no colours sorted to %v", name, got)
+		}
+	}
+
+	p := &Palette{Name: "test", Colors: colors}
+	sorted := p.Sorted(ByLightness)
+	if sorted.Name != "test" || sorted.Colors[0] != colors[3] || sorted.Colors[6] != colors[1] {
+		t.Errorf("sorted palette %v", sorted)
+	}
+}
@@ -0,0 +1,266 @@
+package palette
+
+import (
+	"bufio"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Text palette formats: GIMP, plain hex, the collection's JSON, JASC-PAL
+// and Paint.NET.
+
+// Lines of a text file, trimmed, with their numbers from 1
+func lines(r io.Reader, each func(n int, line string) error) error {
+	sc := bufio.NewScanner(r)
+	n := 0
+	for sc.Scan() {
+		n++
+		line := strings.TrimSpace(sc.Text())
+		if n == 1 {
+			line = strings.TrimPrefix(line, "\ufeff")
+		}
+		if err := each(n, line); err != nil {
+			return fmt.Errorf("line %d: %v", n, err)
+		}
+	}
+	return sc.Err()
+}
+
+// Colour from six hex digits, or eight with alpha after them
+func parseHex(s string) (Color, error) {
+	s = strings.TrimPrefix(s, "#")
+	if len(s) != 6 && len(s) != 8 {
+		return Color{}, fmt.Errorf("%q is not a hex colour", s)
+	}
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		return Color{}, fmt.Errorf("%q is not a hex colour", s)
+	}
+	c := Color{R: b[0], G: b[1], B: b[2], A: 255}
+	if len(b) == 4 {
+		c.A = b[3]
+	}
+	return c, nil
+}
+
+// Channel from a whole number 0-255
+func parseChannel(s string) (uint8, error) {
+	v, err := strconv.Atoi(s)
+	if err != nil || v < 0 || v > 255 {
+		return 0, fmt.Errorf("%q is not a value 0-255", s)
+	}
+	return uint8(v), nil
+}
+
+// ReadGPL reads a GIMP palette: a "GIMP Palette" line, optional Name and
+// Columns, then "r g b name" lines. # starts a comment. "Channels: RGBA",
+// as Aseprite writes it, adds alpha after blue.
+func ReadGPL(r io.Reader) (*Palette, error) {
+	p := &Palette{}
+	channels := 3
+	err := lines(r, func(n int, line string) error {
+		switch {
+		case n == 1:
+			if line != "GIMP Palette" {
+				return fmt.Errorf("not a GIMP palette")
+			}
+			return nil
+		case line == "" || strings.HasPrefix(line, "#"):
+			return nil
+		case strings.HasPrefix(line, "Name:"):
+			p.Title = strings.TrimSpace(strings.TrimPrefix(line, "Name:"))
+			return nil
+		case strings.HasPrefix(line, "Columns:"):
+			return nil
+		case strings.HasPrefix(line, "Channels:"):
+			switch strings.TrimSpace(strings.TrimPrefix(line, "Channels:")) {
+			case "RGB":
+				channels = 3
+			case "RGBA":
+				channels = 4
+			default:
+				return fmt.Errorf("%q is not supported", line)
+			}
+			return nil
+		}
+		fields := strings.Fields(line)
+		if len(fields) < channels {
+			return fmt.Errorf("%q is not %s", line, "r g b a"[:2*channels-1])
+		}
+		c := Color{A: 255}
+		for i, ch := range []*uint8{&c.R, &c.G, &c.B, &c.A}[:channels] {
+			v, err := parseChannel(fields[i])
+			if err != nil {
+				return err
+			}
+			*ch = v
+		}
+		c.Name = strings.Join(fields[channels:], " ")
+		return p.add(c)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return p.check()
+}
+
+// ReadHex reads a colour per line as RRGGBB, with or without a #. Lines
+// starting "# " or ";" are comments; the first comment titles the palette.
+func ReadHex(r io.Reader) (*Palette, error) {
+	p := &Palette{}
+	named := false
+	err := lines(r, func(n int, line string) error {
+		if line == "" {
+			return nil
+		}
+		if c, err := parseHex(line); err == nil {
+			return p.add(c)
+		}
+		if !strings.HasPrefix(line, "#") && !strings.HasPrefix(line, ";") {
+			return fmt.Errorf("%q is not a hex colour", line)
+		}
+		if !named {
+			p.Title = strings.TrimSpace(line[1:])
+			named = true
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return p.check()
+}
+
+// A colour in the collection's JSON: hex, or channels 0-1
+type jsonColor struct {
+	Name string   `json:"name"`
+	Hex  string   `json:"hex"`
+	R    *float64 `json:"r"`
+	G    *float64 `json:"g"`
+	B    *float64 `json:"b"`
+	A    *float64 `json:"a"`
+}
+
+// ReadJSON reads the deluxe palette collection's JSON: an object with a
+// name and colors, each with a hex value or r, g, b and a from 0 to 1.
+func ReadJSON(r io.Reader) (*Palette, error) {
+	var file struct {
+		Name      string      `json:"name"`
+		ShortName string      `json:"shortName"`
+		Colors    []jsonColor `json:"colors"`
+	}
+	if err := json.NewDecoder(r).Decode(&file); err != nil {
+		return nil, err
+	}
+	p := &Palette{Name: file.ShortName, Title: file.Name}
+	unit := func(v float64) uint8 { return uint8(math.Round(min(1, max(0, v)) * 255)) }
+	for i, jc := range file.Colors {
+		var c Color
+		switch {
+		case jc.Hex != "":
+			var err error
+			if c, err = parseHex(jc.Hex); err != nil {
+				return nil, fmt.Errorf("color %d: %v", i, err)
+			}
+		case jc.R != nil && jc.G != nil && jc.B != nil:
+			c = Color{R: unit(*jc.R), G: unit(*jc.G), B: unit(*jc.B), A: 255}
+		default:
+			return nil, fmt.Errorf("color %d has neither hex nor r, g and b", i)
+		}
+		if jc.A != nil {
+			c.A = unit(*jc.A)
+		}
+		c.Name = jc.Name
+		if err := p.add(c); err != nil {
+			return nil, err
+		}
+	}
+	return p.check()
+}
+
+// ReadJASC reads a Paint Shop Pro palette: "JASC-PAL", "0100", the count,
+// then "r g b" lines.
+func ReadJASC(r io.Reader) (*Palette, error) {
+	p := &Palette{}
+	count := -1
+	err := lines(r, func(n int, line string) error {
+		switch n {
+		case 1:
+			if line != "JASC-PAL" {
+				return fmt.Errorf("not a JASC palette")
+			}
+			return nil
+		case 2:
+			if line != "0100" {
+				return fmt.Errorf("version %q is not supported", line)
+			}
+			return nil
+		case 3:
+			v, err := strconv.Atoi(line)
+			if err != nil || v < 0 || v > MaxColors {
+				return fmt.Errorf("%q is not a colour count", line)
+			}
+			count = v
+			return nil
+		}
+		if line == "" {
+			return nil
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 3 {
+			return fmt.Errorf("%q is not r g b", line)
+		}
+		c := Color{A: 255}
+		for i, ch := range []*uint8{&c.R, &c.G, &c.B} {
+			v, err := parseChannel(fields[i])
+			if err != nil {
+				return err
+			}
+			*ch = v
+		}
+		if len(fields) > 3 {
+			// Some writers add alpha
+			v, err := parseChannel(fields[3])
+			if err != nil {
+				return err
+			}
+			c.A = v
+		}
+		return p.add(c)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if count >= 0 && count != len(p.Colors) {
+		return nil, fmt.Errorf("header says %d colors, found %d", count, len(p.Colors))
+	}
+	return p.check()
+}
+
+// ReadPaintNET reads a Paint.NET palette: a colour per line as AARRGGBB,
+// with ";" starting comments.
+func ReadPaintNET(r io.Reader) (*Palette, error) {
+	p := &Palette{}
+	err := lines(r, func(n int, line string) error {
+		if line == "" || strings.HasPrefix(line, ";") {
+			return nil
+		}
+		if len(line) != 8 {
+			return fmt.Errorf("%q is not AARRGGBB", line)
+		}
+		b, err := hex.DecodeString(line)
+		if err != nil {
+			return fmt.Errorf("%q is not AARRGGBB", line)
+		}
+		return p.add(Color{R: b[1], G: b[2], B: b[3], A: b[0]})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return p.check()
+}